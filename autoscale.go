@@ -0,0 +1,190 @@
+package tasqueue
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Default interval at which the concurrency of autoscaled queues is adjusted.
+const DefaultScalingInterval = 5 * time.Second
+
+// Autoscaling scales the number of workers processing a queue between MinConcurrency and
+// MaxConcurrency, instead of the fixed concurrency of its tasks.
+type Autoscaling struct {
+	// MinConcurrency is the number of workers the queue starts with and is never scaled below.
+	// Defaults to 1.
+	MinConcurrency uint32
+	MaxConcurrency uint32
+
+	// OPTIONAL
+	// Policy returns the desired number of workers of the queue, which is clamped between
+	// MinConcurrency and MaxConcurrency. Defaults to DefaultScalingPolicy.
+	Policy ScalingPolicy
+	// Interval at which the policy is evaluated. Defaults to DefaultScalingInterval.
+	Interval time.Duration
+}
+
+// ScalingPolicy returns the desired number of workers of a queue, given its current stats.
+type ScalingPolicy func(QueueStats) int
+
+// QueueStats are the stats of an autoscaled queue, passed to its scaling policy.
+type QueueStats struct {
+	Queue string
+	// Concurrency is the current number of workers.
+	Concurrency int
+	// Busy is the number of workers processing a job.
+	Busy int
+	// Pending is the number of jobs waiting on the queue, or -1 if the broker couldn't report it.
+	Pending int
+	// Processed is the number of jobs processed since the policy was last evaluated.
+	Processed int
+	// Latency is the average processing time of the jobs processed since the policy was last
+	// evaluated, or of the last jobs processed if there were none.
+	Latency time.Duration
+	// Interval is the interval at which the policy is evaluated.
+	Interval time.Duration
+}
+
+// DefaultScalingPolicy scales the workers to process the busy and pending jobs within a scaling
+// interval, based on their average latency. Once the queue is empty, the workers are halved down
+// to the busy ones. If the broker can't report the pending jobs, the workers are doubled when all
+// of them are busy.
+func DefaultScalingPolicy(st QueueStats) int {
+	switch {
+	case st.Pending < 0:
+		if st.Busy >= st.Concurrency {
+			return st.Concurrency * 2
+		}
+		return max(st.Busy, st.Concurrency/2)
+	case st.Pending == 0:
+		return max(st.Busy, st.Concurrency/2)
+	case st.Latency == 0:
+		// No jobs have been processed yet, so run a worker per job.
+		return st.Busy + st.Pending
+	}
+
+	n := math.Ceil(float64(st.Busy+st.Pending) * float64(st.Latency) / float64(st.Interval))
+	return max(int(n), st.Busy)
+}
+
+// scaler tracks the workers and stats of an autoscaled queue.
+type scaler struct {
+	opts Autoscaling
+
+	busy atomic.Int64
+	conc atomic.Int64
+
+	mu        sync.Mutex
+	processed int
+	total     time.Duration
+	latency   time.Duration
+}
+
+func newScaler(queue string, o Autoscaling) (*scaler, error) {
+	if o.MinConcurrency == 0 {
+		o.MinConcurrency = 1
+	}
+	if o.MaxConcurrency < o.MinConcurrency {
+		return nil, fmt.Errorf("max concurrency of queue %s is less than its min concurrency", queue)
+	}
+	if o.Policy == nil {
+		o.Policy = DefaultScalingPolicy
+	}
+	if o.Interval == 0 {
+		o.Interval = DefaultScalingInterval
+	}
+
+	return &scaler{opts: o}, nil
+}
+
+// track() marks a worker as busy and returns a func recording the job's latency once processed.
+// It is a no-op on queues that aren't autoscaled.
+func (sc *scaler) track() func() {
+	if sc == nil {
+		return func() {}
+	}
+
+	sc.busy.Add(1)
+	start := time.Now()
+	return func() {
+		sc.busy.Add(-1)
+		sc.mu.Lock()
+		sc.processed++
+		sc.total += time.Since(start)
+		sc.mu.Unlock()
+	}
+}
+
+// stats() returns the stats of the queue and resets the processed jobs.
+func (sc *scaler) stats(queue string, pending int) QueueStats {
+	sc.mu.Lock()
+	processed := sc.processed
+	if processed > 0 {
+		sc.latency = sc.total / time.Duration(processed)
+	}
+	latency := sc.latency
+	sc.processed, sc.total = 0, 0
+	sc.mu.Unlock()
+
+	return QueueStats{
+		Queue:       queue,
+		Concurrency: int(sc.conc.Load()),
+		Busy:        int(sc.busy.Load()),
+		Pending:     pending,
+		Processed:   processed,
+		Latency:     latency,
+		Interval:    sc.opts.Interval,
+	}
+}
+
+// autoscale() runs the workers of an autoscaled queue, adjusting their number on every interval.
+// Workers are added to wg, so that Start() waits for them. It is a blocking function.
+func (s *Server) autoscale(ctx, jobsCtx context.Context, work chan []byte, queue string, sc *scaler, wg *sync.WaitGroup) {
+	var workers []context.CancelFunc
+	scale := func(n int) {
+		n = min(max(n, int(sc.opts.MinConcurrency)), int(sc.opts.MaxConcurrency))
+		if n == len(workers) {
+			return
+		}
+		s.log.Debug("scaling queue workers", "queue", queue, "from", len(workers), "to", n)
+
+		for len(workers) < n {
+			wctx, cancel := context.WithCancel(ctx)
+			workers = append(workers, cancel)
+			wg.Add(1)
+			go func() {
+				s.process(wctx, jobsCtx, work, queue, sc)
+				wg.Done()
+			}()
+		}
+		// Removed workers stop once they finish their current job.
+		for len(workers) > n {
+			workers[len(workers)-1]()
+			workers = workers[:len(workers)-1]
+		}
+		sc.conc.Store(int64(n))
+	}
+	scale(int(sc.opts.MinConcurrency))
+
+	tk := time.NewTicker(sc.opts.Interval)
+	defer tk.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tk.C:
+		}
+
+		pending := -1
+		if p, _, err := s.queueDepth(ctx, queue); err == nil {
+			pending = p
+		} else {
+			s.log.Debug("could not get queue depth", "queue", queue, "error", err)
+		}
+		scale(sc.opts.Policy(sc.stats(queue, pending)))
+	}
+}
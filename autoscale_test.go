@@ -0,0 +1,106 @@
+package tasqueue
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	rb "github.com/kalbhor/tasqueue/v2/brokers/in-memory"
+	rr "github.com/kalbhor/tasqueue/v2/results/in-memory"
+)
+
+func TestDefaultScalingPolicy(t *testing.T) {
+	tests := []struct {
+		name string
+		st   QueueStats
+		want int
+	}{
+		{"backlog", QueueStats{Concurrency: 2, Busy: 2, Pending: 8, Latency: time.Second, Interval: 5 * time.Second}, 2},
+		{"slow backlog", QueueStats{Concurrency: 2, Busy: 2, Pending: 18, Latency: time.Second, Interval: 2 * time.Second}, 10},
+		{"no latency", QueueStats{Concurrency: 1, Busy: 1, Pending: 3}, 4},
+		{"empty", QueueStats{Concurrency: 8, Busy: 1, Pending: 0}, 4},
+		{"unknown depth, busy", QueueStats{Concurrency: 4, Busy: 4, Pending: -1}, 8},
+		{"unknown depth, idle", QueueStats{Concurrency: 4, Busy: 3, Pending: -1}, 3},
+	}
+	for _, tt := range tests {
+		if got := DefaultScalingPolicy(tt.st); got != tt.want {
+			t.Errorf("%s: expected %d workers, got %d", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestAutoscale(t *testing.T) {
+	var (
+		ctx, cancel = context.WithCancel(context.Background())
+		lo          = slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
+			Level: slog.LevelError,
+		}))
+		running, peak atomic.Int64
+	)
+	defer cancel()
+
+	srv, err := NewServer(ServerOpts{
+		Broker:  rb.New(),
+		Results: rr.New(),
+		Logger:  lo.Handler(),
+		QueueAutoscaling: map[string]Autoscaling{
+			DefaultQueue: {MinConcurrency: 1, MaxConcurrency: 4, Interval: 100 * time.Millisecond},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.RegisterTask(taskName, func([]byte, JobCtx) error {
+		n := running.Add(1)
+		defer running.Add(-1)
+		for p := peak.Load(); n > p && !peak.CompareAndSwap(p, n); p = peak.Load() {
+		}
+		time.Sleep(200 * time.Millisecond)
+		return nil
+	}, TaskOpts{}); err != nil {
+		t.Fatal(err)
+	}
+
+	var ids []string
+	for i := 0; i < 12; i++ {
+		id, err := srv.Enqueue(ctx, makeJob(t, taskName, false))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	go srv.Start(ctx)
+
+	time.Sleep(2 * time.Second)
+	for _, id := range ids {
+		msg, err := srv.GetJob(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg.Status != StatusDone {
+			t.Fatalf("incorrect job status, expected %s, got %s", StatusDone, msg.Status)
+		}
+	}
+	if p := peak.Load(); p < 2 || p > 4 {
+		t.Fatalf("expected the queue to scale up to at most 4 workers, got %d", p)
+	}
+
+	// The idle queue scales back down.
+	if c := srv.scalers[DefaultQueue].conc.Load(); c != 1 {
+		t.Fatalf("expected the idle queue to scale down to 1 worker, got %d", c)
+	}
+}
+
+func TestAutoscalingOpts(t *testing.T) {
+	_, err := NewServer(ServerOpts{
+		Broker:           rb.New(),
+		Results:          rr.New(),
+		QueueAutoscaling: map[string]Autoscaling{DefaultQueue: {MinConcurrency: 4, MaxConcurrency: 2}},
+	})
+	if err == nil {
+		t.Fatal("expected error for max concurrency less than min concurrency")
+	}
+}
@@ -0,0 +1,151 @@
+package tasqueue
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	spans "go.opentelemetry.io/otel/trace"
+)
+
+// EnqueueBatch() enqueues multiple jobs and returns their IDs, in the order of the jobs.
+// The statuses of the jobs are set in one round trip if the results store implements BatchResults
+// and the jobs are pushed onto each queue in one round trip if the broker implements BatchBroker.
+// Jobs that are scheduled, delayed, prioritised or unique are enqueued individually.
+// If an error is returned, some of the jobs may have been enqueued.
+func (s *Server) EnqueueBatch(ctx context.Context, jobs []Job) ([]string, error) {
+	var span spans.Span
+	if s.traceProv != nil {
+		ctx, span = otel.Tracer(tracer).Start(ctx, "enqueue_batch", spans.WithSpanKind(spans.SpanKindProducer))
+		defer span.End()
+	}
+
+	var (
+		ids   = make([]string, len(jobs))
+		batch = make([]JobMessage, 0, len(jobs))
+	)
+	for i, j := range jobs {
+		if !j.batchable() {
+			id, err := s.Enqueue(ctx, j)
+			if err != nil {
+				s.spanError(span, err)
+				return nil, err
+			}
+			ids[i] = id
+			continue
+		}
+
+		j, err := s.offloadPayloads(ctx, j)
+		if err != nil {
+			s.spanError(span, err)
+			return nil, err
+		}
+
+		meta := DefaultMeta(j.Opts)
+		if s.traceProv != nil {
+			meta.TraceContext = make(map[string]string)
+			s.propagator.Inject(ctx, propagation.MapCarrier(meta.TraceContext))
+		}
+		batch = append(batch, j.message(meta))
+		ids[i] = meta.ID
+	}
+	if len(batch) == 0 {
+		return ids, nil
+	}
+
+	if err := s.setJobMessages(ctx, batch); err != nil {
+		s.spanError(span, err)
+		return nil, err
+	}
+
+	// Group the encoded messages by queue, retaining the order of the jobs.
+	var (
+		queues []string
+		msgs   = make(map[string][][]byte)
+	)
+	for _, m := range batch {
+		b, err := s.codec.marshal(m)
+		if err != nil {
+			s.spanError(span, err)
+			return nil, err
+		}
+		if _, ok := msgs[m.Queue]; !ok {
+			queues = append(queues, m.Queue)
+		}
+		msgs[m.Queue] = append(msgs[m.Queue], b)
+	}
+
+	for _, q := range queues {
+		if err := s.brokerEnqueueBatch(ctx, msgs[q], q); err != nil {
+			s.spanError(span, err)
+			return nil, fmt.Errorf("could not enqueue jobs on queue %s : %w", q, err)
+		}
+	}
+
+	for _, m := range batch {
+		s.jobEnqueued(ctx, m)
+	}
+
+	return ids, nil
+}
+
+// batchable() reports whether the job can be enqueued as part of a batch, ie: it is pushed
+// directly onto its queue without any special handling.
+func (t Job) batchable() bool {
+	return t.Opts.Schedule == "" && t.Opts.ETA.IsZero() && t.Opts.Delay == 0 &&
+		t.Opts.Priority == 0 && t.Opts.UniqueKey == "" && len(t.Opts.Tags) == 0
+}
+
+// setJobMessages() sets the status of multiple new job messages as started.
+func (s *Server) setJobMessages(ctx context.Context, msgs []JobMessage) error {
+	var (
+		now   = s.now()
+		items = make(map[string][]byte, len(msgs))
+	)
+	for _, m := range msgs {
+		m.ProcessedAt = now
+		m.Status = StatusStarted
+		b, err := s.codec.marshal(m)
+		if err != nil {
+			return fmt.Errorf("could not set job message in store : %w", err)
+		}
+		items[jobPrefix+m.ID] = b
+	}
+
+	if br, ok := s.results.(BatchResults); ok {
+		if err := br.SetBatch(ctx, items); err != nil {
+			return fmt.Errorf("could not set job messages in store : %w", err)
+		}
+	} else {
+		for id, b := range items {
+			if err := setMeta(ctx, s.results, id, b); err != nil {
+				return fmt.Errorf("could not set job message in store : %w", err)
+			}
+		}
+	}
+
+	for _, m := range msgs {
+		m.ProcessedAt, m.Status = now, StatusStarted
+		s.recordEvent(ctx, m)
+	}
+
+	return nil
+}
+
+// brokerEnqueueBatch() pushes the encoded messages onto the queue, in one round trip
+// if the broker supports it.
+func (s *Server) brokerEnqueueBatch(ctx context.Context, msgs [][]byte, queue string) error {
+	br := s.brokerFor(queue)
+	if bb, ok := br.(BatchBroker); ok {
+		return bb.EnqueueBatch(ctx, msgs, queue)
+	}
+
+	for _, b := range msgs {
+		if err := br.Enqueue(ctx, b, queue); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,55 @@
+package tasqueue
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestEnqueueBatch(t *testing.T) {
+	var (
+		srv  = newServer(t, taskName, MockHandler)
+		ctx  = context.Background()
+		jobs = make([]Job, 10)
+	)
+	for i := range jobs {
+		jobs[i] = makeJob(t, taskName, false)
+	}
+	// Delayed jobs are enqueued individually.
+	jobs[5].Opts.Delay = 100 * time.Millisecond
+
+	ids, err := srv.EnqueueBatch(ctx, jobs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != len(jobs) {
+		t.Fatalf("expected %d ids, got %d", len(jobs), len(ids))
+	}
+
+	pending, err := srv.GetPending(ctx, DefaultQueue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != len(jobs)-1 {
+		t.Fatalf("expected %d pending jobs, got %d", len(jobs)-1, len(pending))
+	}
+	batched := append(append([]string{}, ids[:5]...), ids[6:]...)
+	for i, p := range pending {
+		if p.ID != batched[i] {
+			t.Fatalf("incorrect pending job order at %d, expected %s, got %s", i, batched[i], p.ID)
+		}
+	}
+
+	go srv.Start(ctx)
+	time.Sleep(time.Second)
+
+	for _, id := range ids {
+		msg, err := srv.GetJob(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg.Status != StatusDone {
+			t.Fatalf("incorrect job status, expected %s, got %s", StatusDone, msg.Status)
+		}
+	}
+}
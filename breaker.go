@@ -0,0 +1,136 @@
+package tasqueue
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// CircuitBreaker stops processing the jobs of a task after consecutive failures, eg: while a
+// downstream API the task depends on is down, instead of burning the jobs' retries.
+type CircuitBreaker struct {
+	// Threshold is the number of consecutive failed attempts after which the circuit opens. Zero
+	// disables the breaker.
+	Threshold uint32
+
+	// Cooldown is the duration the circuit stays open for. Jobs of the task consumed meanwhile are
+	// put back onto the queue until the cooldown ends. A single job is then processed as a trial,
+	// closing the circuit if it succeeds and opening it again if it fails.
+	Cooldown time.Duration
+
+	// PauseQueue pauses consuming the task's whole queue on the server while the circuit is open, instead
+	// of only deferring the task's jobs. The queue is resumed after the cooldown.
+	PauseQueue bool
+}
+
+// breaker is the state of a task's circuit breaker on the server. The circuit is closed if openUntil
+// is zero, open until openUntil, and half-open once it passes, until the result of the trial job.
+type breaker struct {
+	opts CircuitBreaker
+
+	mu        sync.Mutex
+	failures  uint32
+	openUntil time.Time
+	trialID   string
+}
+
+// allow() reports whether the job can be processed, or otherwise how long to defer it for.
+func (b *breaker) allow(id string, now time.Time) (time.Duration, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch {
+	case b.openUntil.IsZero():
+		return 0, true
+	case now.Before(b.openUntil):
+		return b.openUntil.Sub(now), false
+	case b.trialID == "" || b.trialID == id:
+		b.trialID = id
+		return 0, true
+	}
+
+	// A trial job is being processed.
+	return concurrencyDeferDelay, false
+}
+
+// record() records the outcome of the job's attempt, reporting whether the circuit opened or closed.
+func (b *breaker) record(id string, err error, now time.Time) (opened, closed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.trialID != "" && b.trialID == id {
+		b.trialID = ""
+		if err == nil {
+			b.failures, b.openUntil = 0, time.Time{}
+			return false, true
+		}
+		b.openUntil = now.Add(b.opts.Cooldown)
+		return true, false
+	}
+
+	// The outcomes of the jobs that were being processed when the circuit opened are ignored.
+	if !b.openUntil.IsZero() {
+		return false, false
+	}
+	if err == nil {
+		b.failures = 0
+		return false, false
+	}
+
+	b.failures++
+	if b.failures < b.opts.Threshold {
+		return false, false
+	}
+	b.openUntil = now.Add(b.opts.Cooldown)
+
+	return true, false
+}
+
+// abort() lets another job be the trial, if the job was the trial but didn't complete (eg: it was cancelled).
+func (b *breaker) abort(id string) {
+	b.mu.Lock()
+	if b.trialID == id {
+		b.trialID = ""
+	}
+	b.mu.Unlock()
+}
+
+// recordBreaker() records the outcome of the job's attempt on its task's circuit breaker, if any,
+// calling the hooks and pausing or resuming the queue when the circuit opens or closes.
+func (s *Server) recordBreaker(ctx context.Context, msg JobMessage, task Task, err error) {
+	if task.breaker == nil {
+		return
+	}
+
+	opened, closed := task.breaker.record(msg.ID, err, s.now())
+	switch {
+	case opened:
+		s.log.Warn("circuit breaker opened", "task", task.name, "cooldown", task.opts.CircuitBreaker.Cooldown, "error", err)
+		if s.hooks.OnCircuitOpen != nil {
+			s.hooks.OnCircuitOpen(task.name, err)
+		}
+		if task.opts.CircuitBreaker.PauseQueue {
+			s.pauseForCooldown(task)
+		}
+	case closed:
+		s.log.Info("circuit breaker closed", "task", task.name)
+		if s.hooks.OnCircuitClose != nil {
+			s.hooks.OnCircuitClose(task.name)
+		}
+	}
+}
+
+// pauseForCooldown() pauses consuming the task's queue on this server until the cooldown has passed. The
+// queue isn't paused on the other servers, whose breakers are their own, so that it isn't left paused if this
+// server stops, and a queue paused with PauseQueue() stays paused after the cooldown.
+func (s *Server) pauseForCooldown(task Task) {
+	var (
+		q     = task.opts.Queue
+		until = s.now().Add(task.opts.CircuitBreaker.Cooldown)
+	)
+	s.pm.Lock()
+	if until.After(s.tripped[q]) {
+		s.tripped[q] = until
+	}
+	s.pm.Unlock()
+}
@@ -0,0 +1,175 @@
+package tasqueue
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"testing"
+	"time"
+
+	rb "github.com/kalbhor/tasqueue/v2/brokers/in-memory"
+	rr "github.com/kalbhor/tasqueue/v2/results/in-memory"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	lo := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
+		Level: slog.LevelError,
+	}))
+	ctx := context.Background()
+
+	var (
+		clock          = rb.NewFakeClock(time.Now())
+		down           = true
+		calls          int
+		opened, closed int
+	)
+	srv, err := NewServer(ServerOpts{
+		Broker:  rb.NewWithClock(clock),
+		Results: rr.New(),
+		Logger:  lo.Handler(),
+		Clock:   clock,
+		Hooks: Hooks{
+			OnCircuitOpen:  func(string, error) { opened++ },
+			OnCircuitClose: func(string) { closed++ },
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.RegisterTask("api", func([]byte, JobCtx) error {
+		calls++
+		if down {
+			return errors.New("api is down")
+		}
+		return nil
+	}, TaskOpts{CircuitBreaker: CircuitBreaker{Threshold: 2, Cooldown: time.Minute}}); err != nil {
+		t.Fatal(err)
+	}
+
+	var ids []string
+	for i := 0; i < 4; i++ {
+		job, err := NewJob("api", nil, JobOpts{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, err := srv.Enqueue(ctx, job)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+
+	check := func(step string, wantCalls, wantOpened, wantClosed int) {
+		t.Helper()
+		if calls != wantCalls || opened != wantOpened || closed != wantClosed {
+			t.Fatalf("%s: expected %d calls, %d opens and %d closes, got %d, %d and %d",
+				step, wantCalls, wantOpened, wantClosed, calls, opened, closed)
+		}
+	}
+
+	// The circuit opens after two failures, deferring the other jobs.
+	if _, err := srv.ProcessAll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	check("open", 2, 1, 0)
+
+	// The trial job fails after the cooldown, opening the circuit again.
+	clock.Advance(time.Minute)
+	if _, err := srv.ProcessAll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	check("failed trial", 3, 2, 0)
+
+	// The trial job succeeds, closing the circuit.
+	down = false
+	clock.Advance(time.Minute)
+	if _, err := srv.ProcessAll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	check("closed", 4, 2, 1)
+
+	for i, id := range ids {
+		msg, err := srv.GetJob(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := StatusFailed
+		if i == 3 {
+			want = StatusDone
+		}
+		if msg.Status != want {
+			t.Fatalf("incorrect status of job %d, expected %s, got %s", i, want, msg.Status)
+		}
+	}
+}
+
+func TestCircuitBreakerPauseQueue(t *testing.T) {
+	lo := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
+		Level: slog.LevelError,
+	}))
+	ctx := context.Background()
+
+	var (
+		clock = rb.NewFakeClock(time.Now())
+		calls int
+	)
+	srv, err := NewServer(ServerOpts{
+		Broker:  rb.NewWithClock(clock),
+		Results: rr.New(),
+		Logger:  lo.Handler(),
+		Clock:   clock,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.RegisterTask("api", func([]byte, JobCtx) error {
+		calls++
+		return errors.New("api is down")
+	}, TaskOpts{CircuitBreaker: CircuitBreaker{Threshold: 1, Cooldown: time.Minute, PauseQueue: true}}); err != nil {
+		t.Fatal(err)
+	}
+
+	job, err := NewJob("api", nil, JobOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := srv.Enqueue(ctx, job); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := srv.Enqueue(ctx, job); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := srv.ProcessAll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 1 {
+		t.Fatalf("expected 1 call before the circuit opened, got %d", calls)
+	}
+
+	// The queue is only paused on the server, until the cooldown.
+	if !srv.isPaused(ctx, DefaultQueue) {
+		t.Fatal("expected the queue to be paused while the circuit is open")
+	}
+	if paused, err := srv.IsQueuePaused(ctx, DefaultQueue); err != nil || paused {
+		t.Fatalf("expected the queue not to be paused on the broker, got %v: %v", paused, err)
+	}
+
+	// A queue paused by hand isn't resumed after the cooldown.
+	if err := srv.PauseQueue(ctx, DefaultQueue); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(2 * time.Minute)
+	if !srv.isPaused(ctx, DefaultQueue) {
+		t.Fatal("expected the queue paused by hand to stay paused after the cooldown")
+	}
+	if err := srv.ResumeQueue(ctx, DefaultQueue); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := srv.ProcessAll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 2 {
+		t.Fatalf("expected the trial job to be processed once resumed, got %d calls", calls)
+	}
+}
@@ -1,86 +1,388 @@
 package inmemory
 
 import (
+	"bytes"
+	"container/heap"
 	"context"
-	"fmt"
+	"sort"
 	"sync"
 	"time"
 )
 
 type Broker struct {
-	mu     sync.RWMutex
-	queues map[string]chan []byte
+	mu     sync.Mutex
+	queues map[string]*queue
+	paused map[string]bool
 
-	pmu     sync.RWMutex
-	pending map[string][]string
+	// sems holds the leases of the slots of each semaphore (key -> token -> expiry).
+	sems map[string]map[string]time.Time
+
+	// clock is the fake clock scheduled messages are held until, if set with NewWithClock().
+	clock     *FakeClock
+	scheduled []scheduledMessage
+}
+
+// scheduledMessage is a message held until the fake clock reaches its timestamp.
+type scheduledMessage struct {
+	msg   []byte
+	queue string
+	ts    time.Time
+}
+
+// queue holds the pending messages of a queue. Messages are consumed by
+// priority, and in the order they were enqueued within the same priority.
+type queue struct {
+	pending msgHeap
+	seq     uint64
+
+	// notify wakes up the consumer when a message is enqueued.
+	notify chan struct{}
+}
+
+type message struct {
+	msg      []byte
+	priority uint8
+	seq      uint64
 }
 
 func New() *Broker {
 	return &Broker{
-		queues:  make(map[string]chan []byte),
-		pending: make(map[string][]string),
+		queues: make(map[string]*queue),
+		paused: make(map[string]bool),
+		sems:   make(map[string]map[string]time.Time),
 	}
 }
 
+// NewWithClock() returns a broker whose scheduled messages are enqueued once the fake clock reaches
+// their timestamps, instead of the system clock. The clock should also be set as the server's clock.
+func NewWithClock(c *FakeClock) *Broker {
+	r := New()
+	r.clock = c
+	c.onAdvance(r.release)
+
+	return r
+}
+
 func (r *Broker) Consume(ctx context.Context, work chan []byte, queue string) {
-	r.mu.RLock()
-	ch, ok := r.queues[queue]
-	r.mu.RUnlock()
+	q := r.queue(queue)
 
-	if !ok {
-		ch = make(chan []byte, 100)
+	for {
 		r.mu.Lock()
-		r.queues[queue] = ch
+		if q.pending.Len() > 0 {
+			m := heap.Pop(&q.pending).(message)
+			r.mu.Unlock()
+
+			select {
+			case <-ctx.Done():
+				// Return the message onto the queue, so that it isn't lost.
+				r.mu.Lock()
+				heap.Push(&q.pending, m)
+				r.mu.Unlock()
+				return
+			case work <- m.msg:
+			}
+			continue
+		}
 		r.mu.Unlock()
-	}
 
-	for {
 		select {
 		case <-ctx.Done():
-			fmt.Println("stopping consumer")
 			return
-		case d := <-ch:
-			r.pmu.Lock()
-			r.pending[queue] = r.pending[queue][1:]
-			r.pmu.Unlock()
-
-			work <- d
+		case <-q.notify:
 		}
 	}
 }
 
 func (r *Broker) Enqueue(ctx context.Context, msg []byte, queue string) error {
-	r.mu.RLock()
-	ch, ok := r.queues[queue]
-	r.mu.RUnlock()
+	return r.EnqueuePriority(ctx, msg, queue, 0)
+}
+
+func (r *Broker) EnqueuePriority(ctx context.Context, msg []byte, queue string, priority uint8) error {
+	q := r.queue(queue)
+
+	r.mu.Lock()
+	q.seq++
+	heap.Push(&q.pending, message{msg: msg, priority: priority, seq: q.seq})
+	r.mu.Unlock()
+
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// EnqueueBatch enqueues the messages onto the queue, in order.
+func (r *Broker) EnqueueBatch(ctx context.Context, msgs [][]byte, queue string) error {
+	q := r.queue(queue)
+
+	r.mu.Lock()
+	for _, msg := range msgs {
+		q.seq++
+		heap.Push(&q.pending, message{msg: msg, seq: q.seq})
+	}
+	r.mu.Unlock()
+
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
 
+	return nil
+}
+
+func (r *Broker) GetPending(ctx context.Context, queue string) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// A queue that wasn't enqueued onto or consumed yet (eg: a sub-queue of tags) has no pending messages.
+	q, ok := r.queues[queue]
 	if !ok {
-		ch = make(chan []byte, 100)
+		return []string{}, nil
+	}
+
+	// Return the messages in the order they will be consumed.
+	msgs := make(msgHeap, len(q.pending))
+	copy(msgs, q.pending)
+	sort.Sort(msgs)
+
+	pending := make([]string, len(msgs))
+	for i, m := range msgs {
+		pending[i] = string(m.msg)
+	}
+
+	return pending, nil
+}
+
+// EnqueueScheduled enqueues the message onto the queue once the timestamp is reached.
+func (r *Broker) EnqueueScheduled(ctx context.Context, msg []byte, queue string, ts time.Time) error {
+	// Create the queue right away, so that it is visible to GetPending.
+	r.queue(queue)
+
+	if r.clock != nil {
+		if !ts.After(r.clock.Now()) {
+			return r.Enqueue(ctx, msg, queue)
+		}
 		r.mu.Lock()
-		r.queues[queue] = ch
+		r.scheduled = append(r.scheduled, scheduledMessage{msg: msg, queue: queue, ts: ts})
 		r.mu.Unlock()
+		return nil
+	}
+
+	time.AfterFunc(time.Until(ts), func() {
+		r.Enqueue(context.Background(), msg, queue)
+	})
+
+	return nil
+}
+
+// release enqueues the scheduled messages due at now, in the order of their timestamps.
+func (r *Broker) release(now time.Time) {
+	r.mu.Lock()
+	var due []scheduledMessage
+	held := r.scheduled[:0]
+	for _, m := range r.scheduled {
+		if m.ts.After(now) {
+			held = append(held, m)
+		} else {
+			due = append(due, m)
+		}
+	}
+	r.scheduled = held
+	r.mu.Unlock()
+
+	sort.SliceStable(due, func(i, j int) bool { return due[i].ts.Before(due[j].ts) })
+	for _, m := range due {
+		r.Enqueue(context.Background(), m.msg, m.queue)
+	}
+}
+
+// Dequeue removes and returns the next pending message on the queue, without blocking.
+func (r *Broker) Dequeue(ctx context.Context, queue string) ([]byte, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	q, ok := r.queues[queue]
+	if !ok || q.pending.Len() == 0 {
+		return nil, false, nil
+	}
+
+	return heap.Pop(&q.pending).(message).msg, true, nil
+}
+
+// Remove removes the first pending message on the queue equal to msg.
+func (r *Broker) Remove(ctx context.Context, msg []byte, queue string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	q, ok := r.queues[queue]
+	if !ok {
+		return nil
+	}
+	for i, m := range q.pending {
+		if bytes.Equal(m.msg, msg) {
+			heap.Remove(&q.pending, i)
+			return nil
+		}
+	}
+
+	return nil
+}
 
+// Purge removes all the pending messages on the queue.
+func (r *Broker) Purge(ctx context.Context, queue string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if q, ok := r.queues[queue]; ok {
+		q.pending = nil
 	}
 
-	r.pmu.Lock()
-	r.pending[queue] = append(r.pending[queue], string(msg))
-	r.pmu.Unlock()
+	return nil
+}
+
+// PauseQueue marks the queue as paused, which the servers sharing the broker stop consuming.
+func (r *Broker) PauseQueue(ctx context.Context, queue string) error {
+	r.mu.Lock()
+	r.paused[queue] = true
+	r.mu.Unlock()
 
-	ch <- msg
 	return nil
 }
 
-func (r *Broker) GetPending(ctx context.Context, queue string) ([]string, error) {
-	r.pmu.Lock()
-	pending, ok := r.pending[queue]
-	r.pmu.Unlock()
+func (r *Broker) ResumeQueue(ctx context.Context, queue string) error {
+	r.mu.Lock()
+	delete(r.paused, queue)
+	r.mu.Unlock()
+
+	return nil
+}
+
+func (r *Broker) IsPaused(ctx context.Context, queue string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.paused[queue], nil
+}
+
+// Acquire takes one of the limit slots of the semaphore for the token, or extends its lease if it
+// already holds one. It returns false if no slot is free.
+func (r *Broker) Acquire(ctx context.Context, key, token string, limit int, ttl time.Duration) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	sem, ok := r.sems[key]
 	if !ok {
-		return nil, fmt.Errorf("non existend queue provided")
+		sem = make(map[string]time.Time)
+		r.sems[key] = sem
+	}
+	for t, exp := range sem {
+		if !exp.After(now) {
+			delete(sem, t)
+		}
 	}
 
-	return pending, nil
+	if _, ok := sem[token]; !ok && len(sem) >= limit {
+		return false, nil
+	}
+	sem[token] = now.Add(ttl)
+
+	return true, nil
+}
+
+func (r *Broker) Release(ctx context.Context, key, token string) error {
+	r.mu.Lock()
+	delete(r.sems[key], token)
+	r.mu.Unlock()
+
+	return nil
+}
+
+// now returns the time of the fake clock, if set, or the current time.
+func (r *Broker) now() time.Time {
+	if r.clock != nil {
+		return r.clock.Now()
+	}
+
+	return time.Now()
+}
+
+// SetNamespace is a no-op, as the broker isn't shared outside of its process.
+func (r *Broker) SetNamespace(ns string) {}
+
+// queue returns the named queue, creating it if it doesn't exist.
+func (r *Broker) queue(name string) *queue {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	q, ok := r.queues[name]
+	if !ok {
+		q = &queue{notify: make(chan struct{}, 1)}
+		r.queues[name] = q
+	}
+
+	return q
+}
+
+// msgHeap implements heap.Interface, ordering messages by priority and then by sequence.
+type msgHeap []message
+
+func (h msgHeap) Len() int { return len(h) }
+func (h msgHeap) Less(i, j int) bool {
+	if h[i].priority != h[j].priority {
+		return h[i].priority > h[j].priority
+	}
+	return h[i].seq < h[j].seq
+}
+func (h msgHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *msgHeap) Push(x any) { *h = append(*h, x.(message)) }
+func (h *msgHeap) Pop() any {
+	old := *h
+	n := len(old)
+	m := old[n-1]
+	*h = old[:n-1]
+	return m
+}
+
+// FakeClock is a clock that only moves when advanced, for tests. It implements tasqueue.Clock.
+type FakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+
+	// fns are called with the new time each time the clock is advanced.
+	fns []func(time.Time)
+}
+
+// NewFakeClock() returns a fake clock set to the time.
+func NewFakeClock(t time.Time) *FakeClock {
+	return &FakeClock{now: t}
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+// Advance moves the clock forward by d, enqueuing the messages scheduled up to the new time
+// on the brokers using the clock.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	now, fns := c.now, c.fns
+	c.mu.Unlock()
+
+	for _, fn := range fns {
+		fn(now)
+	}
 }
 
-func (b *Broker) EnqueueScheduled(ctx context.Context, msg []byte, queue string, ts time.Time) error {
-	return fmt.Errorf("in-memory broker does not support this method")
+func (c *FakeClock) onAdvance(fn func(time.Time)) {
+	c.mu.Lock()
+	c.fns = append(c.fns, fn)
+	c.mu.Unlock()
 }
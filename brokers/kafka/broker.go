@@ -0,0 +1,337 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"regexp"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+const (
+	DefaultGroupID    = "tasqueue"
+	DefaultPartitions = 1
+
+	// Delays before fetching again after an error, doubled after each consecutive error.
+	minFetchBackoff = 100 * time.Millisecond
+	maxFetchBackoff = 10 * time.Second
+)
+
+// invalidTopicChars matches the characters not allowed in kafka topic names.
+var invalidTopicChars = regexp.MustCompile(`[^a-zA-Z0-9._-]`)
+
+type Options struct {
+	Brokers []string
+
+	// GroupID is the consumer group used to consume the queues. Servers sharing
+	// the same GroupID split the partitions of a queue's topic between them.
+	GroupID string
+
+	// Partitions and ReplicationFactor of the topics created for queues.
+	// Partitions bound the number of servers that can concurrently consume a queue.
+	Partitions        int
+	ReplicationFactor int
+}
+
+// Broker is a kafka based broker implementation. Each queue is mapped onto a topic.
+type Broker struct {
+	lo   *slog.Logger
+	opts Options
+
+	client *kafka.Client
+	writer *kafka.Writer
+
+	// topics holds the topics that are known to exist.
+	mu     sync.Mutex
+	topics map[string]struct{}
+
+	// unacked holds the consumed messages that haven't been acknowledged yet.
+	um      sync.Mutex
+	unacked map[delivery][]pending
+}
+
+// delivery identifies a consumed message.
+type delivery struct {
+	queue string
+	body  string
+}
+
+// pending is a consumed message waiting to be acknowledged, along with its consumer.
+type pending struct {
+	m kafka.Message
+	c *consumer
+}
+
+// consumer commits the offsets of the messages consumed by a reader once they are acknowledged.
+type consumer struct {
+	rd reader
+
+	mu sync.Mutex
+	// offsets holds the uncommitted offsets consumed from each partition, and whether they were acknowledged.
+	offsets map[int]map[int64]bool
+	// inflight tracks the messages handed to the processors, the reader is closed once they are acknowledged.
+	inflight sync.WaitGroup
+}
+
+// reader is the part of *kafka.Reader used to consume a topic.
+type reader interface {
+	FetchMessage(ctx context.Context) (kafka.Message, error)
+	CommitMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+// New() returns a new instance of the kafka broker.
+func New(o Options, lo *slog.Logger) (*Broker, error) {
+	if len(o.Brokers) == 0 {
+		return nil, fmt.Errorf("kafka brokers missing in options")
+	}
+	if o.GroupID == "" {
+		o.GroupID = DefaultGroupID
+	}
+	if o.Partitions == 0 {
+		o.Partitions = DefaultPartitions
+	}
+	if o.ReplicationFactor == 0 {
+		o.ReplicationFactor = 1
+	}
+
+	addr := kafka.TCP(o.Brokers...)
+	return &Broker{
+		lo:     lo,
+		opts:   o,
+		client: &kafka.Client{Addr: addr},
+		writer: &kafka.Writer{
+			Addr:         addr,
+			Balancer:     &kafka.LeastBytes{},
+			RequiredAcks: kafka.RequireAll,
+		},
+		topics:  make(map[string]struct{}),
+		unacked: make(map[delivery][]pending),
+	}, nil
+}
+
+func (b *Broker) Enqueue(ctx context.Context, msg []byte, queue string) error {
+	topic, err := b.topic(ctx, queue)
+	if err != nil {
+		return err
+	}
+
+	return b.writer.WriteMessages(ctx, kafka.Message{
+		Topic: topic,
+		Value: msg,
+	})
+}
+
+// EnqueueBatch writes the messages onto the queue's topic in a single request.
+func (b *Broker) EnqueueBatch(ctx context.Context, msgs [][]byte, queue string) error {
+	topic, err := b.topic(ctx, queue)
+	if err != nil {
+		return err
+	}
+
+	km := make([]kafka.Message, len(msgs))
+	for i, msg := range msgs {
+		km[i] = kafka.Message{Topic: topic, Value: msg}
+	}
+
+	return b.writer.WriteMessages(ctx, km...)
+}
+
+// Consume consumes the queue's topic. The offset of a message is committed once it is acknowledged
+// with Ack (and the messages before it on its partition are), so that the jobs of a server that crashed
+// are consumed again. Once ctx is cancelled, the reader is closed after the consumed messages are
+// acknowledged.
+func (b *Broker) Consume(ctx context.Context, work chan []byte, queue string) {
+	topic, err := b.topic(ctx, queue)
+	if err != nil {
+		b.lo.Error("error creating kafka topic", "queue", queue, "error", err)
+		return
+	}
+
+	b.consume(ctx, kafka.NewReader(kafka.ReaderConfig{
+		Brokers: b.opts.Brokers,
+		GroupID: b.opts.GroupID,
+		Topic:   topic,
+	}), work, queue)
+}
+
+// consume consumes the messages of the reader, closing it once it returns.
+func (b *Broker) consume(ctx context.Context, rd reader, work chan []byte, queue string) {
+	c := &consumer{rd: rd, offsets: make(map[int]map[int64]bool)}
+	defer func() {
+		// Wait for the messages handed to the processors to be acknowledged, so that their
+		// offsets are committed before the reader is closed.
+		c.inflight.Wait()
+		rd.Close()
+	}()
+
+	backoff := minFetchBackoff
+	for {
+		msg, err := rd.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				b.lo.Debug("shutting down consumer..")
+				return
+			}
+			b.lo.Error("error consuming from kafka", "queue", queue, "error", err)
+
+			select {
+			case <-ctx.Done():
+				b.lo.Debug("shutting down consumer..")
+				return
+			case <-time.After(backoff):
+			}
+			backoff = min(backoff*2, maxFetchBackoff)
+			continue
+		}
+		backoff = minFetchBackoff
+
+		key := delivery{queue: queue, body: string(msg.Value)}
+		c.track(msg)
+		b.um.Lock()
+		b.unacked[key] = append(b.unacked[key], pending{m: msg, c: c})
+		b.um.Unlock()
+
+		select {
+		case <-ctx.Done():
+			// The message wasn't handed to a processor, its offset isn't committed so that it is
+			// consumed again.
+			b.take(queue, msg.Value)
+			c.inflight.Done()
+			b.lo.Debug("shutting down consumer..")
+			return
+		case work <- msg.Value:
+		}
+	}
+}
+
+// Ack acknowledges the consumed message, committing its offset once the messages before it on its
+// partition are acknowledged too.
+func (b *Broker) Ack(ctx context.Context, msg []byte, queue string) error {
+	p, ok := b.take(queue, msg)
+	if !ok {
+		return fmt.Errorf("message is not pending acknowledgement")
+	}
+	defer p.c.inflight.Done()
+
+	return p.c.commit(ctx, p.m)
+}
+
+// Nack writes the consumed message onto the queue's topic again, to be consumed again, and
+// acknowledges it. Kafka can't redeliver a single message of a partition.
+func (b *Broker) Nack(ctx context.Context, msg []byte, queue string) error {
+	p, ok := b.take(queue, msg)
+	if !ok {
+		return fmt.Errorf("message is not pending acknowledgement")
+	}
+	defer p.c.inflight.Done()
+
+	if err := b.writer.WriteMessages(ctx, kafka.Message{Topic: p.m.Topic, Value: p.m.Value}); err != nil {
+		return err
+	}
+
+	return p.c.commit(ctx, p.m)
+}
+
+// take removes and returns the pending delivery of the consumed message.
+func (b *Broker) take(queue string, msg []byte) (pending, bool) {
+	b.um.Lock()
+	defer b.um.Unlock()
+
+	key := delivery{queue: queue, body: string(msg)}
+	ps := b.unacked[key]
+	if len(ps) == 0 {
+		return pending{}, false
+	}
+
+	p := ps[0]
+	if len(ps) == 1 {
+		delete(b.unacked, key)
+	} else {
+		b.unacked[key] = ps[1:]
+	}
+
+	return p, true
+}
+
+// track records the consumed message as waiting to be acknowledged.
+func (c *consumer) track(m kafka.Message) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.offsets[m.Partition] == nil {
+		c.offsets[m.Partition] = make(map[int64]bool)
+	}
+	c.offsets[m.Partition][m.Offset] = false
+	c.inflight.Add(1)
+}
+
+// commit marks the message as acknowledged and commits the offset of its partition up to the
+// oldest message that isn't acknowledged yet, as committing an offset marks all the messages
+// before it as consumed.
+func (c *consumer) commit(ctx context.Context, m kafka.Message) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	offsets := c.offsets[m.Partition]
+	offsets[m.Offset] = true
+
+	var (
+		keys = slices.Sorted(maps.Keys(offsets))
+		last = int64(-1)
+	)
+	for _, o := range keys {
+		if !offsets[o] {
+			break
+		}
+		delete(offsets, o)
+		last = o
+	}
+	if last < 0 {
+		return nil
+	}
+
+	return c.rd.CommitMessages(ctx, kafka.Message{Topic: m.Topic, Partition: m.Partition, Offset: last})
+}
+
+func (b *Broker) GetPending(ctx context.Context, queue string) ([]string, error) {
+	return nil, fmt.Errorf("kafka broker does not support this method")
+}
+
+func (b *Broker) EnqueueScheduled(ctx context.Context, msg []byte, queue string, ts time.Time) error {
+	return fmt.Errorf("kafka broker does not support this method")
+}
+
+// topic returns the topic name for a queue, creating the topic if it doesn't exist.
+func (b *Broker) topic(ctx context.Context, queue string) (string, error) {
+	name := invalidTopicChars.ReplaceAllString(queue, "_")
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.topics[name]; ok {
+		return name, nil
+	}
+
+	res, err := b.client.CreateTopics(ctx, &kafka.CreateTopicsRequest{
+		Topics: []kafka.TopicConfig{{
+			Topic:             name,
+			NumPartitions:     b.opts.Partitions,
+			ReplicationFactor: b.opts.ReplicationFactor,
+		}},
+	})
+	if err != nil {
+		return "", err
+	}
+	if err := res.Errors[name]; err != nil && !errors.Is(err, kafka.TopicAlreadyExists) {
+		return "", err
+	}
+
+	b.topics[name] = struct{}{}
+	return name, nil
+}
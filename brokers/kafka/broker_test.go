@@ -0,0 +1,133 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// fakeReader returns the messages sent on msgs, or errFetch if it is set, and records the commits.
+type fakeReader struct {
+	msgs chan kafka.Message
+
+	mu       sync.Mutex
+	errFetch error
+	fetches  int
+	commits  []int64
+	closed   bool
+}
+
+func (r *fakeReader) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	r.mu.Lock()
+	r.fetches++
+	err := r.errFetch
+	r.mu.Unlock()
+	if err != nil {
+		return kafka.Message{}, err
+	}
+
+	select {
+	case <-ctx.Done():
+		return kafka.Message{}, ctx.Err()
+	case m := <-r.msgs:
+		return m, nil
+	}
+}
+
+func (r *fakeReader) CommitMessages(_ context.Context, msgs ...kafka.Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, m := range msgs {
+		r.commits = append(r.commits, m.Offset)
+	}
+	return nil
+}
+
+func (r *fakeReader) Close() error {
+	r.mu.Lock()
+	r.closed = true
+	r.mu.Unlock()
+	return nil
+}
+
+func TestConsumeCommit(t *testing.T) {
+	var (
+		ctx, cancel = context.WithCancel(context.Background())
+		rd          = &fakeReader{msgs: make(chan kafka.Message, 3)}
+		work        = make(chan []byte)
+		done        = make(chan struct{})
+		b           = &Broker{lo: slog.Default(), unacked: make(map[delivery][]pending)}
+	)
+	defer cancel()
+
+	go func() {
+		b.consume(ctx, rd, work, "q")
+		close(done)
+	}()
+
+	for i, v := range []string{"a", "b", "c"} {
+		rd.msgs <- kafka.Message{Topic: "q", Offset: int64(i), Value: []byte(v)}
+	}
+	var msgs [][]byte
+	for i := 0; i < 3; i++ {
+		msgs = append(msgs, <-work)
+	}
+
+	// Offsets are only committed once the messages before them are acknowledged.
+	for _, i := range []int{1, 2} {
+		if err := b.Ack(ctx, msgs[i], "q"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rd.mu.Lock()
+	if len(rd.commits) != 0 {
+		t.Fatalf("expected no commits before the first message is acknowledged, got %v", rd.commits)
+	}
+	rd.mu.Unlock()
+
+	// The job finishes after the consumer is stopped.
+	cancel()
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case <-done:
+		t.Fatal("consumer returned before the job was acknowledged")
+	default:
+	}
+	if err := b.Ack(context.Background(), msgs[0], "q"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("consumer didn't return once the job was acknowledged")
+	}
+
+	rd.mu.Lock()
+	defer rd.mu.Unlock()
+	if len(rd.commits) != 1 || rd.commits[0] != 2 || !rd.closed {
+		t.Fatalf("expected the offset of the last message to be committed before closing the reader, got %v", rd.commits)
+	}
+}
+
+func TestConsumeBackoff(t *testing.T) {
+	var (
+		ctx, cancel = context.WithTimeout(context.Background(), 500*time.Millisecond)
+		rd          = &fakeReader{errFetch: errors.New("broker unavailable")}
+		b           = &Broker{lo: slog.Default(), unacked: make(map[delivery][]pending)}
+	)
+	defer cancel()
+
+	b.consume(ctx, rd, make(chan []byte), "q")
+
+	// 100ms, 200ms and 400ms apart.
+	rd.mu.Lock()
+	defer rd.mu.Unlock()
+	if rd.fetches > 4 {
+		t.Fatalf("expected the fetches to back off after errors, got %d fetches", rd.fetches)
+	}
+}
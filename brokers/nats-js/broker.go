@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"sync"
 	"time"
 
 	"github.com/nats-io/nats.go"
@@ -14,6 +15,30 @@ type Broker struct {
 	opt  Options
 	log  *slog.Logger
 	conn nats.JetStreamContext
+
+	// unacked holds the consumed messages that haven't been acknowledged yet.
+	mu      sync.Mutex
+	unacked map[delivery][]*nats.Msg
+
+	// consumers holds the running consumer of each subscribed queue. Queues are subscribed
+	// to once, as unsubscribing deletes the durable consumer.
+	cm        sync.Mutex
+	consumers map[string]*consumer
+
+	// ns is the namespace the subjects are prefixed with, if set with SetNamespace().
+	ns string
+}
+
+// consumer is a running Consume() call, which the messages of its queue are passed onto.
+type consumer struct {
+	ctx  context.Context
+	work chan []byte
+}
+
+// delivery identifies a consumed message.
+type delivery struct {
+	queue string
+	body  string
 }
 
 type Options struct {
@@ -56,9 +81,12 @@ func New(cfg Options, lo *slog.Logger) (*Broker, error) {
 	}
 
 	return &Broker{
-		opt:  cfg,
-		conn: js,
-		log:  lo,
+		opt:     cfg,
+		conn:    js,
+		log:     lo,
+		unacked: make(map[delivery][]*nats.Msg),
+
+		consumers: make(map[string]*consumer),
 	}, nil
 }
 
@@ -89,25 +117,154 @@ func New(cfg Options, lo *slog.Logger) (*Broker, error) {
 // 	return nil
 // }
 
+// SetNamespace prefixes the subjects of the queues with the namespace ("<ns>.<queue>"), so that multiple
+// applications can share a nats cluster. The subjects of the streams (Options.Streams) must include the
+// prefix. It is called by the server with ServerOpts.Namespace, before consuming.
+func (b *Broker) SetNamespace(ns string) {
+	b.ns = ns
+}
+
+// subject returns the subject of the queue, prefixed with the namespace if any.
+func (b *Broker) subject(queue string) string {
+	if b.ns == "" {
+		return queue
+	}
+
+	return b.ns + "." + queue
+}
+
+// durable returns the name of the durable consumer of the queue, which can't contain dots.
+func (b *Broker) durable(queue string) string {
+	if b.ns == "" {
+		return queue
+	}
+
+	return b.ns + "_" + queue
+}
+
 func (b *Broker) Enqueue(_ context.Context, msg []byte, queue string) error {
-	if _, err := b.conn.Publish(queue, msg); err != nil {
+	if _, err := b.conn.Publish(b.subject(queue), msg); err != nil {
 		return err
 	}
 	return nil
 }
 
+// EnqueueBatch publishes the messages asynchronously and waits for all of them to be acknowledged.
+func (b *Broker) EnqueueBatch(ctx context.Context, msgs [][]byte, queue string) error {
+	futs := make([]nats.PubAckFuture, 0, len(msgs))
+	for _, msg := range msgs {
+		f, err := b.conn.PublishAsync(b.subject(queue), msg)
+		if err != nil {
+			return err
+		}
+		futs = append(futs, f)
+	}
+
+	select {
+	case <-b.conn.PublishAsyncComplete():
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	for _, f := range futs {
+		select {
+		case err := <-f.Err():
+			return err
+		default:
+		}
+	}
+
+	return nil
+}
+
 func (b *Broker) Consume(ctx context.Context, work chan []byte, queue string) {
-	_, err := b.conn.Subscribe(queue, func(msg *nats.Msg) {
-		work <- msg.Data
-	}, nats.Durable(queue), nats.AckExplicit())
-	if err != nil {
-		b.log.Error("error consuming from nats", "error", err)
+	c := &consumer{ctx: ctx, work: work}
+
+	b.cm.Lock()
+	_, subscribed := b.consumers[queue]
+	b.consumers[queue] = c
+	b.cm.Unlock()
+
+	if !subscribed {
+		_, err := b.conn.Subscribe(b.subject(queue), func(msg *nats.Msg) {
+			b.deliver(queue, msg)
+		}, nats.Durable(b.durable(queue)), nats.AckExplicit())
+		if err != nil {
+			b.log.Error("error consuming from nats", "error", err)
+		}
 	}
 
 	<-ctx.Done()
 	b.log.Debug("shutting down consumer..")
 }
 
+// deliver passes the message onto the latest consumer of the queue. If it has stopped (eg: the
+// server is shutting down), the message is redelivered later.
+func (b *Broker) deliver(queue string, msg *nats.Msg) {
+	b.cm.Lock()
+	c := b.consumers[queue]
+	b.cm.Unlock()
+
+	key := delivery{queue: queue, body: string(msg.Data)}
+	b.mu.Lock()
+	b.unacked[key] = append(b.unacked[key], msg)
+	b.mu.Unlock()
+
+	select {
+	case c.work <- msg.Data:
+		return
+	case <-c.ctx.Done():
+	}
+
+	if m, ok := b.take(queue, msg.Data); ok {
+		if err := m.NakWithDelay(time.Second); err != nil {
+			b.log.Error("error returning message onto queue", "queue", queue, "error", err)
+		}
+	}
+}
+
+// Ack acknowledges the consumed message. Messages that aren't acknowledged are redelivered
+// once the consumer's ack wait expires.
+func (b *Broker) Ack(ctx context.Context, msg []byte, queue string) error {
+	m, ok := b.take(queue, msg)
+	if !ok {
+		return fmt.Errorf("message is not pending acknowledgement")
+	}
+
+	return m.Ack(nats.Context(ctx))
+}
+
+// Nack negatively acknowledges the consumed message, so that it is redelivered right away.
+func (b *Broker) Nack(ctx context.Context, msg []byte, queue string) error {
+	m, ok := b.take(queue, msg)
+	if !ok {
+		return fmt.Errorf("message is not pending acknowledgement")
+	}
+
+	return m.Nak(nats.Context(ctx))
+}
+
+// take removes and returns the consumed message.
+func (b *Broker) take(queue string, msg []byte) (*nats.Msg, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	key := delivery{queue: queue, body: string(msg)}
+	ms := b.unacked[key]
+	if len(ms) == 0 {
+		return nil, false
+	}
+
+	m := ms[0]
+	if len(ms) == 1 {
+		delete(b.unacked, key)
+	} else {
+		b.unacked[key] = ms[1:]
+	}
+
+	return m, true
+}
+
 func (b *Broker) GetPending(ctx context.Context, queue string) ([]string, error) {
 	return nil, fmt.Errorf("nats broker does not support this method")
 }
@@ -0,0 +1,157 @@
+package rabbitmq
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// fakeChannel delivers the messages sent on msgs, and records the acknowledgements.
+type fakeChannel struct {
+	msgs chan amqp.Delivery
+
+	mu     sync.Mutex
+	acks   int
+	nacks  int
+	closed bool
+	// ackedOpen is set if a message was acknowledged while the channel was open.
+	ackedOpen bool
+}
+
+func (c *fakeChannel) Qos(int, int, bool) error { return nil }
+
+func (c *fakeChannel) Consume(string, string, bool, bool, bool, bool, amqp.Table) (<-chan amqp.Delivery, error) {
+	return c.msgs, nil
+}
+
+func (c *fakeChannel) Cancel(string, bool) error {
+	close(c.msgs)
+	return nil
+}
+
+func (c *fakeChannel) Close() error {
+	c.mu.Lock()
+	c.closed = true
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *fakeChannel) Ack(uint64, bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.acks++
+	c.ackedOpen = !c.closed
+	return nil
+}
+
+func (c *fakeChannel) Nack(uint64, bool, bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.nacks++
+	return nil
+}
+
+func (c *fakeChannel) Reject(uint64, bool) error { return nil }
+
+func TestConsumeShutdown(t *testing.T) {
+	var (
+		ctx, cancel = context.WithCancel(context.Background())
+		ch          = &fakeChannel{msgs: make(chan amqp.Delivery, 1)}
+		work        = make(chan []byte)
+		done        = make(chan struct{})
+		b           = &Broker{lo: slog.Default(), opts: Options{Prefetch: 1}, unacked: make(map[delivery][]pending)}
+	)
+	defer cancel()
+
+	go func() {
+		b.consume(ctx, ch, work, "q")
+		close(done)
+	}()
+
+	ch.msgs <- amqp.Delivery{Acknowledger: ch, DeliveryTag: 1, Body: []byte("job")}
+	msg := <-work
+
+	// The job finishes after the consumer is stopped.
+	cancel()
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case <-done:
+		t.Fatal("consumer returned before the job was acknowledged")
+	default:
+	}
+	if err := b.Ack(context.Background(), msg, "q"); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("consumer didn't return once the job was acknowledged")
+	}
+
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	if ch.acks != 1 || ch.nacks != 0 {
+		t.Fatalf("expected the job to be acknowledged once, got %d acks and %d nacks", ch.acks, ch.nacks)
+	}
+	if !ch.ackedOpen || !ch.closed {
+		t.Fatal("expected the channel to be closed after the job was acknowledged")
+	}
+}
+
+func TestConsumeReconnect(t *testing.T) {
+	var (
+		ctx, cancel = context.WithCancel(context.Background())
+		chans       = make(chan *fakeChannel, 2)
+		work        = make(chan []byte)
+		done        = make(chan struct{})
+		b           = &Broker{lo: slog.Default(), opts: Options{Prefetch: 1, ReconnectDelay: 10 * time.Millisecond},
+			unacked: make(map[delivery][]pending)}
+	)
+	defer cancel()
+
+	// The first channel is closed by rabbitmq, the queue is consumed again on the second one.
+	first := &fakeChannel{msgs: make(chan amqp.Delivery)}
+	close(first.msgs)
+	second := &fakeChannel{msgs: make(chan amqp.Delivery, 1)}
+	chans <- first
+	chans <- second
+	go func() {
+		b.consumeLoop(ctx, work, "q", func() (channel, error) {
+			select {
+			case ch := <-chans:
+				return ch, nil
+			default:
+				return nil, errors.New("connection closed")
+			}
+		})
+		close(done)
+	}()
+
+	second.msgs <- amqp.Delivery{Acknowledger: second, DeliveryTag: 1, Body: []byte("job")}
+	select {
+	case msg := <-work:
+		if err := b.Ack(ctx, msg, "q"); err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("queue wasn't consumed again on a new channel")
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("consumer didn't return once ctx was cancelled")
+	}
+	first.mu.Lock()
+	defer first.mu.Unlock()
+	if !first.closed {
+		t.Fatal("expected the closed channel to be closed by the consumer")
+	}
+}
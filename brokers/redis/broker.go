@@ -5,15 +5,30 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"sort"
 	"strconv"
+	"sync"
 	"time"
 
+	"github.com/kalbhor/tasqueue/v2/internal/redispipe"
 	"github.com/redis/go-redis/v9"
 )
 
 const (
 	DefaultPollPeriod = time.Second
 	sortedSetKey      = "tasqueue:ss:%s"
+	priorityKey       = "%s:p:%d"
+	rateLimitKey      = "tasqueue:rl:%s"
+	processingKey     = "%s:processing"
+	deadlinesKey      = "%s:processing:deadlines"
+	pausedKey         = "tasqueue:paused"
+	semaphoreKey      = "tasqueue:sem:%s"
+
+	// Maximum number of scheduled tasks fetched at once
+	scheduledBatch = 100
+
+	// Maximum number of messages pushed by a single LPUSH in EnqueueBatch
+	enqueueBatch = 1000
 )
 
 type Options struct {
@@ -27,104 +42,448 @@ type Options struct {
 	MinIdleConns int
 	PollPeriod   time.Duration
 
+	// OPTIONAL
+	// Connection pool tuning. Zero values fall back to the go-redis defaults.
+	PoolSize    int
+	MaxRetries  int
+	PoolTimeout time.Duration
+
+	// OPTIONAL
+	// MasterName is the name of the master monitored by redis Sentinel, in which case `Addrs` are
+	// the addresses of the sentinels. SentinelPassword is the password of the sentinels, if any.
+	MasterName       string
+	SentinelPassword string
+
+	// OPTIONAL
+	// If true, the queue names are wrapped in a hash tag (eg: "{default}:processing") so that the keys
+	// of a queue (its priority, scheduled and processing lists), which are used together, land on the
+	// same slot of a redis cluster. It changes the key names, so queues should be drained before it is set.
+	HashTags bool
+
+	// OPTIONAL
+	// PrioritySteps are the priority levels that are honoured, each backed by a separate list.
+	// Jobs are pushed onto the list of the highest step lower than or equal to their priority,
+	// and lists are consumed in order of their step. eg: []uint8{0, 3, 6, 9}
+	// If empty, priorities are ignored.
+	PrioritySteps []uint8
+
+	// OPTIONAL
+	// VisibilityTimeout enables acknowledgements, guaranteeing at-least-once delivery. Consumed
+	// messages are held in a processing list until they are acknowledged, and are returned onto the
+	// queue if they aren't acknowledged within the timeout (eg: if the consuming server crashed).
+	// It should be longer than the time taken to process any job. If zero, consumed messages are
+	// removed from the queue right away.
+	// With PrioritySteps, the higher priority lists are checked every `PollPeriod` while waiting
+	// on an empty queue, and messages that aren't acknowledged are returned onto the lowest step.
+	VisibilityTimeout time.Duration
+
+	// OPTIONAL
+	// Streams backs the queues with redis streams consumed by a consumer group (XADD/XREADGROUP/XACK)
+	// instead of lists, for durable delivery: consumed entries are pending on their consumer until they
+	// are acknowledged, and are claimed by another consumer (XAUTOCLAIM) if they are left idle for more than
+	// `VisibilityTimeout` (defaults to DefaultStreamClaimTimeout). Acknowledged entries are kept on the
+	// stream, trimmed to about `StreamMaxLen` entries, and can be processed again with Replay().
+	// PrioritySteps are ignored. Queues are not migrated between the modes, so they should be drained
+	// before it is changed.
+	Streams bool
+
+	// StreamGroup is the consumer group of the servers sharing the queues (defaults to DefaultStreamGroup),
+	// and StreamConsumer the name of the broker in the group, which must be unique to the process and
+	// should be stable across its restarts (defaults to "<hostname>-<pid>").
+	StreamGroup    string
+	StreamConsumer string
+
+	// StreamMaxLen is the approximate number of entries the streams are trimmed to (defaults to DefaultStreamMaxLen).
+	StreamMaxLen int64
+
 	// OPTIONAL
 	// If non-zero, enqueue redis commands will be piped instead of being directly sent each time.
-	// The pipe will be executed every `PipePeriod` duration.
-	PipePeriod time.Duration
+	// The pipe will be executed every `PipePeriod` duration, or once it holds `PipeMaxCommands`
+	// commands if set. Enqueues wait for the pipe to be executed and return the errors of their commands.
+	PipePeriod      time.Duration
+	PipeMaxCommands int
 }
 
+// rateLimitScript implements a token bucket refilled at ARGV[1] tokens per second,
+// holding up to ARGV[2] tokens. It takes a token and returns 0, or returns the number
+// of seconds to wait for a token to be available.
+var rateLimitScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local t = redis.call("TIME")
+local now = tonumber(t[1]) + tonumber(t[2]) / 1000000
+
+local b = redis.call("HMGET", KEYS[1], "tokens", "ts")
+local tokens = tonumber(b[1]) or burst
+local ts = tonumber(b[2]) or now
+tokens = math.min(burst, tokens + (now - ts) * rate)
+
+local wait = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+else
+	wait = (1 - tokens) / rate
+end
+
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "ts", tostring(now))
+redis.call("EXPIRE", KEYS[1], math.ceil(burst / rate) + 1)
+return tostring(wait)
+`)
+
+// reapScript returns the messages in the processing list (KEYS[1]) whose deadline in KEYS[2] has passed
+// (ARGV[1]) onto the queue (KEYS[3]). Messages without a deadline, consumed by a server that crashed
+// before setting it, are given the deadline ARGV[2]. It returns the number of messages returned.
+var reapScript = redis.NewScript(`
+for _, m in ipairs(redis.call("LRANGE", KEYS[1], 0, -1)) do
+	redis.call("ZADD", KEYS[2], "NX", ARGV[2], m)
+end
+local n = 0
+for _, m in ipairs(redis.call("ZRANGEBYSCORE", KEYS[2], "-inf", ARGV[1])) do
+	if redis.call("LREM", KEYS[1], 1, m) > 0 then
+		redis.call("LPUSH", KEYS[3], m)
+		n = n + 1
+	end
+	redis.call("ZREM", KEYS[2], m)
+end
+return n
+`)
+
+// nackScript returns the message ARGV[1] from the processing list (KEYS[1]) onto the queue (KEYS[3]),
+// unless it has already been returned by the reaper.
+var nackScript = redis.NewScript(`
+if redis.call("LREM", KEYS[1], 1, ARGV[1]) > 0 then
+	redis.call("LPUSH", KEYS[3], ARGV[1])
+end
+redis.call("ZREM", KEYS[2], ARGV[1])
+return 0
+`)
+
+// moveScheduledScript moves up to ARGV[2] messages of the sorted set (KEYS[1]) scheduled before ARGV[1]
+// onto the queue (KEYS[2]): its list, or its stream if ARGV[3] is set, trimmed to about ARGV[4] entries
+// (if non-zero) with the message in the field ARGV[5]. It returns the number of messages moved.
+var moveScheduledScript = redis.NewScript(`
+local msgs = redis.call("ZRANGEBYSCORE", KEYS[1], "0", ARGV[1], "LIMIT", 0, ARGV[2])
+for _, m in ipairs(msgs) do
+	redis.call("ZREM", KEYS[1], m)
+	if ARGV[3] == "1" then
+		if tonumber(ARGV[4]) > 0 then
+			redis.call("XADD", KEYS[2], "MAXLEN", "~", ARGV[4], "*", ARGV[5], m)
+		else
+			redis.call("XADD", KEYS[2], "*", ARGV[5], m)
+		end
+	else
+		redis.call("LPUSH", KEYS[2], m)
+	end
+end
+return #msgs
+`)
+
+// acquireScript takes one of ARGV[2] slots of the semaphore (KEYS[1]) for the token ARGV[1], leased for ARGV[3] ms,
+// or extends the lease of the token's slot. Expired leases are removed first. It returns 1 if the slot was taken.
+var acquireScript = redis.NewScript(`
+local t = redis.call("TIME")
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", now)
+if not redis.call("ZSCORE", KEYS[1], ARGV[1]) and redis.call("ZCARD", KEYS[1]) >= tonumber(ARGV[2]) then
+	return 0
+end
+redis.call("ZADD", KEYS[1], now + tonumber(ARGV[3]), ARGV[1])
+redis.call("PEXPIRE", KEYS[1], ARGV[3])
+return 1
+`)
+
 type Broker struct {
 	lo   *slog.Logger
 	opts Options
 
 	conn redis.UniversalClient
-	pipe redis.Pipeliner
+	pipe *redispipe.Pipe
+
+	// ns is the namespace the keys are prefixed with, if set with SetNamespace().
+	ns string
+
+	// delivered holds the ids of the stream entries of the consumed messages, to acknowledge them
+	// (queue and message -> ids), in Streams mode.
+	mu        sync.Mutex
+	delivered map[string][]string
 }
 
 func New(o Options, lo *slog.Logger) *Broker {
 	if o.PollPeriod == 0 {
 		o.PollPeriod = DefaultPollPeriod
 	}
+	if o.Streams {
+		if o.VisibilityTimeout == 0 {
+			o.VisibilityTimeout = DefaultStreamClaimTimeout
+		}
+		if o.StreamGroup == "" {
+			o.StreamGroup = DefaultStreamGroup
+		}
+		if o.StreamConsumer == "" {
+			o.StreamConsumer = defaultConsumer()
+		}
+		if o.StreamMaxLen == 0 {
+			o.StreamMaxLen = DefaultStreamMaxLen
+		}
+	}
 	b := &Broker{
 		opts: o,
 		lo:   lo,
 		conn: redis.NewUniversalClient(&redis.UniversalOptions{
-			Addrs:           o.Addrs,
-			DB:              o.DB,
-			Password:        o.Password,
-			DialTimeout:     o.DialTimeout,
-			ReadTimeout:     o.ReadTimeout,
-			WriteTimeout:    o.WriteTimeout,
-			MinIdleConns:    o.MinIdleConns,
-			ConnMaxIdleTime: o.IdleTimeout,
+			Addrs:            o.Addrs,
+			DB:               o.DB,
+			Password:         o.Password,
+			DialTimeout:      o.DialTimeout,
+			ReadTimeout:      o.ReadTimeout,
+			WriteTimeout:     o.WriteTimeout,
+			MinIdleConns:     o.MinIdleConns,
+			ConnMaxIdleTime:  o.IdleTimeout,
+			PoolSize:         o.PoolSize,
+			MaxRetries:       o.MaxRetries,
+			PoolTimeout:      o.PoolTimeout,
+			MasterName:       o.MasterName,
+			SentinelPassword: o.SentinelPassword,
 		}),
 	}
 
 	if o.PipePeriod != 0 {
-		b.pipe = b.conn.Pipeline()
-		go b.execPipe(context.TODO())
+		b.pipe = redispipe.New(b.conn, o.PipeMaxCommands)
+		go b.pipe.Run(context.TODO(), o.PipePeriod, b.pipeFlushed)
 	}
 
 	return b
 }
 
-func (r *Broker) execPipe(ctx context.Context) {
-	tk := time.NewTicker(r.opts.PipePeriod)
-	for {
-		select {
-		case <-ctx.Done():
-			r.lo.Debug("context closed, draining redis pipe", "length", r.pipe.Len())
-			if _, err := r.pipe.Exec(ctx); err != nil {
-				r.lo.Error("could not execute redis pipe", "error", err)
-			}
-			return
-		case <-tk.C:
-			plen := r.pipe.Len()
-			if plen == 0 {
-				continue
-			}
-			r.lo.Debug("submitting redis pipe", "length", plen)
-			if _, err := r.pipe.Exec(ctx); err != nil {
-				r.lo.Error("could not execute redis pipe", "error", err)
-			}
-		}
+// pipeFlushed logs the execution of the pipe.
+func (r *Broker) pipeFlushed(cmds []redis.Cmder, err error) {
+	if len(cmds) == 0 {
+		return
 	}
+	r.lo.Debug("submitted redis pipe", "length", len(cmds))
+	if err != nil {
+		r.lo.Error("could not execute redis pipe", "error", err)
+	}
+}
+
+// exec runs the enqueue commands added by fn on the pipe if `PipePeriod` is set, otherwise directly.
+func (b *Broker) exec(ctx context.Context, fn func(redis.Cmdable) error) error {
+	if b.pipe == nil {
+		return fn(b.conn)
+	}
+
+	var err error
+	if perr := b.pipe.Do(ctx, func(p redis.Pipeliner) { err = fn(p) }); perr != nil {
+		return perr
+	}
+
+	return err
+}
+
+// execBatch runs the commands added by fn in a single round trip, on the pipe if `PipePeriod` is set.
+func (b *Broker) execBatch(ctx context.Context, fn func(redis.Pipeliner)) error {
+	if b.pipe != nil {
+		return b.pipe.Do(ctx, fn)
+	}
+
+	_, err := b.conn.Pipelined(ctx, func(p redis.Pipeliner) error {
+		fn(p)
+		return nil
+	})
+	return err
 }
 
 func (r *Broker) GetPending(ctx context.Context, queue string) ([]string, error) {
-	rs, err := r.conn.LRange(ctx, queue, 0, -1).Result()
-	if err == redis.Nil {
+	if r.opts.Streams {
+		return r.getPendingStream(ctx, queue)
+	}
+
+	var pending []string
+	for _, key := range r.queueKeys(queue) {
+		rs, err := r.conn.LRange(ctx, key, 0, -1).Result()
+		if err == redis.Nil {
+			continue
+		} else if err != nil {
+			return []string{}, err
+		}
+		pending = append(pending, rs...)
+	}
+	if pending == nil {
 		return []string{}, nil
-	} else if err != nil {
-		return []string{}, err
 	}
 
-	return rs, nil
+	return pending, nil
+}
+
+// QueueDepth returns the number of messages pending on the queue (including its priority lists) and the
+// number of messages scheduled onto it, without fetching them.
+func (b *Broker) QueueDepth(ctx context.Context, queue string) (int, int, error) {
+	scheduled, err := b.conn.ZCard(ctx, b.key(fmt.Sprintf(sortedSetKey, b.tag(queue)))).Result()
+	if err != nil {
+		return 0, 0, err
+	}
+	if b.opts.Streams {
+		pending, err := b.depthStream(ctx, queue)
+		return pending, int(scheduled), err
+	}
+
+	var cmds []*redis.IntCmd
+	if _, err := b.conn.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for _, key := range b.queueKeys(queue) {
+			cmds = append(cmds, p.LLen(ctx, key))
+		}
+		return nil
+	}); err != nil {
+		return 0, 0, err
+	}
+
+	var pending int64
+	for _, c := range cmds {
+		pending += c.Val()
+	}
+
+	return int(pending), int(scheduled), nil
 }
 
 func (b *Broker) Enqueue(ctx context.Context, msg []byte, queue string) error {
-	if b.opts.PipePeriod != 0 {
-		return b.pipe.LPush(ctx, queue, msg).Err()
+	return b.exec(ctx, func(c redis.Cmdable) error {
+		if b.opts.Streams {
+			return b.xadd(ctx, c, msg, queue).Err()
+		}
+		return c.LPush(ctx, b.key(b.tag(queue)), msg).Err()
+	})
+}
+
+// EnqueueBatch pushes the messages onto the queue in a single round trip, in order.
+func (b *Broker) EnqueueBatch(ctx context.Context, msgs [][]byte, queue string) error {
+	return b.execBatch(ctx, func(p redis.Pipeliner) {
+		if b.opts.Streams {
+			for _, m := range msgs {
+				b.xadd(ctx, p, m, queue)
+			}
+			return
+		}
+		for i := 0; i < len(msgs); i += enqueueBatch {
+			chunk := msgs[i:min(i+enqueueBatch, len(msgs))]
+			vals := make([]interface{}, len(chunk))
+			for j, m := range chunk {
+				vals[j] = m
+			}
+			p.LPush(ctx, b.key(b.tag(queue)), vals...)
+		}
+	})
+}
+
+// EnqueuePriority pushes the message onto the list of the queue's priority step matching the priority.
+func (b *Broker) EnqueuePriority(ctx context.Context, msg []byte, queue string, priority uint8) error {
+	var step uint8
+	for _, s := range b.opts.PrioritySteps {
+		if s <= priority && s > step {
+			step = s
+		}
+	}
+	if step == 0 || b.opts.Streams {
+		return b.Enqueue(ctx, msg, queue)
+	}
+
+	key := b.key(fmt.Sprintf(priorityKey, b.tag(queue), step))
+	return b.exec(ctx, func(c redis.Cmdable) error {
+		return c.LPush(ctx, key, msg).Err()
+	})
+}
+
+// queueKeys returns the lists backing the queue, from the highest priority step to the lowest.
+func (b *Broker) queueKeys(queue string) []string {
+	steps := make([]int, 0, len(b.opts.PrioritySteps))
+	for _, s := range b.opts.PrioritySteps {
+		if s != 0 {
+			steps = append(steps, int(s))
+		}
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(steps)))
+
+	keys := make([]string, 0, len(steps)+1)
+	for _, s := range steps {
+		keys = append(keys, b.key(fmt.Sprintf(priorityKey, b.tag(queue), s)))
 	}
-	return b.conn.LPush(ctx, queue, msg).Err()
+
+	return append(keys, b.key(b.tag(queue)))
 }
 
 func (b *Broker) EnqueueScheduled(ctx context.Context, msg []byte, queue string, ts time.Time) error {
-	if b.opts.PipePeriod != 0 {
-		return b.pipe.ZAdd(ctx, fmt.Sprintf(sortedSetKey, queue), redis.Z{
+	return b.exec(ctx, func(c redis.Cmdable) error {
+		return c.ZAdd(ctx, b.key(fmt.Sprintf(sortedSetKey, b.tag(queue))), redis.Z{
 			Score:  float64(ts.UnixNano()),
 			Member: msg,
 		}).Err()
+	})
+}
+
+// Remove removes a pending message from the queue (including its priority lists).
+func (b *Broker) Remove(ctx context.Context, msg []byte, queue string) error {
+	if b.opts.Streams {
+		return b.removeStream(ctx, msg, queue)
 	}
-	return b.conn.ZAdd(ctx, fmt.Sprintf(sortedSetKey, queue), redis.Z{
-		Score:  float64(ts.UnixNano()),
-		Member: msg,
-	}).Err()
+
+	for _, key := range b.queueKeys(queue) {
+		n, err := b.conn.LRem(ctx, key, 1, msg).Result()
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// Purge removes all the pending messages from the queue (including its priority lists).
+func (b *Broker) Purge(ctx context.Context, queue string) error {
+	if b.opts.Streams {
+		return b.removeStream(ctx, nil, queue)
+	}
+
+	// Delete the keys separately, as they may belong to different cluster slots.
+	for _, key := range b.queueKeys(queue) {
+		if err := b.conn.Del(ctx, key).Err(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// PauseQueue adds the queue to the set of paused queues, which the servers sharing the broker
+// stop consuming.
+func (b *Broker) PauseQueue(ctx context.Context, queue string) error {
+	return b.conn.SAdd(ctx, b.key(pausedKey), queue).Err()
+}
+
+func (b *Broker) ResumeQueue(ctx context.Context, queue string) error {
+	return b.conn.SRem(ctx, b.key(pausedKey), queue).Err()
+}
+
+func (b *Broker) IsPaused(ctx context.Context, queue string) (bool, error) {
+	return b.conn.SIsMember(ctx, b.key(pausedKey), queue).Result()
 }
 
 func (b *Broker) Consume(ctx context.Context, work chan []byte, queue string) {
 	go b.consumeScheduled(ctx, queue)
 
+	if b.opts.Streams {
+		b.consumeStream(ctx, work, queue)
+		return
+	}
+
+	if b.opts.VisibilityTimeout != 0 {
+		go b.reap(ctx, queue)
+		b.consumeAck(ctx, work, queue)
+		return
+	}
+
+	// BLPop checks the keys in order, so higher priority lists are consumed first.
+	keys := b.queueKeys(queue)
+
 	for {
 		select {
 		case <-ctx.Done():
@@ -132,7 +491,7 @@ func (b *Broker) Consume(ctx context.Context, work chan []byte, queue string) {
 			return
 		default:
 			b.lo.Debug("receiving from consumer..")
-			res, err := b.conn.BLPop(ctx, b.opts.PollPeriod, queue).Result()
+			res, err := b.conn.BLPop(ctx, b.opts.PollPeriod, keys...).Result()
 			if err != nil && err.Error() != "redis: nil" {
 				b.lo.Error("error consuming from redis queue", "error", err)
 			} else if errors.Is(err, redis.Nil) {
@@ -143,7 +502,133 @@ func (b *Broker) Consume(ctx context.Context, work chan []byte, queue string) {
 					b.lo.Error("error parsing response from redis", "error", err)
 					return
 				}
-				work <- []byte(msg)
+				select {
+				case work <- []byte(msg):
+				case <-ctx.Done():
+					// Return the message onto the list it was popped from, so that it isn't lost.
+					if err := b.conn.LPush(context.WithoutCancel(ctx), res[0], msg).Err(); err != nil {
+						b.lo.Error("error returning message onto queue", "queue", queue, "error", err)
+					}
+					b.lo.Debug("shutting down consumer..")
+					return
+				}
+			}
+		}
+	}
+}
+
+// consumeAck consumes messages by moving them onto the queue's processing list, where they
+// are held until acknowledged.
+func (b *Broker) consumeAck(ctx context.Context, work chan []byte, queue string) {
+	var (
+		keys = b.queueKeys(queue)
+		proc = b.key(fmt.Sprintf(processingKey, b.tag(queue)))
+	)
+
+	for {
+		select {
+		case <-ctx.Done():
+			b.lo.Debug("shutting down consumer..")
+			return
+		default:
+		}
+
+		b.lo.Debug("receiving from consumer..")
+		msg, err := b.moveNext(ctx, keys, proc)
+		if errors.Is(err, redis.Nil) {
+			b.lo.Debug("no tasks to consume..", "queue", queue)
+			continue
+		} else if err != nil {
+			b.lo.Error("error consuming from redis queue", "error", err)
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			if err := b.Nack(context.WithoutCancel(ctx), []byte(msg), queue); err != nil {
+				b.lo.Error("error returning message onto queue", "queue", queue, "error", err)
+			}
+			b.lo.Debug("shutting down consumer..")
+			return
+		case work <- []byte(msg):
+		}
+
+		// Start the visibility timeout once a processor has picked up the message.
+		deadline := time.Now().Add(b.opts.VisibilityTimeout).UnixNano()
+		if err := b.conn.ZAdd(ctx, b.key(fmt.Sprintf(deadlinesKey, b.tag(queue))), redis.Z{Score: float64(deadline), Member: msg}).Err(); err != nil {
+			b.lo.Error("error setting message visibility timeout", "queue", queue, "error", err)
+		}
+	}
+}
+
+// moveNext moves the next message onto the processing list, checking the priority lists in order
+// before waiting on the base list of the queue for up to `PollPeriod`.
+func (b *Broker) moveNext(ctx context.Context, keys []string, proc string) (string, error) {
+	for _, key := range keys[:len(keys)-1] {
+		msg, err := b.conn.LMove(ctx, key, proc, "LEFT", "RIGHT").Result()
+		if err == nil || !errors.Is(err, redis.Nil) {
+			return msg, err
+		}
+	}
+
+	return b.conn.BLMove(ctx, keys[len(keys)-1], proc, "LEFT", "RIGHT", b.opts.PollPeriod).Result()
+}
+
+// Ack removes the message from the queue's processing list, or acknowledges its stream entry in Streams mode.
+// It is a no-op if `VisibilityTimeout` is not set.
+func (b *Broker) Ack(ctx context.Context, msg []byte, queue string) error {
+	if b.opts.Streams {
+		return b.ackStream(ctx, msg, queue)
+	}
+	if b.opts.VisibilityTimeout == 0 {
+		return nil
+	}
+
+	_, err := b.conn.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.LRem(ctx, b.key(fmt.Sprintf(processingKey, b.tag(queue))), 1, msg)
+		p.ZRem(ctx, b.key(fmt.Sprintf(deadlinesKey, b.tag(queue))), msg)
+		return nil
+	})
+	return err
+}
+
+// Nack returns the message from the queue's processing list onto the queue. It is a no-op
+// if `VisibilityTimeout` is not set.
+func (b *Broker) Nack(ctx context.Context, msg []byte, queue string) error {
+	if b.opts.Streams {
+		return b.nackStream(ctx, msg, queue)
+	}
+	if b.opts.VisibilityTimeout == 0 {
+		return nil
+	}
+
+	return nackScript.Run(ctx, b.conn, []string{
+		b.key(fmt.Sprintf(processingKey, b.tag(queue))), b.key(fmt.Sprintf(deadlinesKey, b.tag(queue))), b.key(b.tag(queue)),
+	}, msg).Err()
+}
+
+// reap periodically returns the messages whose visibility timeout has expired onto the queue.
+func (b *Broker) reap(ctx context.Context, queue string) {
+	tk := time.NewTicker(b.opts.PollPeriod)
+	defer tk.Stop()
+
+	keys := []string{b.key(fmt.Sprintf(processingKey, b.tag(queue))), b.key(fmt.Sprintf(deadlinesKey, b.tag(queue))), b.key(b.tag(queue))}
+	for {
+		select {
+		case <-ctx.Done():
+			b.lo.Debug("shutting down reaper..")
+			return
+		case <-tk.C:
+			now := time.Now()
+			n, err := reapScript.Run(ctx, b.conn, keys, now.UnixNano(), now.Add(b.opts.VisibilityTimeout).UnixNano()).Int()
+			if err != nil {
+				if ctx.Err() == nil {
+					b.lo.Error("error returning expired messages onto queue", "queue", queue, "error", err)
+				}
+				continue
+			}
+			if n > 0 {
+				b.lo.Info("returned messages with expired visibility timeout onto queue", "queue", queue, "count", n)
 			}
 		}
 	}
@@ -158,35 +643,142 @@ func (b *Broker) consumeScheduled(ctx context.Context, queue string) {
 			b.lo.Debug("shutting down scheduled consumer..")
 			return
 		case <-poll.C:
-			b.conn.Watch(ctx, func(tx *redis.Tx) error {
-				// Fetch the tasks with score less than current time. These tasks have been scheduled
-				// to be queued.
-				tasks, err := tx.ZRevRangeByScore(ctx, fmt.Sprintf(sortedSetKey, queue), &redis.ZRangeBy{
-					Min:    "0",
-					Max:    strconv.FormatInt(time.Now().UnixNano(), 10),
-					Offset: 0,
-					Count:  1,
-				}).Result()
-				if err != nil {
-					return err
-				}
+			if err := b.moveScheduled(ctx, queue); err != nil {
+				b.lo.Error("error moving scheduled tasks", "queue", queue, "error", err)
+			}
+		}
+	}
+}
 
-				for _, task := range tasks {
-					if err := b.Enqueue(ctx, []byte(task), queue); err != nil {
-						return err
-					}
-				}
+// moveScheduled moves the scheduled tasks that are due onto the queue. Tasks are removed from
+// the sorted set and enqueued atomically, so that they are neither lost nor enqueued twice by
+// concurrent consumers.
+func (b *Broker) moveScheduled(ctx context.Context, queue string) error {
+	var (
+		key     = b.key(fmt.Sprintf(sortedSetKey, b.tag(queue)))
+		dest    = b.key(b.tag(queue))
+		streams = "0"
+	)
+	if b.opts.Streams {
+		dest, streams = b.streamKey(queue), "1"
+	}
 
-				// Remove the tasks
-				if err := tx.ZRem(ctx, fmt.Sprintf(sortedSetKey, queue), tasks).Err(); err != nil {
-					return err
-				}
+	for {
+		// Move the tasks with score less than current time. These tasks have been scheduled
+		// to be queued.
+		n, err := moveScheduledScript.Run(ctx, b.conn, []string{key, dest},
+			time.Now().UnixNano(), scheduledBatch, streams, b.opts.StreamMaxLen, streamField).Int()
+		if err != nil {
+			return err
+		}
 
-				return nil
-			})
+		if n < scheduledBatch {
+			return nil
 		}
+	}
+}
 
+// Allow takes a token from the rate limit bucket identified by key, shared by all the
+// servers using this redis. If no token is available, it returns the duration to wait.
+func (b *Broker) Allow(ctx context.Context, key string, rate float64, burst int) (time.Duration, error) {
+	res, err := rateLimitScript.Run(ctx, b.conn, []string{b.key(fmt.Sprintf(rateLimitKey, key))}, rate, burst).Text()
+	if err != nil {
+		return 0, err
 	}
+
+	wait, err := strconv.ParseFloat(res, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	return time.Duration(wait * float64(time.Second)), nil
+}
+
+// SetNamespace prefixes all the keys with the namespace ("<ns>:"), so that multiple applications can
+// share a redis instance. It is called by the server with ServerOpts.Namespace, before consuming.
+func (b *Broker) SetNamespace(ns string) {
+	b.ns = ns
+}
+
+// Acquire takes one of the limit slots of the semaphore for the token, leased until ttl elapses, or
+// extends its lease if it already holds one. It returns false if no slot is free.
+func (b *Broker) Acquire(ctx context.Context, key, token string, limit int, ttl time.Duration) (bool, error) {
+	n, err := acquireScript.Run(ctx, b.conn, []string{b.key(fmt.Sprintf(semaphoreKey, key))}, token, limit, ttl.Milliseconds()).Int()
+	if err != nil {
+		return false, err
+	}
+
+	return n == 1, nil
+}
+
+// Release frees the semaphore slot held by the token, if any.
+func (b *Broker) Release(ctx context.Context, key, token string) error {
+	return b.conn.ZRem(ctx, b.key(fmt.Sprintf(semaphoreKey, key)), token).Err()
+}
+
+// tag returns the queue's name as used in its keys, wrapped in a hash tag with HashTags.
+func (b *Broker) tag(queue string) string {
+	if !b.opts.HashTags {
+		return queue
+	}
+
+	return "{" + queue + "}"
+}
+
+// key returns the key prefixed with the namespace, if any.
+func (b *Broker) key(k string) string {
+	if b.ns == "" {
+		return k
+	}
+
+	return b.ns + ":" + k
+}
+
+// MigrateNamespace moves the keys of the queues (pending, priority, scheduled and processing messages and
+// the paused state) stored without a namespace onto the namespace set with SetNamespace(), returning the
+// number of keys moved. Keys that already exist in the namespace are left as is. The servers using the
+// queues should be stopped while migrating. On a redis cluster, both keys of a move must be in the same slot.
+func (b *Broker) MigrateNamespace(ctx context.Context, queues ...string) (int, error) {
+	if b.ns == "" {
+		return 0, nil
+	}
+
+	var count int
+	for _, queue := range queues {
+		keys := []string{b.tag(queue), fmt.Sprintf(sortedSetKey, b.tag(queue)), fmt.Sprintf(processingKey, b.tag(queue)), fmt.Sprintf(deadlinesKey, b.tag(queue)), fmt.Sprintf(streamKey, b.tag(queue))}
+		for _, s := range b.opts.PrioritySteps {
+			if s != 0 {
+				keys = append(keys, fmt.Sprintf(priorityKey, b.tag(queue), s))
+			}
+		}
+
+		for _, k := range keys {
+			ok, err := b.conn.RenameNX(ctx, k, b.key(k)).Result()
+			if err != nil && err.Error() != "ERR no such key" {
+				return count, err
+			}
+			if ok {
+				count++
+			}
+		}
+
+		paused, err := b.conn.SIsMember(ctx, pausedKey, queue).Result()
+		if err != nil {
+			return count, err
+		}
+		if paused {
+			if err := b.conn.SAdd(ctx, b.key(pausedKey), queue).Err(); err != nil {
+				return count, err
+			}
+			if err := b.conn.SRem(ctx, pausedKey, queue).Err(); err != nil {
+				return count, err
+			}
+			count++
+		}
+	}
+
+	b.lo.Info("migrated queues onto namespace", "namespace", b.ns, "count", count)
+	return count, nil
 }
 
 func blpopResult(rs []string) (string, error) {
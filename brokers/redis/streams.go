@@ -0,0 +1,360 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	streamKey = "%s:stream"
+
+	// Field of the stream entries holding the message.
+	streamField = "msg"
+
+	DefaultStreamGroup  = "tasqueue"
+	DefaultStreamMaxLen = 100000
+
+	// DefaultStreamClaimTimeout is the idle time after which unacknowledged stream entries are claimed
+	// by another consumer, if VisibilityTimeout is not set.
+	DefaultStreamClaimTimeout = 5 * time.Minute
+
+	// Maximum number of idle entries claimed at once
+	claimBatch = 10
+)
+
+// streamKey returns the stream backing the queue in Streams mode.
+func (b *Broker) streamKey(queue string) string {
+	return b.key(fmt.Sprintf(streamKey, b.tag(queue)))
+}
+
+// xadd returns the command appending the message onto the queue's stream, trimmed to about `StreamMaxLen` entries.
+func (b *Broker) xadd(ctx context.Context, c redis.Cmdable, msg []byte, queue string) *redis.StringCmd {
+	return c.XAdd(ctx, &redis.XAddArgs{
+		Stream: b.streamKey(queue),
+		MaxLen: b.opts.StreamMaxLen,
+		Approx: true,
+		Values: []interface{}{streamField, msg},
+	})
+}
+
+// createGroup creates the consumer group of the queue's stream (and the stream), if it doesn't exist.
+// The group starts at the beginning of the stream, so that the entries added before it are consumed.
+func (b *Broker) createGroup(ctx context.Context, queue string) error {
+	err := b.conn.XGroupCreateMkStream(ctx, b.streamKey(queue), b.opts.StreamGroup, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return err
+	}
+
+	return nil
+}
+
+// consumeStream consumes the queue's stream as a member of the consumer group. Every `PollPeriod`,
+// the entries left unacknowledged by a consumer for longer than `VisibilityTimeout` (eg: one that crashed)
+// are claimed and consumed again.
+func (b *Broker) consumeStream(ctx context.Context, work chan []byte, queue string) {
+	key := b.streamKey(queue)
+	if err := b.createGroup(ctx, queue); err != nil {
+		b.lo.Error("error creating consumer group", "queue", queue, "error", err)
+	}
+
+	var (
+		cursor    = "0-0"
+		lastClaim time.Time
+	)
+	for {
+		select {
+		case <-ctx.Done():
+			b.lo.Debug("shutting down consumer..")
+			return
+		default:
+		}
+
+		var (
+			entries []redis.XMessage
+			err     error
+		)
+		if time.Since(lastClaim) >= b.opts.PollPeriod {
+			lastClaim = time.Now()
+			entries, cursor, err = b.conn.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+				Stream:   key,
+				Group:    b.opts.StreamGroup,
+				Consumer: b.opts.StreamConsumer,
+				MinIdle:  b.opts.VisibilityTimeout,
+				Start:    cursor,
+				Count:    claimBatch,
+			}).Result()
+			if err != nil && ctx.Err() == nil {
+				b.lo.Error("error claiming idle stream entries", "queue", queue, "error", err)
+			}
+			if cursor == "" {
+				cursor = "0-0"
+			}
+			if len(entries) > 0 {
+				b.lo.Info("claimed unacknowledged stream entries", "queue", queue, "count", len(entries))
+			}
+		}
+
+		if len(entries) == 0 {
+			b.lo.Debug("receiving from consumer..")
+			res, err := b.conn.XReadGroup(ctx, &redis.XReadGroupArgs{
+				Group:    b.opts.StreamGroup,
+				Consumer: b.opts.StreamConsumer,
+				Streams:  []string{key, ">"},
+				Count:    1,
+				Block:    b.opts.PollPeriod,
+			}).Result()
+			switch {
+			case errors.Is(err, redis.Nil):
+				b.lo.Debug("no tasks to consume..", "queue", queue)
+				continue
+			case err != nil && strings.HasPrefix(err.Error(), "NOGROUP"):
+				// The stream was deleted.
+				if err := b.createGroup(ctx, queue); err != nil {
+					b.lo.Error("error creating consumer group", "queue", queue, "error", err)
+				}
+				continue
+			case err != nil:
+				if ctx.Err() == nil {
+					b.lo.Error("error consuming from redis stream", "queue", queue, "error", err)
+				}
+				continue
+			}
+			for _, s := range res {
+				entries = append(entries, s.Messages...)
+			}
+		}
+
+		for _, e := range entries {
+			msg, ok := e.Values[streamField].(string)
+			if !ok {
+				// Entries deleted while pending are claimed without values.
+				if err := b.conn.XAck(ctx, key, b.opts.StreamGroup, e.ID).Err(); err != nil {
+					b.lo.Error("error acknowledging stream entry", "queue", queue, "error", err)
+				}
+				continue
+			}
+
+			b.track(queue, msg, e.ID)
+			select {
+			case work <- []byte(msg):
+			case <-ctx.Done():
+				// The entry stays pending, it is returned onto the stream so that it isn't
+				// held until it is claimed.
+				if err := b.Nack(context.WithoutCancel(ctx), []byte(msg), queue); err != nil {
+					b.lo.Error("error returning message onto queue", "queue", queue, "error", err)
+				}
+				b.lo.Debug("shutting down consumer..")
+				return
+			}
+		}
+	}
+}
+
+// track records the id of the stream entry holding the consumed message, to acknowledge it.
+func (b *Broker) track(queue, msg, id string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.delivered == nil {
+		b.delivered = make(map[string][]string)
+	}
+	k := queue + "\x00" + msg
+	b.delivered[k] = append(b.delivered[k], id)
+}
+
+// untrack returns the id of the stream entry holding the consumed message, if any.
+func (b *Broker) untrack(queue, msg string) (string, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	k := queue + "\x00" + msg
+	ids := b.delivered[k]
+	if len(ids) == 0 {
+		return "", false
+	}
+	if len(ids) == 1 {
+		delete(b.delivered, k)
+	} else {
+		b.delivered[k] = ids[1:]
+	}
+
+	return ids[0], true
+}
+
+// ackStream acknowledges the stream entry of the consumed message. Acknowledged entries are kept
+// on the stream (up to `StreamMaxLen`) so that they can be replayed.
+func (b *Broker) ackStream(ctx context.Context, msg []byte, queue string) error {
+	id, ok := b.untrack(queue, string(msg))
+	if !ok {
+		return nil
+	}
+
+	return b.conn.XAck(ctx, b.streamKey(queue), b.opts.StreamGroup, id).Err()
+}
+
+// nackStream acknowledges the stream entry of the consumed message and appends the message onto the
+// stream again, to be consumed right away.
+func (b *Broker) nackStream(ctx context.Context, msg []byte, queue string) error {
+	id, ok := b.untrack(queue, string(msg))
+	if !ok {
+		return nil
+	}
+
+	_, err := b.conn.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		b.xadd(ctx, p, msg, queue)
+		p.XAck(ctx, b.streamKey(queue), b.opts.StreamGroup, id)
+		return nil
+	})
+	return err
+}
+
+// undelivered returns the entries of the queue's stream that haven't been delivered to the consumer group yet.
+func (b *Broker) undelivered(ctx context.Context, queue string) ([]redis.XMessage, error) {
+	key := b.streamKey(queue)
+	start := "-"
+
+	groups, err := b.conn.XInfoGroups(ctx, key).Result()
+	if err != nil && !strings.HasPrefix(err.Error(), "ERR no such key") {
+		return nil, err
+	}
+	for _, g := range groups {
+		if g.Name == b.opts.StreamGroup {
+			start = "(" + g.LastDeliveredID
+		}
+	}
+
+	return b.conn.XRange(ctx, key, start, "+").Result()
+}
+
+func (b *Broker) getPendingStream(ctx context.Context, queue string) ([]string, error) {
+	entries, err := b.undelivered(ctx, queue)
+	if err != nil {
+		return []string{}, err
+	}
+
+	pending := make([]string, 0, len(entries))
+	for _, e := range entries {
+		if msg, ok := e.Values[streamField].(string); ok {
+			pending = append(pending, msg)
+		}
+	}
+
+	return pending, nil
+}
+
+// depthStream returns the number of entries of the queue's stream that haven't been delivered to the
+// consumer group yet, from the lag of the group if redis reports it.
+func (b *Broker) depthStream(ctx context.Context, queue string) (int, error) {
+	key := b.streamKey(queue)
+	groups, err := b.conn.XInfoGroups(ctx, key).Result()
+	if err != nil && !strings.HasPrefix(err.Error(), "ERR no such key") {
+		return 0, err
+	}
+
+	for _, g := range groups {
+		if g.Name != b.opts.StreamGroup {
+			continue
+		}
+		// Redis reports the lag from 7.0, and can't determine it once entries are deleted, in which
+		// case the entries are counted instead.
+		if g.Lag > 0 || (g.Lag == 0 && (g.EntriesRead > 0 || g.LastDeliveredID == "0-0")) {
+			return int(g.Lag), nil
+		}
+		entries, err := b.undelivered(ctx, queue)
+		return len(entries), err
+	}
+
+	// None of the entries have been delivered.
+	n, err := b.conn.XLen(ctx, key).Result()
+	return int(n), err
+}
+
+// removeStream deletes the undelivered entries of the queue's stream holding the message, or all of
+// them if msg is nil.
+func (b *Broker) removeStream(ctx context.Context, msg []byte, queue string) error {
+	entries, err := b.undelivered(ctx, queue)
+	if err != nil {
+		return err
+	}
+
+	var ids []string
+	for _, e := range entries {
+		if msg == nil || e.Values[streamField] == string(msg) {
+			ids = append(ids, e.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	return b.conn.XDel(ctx, b.streamKey(queue), ids...).Err()
+}
+
+// Replay appends the messages of the queue's stream entries that were consumed since the time onto the
+// stream again, to be processed once more (eg: after fixing a bug in their task). It is only
+// supported in Streams mode, and is limited to the entries kept on the stream (`StreamMaxLen`). It returns
+// the number of messages replayed.
+func (b *Broker) Replay(ctx context.Context, queue string, since time.Time) (int, error) {
+	if !b.opts.Streams {
+		return 0, fmt.Errorf("replay is only supported in streams mode")
+	}
+
+	key := b.streamKey(queue)
+	groups, err := b.conn.XInfoGroups(ctx, key).Result()
+	if err != nil {
+		return 0, fmt.Errorf("could not get consumer groups of stream : %w", err)
+	}
+	end := ""
+	for _, g := range groups {
+		if g.Name == b.opts.StreamGroup {
+			end = g.LastDeliveredID
+		}
+	}
+	if end == "" || end == "0-0" {
+		return 0, nil
+	}
+
+	var (
+		start = fmt.Sprintf("%d", since.UnixMilli())
+		count int
+	)
+	for {
+		entries, err := b.conn.XRangeN(ctx, key, start, end, scheduledBatch).Result()
+		if err != nil {
+			return count, fmt.Errorf("could not read stream : %w", err)
+		}
+
+		pipe := b.conn.Pipeline()
+		for _, e := range entries {
+			if msg, ok := e.Values[streamField].(string); ok {
+				b.xadd(ctx, pipe, []byte(msg), queue)
+				count++
+			}
+		}
+		if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+			return count, fmt.Errorf("could not replay messages : %w", err)
+		}
+
+		if len(entries) < scheduledBatch {
+			b.lo.Info("replayed stream entries", "queue", queue, "count", count)
+			return count, nil
+		}
+		start = "(" + entries[len(entries)-1].ID
+	}
+}
+
+// defaultConsumer returns the name of the broker's consumer in the group, unique to the process.
+func defaultConsumer() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "tasqueue"
+	}
+
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
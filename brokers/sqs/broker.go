@@ -0,0 +1,439 @@
+package sqs
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/google/uuid"
+)
+
+const (
+	DefaultWaitTime          = 20 * time.Second
+	DefaultMaxMessages       = 10
+	DefaultVisibilityTimeout = 30 * time.Second
+	DefaultMaxReceiveCount   = 5
+
+	// Maximum delay of a message on a standard queue.
+	maxDelay = 15 * time.Minute
+
+	// Maximum number of entries in a batch request.
+	maxBatch = 10
+)
+
+type Options struct {
+	// Config is the AWS config (region, credentials) the SQS client is created with,
+	// eg: loaded with config.LoadDefaultConfig().
+	Config aws.Config
+
+	// OPTIONAL
+	// FIFO maps the queues onto FIFO queues (named with the ".fifo" suffix). The messages of a
+	// queue are in a single message group, named after the queue, so they are received in order.
+	FIFO bool
+
+	// CreateQueues creates the queues that don't exist.
+	CreateQueues bool
+
+	// WaitTime is the duration a receive waits for messages (long polling), up to 20s.
+	// Defaults to `DefaultWaitTime`.
+	WaitTime time.Duration
+
+	// MaxMessages is the number of messages received at once, up to 10. Defaults to `DefaultMaxMessages`.
+	MaxMessages int
+
+	// VisibilityTimeout is the duration a received message is hidden from the other consumers for.
+	// It is extended while the message is being processed, until it is acknowledged.
+	// Defaults to `DefaultVisibilityTimeout`.
+	VisibilityTimeout time.Duration
+
+	// DeadLetterQueue is the SQS queue that messages are moved onto by SQS after being received
+	// MaxReceiveCount times (defaults to `DefaultMaxReceiveCount`) without being acknowledged.
+	// If set, the redrive policy of the queues is configured on first use.
+	DeadLetterQueue string
+	MaxReceiveCount int
+}
+
+// Broker is an Amazon SQS based broker implementation. Each queue is mapped onto an SQS queue,
+// named after the queue with the characters that aren't allowed replaced by "-". Messages are
+// base64 encoded, as SQS only allows text.
+type Broker struct {
+	lo     *slog.Logger
+	opts   Options
+	client *sqs.Client
+
+	// urls holds the URLs of the resolved queues (queue name -> url).
+	mu   sync.Mutex
+	urls map[string]string
+
+	// unacked holds the consumed messages that haven't been acknowledged yet.
+	um      sync.Mutex
+	unacked map[delivery][]types.Message
+}
+
+// delivery identifies a consumed message.
+type delivery struct {
+	queue string
+	body  string
+}
+
+// New() returns a new instance of the SQS broker.
+func New(o Options, lo *slog.Logger) (*Broker, error) {
+	if o.WaitTime == 0 {
+		o.WaitTime = DefaultWaitTime
+	}
+	if o.MaxMessages == 0 {
+		o.MaxMessages = DefaultMaxMessages
+	}
+	if o.VisibilityTimeout == 0 {
+		o.VisibilityTimeout = DefaultVisibilityTimeout
+	}
+	if o.MaxReceiveCount == 0 {
+		o.MaxReceiveCount = DefaultMaxReceiveCount
+	}
+	if o.WaitTime > DefaultWaitTime || o.MaxMessages > maxBatch {
+		return nil, fmt.Errorf("sqs wait time can be up to 20s and max messages up to 10")
+	}
+
+	return &Broker{
+		lo:      lo,
+		opts:    o,
+		client:  sqs.NewFromConfig(o.Config),
+		urls:    make(map[string]string),
+		unacked: make(map[delivery][]types.Message),
+	}, nil
+}
+
+func (b *Broker) Enqueue(ctx context.Context, msg []byte, queue string) error {
+	return b.send(ctx, msg, queue, 0)
+}
+
+// EnqueueScheduled sends the message with a delay until the timestamp. SQS only supports delays
+// of up to 15 minutes, on standard queues.
+func (b *Broker) EnqueueScheduled(ctx context.Context, msg []byte, queue string, ts time.Time) error {
+	d := time.Until(ts)
+	if d > maxDelay || (b.opts.FIFO && d > 0) {
+		return fmt.Errorf("sqs broker only supports delays of up to 15 minutes on standard queues")
+	}
+
+	return b.send(ctx, msg, queue, max(d, 0))
+}
+
+func (b *Broker) send(ctx context.Context, msg []byte, queue string, delay time.Duration) error {
+	url, err := b.queueURL(ctx, queue)
+	if err != nil {
+		return err
+	}
+
+	in := &sqs.SendMessageInput{
+		QueueUrl:     aws.String(url),
+		MessageBody:  aws.String(base64.StdEncoding.EncodeToString(msg)),
+		DelaySeconds: int32(delay.Round(time.Second) / time.Second),
+	}
+	if b.opts.FIFO {
+		in.MessageGroupId, in.MessageDeduplicationId = aws.String(queue), aws.String(uuid.NewString())
+	}
+	_, err = b.client.SendMessage(ctx, in)
+	return err
+}
+
+// EnqueueBatch sends the messages in batches of up to 10.
+func (b *Broker) EnqueueBatch(ctx context.Context, msgs [][]byte, queue string) error {
+	url, err := b.queueURL(ctx, queue)
+	if err != nil {
+		return err
+	}
+
+	for len(msgs) > 0 {
+		n := min(len(msgs), maxBatch)
+		entries := make([]types.SendMessageBatchRequestEntry, n)
+		for i, msg := range msgs[:n] {
+			entries[i] = types.SendMessageBatchRequestEntry{
+				Id:          aws.String(strconv.Itoa(i)),
+				MessageBody: aws.String(base64.StdEncoding.EncodeToString(msg)),
+			}
+			if b.opts.FIFO {
+				entries[i].MessageGroupId, entries[i].MessageDeduplicationId = aws.String(queue), aws.String(uuid.NewString())
+			}
+		}
+
+		out, err := b.client.SendMessageBatch(ctx, &sqs.SendMessageBatchInput{QueueUrl: aws.String(url), Entries: entries})
+		if err != nil {
+			return err
+		}
+		if len(out.Failed) > 0 {
+			return fmt.Errorf("could not send %d messages to sqs : %s", len(out.Failed), aws.ToString(out.Failed[0].Message))
+		}
+		msgs = msgs[n:]
+	}
+
+	return nil
+}
+
+// Consume receives messages from the queue with long polling. Messages are held until they are
+// acknowledged with Ack, extending their visibility timeout meanwhile, and are received again
+// once it expires if they aren't (eg: if the server crashed while processing them).
+func (b *Broker) Consume(ctx context.Context, work chan []byte, queue string) {
+	url, err := b.queueURL(ctx, queue)
+	if err != nil {
+		b.lo.Error("error resolving sqs queue", "queue", queue, "error", err)
+		return
+	}
+
+	go b.extendVisibility(ctx, queue, url)
+
+	for {
+		out, err := b.client.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:            aws.String(url),
+			MaxNumberOfMessages: int32(b.opts.MaxMessages),
+			WaitTimeSeconds:     int32(b.opts.WaitTime / time.Second),
+			VisibilityTimeout:   int32(b.opts.VisibilityTimeout / time.Second),
+		})
+		if ctx.Err() != nil {
+			b.lo.Debug("shutting down consumer..")
+			return
+		}
+		if err != nil {
+			b.lo.Error("error consuming from sqs", "queue", queue, "error", err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+
+		for i, m := range out.Messages {
+			body, err := base64.StdEncoding.DecodeString(aws.ToString(m.Body))
+			if err != nil {
+				b.lo.Error("error decoding sqs message", "queue", queue, "error", err)
+				continue
+			}
+
+			key := delivery{queue: queue, body: string(body)}
+			b.um.Lock()
+			b.unacked[key] = append(b.unacked[key], m)
+			b.um.Unlock()
+
+			select {
+			case work <- body:
+			case <-ctx.Done():
+				// Return the messages that weren't passed on, so that they are received right away.
+				b.take(queue, body)
+				b.release(context.WithoutCancel(ctx), url, out.Messages[i:])
+				b.lo.Debug("shutting down consumer..")
+				return
+			}
+		}
+	}
+}
+
+// extendVisibility periodically extends the visibility timeout of the consumed messages of the
+// queue that haven't been acknowledged yet.
+func (b *Broker) extendVisibility(ctx context.Context, queue, url string) {
+	tk := time.NewTicker(b.opts.VisibilityTimeout / 2)
+	defer tk.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tk.C:
+		}
+
+		var msgs []types.Message
+		b.um.Lock()
+		for key, ms := range b.unacked {
+			if key.queue == queue {
+				msgs = append(msgs, ms...)
+			}
+		}
+		b.um.Unlock()
+
+		if err := b.changeVisibility(ctx, url, msgs, b.opts.VisibilityTimeout); err != nil {
+			b.lo.Error("error extending sqs message visibility", "queue", queue, "error", err)
+		}
+	}
+}
+
+// release makes the messages visible to the consumers again.
+func (b *Broker) release(ctx context.Context, url string, msgs []types.Message) {
+	if err := b.changeVisibility(ctx, url, msgs, 0); err != nil {
+		b.lo.Error("error returning messages onto sqs queue", "error", err)
+	}
+}
+
+// changeVisibility sets the visibility timeout of the messages, in batches of up to 10.
+func (b *Broker) changeVisibility(ctx context.Context, url string, msgs []types.Message, d time.Duration) error {
+	for len(msgs) > 0 {
+		n := min(len(msgs), maxBatch)
+		entries := make([]types.ChangeMessageVisibilityBatchRequestEntry, n)
+		for i, m := range msgs[:n] {
+			entries[i] = types.ChangeMessageVisibilityBatchRequestEntry{
+				Id:                aws.String(strconv.Itoa(i)),
+				ReceiptHandle:     m.ReceiptHandle,
+				VisibilityTimeout: int32(d / time.Second),
+			}
+		}
+		if _, err := b.client.ChangeMessageVisibilityBatch(ctx, &sqs.ChangeMessageVisibilityBatchInput{
+			QueueUrl: aws.String(url),
+			Entries:  entries,
+		}); err != nil {
+			return err
+		}
+		msgs = msgs[n:]
+	}
+
+	return nil
+}
+
+// Ack acknowledges the consumed message, deleting it from the queue.
+func (b *Broker) Ack(ctx context.Context, msg []byte, queue string) error {
+	m, ok := b.take(queue, msg)
+	if !ok {
+		return fmt.Errorf("message is not pending acknowledgement")
+	}
+	url, err := b.queueURL(ctx, queue)
+	if err != nil {
+		return err
+	}
+
+	_, err = b.client.DeleteMessage(ctx, &sqs.DeleteMessageInput{QueueUrl: aws.String(url), ReceiptHandle: m.ReceiptHandle})
+	return err
+}
+
+// Nack returns the consumed message onto the queue, by resetting its visibility timeout.
+func (b *Broker) Nack(ctx context.Context, msg []byte, queue string) error {
+	m, ok := b.take(queue, msg)
+	if !ok {
+		return fmt.Errorf("message is not pending acknowledgement")
+	}
+	url, err := b.queueURL(ctx, queue)
+	if err != nil {
+		return err
+	}
+
+	return b.changeVisibility(ctx, url, []types.Message{m}, 0)
+}
+
+// take removes and returns the consumed message.
+func (b *Broker) take(queue string, msg []byte) (types.Message, bool) {
+	b.um.Lock()
+	defer b.um.Unlock()
+
+	key := delivery{queue: queue, body: string(msg)}
+	ms := b.unacked[key]
+	if len(ms) == 0 {
+		return types.Message{}, false
+	}
+
+	m := ms[0]
+	if len(ms) == 1 {
+		delete(b.unacked, key)
+	} else {
+		b.unacked[key] = ms[1:]
+	}
+
+	return m, true
+}
+
+func (b *Broker) GetPending(ctx context.Context, queue string) ([]string, error) {
+	return nil, fmt.Errorf("sqs broker does not support this method")
+}
+
+var invalidChars = regexp.MustCompile(`[^a-zA-Z0-9_-]`)
+
+// queueName returns the name of the SQS queue the queue is mapped onto.
+func (b *Broker) queueName(queue string) string {
+	name := invalidChars.ReplaceAllString(queue, "-")
+	if b.opts.FIFO {
+		name += ".fifo"
+	}
+
+	return name
+}
+
+// queueURL returns the URL of the queue, creating it and configuring its redrive policy if set.
+func (b *Broker) queueURL(ctx context.Context, queue string) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if url, ok := b.urls[queue]; ok {
+		return url, nil
+	}
+
+	url, err := b.resolve(ctx, b.queueName(queue))
+	if err != nil {
+		return "", err
+	}
+	if b.opts.DeadLetterQueue != "" {
+		if err := b.setRedrive(ctx, url); err != nil {
+			return "", fmt.Errorf("error configuring sqs redrive policy : %w", err)
+		}
+	}
+	b.urls[queue] = url
+
+	return url, nil
+}
+
+// resolve returns the URL of the SQS queue, creating it if it doesn't exist and CreateQueues is set.
+func (b *Broker) resolve(ctx context.Context, name string) (string, error) {
+	out, err := b.client.GetQueueUrl(ctx, &sqs.GetQueueUrlInput{QueueName: aws.String(name)})
+	if err == nil {
+		return aws.ToString(out.QueueUrl), nil
+	}
+	var nf *types.QueueDoesNotExist
+	if !errors.As(err, &nf) || !b.opts.CreateQueues {
+		return "", fmt.Errorf("error getting sqs queue %s : %w", name, err)
+	}
+
+	attrs := map[string]string{
+		string(types.QueueAttributeNameVisibilityTimeout): strconv.Itoa(int(b.opts.VisibilityTimeout / time.Second)),
+	}
+	if b.opts.FIFO {
+		attrs[string(types.QueueAttributeNameFifoQueue)] = "true"
+	}
+	cout, err := b.client.CreateQueue(ctx, &sqs.CreateQueueInput{QueueName: aws.String(name), Attributes: attrs})
+	if err != nil {
+		return "", fmt.Errorf("error creating sqs queue %s : %w", name, err)
+	}
+
+	return aws.ToString(cout.QueueUrl), nil
+}
+
+// setRedrive sets the redrive policy of the queue, moving messages onto the dead letter queue.
+func (b *Broker) setRedrive(ctx context.Context, url string) error {
+	dlq, err := b.resolve(ctx, b.queueName(b.opts.DeadLetterQueue))
+	if err != nil {
+		return err
+	}
+	out, err := b.client.GetQueueAttributes(ctx, &sqs.GetQueueAttributesInput{
+		QueueUrl:       aws.String(dlq),
+		AttributeNames: []types.QueueAttributeName{types.QueueAttributeNameQueueArn},
+	})
+	if err != nil {
+		return err
+	}
+
+	policy, err := json.Marshal(map[string]string{
+		"deadLetterTargetArn": out.Attributes[string(types.QueueAttributeNameQueueArn)],
+		"maxReceiveCount":     strconv.Itoa(b.opts.MaxReceiveCount),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = b.client.SetQueueAttributes(ctx, &sqs.SetQueueAttributesInput{
+		QueueUrl:   aws.String(url),
+		Attributes: map[string]string{string(types.QueueAttributeNameRedrivePolicy): string(policy)},
+	})
+	return err
+}
@@ -0,0 +1,133 @@
+package tasqueue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	spans "go.opentelemetry.io/otel/trace"
+)
+
+const (
+	cancelPrefix = "job:cancel:"
+
+	// Interval at which the results store is checked for the cancellation of a job being processed
+	// (for jobs that were cancelled through another server).
+	cancelPollInterval = time.Second
+)
+
+// errJobCancelled is set as the cause on the context of a cancelled job.
+var errJobCancelled = errors.New("job cancelled")
+
+// CancelJob() cancels a job. A queued job is skipped when it is consumed, while the context
+// passed to the handler of a job being processed is cancelled. The job's status is set as
+// cancelled and its callbacks, retries and OnError jobs are skipped.
+func (s *Server) CancelJob(ctx context.Context, id string) error {
+	msg, err := s.GetJob(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	switch msg.Status {
+	case StatusDone, StatusFailed, StatusCancelled, StatusExpired:
+		return fmt.Errorf("job has already finished with status %s", msg.Status)
+	}
+
+	if err := setMeta(ctx, s.results, cancelPrefix+id, []byte(StatusCancelled)); err != nil {
+		return err
+	}
+
+	// Cancel the job right away if it is being processed by this server. Other servers
+	// pick up the cancellation when they poll the results store.
+	s.rm.Lock()
+	cancel, ok := s.running[id]
+	s.rm.Unlock()
+	if ok {
+		cancel(errJobCancelled)
+		return nil
+	}
+
+	return s.statusCancelled(ctx, msg)
+}
+
+// isCancelled() checks the results store for a cancellation marker of the job.
+func (s *Server) isCancelled(ctx context.Context, id string) (bool, error) {
+	if _, err := s.getResult(ctx, cancelPrefix+id); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+// clearCancel() deletes the cancellation marker of the job, once the job has been cancelled.
+func (s *Server) clearCancel(ctx context.Context, id string) {
+	if err := s.results.DeleteJob(ctx, cancelPrefix+id); err != nil {
+		s.log.Error("could not delete job cancellation", "id", id, "error", err)
+	}
+}
+
+// watchCancel() tracks a job being processed so that it can be cancelled, either directly
+// by CancelJob() on this server or by polling the results store. The returned func
+// must be called once the job's handler returns.
+func (s *Server) watchCancel(ctx context.Context, id string, cancel context.CancelCauseFunc) func() {
+	s.rm.Lock()
+	s.running[id] = cancel
+	s.rm.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		tk := time.NewTicker(cancelPollInterval)
+		defer tk.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			case <-tk.C:
+				ok, err := s.isCancelled(ctx, id)
+				if err != nil {
+					s.log.Error("error checking job cancellation", "id", id, "error", err)
+					continue
+				}
+				if ok {
+					cancel(errJobCancelled)
+					return
+				}
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		s.rm.Lock()
+		delete(s.running, id)
+		s.rm.Unlock()
+	}
+}
+
+func (s *Server) statusCancelled(ctx context.Context, t JobMessage) error {
+	var span spans.Span
+	if s.traceProv != nil {
+		ctx, span = otel.Tracer(tracer).Start(ctx, "status_cancelled")
+		defer span.End()
+	}
+
+	s.releaseUnique(ctx, t)
+	s.deleteBlobs(ctx, t, nil)
+	t.ProcessedAt = s.now()
+	t.Status = StatusCancelled
+
+	if err := s.setJobMessage(ctx, t); err != nil {
+		s.spanError(span, err)
+		return err
+	}
+	s.recordEvent(ctx, t)
+
+	return nil
+}
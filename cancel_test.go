@@ -0,0 +1,135 @@
+package tasqueue
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"testing"
+	"time"
+
+	rb "github.com/kalbhor/tasqueue/v2/brokers/in-memory"
+	rr "github.com/kalbhor/tasqueue/v2/results/in-memory"
+)
+
+// blockingHandler blocks until the job's context is cancelled and reports the cause on done.
+func blockingHandler(done chan error) func([]byte, JobCtx) error {
+	return func(_ []byte, c JobCtx) error {
+		<-c.Context.Done()
+		done <- context.Cause(c.Context)
+		return c.Context.Err()
+	}
+}
+
+func TestCancelJob(t *testing.T) {
+	var (
+		ctx, cancel = context.WithCancel(context.Background())
+		done        = make(chan error, 1)
+		srv         = newServer(t, taskName, blockingHandler(done))
+	)
+	defer cancel()
+	go srv.Start(ctx)
+
+	// Cancel a job being processed.
+	id, err := srv.Enqueue(ctx, makeJob(t, taskName, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if err := srv.CancelJob(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case cause := <-done:
+		if cause != errJobCancelled {
+			t.Fatalf("incorrect cancellation cause: %v", cause)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("handler context was not cancelled")
+	}
+	time.Sleep(100 * time.Millisecond)
+	msg, err := srv.GetJob(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Status != StatusCancelled {
+		t.Fatalf("incorrect job status, expected %s, got %s", StatusCancelled, msg.Status)
+	}
+	if ok, err := srv.isCancelled(ctx, id); err != nil || ok {
+		t.Fatalf("expected the cancellation marker to be deleted: %v", err)
+	}
+	if err := srv.CancelJob(ctx, id); err == nil {
+		t.Fatal("expected error cancelling a finished job")
+	}
+
+	// Cancel a queued job, which should be skipped when consumed.
+	job := makeJob(t, taskName, false)
+	job.Opts.Delay = 500 * time.Millisecond
+	id, err = srv.Enqueue(ctx, job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.CancelJob(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-done:
+		t.Fatal("cancelled job was processed")
+	case <-time.After(time.Second):
+	}
+	if msg, err = srv.GetJob(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Status != StatusCancelled {
+		t.Fatalf("incorrect job status, expected %s, got %s", StatusCancelled, msg.Status)
+	}
+	if ok, err := srv.isCancelled(ctx, id); err != nil || ok {
+		t.Fatalf("expected the cancellation marker to be deleted: %v", err)
+	}
+}
+
+func TestCancelJobRemote(t *testing.T) {
+	var (
+		ctx, cancel = context.WithCancel(context.Background())
+		done        = make(chan error, 1)
+		lo          = slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
+			Level: slog.LevelError,
+		}))
+		opts = ServerOpts{
+			Broker:  rb.New(),
+			Results: rr.New(),
+			Logger:  lo.Handler(),
+		}
+	)
+	defer cancel()
+
+	worker, err := NewServer(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := worker.RegisterTask(taskName, blockingHandler(done), TaskOpts{}); err != nil {
+		t.Fatal(err)
+	}
+	go worker.Start(ctx)
+
+	// The job is cancelled through a server that isn't processing it.
+	srv, err := NewServer(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := srv.Enqueue(ctx, makeJob(t, taskName, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if err := srv.CancelJob(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case cause := <-done:
+		if cause != errJobCancelled {
+			t.Fatalf("incorrect cancellation cause: %v", cause)
+		}
+	case <-time.After(cancelPollInterval * 3):
+		t.Fatal("handler context was not cancelled")
+	}
+}
@@ -2,10 +2,10 @@ package tasqueue
 
 import (
 	"context"
+	"errors"
 	"fmt"
 
 	"github.com/google/uuid"
-	"github.com/vmihailenco/msgpack/v5"
 )
 
 // ChainMeta contains fields related to a chain job.
@@ -17,12 +17,21 @@ type ChainMeta struct {
 	JobID string
 	// List of IDs of completed jobs
 	PrevJobs []string
+
+	// OnFailure is the chain's failure policy.
+	OnFailure ChainPolicy
+	// ID and index of the first job of the chain that failed, if any, from which the chain can be resumed.
+	FailedJobID string
+	FailedStep  int
 }
 
 // ChainMessage is a wrapper over Chain, containing meta info such as status, id.
 // A ChainMessage is stored in the results store.
 type ChainMessage struct {
 	ChainMeta
+
+	// Compensate is the job enqueued if a job of the chain fails, with ChainCompensate.
+	Compensate *Job
 }
 
 type Chain struct {
@@ -33,8 +42,29 @@ type Chain struct {
 type ChainOpts struct {
 	// Optional ID passed by client. If empty, Tasqueue generates it.
 	ID string
+
+	// OnFailure decides what happens to the remaining jobs when a job of the chain fails (after its
+	// retries). Defaults to ChainAbort.
+	OnFailure ChainPolicy
+	// Compensate is the job enqueued when a job of the chain fails, with ChainCompensate (eg: to roll
+	// back the jobs that succeeded). Its JobCtx.Meta carries the chain's ID, the index of the failed
+	// job (ChainStep) and its error (PrevErr).
+	Compensate *Job
 }
 
+// ChainPolicy is the policy for the remaining jobs of a chain when one of its jobs fails.
+type ChainPolicy string
+
+const (
+	// ChainAbort skips the remaining jobs, failing the chain. It is the default.
+	ChainAbort ChainPolicy = "abort"
+	// ChainContinue enqueues the next job as if the failed job had succeeded (without a result).
+	// The chain fails once all its jobs have finished.
+	ChainContinue ChainPolicy = "continue"
+	// ChainCompensate skips the remaining jobs and enqueues the chain's Compensate job.
+	ChainCompensate ChainPolicy = "compensate"
+)
+
 // NewChain() accepts a list of Tasks and creates a chain by setting the
 // onSuccess task of i'th task to (i+1)'th task, hence forming a "chain".
 // It returns the first task (essentially the first node of the linked list), which can be queued normally.
@@ -42,6 +72,15 @@ func NewChain(j []Job, opts ChainOpts) (Chain, error) {
 	if len(j) < 2 {
 		return Chain{}, fmt.Errorf("minimum 2 tasks required to form chain")
 	}
+	switch opts.OnFailure {
+	case "", ChainAbort, ChainContinue:
+	case ChainCompensate:
+		if opts.Compensate == nil {
+			return Chain{}, fmt.Errorf("compensate job required with the %s policy", ChainCompensate)
+		}
+	default:
+		return Chain{}, fmt.Errorf("invalid chain failure policy %s", opts.OnFailure)
+	}
 
 	// Set the on success tasks as the i+1 task,
 	// hence forming a "chain" of tasks.
@@ -60,24 +99,30 @@ func (c *Chain) message() ChainMessage {
 
 	return ChainMessage{
 		ChainMeta: ChainMeta{
-			ID:     c.Opts.ID,
-			Status: StatusProcessing,
+			ID:        c.Opts.ID,
+			Status:    StatusProcessing,
+			OnFailure: c.Opts.OnFailure,
 		},
+		Compensate: c.Opts.Compensate,
 	}
 }
 
 func (s *Server) EnqueueChain(ctx context.Context, c Chain) (string, error) {
-	msg := c.message()
-	root := c.Jobs[0]
-	jobID, err := s.Enqueue(ctx, root)
-	if err != nil {
-		return "", err
-	}
-	msg.JobID = jobID
+	var (
+		msg  = c.message()
+		root = c.Jobs[0]
+		meta = DefaultMeta(root.Opts)
+	)
+	meta.ChainID = msg.ID
+	msg.JobID = meta.ID
 
+	// The chain message is stored first, as it is read if the root job fails.
 	if err := s.setChainMessage(ctx, msg); err != nil {
 		return "", err
 	}
+	if _, err := s.enqueueWithMeta(ctx, root, meta); err != nil {
+		return "", err
+	}
 
 	return msg.ID, nil
 }
@@ -88,7 +133,7 @@ func (s *Server) GetChain(ctx context.Context, id string) (ChainMessage, error)
 		return ChainMessage{}, err
 	}
 
-	if c.Status == StatusDone || c.Status == StatusFailed {
+	if c.Status == StatusDone || c.Status == StatusFailed || c.Status == StatusCancelled || c.Status == StatusExpired {
 		return c, nil
 	}
 
@@ -99,12 +144,29 @@ func (s *Server) GetChain(ctx context.Context, id string) (ChainMessage, error)
 	}
 
 checkJobs:
+	c.JobID = currJob.ID
 	switch currJob.Status {
-	//If the current job failed, add it to previous jobs list
-	// Set the chain status to failed
+	// If the current job failed, record it as the chain's failed job. With ChainContinue, the next
+	// job is checked once it has been enqueued, otherwise the chain has failed.
 	case StatusFailed:
+		if c.FailedJobID == "" {
+			c.FailedJobID, c.FailedStep = currJob.ID, len(c.PrevJobs)
+		}
+		if c.OnFailure == ChainContinue && len(currJob.OnSuccessIDs) > 0 {
+			c.PrevJobs = append(c.PrevJobs, currJob.ID)
+			currJob, err = s.GetJob(ctx, currJob.OnSuccessIDs[0])
+			if err != nil {
+				return ChainMessage{}, nil
+			}
+			goto checkJobs
+		}
 		c.PrevJobs = append(c.PrevJobs, currJob.ID)
 		c.Status = StatusFailed
+	// If the current job was cancelled or expired, add it to previous jobs list
+	// and set the chain status accordingly.
+	case StatusCancelled, StatusExpired:
+		c.PrevJobs = append(c.PrevJobs, currJob.ID)
+		c.Status = currJob.Status
 	// If the current job status is an intermediatery status
 	// Set the chain status as processing.
 	case StatusStarted, StatusProcessing, StatusRetrying:
@@ -116,6 +178,9 @@ checkJobs:
 		c.PrevJobs = append(c.PrevJobs, currJob.ID)
 		if len(currJob.OnSuccessIDs) == 0 {
 			c.Status = StatusDone
+			if c.FailedJobID != "" {
+				c.Status = StatusFailed
+			}
 		} else {
 			currJob, err = s.GetJob(ctx, currJob.OnSuccessIDs[0])
 			if err != nil {
@@ -132,24 +197,65 @@ checkJobs:
 	return c, nil
 }
 
+// failChain() applies the failure policy of the job's chain, if it is part of one, once the job has failed.
+// With ChainContinue, it enqueues the next job and returns its ID.
+func (s *Server) failChain(ctx context.Context, msg JobMessage) ([]string, error) {
+	if msg.ChainID == "" {
+		return nil, nil
+	}
+	c, err := s.getChainMessage(ctx, msg.ChainID)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	switch c.OnFailure {
+	case ChainContinue:
+		var ids []string
+		for _, j := range msg.Job.OnSuccess {
+			meta := DefaultMeta(j.Opts)
+			meta.ChainID, meta.ChainStep = msg.ChainID, msg.ChainStep+1
+			id, err := s.enqueueWithMeta(ctx, *j, meta)
+			if err != nil {
+				return nil, err
+			}
+			ids = append(ids, id)
+		}
+		return ids, nil
+	case ChainCompensate:
+		if c.Compensate == nil {
+			return nil, nil
+		}
+		meta := DefaultMeta(c.Compensate.Opts)
+		meta.ChainID, meta.ChainStep, meta.PrevErr = msg.ChainID, msg.ChainStep, msg.PrevErr
+		if _, err := s.enqueueWithMeta(ctx, *c.Compensate, meta); err != nil {
+			return nil, err
+		}
+	}
+
+	return nil, nil
+}
+
 const chainPrefix = "chain:msg:"
 
 func (s *Server) setChainMessage(ctx context.Context, c ChainMessage) error {
-	b, err := msgpack.Marshal(c)
+	b, err := s.codec.marshal(c)
 	if err != nil {
 		return err
 	}
-	return s.results.Set(ctx, chainPrefix+c.ID, b)
+	return setMeta(ctx, s.results, chainPrefix+c.ID, b)
 }
 
 func (s *Server) getChainMessage(ctx context.Context, id string) (ChainMessage, error) {
-	b, err := s.GetResult(ctx, chainPrefix+id)
+	b, err := s.getResult(ctx, chainPrefix+id)
 	if err != nil {
 		return ChainMessage{}, err
 	}
 
 	var c ChainMessage
-	if err := msgpack.Unmarshal(b, &c); err != nil {
+	if err := s.codec.unmarshal(b, &c); err != nil {
 		return ChainMessage{}, err
 	}
 
@@ -40,6 +40,9 @@ func TestGetChain(t *testing.T) {
 			if string(j.Meta.PrevJobResult) != string(d) {
 				t.Fatalf("chain previous results do not match. got=%v, want=%v", string(j.Meta.PrevJobResult), string(d))
 			}
+			if string(GetPreviousResult(j)) != string(d) {
+				t.Fatalf("chain previous results in context do not match. got=%v, want=%v", string(GetPreviousResult(j)), string(d))
+			}
 
 			return nil
 		}
@@ -97,3 +100,91 @@ func makeChain(t *testing.T, taskName string, fs ...bool) Chain {
 
 	return chn
 }
+
+func TestChainFailurePolicies(t *testing.T) {
+	ctx := context.Background()
+
+	for policy, exp := range map[ChainPolicy]struct {
+		last, compensated bool
+	}{
+		ChainAbort:      {},
+		ChainContinue:   {last: true},
+		ChainCompensate: {compensated: true},
+	} {
+		t.Run(string(policy), func(t *testing.T) {
+			var (
+				srv         = newServer(t, taskName, MockHandler)
+				last        bool
+				compensated Meta
+			)
+			if err := srv.RegisterTask("last", func([]byte, JobCtx) error {
+				last = true
+				return nil
+			}, TaskOpts{}); err != nil {
+				t.Fatal(err)
+			}
+			if err := srv.RegisterTask("compensate", func(_ []byte, j JobCtx) error {
+				compensated = j.Meta
+				return nil
+			}, TaskOpts{}); err != nil {
+				t.Fatal(err)
+			}
+
+			failing := makeJob(t, taskName, true)
+			failing.Opts.MaxRetries = 0
+			opts := ChainOpts{OnFailure: policy}
+			if policy == ChainCompensate {
+				j, err := NewJob("compensate", nil, JobOpts{})
+				if err != nil {
+					t.Fatal(err)
+				}
+				opts.Compensate = &j
+			}
+			lastJob, err := NewJob("last", nil, JobOpts{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			chain, err := NewChain([]Job{makeJob(t, taskName, false), failing, lastJob}, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			id, err := srv.EnqueueChain(ctx, chain)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := srv.ProcessAll(ctx); err != nil {
+				t.Fatal(err)
+			}
+
+			msg, err := srv.GetChain(ctx, id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if msg.Status != StatusFailed {
+				t.Fatalf("incorrect chain status, expected %s, got %s", StatusFailed, msg.Status)
+			}
+			if msg.FailedStep != 1 || msg.FailedJobID == "" {
+				t.Fatalf("incorrect failed job, expected step 1, got %d (%q)", msg.FailedStep, msg.FailedJobID)
+			}
+			if last != exp.last {
+				t.Fatalf("expected the last job to be processed: %v, got %v", exp.last, last)
+			}
+			if exp.compensated && (compensated.ChainID != id || compensated.ChainStep != 1 || compensated.PrevErr == "") {
+				t.Fatalf("compensation job not processed with the failure, got %+v", compensated)
+			}
+
+			// The chain message is stable across calls.
+			again, err := srv.GetChain(ctx, id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(again.PrevJobs) != len(msg.PrevJobs) {
+				t.Fatalf("incorrect previous jobs, expected %v, got %v", msg.PrevJobs, again.PrevJobs)
+			}
+		})
+	}
+
+	if _, err := NewChain([]Job{{}, {}}, ChainOpts{OnFailure: ChainCompensate}); err == nil {
+		t.Fatal("expected an error without a compensate job")
+	}
+}
@@ -0,0 +1,150 @@
+// Package client enqueues jobs and queries their status over the remote API of a tasqueue server
+// (Server.MountRemote()), without access to the broker and results store.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/kalbhor/tasqueue/v2"
+)
+
+type Options struct {
+	// URL is the base URL of the server the remote API is mounted on, eg: "http://tasqueue:8080".
+	URL string
+
+	// OPTIONAL
+	// Token is sent as the bearer token of the requests.
+	Token string
+
+	// OPTIONAL
+	// Timeout of the requests. Ignored if HTTPClient is set.
+	Timeout time.Duration
+
+	// OPTIONAL
+	// HTTPClient is the client the requests are made with. Defaults to a client with `Timeout`.
+	HTTPClient *http.Client
+}
+
+type Client struct {
+	opts Options
+	base string
+	hc   *http.Client
+}
+
+func New(o Options) (*Client, error) {
+	u, err := url.Parse(o.URL)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid url %q", o.URL)
+	}
+
+	hc := o.HTTPClient
+	if hc == nil {
+		hc = &http.Client{Timeout: o.Timeout}
+	}
+
+	return &Client{
+		opts: o,
+		base: strings.TrimRight(o.URL, "/") + "/tasqueue/remote/jobs",
+		hc:   hc,
+	}, nil
+}
+
+// Enqueue() enqueues the job on the server and returns its id. tasqueue.ErrDuplicateJob is returned
+// if a job with the same unique key is pending.
+func (c *Client) Enqueue(ctx context.Context, job tasqueue.Job) (string, error) {
+	b, err := json.Marshal(job)
+	if err != nil {
+		return "", err
+	}
+
+	var res struct {
+		ID string `json:"id"`
+	}
+	body, err := c.do(ctx, http.MethodPost, c.base, b)
+	if err != nil {
+		return "", err
+	}
+	if err := json.Unmarshal(body, &res); err != nil {
+		return "", fmt.Errorf("could not decode response : %w", err)
+	}
+
+	return res.ID, nil
+}
+
+// GetJob() returns the job's message. tasqueue.ErrNotFound is returned if the job doesn't exist.
+func (c *Client) GetJob(ctx context.Context, id string) (tasqueue.JobMessage, error) {
+	body, err := c.do(ctx, http.MethodGet, c.base+"/"+url.PathEscape(id), nil)
+	if err != nil {
+		return tasqueue.JobMessage{}, err
+	}
+
+	var msg tasqueue.JobMessage
+	if err := json.Unmarshal(body, &msg); err != nil {
+		return tasqueue.JobMessage{}, fmt.Errorf("could not decode response : %w", err)
+	}
+
+	return msg, nil
+}
+
+// GetResult() returns the result saved by the job. tasqueue.ErrNotFound is returned if there
+// is none.
+func (c *Client) GetResult(ctx context.Context, id string) ([]byte, error) {
+	return c.do(ctx, http.MethodGet, c.base+"/"+url.PathEscape(id)+"/result", nil)
+}
+
+// do() makes the request and returns the body of the response, or its error.
+func (c *Client) do(ctx context.Context, method, u string, body []byte) ([]byte, error) {
+	var rd io.Reader
+	if body != nil {
+		rd = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, rd)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.opts.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.opts.Token)
+	}
+
+	res, err := c.hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode == http.StatusOK {
+		return b, nil
+	}
+
+	// Errors are returned as JSON by the API, and as text for invalid requests.
+	var e struct {
+		Error string `json:"error"`
+	}
+	msg := strings.TrimSpace(string(b))
+	if json.Unmarshal(b, &e) == nil && e.Error != "" {
+		msg = e.Error
+	}
+	switch res.StatusCode {
+	case http.StatusNotFound:
+		return nil, tasqueue.ErrNotFound
+	case http.StatusConflict:
+		return nil, tasqueue.ErrDuplicateJob
+	}
+
+	return nil, fmt.Errorf("request failed with status %d : %s", res.StatusCode, msg)
+}
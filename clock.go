@@ -0,0 +1,19 @@
+package tasqueue
+
+import "time"
+
+// Clock returns the current time, which the server schedules jobs (delays, ETAs of scheduled jobs and
+// retry delays) and stamps job messages with. It is set with ServerOpts.Clock, eg: to the fake clock
+// of the in-memory broker in tests, so that delayed and scheduled jobs are made due by advancing it.
+type Clock interface {
+	Now() time.Time
+}
+
+// now() returns the current time of the server's clock.
+func (s *Server) now() time.Time {
+	if s.clock == nil {
+		return time.Now()
+	}
+
+	return s.clock.Now()
+}
@@ -0,0 +1,179 @@
+package tasqueue
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Codec serializes the job messages pushed onto the broker and the job/group/chain metadata stored
+// in the results store. All the servers sharing a broker and results store must use the same codec.
+// Encoded values must not start with a 0 byte, which marks compressed values.
+type Codec interface {
+	Marshal(v any) ([]byte, error)
+	Unmarshal(b []byte, v any) error
+}
+
+// MsgpackCodec encodes values as msgpack. It is the default codec.
+type MsgpackCodec struct{}
+
+func (MsgpackCodec) Marshal(v any) ([]byte, error)   { return msgpack.Marshal(v) }
+func (MsgpackCodec) Unmarshal(b []byte, v any) error { return msgpack.Unmarshal(b, v) }
+
+// JSONCodec encodes values as JSON, which is easier to inspect on the broker and results store.
+type JSONCodec struct{}
+
+func (JSONCodec) Marshal(v any) ([]byte, error)   { return json.Marshal(v) }
+func (JSONCodec) Unmarshal(b []byte, v any) error { return json.Unmarshal(b, v) }
+
+const (
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+
+	// Default size of encoded values above which they are compressed.
+	DefaultCompressionThreshold = 1024
+)
+
+// Compression configures the compression of encoded values above a size threshold.
+type Compression struct {
+	// Algorithm is CompressionGzip or CompressionZstd. If empty, values aren't compressed.
+	Algorithm string
+
+	// OPTIONAL
+	// Threshold is the minimum size (in bytes) of encoded values that are compressed.
+	// Defaults to DefaultCompressionThreshold.
+	Threshold int
+}
+
+// Compressed (and encrypted) values are prefixed with a 0 byte and the algorithm's byte. Compressed values
+// are decoded regardless of the server's compression options, so that it can be changed safely.
+const (
+	compressedMarker = 0
+	algoGzip         = 1
+	algoZstd         = 2
+	algoEncrypted    = 3
+)
+
+// zstdDecoder is shared by all the servers, as it is safe for concurrent use.
+var zstdDecoder = sync.OnceValues(func() (*zstd.Decoder, error) {
+	return zstd.NewReader(nil)
+})
+
+// codec encodes the values stored by the server with its Codec, compressing and encrypting them
+// if configured.
+type codec struct {
+	Codec
+	comp Compression
+	zenc *zstd.Encoder
+	enc  Encrypter
+}
+
+func newCodec(c Codec, comp Compression, enc Encrypter) (*codec, error) {
+	if c == nil {
+		c = MsgpackCodec{}
+	}
+	if comp.Threshold == 0 {
+		comp.Threshold = DefaultCompressionThreshold
+	}
+
+	cd := &codec{Codec: c, comp: comp, enc: enc}
+	switch comp.Algorithm {
+	case "", CompressionGzip:
+	case CompressionZstd:
+		zenc, err := zstd.NewWriter(nil)
+		if err != nil {
+			return nil, err
+		}
+		cd.zenc = zenc
+	default:
+		return nil, fmt.Errorf("unknown compression algorithm %s", comp.Algorithm)
+	}
+
+	return cd, nil
+}
+
+func (c *codec) marshal(v any) ([]byte, error) {
+	b, err := c.compress(v)
+	if err != nil || c.enc == nil {
+		return b, err
+	}
+
+	e, err := c.enc.Encrypt(b)
+	if err != nil {
+		return nil, fmt.Errorf("could not encrypt value : %w", err)
+	}
+	return append([]byte{compressedMarker, algoEncrypted}, e...), nil
+}
+
+func (c *codec) compress(v any) ([]byte, error) {
+	b, err := c.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if c.comp.Algorithm == "" || len(b) < c.comp.Threshold {
+		return b, nil
+	}
+
+	switch c.comp.Algorithm {
+	case CompressionZstd:
+		return c.zenc.EncodeAll(b, []byte{compressedMarker, algoZstd}), nil
+	default:
+		var buf bytes.Buffer
+		buf.Write([]byte{compressedMarker, algoGzip})
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(b); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+}
+
+func (c *codec) unmarshal(b []byte, v any) error {
+	if len(b) < 2 || b[0] != compressedMarker {
+		return c.Unmarshal(b, v)
+	}
+
+	var (
+		raw []byte
+		err error
+	)
+	switch b[1] {
+	case algoEncrypted:
+		if c.enc == nil {
+			return fmt.Errorf("value is encrypted, but no encrypter is set")
+		}
+		d, err := c.enc.Decrypt(b[2:])
+		if err != nil {
+			return fmt.Errorf("could not decrypt value : %w", err)
+		}
+		return c.unmarshal(d, v)
+	case algoGzip:
+		var r *gzip.Reader
+		if r, err = gzip.NewReader(bytes.NewReader(b[2:])); err != nil {
+			return err
+		}
+		raw, err = io.ReadAll(r)
+	case algoZstd:
+		var dec *zstd.Decoder
+		if dec, err = zstdDecoder(); err != nil {
+			return err
+		}
+		raw, err = dec.DecodeAll(b[2:], nil)
+	default:
+		return fmt.Errorf("unknown compression of value: %d", b[1])
+	}
+	if err != nil {
+		return fmt.Errorf("could not decompress value : %w", err)
+	}
+
+	return c.Unmarshal(raw, v)
+}
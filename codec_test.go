@@ -0,0 +1,109 @@
+package tasqueue
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"os"
+	"testing"
+	"time"
+
+	rb "github.com/kalbhor/tasqueue/v2/brokers/in-memory"
+	rr "github.com/kalbhor/tasqueue/v2/results/in-memory"
+)
+
+func TestCodec(t *testing.T) {
+	msg := JobMessage{
+		Meta: Meta{ID: "id", Status: StatusStarted, Queue: DefaultQueue},
+		Job:  &Job{Task: taskName, Payload: bytes.Repeat([]byte("payload"), 1000)},
+	}
+
+	for _, tc := range []struct {
+		name  string
+		codec Codec
+		comp  Compression
+	}{
+		{"msgpack", nil, Compression{}},
+		{"json", JSONCodec{}, Compression{}},
+		{"gzip", nil, Compression{Algorithm: CompressionGzip}},
+		{"zstd", JSONCodec{}, Compression{Algorithm: CompressionZstd}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cd, err := newCodec(tc.codec, tc.comp, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := cd.marshal(msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			raw, err := cd.Marshal(msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if compressed := b[0] == compressedMarker; compressed != (tc.comp.Algorithm != "") {
+				t.Fatalf("incorrect compression, compressed: %v", compressed)
+			}
+			if tc.comp.Algorithm != "" && len(b) >= len(raw) {
+				t.Fatalf("compressed value (%d bytes) is not smaller than the encoded value (%d bytes)", len(b), len(raw))
+			}
+
+			// Compressed values are decoded by codecs without compression.
+			plain, err := newCodec(tc.codec, Compression{}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got JobMessage
+			if err := plain.unmarshal(b, &got); err != nil {
+				t.Fatal(err)
+			}
+			if got.ID != msg.ID || !bytes.Equal(got.Job.Payload, msg.Job.Payload) {
+				t.Fatal("decoded job message does not match")
+			}
+		})
+	}
+
+	if _, err := newCodec(nil, Compression{Algorithm: "lz4"}, nil); err == nil {
+		t.Fatal("expected error for an unknown compression algorithm")
+	}
+}
+
+func TestServerCodec(t *testing.T) {
+	var (
+		ctx, cancel = context.WithCancel(context.Background())
+		lo          = slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
+			Level: slog.LevelError,
+		}))
+	)
+	defer cancel()
+
+	srv, err := NewServer(ServerOpts{
+		Broker:      rb.New(),
+		Results:     rr.New(),
+		Logger:      lo.Handler(),
+		Codec:       JSONCodec{},
+		Compression: Compression{Algorithm: CompressionZstd, Threshold: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.RegisterTask(taskName, MockHandler, TaskOpts{}); err != nil {
+		t.Fatal(err)
+	}
+	go srv.Start(ctx)
+
+	id, err := srv.Enqueue(ctx, makeJob(t, taskName, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(200 * time.Millisecond)
+
+	msg, err := srv.GetJob(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Status != StatusDone {
+		t.Fatalf("incorrect job status, expected %s, got %s", StatusDone, msg.Status)
+	}
+}
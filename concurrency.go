@@ -0,0 +1,94 @@
+package tasqueue
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	// Delay after which a job consumed while its task was at its concurrency limit is consumed again.
+	concurrencyDeferDelay = time.Second
+
+	// Lease of the global concurrency slots, which are extended while their jobs are being processed.
+	semaphoreTTL = 30 * time.Second
+)
+
+// acquireTask() takes a slot of the task's concurrency limits (MaxConcurrency on the server and
+// GlobalConcurrency on the broker) for the job, if set. It returns false if the task is at either of
+// its limits, and otherwise a func releasing the slots, to be called once the job has been processed.
+func (s *Server) acquireTask(ctx context.Context, msg JobMessage, task Task) (func(), bool, error) {
+	if task.sem != nil {
+		select {
+		case task.sem <- struct{}{}:
+		default:
+			return nil, false, nil
+		}
+	}
+	releaseLocal := func() {
+		if task.sem != nil {
+			<-task.sem
+		}
+	}
+
+	if task.opts.GlobalConcurrency == 0 {
+		return releaseLocal, true, nil
+	}
+
+	var (
+		sb    = s.broker.(SemaphoreBroker)
+		key   = "task:" + task.name
+		limit = int(task.opts.GlobalConcurrency)
+	)
+	ok, err := sb.Acquire(ctx, key, msg.ID, limit, semaphoreTTL)
+	if err != nil || !ok {
+		releaseLocal()
+		return nil, false, err
+	}
+
+	// Extend the lease of the slot until the job has been processed.
+	done := make(chan struct{})
+	go func() {
+		tk := time.NewTicker(semaphoreTTL / 2)
+		defer tk.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-tk.C:
+				if _, err := sb.Acquire(ctx, key, msg.ID, limit, semaphoreTTL); err != nil {
+					s.log.Error("error extending task concurrency slot", "task", task.name, "error", err)
+				}
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		if err := sb.Release(context.WithoutCancel(ctx), key, msg.ID); err != nil {
+			s.log.Error("error releasing task concurrency slot", "task", task.name, "error", err)
+		}
+		releaseLocal()
+	}, true, nil
+}
+
+// deferJob() puts a job whose task can't be processed yet (eg: it is at its concurrency limit) back
+// onto its queue, to be consumed again after the delay. It returns false if the job couldn't be put back, in which
+// case it should be consumed again. As the broker may not support scheduled jobs (eg: rabbitmq), the job is then
+// held for the delay (up to concurrencyDeferDelay) first, so that it isn't consumed again right away.
+func (s *Server) deferJob(ctx context.Context, work []byte, msg JobMessage, delay time.Duration) bool {
+	s.log.Debug("deferring job", "id", msg.ID, "task", msg.Job.Task, "delay", delay)
+
+	if err := s.brokerFor(msg.Queue).EnqueueScheduled(ctx, work, msgQueue(msg), s.now().Add(delay)); err != nil {
+		s.log.Error("error deferring job", "id", msg.ID, "error", err)
+
+		tm := time.NewTimer(min(delay, concurrencyDeferDelay))
+		defer tm.Stop()
+		select {
+		case <-ctx.Done():
+		case <-tm.C:
+		}
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,132 @@
+package tasqueue
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	rb "github.com/kalbhor/tasqueue/v2/brokers/in-memory"
+	rr "github.com/kalbhor/tasqueue/v2/results/in-memory"
+)
+
+func TestTaskConcurrency(t *testing.T) {
+	lo := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
+		Level: slog.LevelError,
+	}))
+
+	for name, tc := range map[string]struct {
+		servers int
+		opts    TaskOpts
+	}{
+		"server": {servers: 1, opts: TaskOpts{MaxConcurrency: 1}},
+		"global": {servers: 2, opts: TaskOpts{GlobalConcurrency: 1}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			var (
+				broker  = rb.New()
+				results = rr.New()
+				running atomic.Int32
+				maxRun  atomic.Int32
+				heavy   = make(chan struct{}, 3)
+				light   = make(chan struct{}, 3)
+			)
+			// The heavy task shares the queue with the light one, which isn't limited.
+			tc.opts.Queue, tc.opts.Concurrency = "mixed", 4
+			var srv *Server
+			for i := 0; i < tc.servers; i++ {
+				s, err := NewServer(ServerOpts{Broker: broker, Results: results, Logger: lo.Handler()})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := s.RegisterTask("heavy", func([]byte, JobCtx) error {
+					n := running.Add(1)
+					defer running.Add(-1)
+					for m := maxRun.Load(); n > m; m = maxRun.Load() {
+						if maxRun.CompareAndSwap(m, n) {
+							break
+						}
+					}
+					time.Sleep(300 * time.Millisecond)
+					heavy <- struct{}{}
+					return nil
+				}, tc.opts); err != nil {
+					t.Fatal(err)
+				}
+				if err := s.RegisterTask("light", func([]byte, JobCtx) error {
+					light <- struct{}{}
+					return nil
+				}, TaskOpts{Queue: "mixed", Concurrency: 4}); err != nil {
+					t.Fatal(err)
+				}
+				go s.Start(ctx)
+				srv = s
+			}
+
+			start := time.Now()
+			for _, task := range []string{"heavy", "heavy", "heavy", "light", "light", "light"} {
+				job, err := NewJob(task, nil, JobOpts{Queue: "mixed"})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err := srv.Enqueue(ctx, job); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			for i := 0; i < 3; i++ {
+				<-light
+			}
+			if el := time.Since(start); el > 600*time.Millisecond {
+				t.Fatalf("light jobs were held by the heavy ones, processed in %v", el)
+			}
+			for i := 0; i < 3; i++ {
+				select {
+				case <-heavy:
+				case <-ctx.Done():
+					t.Fatal("heavy jobs were not processed")
+				}
+			}
+			if n := maxRun.Load(); n != 1 {
+				t.Fatalf("expected at most 1 heavy job to run at once, got %d", n)
+			}
+		})
+	}
+}
+
+// noScheduleBroker is a broker that doesn't support scheduled jobs, like rabbitmq.
+type noScheduleBroker struct {
+	Broker
+}
+
+func (noScheduleBroker) EnqueueScheduled(context.Context, []byte, string, time.Time) error {
+	return errors.New("scheduled jobs are not supported")
+}
+
+func TestDeferJobUnsupported(t *testing.T) {
+	lo := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
+		Level: slog.LevelError,
+	}))
+	srv, err := NewServer(ServerOpts{Broker: noScheduleBroker{rb.New()}, Results: rr.New(), Logger: lo.Handler()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The job is held for the delay before it is returned onto the queue, instead of being consumed
+	// again right away.
+	job := makeJob(t, taskName, false)
+	msg := job.message(DefaultMeta(job.Opts))
+	start := time.Now()
+	if srv.deferJob(context.Background(), nil, msg, 100*time.Millisecond) {
+		t.Fatal("expected the job not to be deferred")
+	}
+	if d := time.Since(start); d < 100*time.Millisecond {
+		t.Fatalf("expected the job to be held for the delay, returned after %v", d)
+	}
+}
@@ -0,0 +1,106 @@
+package tasqueue
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel"
+	spans "go.opentelemetry.io/otel/trace"
+)
+
+// deadLetter pushes a job that exhausted its retries onto the dead letter queue, if configured.
+func (s *Server) deadLetter(ctx context.Context, msg JobMessage) error {
+	if s.deadQueue == "" {
+		return nil
+	}
+
+	var span spans.Span
+	if s.traceProv != nil {
+		ctx, span = otel.Tracer(tracer).Start(ctx, "dead_letter")
+		defer span.End()
+	}
+
+	msg.Status = StatusFailed
+	b, err := s.codec.marshal(msg)
+	if err != nil {
+		s.spanError(span, err)
+		return err
+	}
+
+	if err := s.brokerFor(s.deadQueue).Enqueue(ctx, b, s.deadQueue); err != nil {
+		s.spanError(span, err)
+		return fmt.Errorf("could not push job onto dead letter queue : %w", err)
+	}
+
+	return nil
+}
+
+// GetDeadJobs() returns the job messages in the dead letter queue.
+func (s *Server) GetDeadJobs(ctx context.Context) ([]JobMessage, error) {
+	if s.deadQueue == "" {
+		return nil, fmt.Errorf("dead letter queue is not configured")
+	}
+
+	return s.pending(ctx, s.deadQueue, s.deadQueue)
+}
+
+// RequeueDeadJob() removes a job from the dead letter queue and enqueues it again
+// with its retries reset. The job retains its ID.
+func (s *Server) RequeueDeadJob(ctx context.Context, id string) error {
+	qm, err := s.deadQueueManager()
+	if err != nil {
+		return err
+	}
+
+	rs, err := s.brokerFor(s.deadQueue).GetPending(ctx, s.deadQueue)
+	if err != nil {
+		return err
+	}
+
+	for _, r := range rs {
+		var msg JobMessage
+		if err := s.codec.unmarshal([]byte(r), &msg); err != nil {
+			return err
+		}
+		if msg.ID != id {
+			continue
+		}
+
+		if err := qm.Remove(ctx, []byte(r), s.deadQueue); err != nil {
+			return err
+		}
+
+		msg.Retried = 0
+		msg.PrevErr, msg.Error = "", ""
+		if err := s.statusStarted(ctx, msg); err != nil {
+			return err
+		}
+
+		return s.enqueueMessage(ctx, msg)
+	}
+
+	return ErrNotFound
+}
+
+// PurgeDeadQueue() removes all the jobs from the dead letter queue.
+func (s *Server) PurgeDeadQueue(ctx context.Context) error {
+	qm, err := s.deadQueueManager()
+	if err != nil {
+		return err
+	}
+
+	return qm.Purge(ctx, s.deadQueue)
+}
+
+func (s *Server) deadQueueManager() (QueueManager, error) {
+	if s.deadQueue == "" {
+		return nil, fmt.Errorf("dead letter queue is not configured")
+	}
+
+	qm, ok := s.brokerFor(s.deadQueue).(QueueManager)
+	if !ok {
+		return nil, fmt.Errorf("broker does not support removing jobs from a queue")
+	}
+
+	return qm, nil
+}
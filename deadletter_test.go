@@ -0,0 +1,78 @@
+package tasqueue
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"testing"
+	"time"
+
+	rb "github.com/kalbhor/tasqueue/v2/brokers/in-memory"
+	rr "github.com/kalbhor/tasqueue/v2/results/in-memory"
+)
+
+const deadQueue = "tasqueue:dead"
+
+func TestDeadLetterQueue(t *testing.T) {
+	var (
+		ctx = context.Background()
+		lo  = slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
+			Level: slog.LevelError,
+		}))
+	)
+	srv, err := NewServer(ServerOpts{
+		Broker:          rb.New(),
+		Results:         rr.New(),
+		Logger:          lo.Handler(),
+		DeadLetterQueue: deadQueue,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.RegisterTask(taskName, MockHandler, TaskOpts{}); err != nil {
+		t.Fatal(err)
+	}
+	go srv.Start(ctx)
+
+	job := makeJob(t, taskName, true)
+	job.Opts.MaxRetries = 0
+	id, err := srv.Enqueue(ctx, job)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Wait for the job to fail and be pushed onto the dead letter queue.
+	time.Sleep(time.Second)
+	dead, err := srv.GetDeadJobs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dead) != 1 || dead[0].ID != id || dead[0].Status != StatusFailed {
+		t.Fatalf("dead job not found. resp: %+v", dead)
+	}
+
+	// Requeue the job, which fails and is pushed onto the dead letter queue again.
+	if err := srv.RequeueDeadJob(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.RequeueDeadJob(ctx, id); err != ErrNotFound {
+		t.Fatalf("expected requeued job to be removed from dead letter queue, got: %v", err)
+	}
+	time.Sleep(time.Second)
+	if dead, err = srv.GetDeadJobs(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(dead) != 1 || dead[0].ID != id {
+		t.Fatalf("requeued job not found in dead letter queue. resp: %+v", dead)
+	}
+
+	if err := srv.PurgeDeadQueue(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if dead, err = srv.GetDeadJobs(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(dead) != 0 {
+		t.Fatalf("dead letter queue not purged. resp: %+v", dead)
+	}
+}
@@ -0,0 +1,184 @@
+package tasqueue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// debouncePrefix is the key of the payloads coalesced onto a debounced job.
+	debouncePrefix     = "job:debounce:"
+	debounceLockPrefix = "job:debounce:lock:"
+
+	debounceLockTTL   = 5 * time.Second
+	debounceLockRetry = 10 * time.Millisecond
+	debounceLockTries = 500
+)
+
+// acquireDebounced() acquires the unique key of a debounced job, returning ok if the job is to be
+// enqueued. If the key is held by a pending job, the job's payload is coalesced onto it instead and the
+// pending job's id is returned.
+func (s *Server) acquireDebounced(ctx context.Context, msg JobMessage, payload []byte) (string, bool, error) {
+	for {
+		id, err := s.acquireUnique(ctx, msg)
+		if err == nil {
+			return id, true, nil
+		}
+		if !errors.Is(err, ErrDuplicateJob) {
+			return "", false, err
+		}
+
+		if err := s.addDebounced(ctx, id, msg.Job.Opts.DebounceCollect, payload); err != nil {
+			return "", false, err
+		}
+
+		// The pending job releases the key before reading the coalesced payloads once it is processed.
+		// If the key is still held by it, the payload will be read. Otherwise the job is enqueued as the
+		// next debounced run, or coalesced onto it.
+		holder, err := s.acquireUnique(ctx, msg)
+		if err == nil {
+			return holder, true, nil
+		}
+		if !errors.Is(err, ErrDuplicateJob) {
+			return "", false, err
+		}
+		if holder == id {
+			return id, false, nil
+		}
+	}
+}
+
+// addDebounced() coalesces the payload onto the pending debounced job, replacing the previous
+// payloads unless they are collected.
+func (s *Server) addDebounced(ctx context.Context, id string, collect bool, payload []byte) error {
+	payloads := [][]byte{payload}
+	if collect {
+		unlock, err := s.lockDebounced(ctx, id)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+
+		prev, err := s.getDebounced(ctx, id)
+		if err != nil {
+			return err
+		}
+		payloads = append(prev, payload)
+	}
+
+	b, err := json.Marshal(payloads)
+	if err != nil {
+		return fmt.Errorf("could not encode debounced payloads : %w", err)
+	}
+	if s.enc != nil {
+		if b, err = s.enc.Encrypt(b); err != nil {
+			return fmt.Errorf("could not encrypt debounced payloads : %w", err)
+		}
+	}
+	if err := setMeta(ctx, s.results, debouncePrefix+id, b); err != nil {
+		return fmt.Errorf("could not set debounced payloads : %w", err)
+	}
+
+	return nil
+}
+
+// lockDebounced() locks the payloads of the debounced job, so that concurrent enqueues don't
+// overwrite each other's payloads. It returns the func releasing the lock.
+func (s *Server) lockDebounced(ctx context.Context, id string) (func(), error) {
+	var (
+		ur    = s.results.(UniqueResults)
+		key   = debounceLockPrefix + id
+		token = uuid.NewString()
+	)
+	for i := 0; ; i++ {
+		_, ok, err := ur.SetUnique(ctx, key, token, debounceLockTTL)
+		if err != nil {
+			return nil, fmt.Errorf("could not lock debounced job : %w", err)
+		}
+		if ok {
+			break
+		}
+		if i == debounceLockTries {
+			return nil, fmt.Errorf("could not lock debounced job %s : timed out", id)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(debounceLockRetry):
+		}
+	}
+
+	return func() {
+		if err := ur.DeleteUnique(context.WithoutCancel(ctx), key, token); err != nil {
+			s.log.Error("could not unlock debounced job", "id", id, "error", err)
+		}
+	}, nil
+}
+
+// getDebounced() returns the payloads coalesced onto the debounced job, if any.
+func (s *Server) getDebounced(ctx context.Context, id string) ([][]byte, error) {
+	b, err := s.results.Get(ctx, debouncePrefix+id)
+	if errors.Is(err, s.results.NilError()) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not get debounced payloads : %w", err)
+	}
+	if s.enc != nil {
+		if b, err = s.enc.Decrypt(b); err != nil {
+			return nil, fmt.Errorf("could not decrypt debounced payloads : %w", err)
+		}
+	}
+
+	var payloads [][]byte
+	if err := json.Unmarshal(b, &payloads); err != nil {
+		return nil, fmt.Errorf("could not decode debounced payloads : %w", err)
+	}
+
+	return payloads, nil
+}
+
+// debouncedPayload() returns the payload the debounced job (id) is processed with: the latest payload
+// coalesced onto it, or all of them (including its own), encoded with DecodeDebounced().
+func (s *Server) debouncedPayload(ctx context.Context, id string, opts JobOpts, payload []byte) ([]byte, error) {
+	if opts.Debounce == 0 {
+		return payload, nil
+	}
+
+	payloads, err := s.getDebounced(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if !opts.DebounceCollect {
+		if len(payloads) == 0 {
+			return payload, nil
+		}
+		return payloads[len(payloads)-1], nil
+	}
+
+	return json.Marshal(append([][]byte{payload}, payloads...))
+}
+
+// deleteDebounced() deletes the payloads coalesced onto the finished debounced job.
+func (s *Server) deleteDebounced(ctx context.Context, msg JobMessage) {
+	if err := s.results.DeleteJob(ctx, debouncePrefix+msg.ID); err != nil {
+		s.log.Error("could not delete debounced payloads", "id", msg.ID, "error", err)
+	}
+}
+
+// DecodeDebounced() decodes the payload passed to the handler of a job enqueued with
+// JobOpts.DebounceCollect into the payloads of the jobs coalesced onto it, in order of enqueue.
+func DecodeDebounced(b []byte) ([][]byte, error) {
+	var payloads [][]byte
+	if err := json.Unmarshal(b, &payloads); err != nil {
+		return nil, fmt.Errorf("could not decode debounced payloads : %w", err)
+	}
+
+	return payloads, nil
+}
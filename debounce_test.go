@@ -0,0 +1,111 @@
+package tasqueue
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	rb "github.com/kalbhor/tasqueue/v2/brokers/in-memory"
+	rr "github.com/kalbhor/tasqueue/v2/results/in-memory"
+)
+
+func TestDebounce(t *testing.T) {
+	lo := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
+		Level: slog.LevelError,
+	}))
+
+	for name, collect := range map[string]bool{"latest": false, "collect": true} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			clock := rb.NewFakeClock(time.Now())
+			srv, err := NewServer(ServerOpts{
+				Broker:  rb.NewWithClock(clock),
+				Results: rr.New(),
+				Logger:  lo.Handler(),
+				Clock:   clock,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			if err := srv.RegisterTask("reindex", func(b []byte, _ JobCtx) error {
+				if !collect {
+					got = append(got, string(b))
+					return nil
+				}
+				payloads, err := DecodeDebounced(b)
+				if err != nil {
+					return err
+				}
+				var p []string
+				for _, b := range payloads {
+					p = append(p, string(b))
+				}
+				got = append(got, strings.Join(p, ","))
+				return nil
+			}, TaskOpts{}); err != nil {
+				t.Fatal(err)
+			}
+
+			// Enqueues a debounced job for the entity, returning its id.
+			enqueue := func(payload string) string {
+				job, err := NewJob("reindex", []byte(payload), JobOpts{
+					UniqueKey:       "entity:1",
+					Debounce:        time.Minute,
+					DebounceCollect: collect,
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+				id, err := srv.Enqueue(ctx, job)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return id
+			}
+
+			id := enqueue("a")
+			for _, p := range []string{"b", "c"} {
+				if other := enqueue(p); other != id {
+					t.Fatalf("expected the job to be coalesced onto %s, got %s", id, other)
+				}
+			}
+
+			// The job isn't processed before the debounce window ends.
+			if _, err := srv.ProcessAll(ctx); err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != 0 {
+				t.Fatalf("expected the job not to be processed yet, got %v", got)
+			}
+
+			clock.Advance(time.Minute)
+			if _, err := srv.ProcessAll(ctx); err != nil {
+				t.Fatal(err)
+			}
+			want := "c"
+			if collect {
+				want = "a,b,c"
+			}
+			if len(got) != 1 || got[0] != want {
+				t.Fatalf("expected a single run with %q, got %v", want, got)
+			}
+
+			// Jobs enqueued after the run are debounced into a new one.
+			if next := enqueue("d"); next == id {
+				t.Fatalf("expected a new job, got %s", next)
+			}
+			clock.Advance(time.Minute)
+			if _, err := srv.ProcessAll(ctx); err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != 2 || got[1] != "d" {
+				t.Fatalf("expected a second run with %q, got %v", "d", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package tasqueue
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+)
+
+// Encrypter encrypts the job messages (including their payloads) and the job results before they
+// are stored on the broker and the results store. All the servers sharing a broker and results
+// store must be able to decrypt the values encrypted by the others.
+type Encrypter interface {
+	Encrypt(b []byte) ([]byte, error)
+	Decrypt(b []byte) ([]byte, error)
+}
+
+// AESKey is a key used by AESEncrypter. The ID is stored along with the encrypted values, to
+// pick the key to decrypt them with.
+type AESKey struct {
+	ID string
+	// Key is a 16, 24 or 32 byte key, for AES-128, AES-192 or AES-256.
+	Key []byte
+}
+
+// AESEncrypter encrypts values with AES-GCM.
+type AESEncrypter struct {
+	// current is the ID of the key values are encrypted with.
+	current string
+	aeads   map[string]cipher.AEAD
+}
+
+// Version of the format of the values encrypted by AESEncrypter.
+const aesVersion = 1
+
+var errUnknownKey = errors.New("value is encrypted with an unknown key")
+
+// NewAESEncrypter() returns an AES-GCM encrypter. Values are encrypted with the first key and
+// decrypted with the key they were encrypted with. To rotate keys, pass the new key first and
+// keep the old keys until the values encrypted with them have expired.
+func NewAESEncrypter(keys ...AESKey) (*AESEncrypter, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("minimum 1 key required for encryption")
+	}
+
+	e := &AESEncrypter{current: keys[0].ID, aeads: make(map[string]cipher.AEAD, len(keys))}
+	for _, k := range keys {
+		if len(k.ID) > 255 {
+			return nil, fmt.Errorf("key id %s is longer than 255 bytes", k.ID)
+		}
+		if _, ok := e.aeads[k.ID]; ok {
+			return nil, fmt.Errorf("duplicate key id %s", k.ID)
+		}
+
+		block, err := aes.NewCipher(k.Key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %s : %w", k.ID, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		e.aeads[k.ID] = aead
+	}
+
+	return e, nil
+}
+
+// Encrypt() encrypts the value as: version | key id length | key id | nonce | ciphertext.
+// The key id is authenticated along with the ciphertext.
+func (e *AESEncrypter) Encrypt(b []byte) ([]byte, error) {
+	aead := e.aeads[e.current]
+
+	hdr := make([]byte, 0, 2+len(e.current)+aead.NonceSize()+len(b)+aead.Overhead())
+	hdr = append(hdr, aesVersion, byte(len(e.current)))
+	hdr = append(hdr, e.current...)
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	out := append(hdr, nonce...)
+	return aead.Seal(out, nonce, b, hdr), nil
+}
+
+func (e *AESEncrypter) Decrypt(b []byte) ([]byte, error) {
+	if len(b) < 2 || b[0] != aesVersion {
+		return nil, fmt.Errorf("value is not encrypted")
+	}
+	n := 2 + int(b[1])
+	if len(b) < n {
+		return nil, fmt.Errorf("invalid encrypted value")
+	}
+
+	aead, ok := e.aeads[string(b[2:n])]
+	if !ok {
+		return nil, errUnknownKey
+	}
+	if len(b) < n+aead.NonceSize() {
+		return nil, fmt.Errorf("invalid encrypted value")
+	}
+
+	return aead.Open(nil, b[n:n+aead.NonceSize()], b[n+aead.NonceSize():], b[:n])
+}
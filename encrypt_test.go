@@ -0,0 +1,145 @@
+package tasqueue
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"testing"
+	"time"
+
+	rb "github.com/kalbhor/tasqueue/v2/brokers/in-memory"
+	rr "github.com/kalbhor/tasqueue/v2/results/in-memory"
+)
+
+func TestAESEncrypter(t *testing.T) {
+	var (
+		oldKey = AESKey{ID: "2024", Key: bytes.Repeat([]byte{1}, 32)}
+		newKey = AESKey{ID: "2025", Key: bytes.Repeat([]byte{2}, 16)}
+		plain  = []byte("sensitive payload")
+	)
+
+	old, err := NewAESEncrypter(oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := old.Encrypt(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(b, plain) {
+		t.Fatal("encrypted value contains the plaintext")
+	}
+
+	// Values encrypted with the old key are decrypted after rotating keys.
+	rotated, err := NewAESEncrypter(newKey, oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := rotated.Decrypt(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(d, plain) {
+		t.Fatalf("incorrect decrypted value: %s", d)
+	}
+
+	// New values are encrypted with the new key, which the old encrypter doesn't have.
+	if b, err = rotated.Encrypt(plain); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := old.Decrypt(b); !errors.Is(err, errUnknownKey) {
+		t.Fatalf("expected %v, got %v", errUnknownKey, err)
+	}
+
+	b[len(b)-1] ^= 1
+	if _, err := rotated.Decrypt(b); err == nil {
+		t.Fatal("expected error decrypting a tampered value")
+	}
+
+	if _, err := NewAESEncrypter(AESKey{ID: "short", Key: []byte("short")}); err == nil {
+		t.Fatal("expected error for an invalid key size")
+	}
+}
+
+func TestServerEncryption(t *testing.T) {
+	var (
+		ctx, cancel = context.WithCancel(context.Background())
+		payload     = []byte("sensitive payload")
+		result      = []byte("sensitive result")
+		broker      = rb.New()
+		results     = rr.New()
+		got         = make(chan []byte, 1)
+	)
+	defer cancel()
+
+	enc, err := NewAESEncrypter(AESKey{ID: "1", Key: bytes.Repeat([]byte{1}, 32)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv, err := NewServer(ServerOpts{
+		Broker:    broker,
+		Results:   results,
+		Logger:    slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError})).Handler(),
+		Encrypter: enc,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.RegisterTask(taskName, func(b []byte, c JobCtx) error {
+		got <- b
+		return c.Save(result)
+	}, TaskOpts{}); err != nil {
+		t.Fatal(err)
+	}
+
+	j, err := NewJob(taskName, payload, JobOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := srv.Enqueue(ctx, j)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The payload is encrypted on the broker and the results store.
+	pending, err := broker.GetPending(ctx, DefaultQueue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 1 || bytes.Contains([]byte(pending[0]), payload) {
+		t.Fatal("job message on the broker is not encrypted")
+	}
+	b, err := results.Get(ctx, jobPrefix+id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(b, payload) {
+		t.Fatal("job message on the results store is not encrypted")
+	}
+
+	go srv.Start(ctx)
+	select {
+	case b := <-got:
+		if !bytes.Equal(b, payload) {
+			t.Fatalf("incorrect payload passed to the handler: %s", b)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("job was not processed")
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	if b, err = results.Get(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(b, result) {
+		t.Fatal("result on the results store is not encrypted")
+	}
+	if b, err = srv.GetResult(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, result) {
+		t.Fatalf("incorrect result: %s", b)
+	}
+}
@@ -0,0 +1,84 @@
+package tasqueue
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Event is a state transition of a job, recorded on the EventsStore set with ServerOpts.Events.
+type Event struct {
+	JobID string `json:"job_id"`
+	Task  string `json:"task"`
+	Queue string `json:"queue"`
+
+	// Status is the state the job moved onto, eg: StatusProcessing.
+	Status string    `json:"status"`
+	At     time.Time `json:"at"`
+
+	// Worker is the ID of the server that made the transition (ServerOpts.WorkerID).
+	Worker string `json:"worker"`
+
+	// Error is the error of the job's last attempt, if any.
+	Error string `json:"error,omitempty"`
+}
+
+// defaultWorkerID returns the ID of the server, derived from its hostname and process ID.
+func defaultWorkerID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
+
+// recordEvent() records the transition of the job onto its current status, if an events store is set.
+// Events are recorded on a best effort basis, so that the job isn't failed if the store is unavailable.
+func (s *Server) recordEvent(ctx context.Context, msg JobMessage) {
+	if s.events == nil {
+		return
+	}
+
+	e := Event{
+		JobID:  msg.ID,
+		Task:   msg.Job.Task,
+		Queue:  msg.Queue,
+		Status: msg.Status,
+		At:     msg.ProcessedAt,
+		Worker: s.workerID,
+		Error:  msg.Error,
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		s.log.Error("could not encode job event", "id", msg.ID, "error", err)
+		return
+	}
+	if err := s.events.RecordEvent(ctx, msg.ID, e.At, b); err != nil {
+		s.log.Error("could not record job event", "id", msg.ID, "status", msg.Status, "error", err)
+	}
+}
+
+// GetJobEvents() returns the state transitions of the job recorded on the events store, in the
+// order they were recorded.
+func (s *Server) GetJobEvents(ctx context.Context, id string) ([]Event, error) {
+	if s.events == nil {
+		return nil, fmt.Errorf("events store not set")
+	}
+
+	rs, err := s.events.GetEvents(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]Event, len(rs))
+	for i, b := range rs {
+		if err := json.Unmarshal(b, &out[i]); err != nil {
+			return nil, fmt.Errorf("could not decode job event : %w", err)
+		}
+	}
+
+	return out, nil
+}
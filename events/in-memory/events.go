@@ -0,0 +1,34 @@
+// Package inmemory is an in-memory events store, holding the events of jobs for the lifetime of the process.
+package inmemory
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type Events struct {
+	mu     sync.Mutex
+	events map[string][][]byte
+}
+
+func New() *Events {
+	return &Events{
+		events: make(map[string][][]byte),
+	}
+}
+
+func (e *Events) RecordEvent(ctx context.Context, id string, at time.Time, event []byte) error {
+	e.mu.Lock()
+	e.events[id] = append(e.events[id], event)
+	e.mu.Unlock()
+
+	return nil
+}
+
+func (e *Events) GetEvents(ctx context.Context, id string) ([][]byte, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return append([][]byte(nil), e.events[id]...), nil
+}
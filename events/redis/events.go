@@ -0,0 +1,103 @@
+// Package redis is an events store recording the events of each job on a redis stream.
+package redis
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Prefix of the streams holding the events of each job
+const eventsPrefix = "tq:events:"
+
+type Options struct {
+	Addrs        []string
+	Password     string
+	DB           int
+	DialTimeout  time.Duration
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+
+	// OPTIONAL
+	// MasterName is the name of the master monitored by redis Sentinel, in which case `Addrs` are
+	// the addresses of the sentinels.
+	MasterName string
+
+	// OPTIONAL
+	// Expiry is the duration the events of a job are kept for after its last event. If zero,
+	// they don't expire.
+	Expiry time.Duration
+
+	// OPTIONAL
+	// If non-zero, the streams are trimmed to approximately the last `MaxLen` events of each job.
+	MaxLen int64
+}
+
+type Events struct {
+	opts Options
+	lo   *slog.Logger
+	conn redis.UniversalClient
+}
+
+func New(o Options, lo *slog.Logger) *Events {
+	return &Events{
+		opts: o,
+		lo:   lo,
+		conn: redis.NewUniversalClient(&redis.UniversalOptions{
+			Addrs:        o.Addrs,
+			Password:     o.Password,
+			DB:           o.DB,
+			DialTimeout:  o.DialTimeout,
+			ReadTimeout:  o.ReadTimeout,
+			WriteTimeout: o.WriteTimeout,
+			MasterName:   o.MasterName,
+		}),
+	}
+}
+
+// RecordEvent appends the event onto the job's stream, along with its time (unix nanoseconds).
+func (e *Events) RecordEvent(ctx context.Context, id string, at time.Time, event []byte) error {
+	e.lo.Debug("recording job event", "id", id)
+
+	key := eventsPrefix + id
+	_, err := e.conn.Pipelined(ctx, func(p redis.Pipeliner) error {
+		p.XAdd(ctx, &redis.XAddArgs{
+			Stream: key,
+			MaxLen: e.opts.MaxLen,
+			Approx: e.opts.MaxLen != 0,
+			Values: []any{"at", strconv.FormatInt(at.UnixNano(), 10), "event", event},
+		})
+		if e.opts.Expiry != 0 {
+			p.PExpire(ctx, key, e.opts.Expiry)
+		}
+		return nil
+	})
+
+	return err
+}
+
+func (e *Events) GetEvents(ctx context.Context, id string) ([][]byte, error) {
+	e.lo.Debug("getting job events", "id", id)
+
+	msgs, err := e.conn.XRange(ctx, eventsPrefix+id, "-", "+").Result()
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([][]byte, 0, len(msgs))
+	for _, m := range msgs {
+		if v, ok := m.Values["event"].(string); ok {
+			out = append(out, []byte(v))
+		}
+	}
+
+	return out, nil
+}
+
+// Close closes the connection to redis.
+func (e *Events) Close() error {
+	return e.conn.Close()
+}
@@ -0,0 +1,62 @@
+package tasqueue
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"slices"
+	"testing"
+
+	rb "github.com/kalbhor/tasqueue/v2/brokers/in-memory"
+	re "github.com/kalbhor/tasqueue/v2/events/in-memory"
+	rr "github.com/kalbhor/tasqueue/v2/results/in-memory"
+)
+
+func TestJobEvents(t *testing.T) {
+	var (
+		ctx = context.Background()
+		lo  = slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
+			Level: slog.LevelError,
+		}))
+	)
+	srv, err := NewServer(ServerOpts{
+		Broker:   rb.New(),
+		Results:  rr.New(),
+		Logger:   lo.Handler(),
+		Events:   re.New(),
+		WorkerID: "worker-1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.RegisterTask(taskName, MockHandler, TaskOpts{}); err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := srv.Enqueue(ctx, makeJob(t, taskName, true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := srv.ProcessAll(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := srv.GetJobEvents(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var statuses []string
+	for _, e := range events {
+		if e.JobID != id || e.Task != taskName || e.Worker != "worker-1" || e.At.IsZero() {
+			t.Fatalf("incorrect event, got %+v", e)
+		}
+		statuses = append(statuses, e.Status)
+	}
+	exp := []string{StatusStarted, StatusProcessing, StatusRetrying, StatusProcessing, StatusFailed}
+	if !slices.Equal(statuses, exp) {
+		t.Fatalf("incorrect job events, expected %v, got %v", exp, statuses)
+	}
+	if events[len(events)-1].Error == "" {
+		t.Fatal("expected the failed event to carry the job's error")
+	}
+}
@@ -0,0 +1,247 @@
+package tasqueue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// Kinds of the records of an export.
+const (
+	exportPending  = "pending"
+	exportFinished = "finished"
+	exportSchedule = "schedule"
+)
+
+// exportRecord is a line of an export: a pending job along with its queue, a finished job along with
+// its result, or a schedule.
+type exportRecord struct {
+	Kind     string      `json:"kind"`
+	Queue    string      `json:"queue,omitempty"`
+	Job      *JobMessage `json:"job,omitempty"`
+	Result   []byte      `json:"result,omitempty"`
+	Schedule *Schedule   `json:"schedule,omitempty"`
+}
+
+// ExportStats counts the records exported or imported.
+type ExportStats struct {
+	Pending   int
+	Finished  int
+	Schedules int
+}
+
+// Export() writes the pending jobs of the registered queues (and the dead letter queue), the finished jobs
+// along with their results, and the schedules onto w as JSON lines, to be loaded into servers of other brokers
+// or results stores with Import(). Offloaded payloads are inlined (finished jobs whose payloads were deleted
+// are exported without them), and payloads and results are decrypted, so that the export is portable. Jobs
+// scheduled for later (with an ETA or waiting to be retried) are held by the broker and are not exported. The
+// queues should be paused while exporting, so that jobs aren't processed meanwhile.
+func (s *Server) Export(ctx context.Context, w io.Writer) (ExportStats, error) {
+	var (
+		st  ExportStats
+		enc = json.NewEncoder(w)
+	)
+
+	// parent is a map of broker queue -> the queue it's a sub-queue of, whose broker holds it.
+	s.q.RLock()
+	var (
+		queues = make([]string, 0, len(s.queues)+1)
+		parent = make(map[string]string, len(s.queues)+1)
+	)
+	for q := range s.queues {
+		for _, sub := range s.subQueues(q) {
+			queues = append(queues, sub)
+			parent[sub] = q
+		}
+	}
+	s.q.RUnlock()
+	sort.Strings(queues)
+	if s.deadQueue != "" {
+		queues = append(queues, s.deadQueue)
+		parent[s.deadQueue] = s.deadQueue
+	}
+
+	for _, q := range queues {
+		msgs, err := s.pending(ctx, parent[q], q)
+		if err != nil {
+			return st, fmt.Errorf("could not get pending jobs of queue %s : %w", q, err)
+		}
+		for _, m := range msgs {
+			if err := s.inlinePayloads(ctx, m.Job); err != nil {
+				return st, err
+			}
+			if err := enc.Encode(exportRecord{Kind: exportPending, Queue: q, Job: &m}); err != nil {
+				return st, fmt.Errorf("could not write export : %w", err)
+			}
+			st.Pending++
+		}
+	}
+
+	for _, list := range []func(context.Context) ([]string, error){s.results.GetSuccess, s.results.GetFailed} {
+		ids, err := list(ctx)
+		if err != nil {
+			return st, fmt.Errorf("could not get finished jobs : %w", err)
+		}
+		for _, id := range ids {
+			msg, err := s.GetJob(ctx, id)
+			if errors.Is(err, ErrNotFound) {
+				continue
+			}
+			if err != nil {
+				return st, fmt.Errorf("could not get job %s : %w", id, err)
+			}
+			// The offloaded payloads of finished jobs are deleted once they are done with.
+			if err := s.inlinePayloads(ctx, msg.Job); err != nil {
+				for _, j := range jobTree(msg.Job) {
+					j.PayloadRef = ""
+				}
+			}
+			res, err := s.GetResult(ctx, id)
+			if err != nil && !errors.Is(err, ErrNotFound) {
+				return st, fmt.Errorf("could not get result of job %s : %w", id, err)
+			}
+			if err := enc.Encode(exportRecord{Kind: exportFinished, Job: &msg, Result: res}); err != nil {
+				return st, fmt.Errorf("could not write export : %w", err)
+			}
+			st.Finished++
+		}
+	}
+
+	if _, ok := s.results.(UniqueResults); ok {
+		schs, err := s.GetSchedules(ctx)
+		if err != nil {
+			return st, fmt.Errorf("could not get schedules : %w", err)
+		}
+		for _, sch := range schs {
+			if err := enc.Encode(exportRecord{Kind: exportSchedule, Schedule: &sch}); err != nil {
+				return st, fmt.Errorf("could not write export : %w", err)
+			}
+			st.Schedules++
+		}
+	}
+
+	return st, nil
+}
+
+// Import() loads an export written by Export(). Pending jobs are enqueued onto their queues (or onto the
+// dead letter queue, if they were exported from it), keeping their IDs, finished jobs and their results are
+// stored, and schedules are registered, replacing the ones with the same IDs.
+func (s *Server) Import(ctx context.Context, r io.Reader) (ExportStats, error) {
+	var (
+		st   ExportStats
+		dec  = json.NewDecoder(r)
+		schs []Schedule
+	)
+	for {
+		var rec exportRecord
+		if err := dec.Decode(&rec); err == io.EOF {
+			break
+		} else if err != nil {
+			return st, fmt.Errorf("could not read export : %w", err)
+		}
+
+		switch {
+		case rec.Kind == exportPending && rec.Job != nil && rec.Job.Job != nil:
+			if err := s.importPending(ctx, rec); err != nil {
+				return st, fmt.Errorf("could not import job %s : %w", rec.Job.ID, err)
+			}
+			st.Pending++
+		case rec.Kind == exportFinished && rec.Job != nil && rec.Job.Job != nil:
+			if err := s.importFinished(ctx, rec); err != nil {
+				return st, fmt.Errorf("could not import job %s : %w", rec.Job.ID, err)
+			}
+			st.Finished++
+		case rec.Kind == exportSchedule && rec.Schedule != nil:
+			schs = append(schs, *rec.Schedule)
+		default:
+			return st, fmt.Errorf("invalid export record of kind %q", rec.Kind)
+		}
+	}
+
+	if len(schs) == 0 {
+		return st, nil
+	}
+	// Schedules are picked up as updated, so that their missed runs aren't enqueued.
+	now := time.Now()
+	if err := s.updateSchedules(ctx, func(cur map[string]Schedule) error {
+		for _, sch := range schs {
+			sch.UpdatedAt = now
+			cur[sch.ID] = sch
+		}
+		return nil
+	}); err != nil {
+		return st, fmt.Errorf("could not import schedules : %w", err)
+	}
+	st.Schedules = len(schs)
+
+	return st, nil
+}
+
+// importPending() enqueues the exported pending job.
+func (s *Server) importPending(ctx context.Context, rec exportRecord) error {
+	msg := *rec.Job
+	if s.deadQueue != "" && rec.Queue == s.deadQueue {
+		b, err := s.codec.marshal(msg)
+		if err != nil {
+			return err
+		}
+		return s.brokerFor(s.deadQueue).Enqueue(ctx, b, s.deadQueue)
+	}
+
+	// The job was already due, it is enqueued right away even if it has an ETA.
+	if err := s.statusStarted(ctx, msg); err != nil {
+		return err
+	}
+
+	return s.enqueueMessage(ctx, msg)
+}
+
+// importFinished() stores the exported finished job and its result.
+func (s *Server) importFinished(ctx context.Context, rec exportRecord) error {
+	msg := *rec.Job
+	if err := s.setJobMessage(ctx, msg); err != nil {
+		return err
+	}
+
+	if rec.Result != nil {
+		b := rec.Result
+		if s.enc != nil {
+			var err error
+			if b, err = s.enc.Encrypt(b); err != nil {
+				return fmt.Errorf("could not encrypt result : %w", err)
+			}
+		}
+		if err := s.results.Set(ctx, msg.ID, b); err != nil {
+			return err
+		}
+	}
+
+	switch msg.Status {
+	case StatusDone:
+		return s.results.SetSuccess(ctx, msg.ID)
+	case StatusFailed:
+		return s.results.SetFailed(ctx, msg.ID)
+	}
+
+	return nil
+}
+
+// inlinePayloads() loads the offloaded payloads of the job and its OnSuccess and OnError jobs back onto them.
+func (s *Server) inlinePayloads(ctx context.Context, j *Job) error {
+	for _, t := range jobTree(j) {
+		if t.PayloadRef == "" {
+			continue
+		}
+		b, err := s.loadPayload(ctx, t)
+		if err != nil {
+			return err
+		}
+		t.Payload, t.PayloadRef = b, ""
+	}
+
+	return nil
+}
@@ -0,0 +1,110 @@
+package tasqueue
+
+import (
+	"bytes"
+	"context"
+	"slices"
+	"testing"
+)
+
+func TestExportImport(t *testing.T) {
+	var (
+		ctx     = context.Background()
+		handler = func(b []byte, j JobCtx) error {
+			if err := j.Save([]byte("result")); err != nil {
+				return err
+			}
+			return MockHandler(b, j)
+		}
+		src = newServer(t, taskName, handler)
+		dst = newServer(t, taskName, handler)
+	)
+
+	// A finished job, along with its result.
+	done, err := src.Enqueue(ctx, makeJob(t, taskName, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := src.ProcessAll(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Pending jobs.
+	var pending []string
+	for i := 0; i < 2; i++ {
+		id, err := src.Enqueue(ctx, makeJob(t, taskName, false))
+		if err != nil {
+			t.Fatal(err)
+		}
+		pending = append(pending, id)
+	}
+
+	// A schedule.
+	sch, err := NewSchedule("@every 1h", makeJob(t, taskName, false), ScheduleOpts{ID: "hourly"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := src.RegisterSchedule(ctx, sch); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	st, err := src.Export(ctx, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st != (ExportStats{Pending: 2, Finished: 1, Schedules: 1}) {
+		t.Fatalf("incorrect export stats: %+v", st)
+	}
+
+	st, err = dst.Import(ctx, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st != (ExportStats{Pending: 2, Finished: 1, Schedules: 1}) {
+		t.Fatalf("incorrect import stats: %+v", st)
+	}
+
+	msgs, err := dst.GetPending(ctx, DefaultQueue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 2 || msgs[0].ID != pending[0] || msgs[1].ID != pending[1] {
+		t.Fatalf("incorrect pending jobs: %v", msgs)
+	}
+
+	msg, err := dst.GetJob(ctx, done)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Status != StatusDone {
+		t.Fatalf("incorrect status of finished job: %s", msg.Status)
+	}
+	res, err := dst.GetResult(ctx, done)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(res) != "result" {
+		t.Fatalf("incorrect result: %s", res)
+	}
+	ids, err := dst.GetSuccess(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(ids, done) {
+		t.Fatalf("incorrect successful jobs: %v", ids)
+	}
+
+	schs, err := dst.GetSchedules(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(schs) != 1 || schs[0].ID != "hourly" {
+		t.Fatalf("incorrect schedules: %v", schs)
+	}
+
+	// The imported jobs are processed by the destination.
+	if n, err := dst.ProcessAll(ctx); err != nil || n != 2 {
+		t.Fatalf("expected 2 processed jobs, got %d: %v", n, err)
+	}
+}
@@ -2,7 +2,9 @@ package tasqueue
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/vmihailenco/msgpack/v5"
@@ -15,6 +17,11 @@ type Group struct {
 
 type GroupOpts struct {
 	ID string
+
+	// OnComplete is enqueued once all the jobs of the group are successful (a chord). Its
+	// JobCtx.Meta.PrevJobResult holds the results of the group's jobs, which can be decoded
+	// with DecodeResults(). The results store must implement UniqueResults.
+	OnComplete *Job
 }
 
 // GroupMeta contains fields related to a group job. These are updated when a task is consumed.
@@ -23,6 +30,13 @@ type GroupMeta struct {
 	Status string
 	// JobStatus is a map of job id -> status
 	JobStatus map[string]string
+	// IDs of the jobs, in the order of the group's jobs
+	JobIDs []string
+	// Results is a map of job id -> result of the successful jobs that saved a result.
+	// It is populated by GetGroup() and isn't stored.
+	Results map[string][]byte
+	// ID of the OnComplete job, if any
+	CompleteJobID string
 }
 
 // GroupMessage is a wrapper over Group, containing meta info such as status, id.
@@ -64,18 +78,35 @@ func NewGroup(j []Job, opts GroupOpts) (Group, error) {
 // 3. Loops over all jobs part of the group and enqueues the job each job.
 // 4. The job status map is updated with the IDs of each enqueued job.
 func (s *Server) EnqueueGroup(ctx context.Context, t Group) (string, error) {
-	msg := t.message()
-	for _, v := range t.Jobs {
-		uid, err := s.Enqueue(ctx, v)
-		if err != nil {
-			return "", fmt.Errorf("could not enqueue group : %w", err)
+	if t.Opts.OnComplete != nil {
+		if _, ok := s.results.(UniqueResults); !ok {
+			return "", fmt.Errorf("results store does not support group completion jobs")
 		}
-		msg.JobStatus[uid] = StatusStarted
 	}
 
+	msg := t.message()
+	metas := make([]Meta, len(t.Jobs))
+	for i, v := range t.Jobs {
+		metas[i] = DefaultMeta(v.Opts)
+		metas[i].GroupID = msg.ID
+		msg.JobIDs = append(msg.JobIDs, metas[i].ID)
+		msg.JobStatus[metas[i].ID] = StatusStarted
+	}
+	if t.Opts.OnComplete != nil {
+		msg.CompleteJobID = DefaultMeta(t.Opts.OnComplete.Opts).ID
+	}
+
+	// Store the group before enqueuing its jobs, so that it is known to the servers
+	// processing them.
 	if err := s.setGroupMessage(ctx, msg); err != nil {
 		return "", err
 	}
+	for i, v := range t.Jobs {
+		if _, err := s.enqueueWithMeta(ctx, v, metas[i]); err != nil {
+			return "", fmt.Errorf("could not enqueue group : %w", err)
+		}
+	}
+
 	return msg.ID, nil
 }
 
@@ -86,8 +117,8 @@ func (s *Server) GetGroup(ctx context.Context, id string) (GroupMessage, error)
 	}
 	// If the group status is either "done" or "failed".
 	// Do an early return
-	if g.Status == StatusDone || g.Status == StatusFailed {
-		return g, nil
+	if g.Status == StatusDone || g.Status == StatusFailed || g.Status == StatusCancelled || g.Status == StatusExpired {
+		return s.groupResults(ctx, g)
 	}
 
 	// jobStatus holds the updated map of job status'
@@ -97,7 +128,7 @@ func (s *Server) GetGroup(ctx context.Context, id string) (GroupMessage, error)
 	for id, status := range g.JobStatus {
 		switch status {
 		// Jobs with a final status remain the same and do not require lookup
-		case StatusFailed, StatusDone:
+		case StatusFailed, StatusDone, StatusCancelled, StatusExpired:
 			jobStatus[id] = status
 		// Re-look the jobs where the status is an intermediatery state (processing, retrying, etc).
 		case StatusStarted, StatusProcessing, StatusRetrying:
@@ -119,14 +150,98 @@ func (s *Server) GetGroup(ctx context.Context, id string) (GroupMessage, error)
 		return GroupMessage{}, err
 	}
 
+	return s.groupResults(ctx, g)
+}
+
+// groupResults() sets the results of the group's successful jobs on the group message.
+func (s *Server) groupResults(ctx context.Context, g GroupMessage) (GroupMessage, error) {
+	g.Results = make(map[string][]byte)
+	for id, status := range g.JobStatus {
+		if status != StatusDone {
+			continue
+		}
+
+		b, err := s.GetResult(ctx, id)
+		if err != nil {
+			if errors.Is(err, ErrNotFound) {
+				continue
+			}
+			return GroupMessage{}, err
+		}
+		g.Results[id] = b
+	}
+
 	return g, nil
 }
 
+// completeGroup() is called once a job of a group is successful. If all the jobs of the group
+// are successful, the group's OnComplete job is enqueued with their results.
+func (s *Server) completeGroup(ctx context.Context, msg JobMessage) error {
+	if msg.GroupID == "" {
+		return nil
+	}
+
+	g, err := s.getGroupMessage(ctx, msg.GroupID)
+	if err != nil {
+		return err
+	}
+	if g.Group == nil || g.Group.Opts.OnComplete == nil {
+		return nil
+	}
+
+	results := make([][]byte, len(g.JobIDs))
+	for i, id := range g.JobIDs {
+		j, err := s.GetJob(ctx, id)
+		if err != nil {
+			return err
+		}
+		// The group has jobs that haven't finished yet, or it failed.
+		if j.Status != StatusDone {
+			return nil
+		}
+
+		results[i], err = s.GetResult(ctx, id)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+	}
+
+	// Only the server that acquires the group's key enqueues the OnComplete job, if it wasn't
+	// already enqueued. The key is released once the job is enqueued (or fails to be).
+	ur := s.results.(UniqueResults)
+	key := groupCompletePrefix + g.ID
+	if _, ok, err := ur.SetUnique(ctx, key, g.ID, advanceKeyTTL); err != nil || !ok {
+		return err
+	}
+	defer s.releaseKey(ctx, key, g.ID)
+
+	if _, err := s.GetJob(ctx, g.CompleteJobID); !errors.Is(err, ErrNotFound) {
+		return err
+	}
+
+	oc := *g.Group.Opts.OnComplete
+	meta := DefaultMeta(oc.Opts)
+	meta.ID = g.CompleteJobID
+	if meta.PrevJobResult, err = msgpack.Marshal(results); err != nil {
+		return err
+	}
+
+	if _, err := s.enqueueWithMeta(ctx, oc, meta); err != nil {
+		// Delete the job's message, if it was stored, so that the job is enqueued again.
+		if err := s.results.DeleteJob(ctx, jobPrefix+meta.ID); err != nil {
+			s.log.Error("could not delete group completion job", "id", meta.ID, "group_id", g.ID, "error", err)
+		}
+		return err
+	}
+
+	return nil
+}
+
 func getGroupStatus(jobStatus map[string]string) string {
 	status := StatusDone
 	for _, st := range jobStatus {
-		if st == StatusFailed {
-			return StatusFailed
+		if st == StatusFailed || st == StatusCancelled || st == StatusExpired {
+			return st
 		}
 		if st != StatusDone {
 			status = StatusProcessing
@@ -136,24 +251,33 @@ func getGroupStatus(jobStatus map[string]string) string {
 	return status
 }
 
-const groupPrefix = "group:msg:"
+const (
+	groupPrefix = "group:msg:"
+
+	// Prefix of the unique keys acquired to enqueue the OnComplete job of a group.
+	groupCompletePrefix = "group:complete:"
+
+	// TTL of the keys acquired to enqueue the OnComplete job of a group, or the next step of
+	// a workflow, in case the server holding a key doesn't release it.
+	advanceKeyTTL = time.Minute
+)
 
 func (s *Server) setGroupMessage(ctx context.Context, g GroupMessage) error {
-	b, err := msgpack.Marshal(g)
+	b, err := s.codec.marshal(g)
 	if err != nil {
 		return err
 	}
-	return s.results.Set(ctx, groupPrefix+g.ID, b)
+	return setMeta(ctx, s.results, groupPrefix+g.ID, b)
 }
 
 func (s *Server) getGroupMessage(ctx context.Context, id string) (GroupMessage, error) {
-	b, err := s.GetResult(ctx, groupPrefix+id)
+	b, err := s.getResult(ctx, groupPrefix+id)
 	if err != nil {
 		return GroupMessage{}, err
 	}
 
 	var g GroupMessage
-	if err := msgpack.Unmarshal(b, &g); err != nil {
+	if err := s.codec.unmarshal(b, &g); err != nil {
 		return GroupMessage{}, err
 	}
 
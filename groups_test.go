@@ -51,6 +51,96 @@ func TestGetGroup(t *testing.T) {
 	}
 }
 
+func TestGroupOnComplete(t *testing.T) {
+	var (
+		ctx      = context.Background()
+		complete = make(chan [][]byte, 1)
+		srv      = newServer(t, taskName, func(b []byte, c JobCtx) error {
+			return c.Save(b)
+		})
+	)
+	if err := srv.RegisterTask(chainTask, func(_ []byte, c JobCtx) error {
+		res, err := DecodeResults(c.Meta.PrevJobResult)
+		if err != nil {
+			return err
+		}
+		complete <- res
+		return nil
+	}, TaskOpts{}); err != nil {
+		t.Fatal(err)
+	}
+	go srv.Start(ctx)
+
+	var jobs []Job
+	for _, p := range []string{"a", "b", "c"} {
+		j, err := NewJob(taskName, []byte(p), JobOpts{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		jobs = append(jobs, j)
+	}
+	oc, err := NewJob(chainTask, nil, JobOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	grp, err := NewGroup(jobs, GroupOpts{OnComplete: &oc})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := srv.EnqueueGroup(ctx, grp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case res := <-complete:
+		if len(res) != 3 || string(res[0]) != "a" || string(res[1]) != "b" || string(res[2]) != "c" {
+			t.Fatalf("incorrect results passed to the completion job: %q", res)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("completion job was not run")
+	}
+
+	msg, err := srv.GetGroup(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Status != StatusDone {
+		t.Fatalf("incorrect group status, expected %s, got %s", StatusDone, msg.Status)
+	}
+	for i, jid := range msg.JobIDs {
+		if string(msg.Results[jid]) != string(jobs[i].Payload) {
+			t.Fatalf("incorrect result of job %s: %s", jid, msg.Results[jid])
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+	if j, err := srv.GetJob(ctx, msg.CompleteJobID); err != nil || j.Status != StatusDone {
+		t.Fatalf("completion job not successful: %v, %v", j.Status, err)
+	}
+
+	// The group's key is released, and the completion job isn't enqueued again (eg: by a redelivered job).
+	ur := srv.results.(UniqueResults)
+	if _, ok, err := ur.SetUnique(ctx, groupCompletePrefix+id, "test", 0); err != nil || !ok {
+		t.Fatalf("expected the group's key to be released: %v", err)
+	}
+	if err := ur.DeleteUnique(ctx, groupCompletePrefix+id, "test"); err != nil {
+		t.Fatal(err)
+	}
+	j, err := srv.GetJob(ctx, msg.JobIDs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.completeGroup(ctx, j); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-complete:
+		t.Fatal("completion job was enqueued again")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
 func makeGroup(t *testing.T, fs ...bool) Group {
 	var jobs []Job
 	for _, f := range fs {
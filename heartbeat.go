@@ -0,0 +1,181 @@
+package tasqueue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// heartbeatState is the state the jobs being processed are indexed in on the JobLister, at the time
+	// of their last heartbeat. The jobs are indexed again in their final state once processed.
+	heartbeatState = "heartbeat"
+
+	// stuckLockPrefix prefixes the keys locking the requeue of a stuck job, so that only one of the
+	// servers running the watchdog requeues it.
+	stuckLockPrefix = "job:stuck:"
+)
+
+// StuckJob is a job that is still being processed, but whose worker stopped sending heartbeats
+// (eg: it crashed).
+type StuckJob struct {
+	Job           JobMessage
+	LastHeartbeat time.Time
+}
+
+// heartbeat() periodically records a heartbeat for the job being processed, until the returned
+// func is called. It is a no-op if heartbeats are disabled.
+func (s *Server) heartbeat(ctx context.Context, msg JobMessage) func() {
+	jl, ok := s.results.(JobLister)
+	if !ok || s.heartbeatInterval <= 0 {
+		return func() {}
+	}
+
+	beat := func() {
+		if err := jl.IndexJob(ctx, msg.ID, heartbeatState, msg.Queue, msg.Job.Task, s.now()); err != nil {
+			s.log.Error("error recording job heartbeat", "id", msg.ID, "error", err)
+		}
+	}
+	beat()
+
+	var (
+		done = make(chan struct{})
+		wg   sync.WaitGroup
+	)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		tk := time.NewTicker(s.heartbeatInterval)
+		defer tk.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			case <-tk.C:
+				beat()
+			}
+		}
+	}()
+
+	// Wait for the goroutine to exit, so that a late heartbeat doesn't overwrite the job's final index.
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}
+
+// GetStuckJobs() returns the jobs being processed whose last heartbeat is older than timeout, ie.
+// whose worker likely crashed. Heartbeats must be enabled (ServerOpts.HeartbeatInterval) on the
+// servers processing the jobs, and the results store must implement JobLister.
+func (s *Server) GetStuckJobs(ctx context.Context, timeout time.Duration) ([]StuckJob, error) {
+	jl, ok := s.results.(JobLister)
+	if !ok {
+		return nil, fmt.Errorf("results store does not support heartbeats")
+	}
+
+	var (
+		out    []StuckJob
+		cursor string
+		before = s.now().Add(-timeout)
+	)
+	for {
+		ids, ats, next, err := jl.ListJobs(ctx, heartbeatState, "", DefaultListLimit, cursor, "", time.Time{}, before)
+		if err != nil {
+			return nil, err
+		}
+
+		for i, id := range ids {
+			msg, err := s.GetJob(ctx, id)
+			if err != nil {
+				if errors.Is(err, ErrNotFound) {
+					continue
+				}
+				return nil, err
+			}
+
+			// The job was retried, cancelled, etc. by its worker. It is indexed in its status so
+			// that it is no longer listed.
+			if msg.Status != StatusProcessing {
+				if err := jl.IndexJob(ctx, msg.ID, msg.Status, msg.Queue, msg.Job.Task, msg.ProcessedAt); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			out = append(out, StuckJob{Job: msg, LastHeartbeat: ats[i]})
+		}
+
+		if next == "" {
+			return out, nil
+		}
+		cursor = next
+	}
+}
+
+// watchStuckJobs() periodically looks for stuck jobs, calling Hooks.OnJobStuck for each of them and
+// requeuing them if ServerOpts.RequeueStuckJobs is set.
+func (s *Server) watchStuckJobs(ctx context.Context) {
+	tk := time.NewTicker(s.stuckTimeout / 2)
+	defer tk.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tk.C:
+			jobs, err := s.GetStuckJobs(ctx, s.stuckTimeout)
+			if err != nil {
+				s.log.Error("error getting stuck jobs", "error", err)
+				continue
+			}
+
+			for _, j := range jobs {
+				s.log.Warn("job is stuck", "id", j.Job.ID, "worker", j.Job.Worker, "last_heartbeat", j.LastHeartbeat)
+				if s.hooks.OnJobStuck != nil {
+					s.hooks.OnJobStuck(ctx, j.Job)
+				}
+				if !s.requeueStuck {
+					continue
+				}
+				if err := s.requeueStuckJob(ctx, j); err != nil {
+					s.log.Error("error requeuing stuck job", "id", j.Job.ID, "error", err)
+				}
+			}
+		}
+	}
+}
+
+// requeueStuckJob() pushes the stuck job back onto its queue, to be processed again. If the results
+// store implements UniqueResults, the requeue is locked so that the job is only requeued once.
+func (s *Server) requeueStuckJob(ctx context.Context, j StuckJob) error {
+	msg := j.Job
+	if ur, ok := s.results.(UniqueResults); ok {
+		key := stuckLockPrefix + msg.ID + ":" + strconv.FormatInt(j.LastHeartbeat.UnixNano(), 10)
+		if _, ok, err := ur.SetUnique(ctx, key, msg.ID, s.stuckTimeout); err != nil || !ok {
+			return err
+		}
+	}
+
+	s.log.Info("requeuing stuck job", "id", msg.ID, "queue", msg.Queue)
+	msg.Status = StatusStarted
+	b, err := s.codec.marshal(msg)
+	if err != nil {
+		return err
+	}
+	if err := s.statusStarted(ctx, msg); err != nil {
+		return err
+	}
+	// Index the job out of the heartbeats, so that it isn't requeued again.
+	if jl, ok := s.results.(JobLister); ok {
+		if err := jl.IndexJob(ctx, msg.ID, StatusStarted, msg.Queue, msg.Job.Task, s.now()); err != nil {
+			return err
+		}
+	}
+
+	return s.brokerEnqueue(ctx, b, msg)
+}
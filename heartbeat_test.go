@@ -0,0 +1,154 @@
+package tasqueue
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"testing"
+	"time"
+
+	rb "github.com/kalbhor/tasqueue/v2/brokers/in-memory"
+	rr "github.com/kalbhor/tasqueue/v2/results/in-memory"
+)
+
+func TestStuckJobs(t *testing.T) {
+	lo := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
+		Level: slog.LevelError,
+	}))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var (
+		results = rr.New()
+		stuck   = make(chan JobMessage, 10)
+		release = make(chan struct{})
+	)
+	srv, err := NewServer(ServerOpts{
+		Broker:           rb.New(),
+		Results:          results,
+		Logger:           lo.Handler(),
+		WorkerID:         "worker",
+		StuckJobTimeout:  300 * time.Millisecond,
+		RequeueStuckJobs: true,
+		Hooks: Hooks{OnJobStuck: func(_ context.Context, msg JobMessage) {
+			stuck <- msg
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.RegisterTask("echo", func(b []byte, j JobCtx) error {
+		return j.Save(b)
+	}, TaskOpts{Concurrency: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.RegisterTask("hang", func([]byte, JobCtx) error {
+		<-release
+		return nil
+	}, TaskOpts{Concurrency: 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A job being processed by a crashed worker: it is processing, but its heartbeat is old. Its
+	// message isn't on the broker anymore, as it was consumed by the worker.
+	other, err := NewServer(ServerOpts{Broker: rb.New(), Results: results, Logger: lo.Handler()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	job, err := NewJob("echo", []byte("pong"), JobOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	crashedID, err := other.Enqueue(ctx, job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := other.GetJob(ctx, crashedID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg.Worker = "crashed"
+	if err := other.statusProcessing(ctx, msg); err != nil {
+		t.Fatal(err)
+	}
+	if err := results.IndexJob(ctx, crashedID, heartbeatState, msg.Queue, msg.Job.Task, time.Now().Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	jobs, err := srv.GetStuckJobs(ctx, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 1 || jobs[0].Job.ID != crashedID || jobs[0].Job.Worker != "crashed" {
+		t.Fatalf("expected the job %s of the crashed worker to be stuck, got %+v", crashedID, jobs)
+	}
+
+	go srv.Start(ctx)
+
+	// The job of the live worker keeps sending heartbeats while it is processed.
+	job, err = NewJob("hang", nil, JobOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	liveID, err := srv.Enqueue(ctx, job)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case m := <-stuck:
+		if m.ID != crashedID {
+			t.Fatalf("expected the job %s to be stuck, got %s", crashedID, m.ID)
+		}
+	case <-ctx.Done():
+		t.Fatal("the stuck job wasn't flagged")
+	}
+	// The stuck job is requeued and processed by the live worker.
+	for {
+		msg, err := srv.GetJob(ctx, crashedID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg.Status == StatusDone {
+			if msg.Worker != "worker" {
+				t.Fatalf("expected the job to be processed by worker, got %s", msg.Worker)
+			}
+			break
+		}
+		select {
+		case <-time.After(20 * time.Millisecond):
+		case <-ctx.Done():
+			t.Fatalf("the stuck job wasn't processed again, status %s", msg.Status)
+		}
+	}
+
+	time.Sleep(time.Second)
+	select {
+	case m := <-stuck:
+		t.Fatalf("unexpected stuck job %s", m.ID)
+	default:
+	}
+	jobs, err = srv.GetStuckJobs(ctx, 300*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 0 {
+		t.Fatalf("expected no stuck jobs, got %+v", jobs)
+	}
+	close(release)
+
+	for {
+		msg, err := srv.GetJob(ctx, liveID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg.Status == StatusDone {
+			break
+		}
+		select {
+		case <-time.After(20 * time.Millisecond):
+		case <-ctx.Done():
+			t.Fatalf("the live job wasn't processed, status %s", msg.Status)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package tasqueue
+
+import "context"
+
+// Hooks are called by the server on the lifecycle events of the jobs of all the tasks (eg: for
+// audit logs or notifications), after the task's callbacks. They are called synchronously
+// by the enqueuer and the processor, so slow hooks should hand off their work.
+type Hooks struct {
+	// OnJobEnqueued is called once a new job is pushed onto the broker (retries call OnJobRetried).
+	OnJobEnqueued func(context.Context, JobMessage)
+	// OnJobStarted is called before a job's handler is run.
+	OnJobStarted func(JobCtx)
+	// OnJobSuccess is called once a job's handler returns successfully.
+	OnJobSuccess func(JobCtx)
+	// OnJobRetried is called once a job's handler fails and the job has retries left.
+	OnJobRetried func(JobCtx, error)
+	// OnJobFailed is called once a job's handler fails and the job has exhausted its retries.
+	OnJobFailed func(JobCtx, error)
+	// OnJobStuck is called by the watchdog (ServerOpts.StuckJobTimeout) for each job whose worker
+	// stopped sending heartbeats.
+	OnJobStuck func(context.Context, JobMessage)
+	// OnCircuitOpen is called when the circuit breaker of a task opens (TaskOpts.CircuitBreaker), with
+	// the error of the last failed attempt. OnCircuitClose is called once it closes again.
+	OnCircuitOpen  func(task string, err error)
+	OnCircuitClose func(task string)
+}
+
+func (s *Server) jobEnqueued(ctx context.Context, msg JobMessage) {
+	if s.metrics != nil {
+		s.metrics.JobEnqueued(msg.Queue, msg.Job.Task)
+	}
+	if s.hooks.OnJobEnqueued != nil {
+		s.hooks.OnJobEnqueued(ctx, msg)
+	}
+}
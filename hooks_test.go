@@ -0,0 +1,84 @@
+package tasqueue
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	rb "github.com/kalbhor/tasqueue/v2/brokers/in-memory"
+	rr "github.com/kalbhor/tasqueue/v2/results/in-memory"
+)
+
+func TestHooks(t *testing.T) {
+	var (
+		ctx, cancel = context.WithCancel(context.Background())
+		mu          sync.Mutex
+		events      []string
+		done        = make(chan struct{}, 2)
+		record      = func(ev string) {
+			mu.Lock()
+			events = append(events, ev)
+			mu.Unlock()
+		}
+	)
+	defer cancel()
+
+	srv, err := NewServer(ServerOpts{
+		Broker:  rb.New(),
+		Results: rr.New(),
+		Logger:  slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError})).Handler(),
+		Hooks: Hooks{
+			OnJobEnqueued: func(_ context.Context, m JobMessage) { record("enqueued") },
+			OnJobStarted:  func(JobCtx) { record("started") },
+			OnJobSuccess: func(JobCtx) {
+				record("success")
+				done <- struct{}{}
+			},
+			OnJobRetried: func(_ JobCtx, err error) { record("retried") },
+			OnJobFailed: func(_ JobCtx, err error) {
+				record("failed")
+				done <- struct{}{}
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.RegisterTask(taskName, MockHandler, TaskOpts{Concurrency: 1}); err != nil {
+		t.Fatal(err)
+	}
+	go srv.Start(ctx)
+
+	for _, tc := range []struct {
+		doErr bool
+		want  string
+	}{
+		{false, "[enqueued started success]"},
+		// The job has 1 retry, so it is started twice.
+		{true, "[enqueued started retried started failed]"},
+	} {
+		mu.Lock()
+		events = nil
+		mu.Unlock()
+
+		if _, err := srv.Enqueue(ctx, makeJob(t, taskName, tc.doErr)); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("job was not processed")
+		}
+
+		mu.Lock()
+		got := fmt.Sprint(events)
+		mu.Unlock()
+		if got != tc.want {
+			t.Fatalf("incorrect hooks called, expected %s, got %s", tc.want, got)
+		}
+	}
+}
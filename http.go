@@ -0,0 +1,214 @@
+package tasqueue
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+//go:embed static/dashboard.html
+var dashboard embed.FS
+
+// QueueInfo describes a queue registered on the server.
+type QueueInfo struct {
+	Name        string `json:"name"`
+	Concurrency uint32 `json:"concurrency"`
+	// Pending is the number of jobs waiting on the queue, or -1 if the broker couldn't report it.
+	Pending int `json:"pending"`
+	// Scheduled is the number of jobs scheduled onto the queue (with an ETA or waiting to be retried), or -1
+	// if the broker couldn't report it.
+	Scheduled int    `json:"scheduled"`
+	Paused    bool   `json:"paused"`
+	Error     string `json:"error,omitempty"`
+}
+
+// MountHTTP registers the HTTP management API and a minimal web dashboard on the mux,
+// under the /tasqueue/ path. The API exposes:
+//
+//	GET    /tasqueue/api/queues                 registered queues and their depths
+//	GET    /tasqueue/api/queues/{queue}/pending pending job messages on a queue
+//	POST   /tasqueue/api/queues/{queue}/pause   pause consuming a queue
+//	POST   /tasqueue/api/queues/{queue}/resume  resume consuming a paused queue
+//	GET    /tasqueue/api/jobs                   a page of finished jobs (state, queue, task, from, to, limit, cursor)
+//	GET    /tasqueue/api/jobs/success           ids of successful jobs
+//	GET    /tasqueue/api/jobs/failed            ids of failed jobs
+//	GET    /tasqueue/api/jobs/dead              job messages in the dead letter queue
+//	GET    /tasqueue/api/jobs/{id}              a job's message
+//	GET    /tasqueue/api/jobs/{id}/result       a job's result
+//	GET    /tasqueue/api/jobs/{id}/progress     a job's last reported progress
+//	POST   /tasqueue/api/jobs/{id}/retry        requeue a job from the dead letter queue
+//	POST   /tasqueue/api/jobs/{id}/replay       enqueue a fresh run of a finished job, returning its id
+//	POST   /tasqueue/api/jobs/{id}/cancel       cancel a queued or running job
+//	DELETE /tasqueue/api/jobs/{id}              delete a job's results
+//	GET    /tasqueue/api/schedules              registered schedules
+//	POST   /tasqueue/api/schedules/{id}/pause   pause a schedule
+//	POST   /tasqueue/api/schedules/{id}/resume  resume a paused schedule
+//	DELETE /tasqueue/api/schedules/{id}         delete a schedule
+//
+// The API is unauthenticated, access to it should be restricted by the caller.
+func (s *Server) MountHTTP(mux *http.ServeMux) {
+	mux.HandleFunc("GET /tasqueue/{$}", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFileFS(w, r, dashboard, "static/dashboard.html")
+	})
+
+	mux.HandleFunc("GET /tasqueue/api/queues", s.handleGetQueues)
+	mux.HandleFunc("GET /tasqueue/api/queues/{queue}/pending", func(w http.ResponseWriter, r *http.Request) {
+		msgs, err := s.GetPending(r.Context(), r.PathValue("queue"))
+		writeJSON(w, msgs, err)
+	})
+	mux.HandleFunc("POST /tasqueue/api/queues/{queue}/pause", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, true, s.PauseQueue(r.Context(), r.PathValue("queue")))
+	})
+	mux.HandleFunc("POST /tasqueue/api/queues/{queue}/resume", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, true, s.ResumeQueue(r.Context(), r.PathValue("queue")))
+	})
+
+	mux.HandleFunc("GET /tasqueue/api/jobs", s.handleListJobs)
+	mux.HandleFunc("GET /tasqueue/api/jobs/success", func(w http.ResponseWriter, r *http.Request) {
+		ids, err := s.GetSuccess(r.Context())
+		writeJSON(w, ids, err)
+	})
+	mux.HandleFunc("GET /tasqueue/api/jobs/failed", func(w http.ResponseWriter, r *http.Request) {
+		ids, err := s.GetFailed(r.Context())
+		writeJSON(w, ids, err)
+	})
+	mux.HandleFunc("GET /tasqueue/api/jobs/dead", func(w http.ResponseWriter, r *http.Request) {
+		msgs, err := s.GetDeadJobs(r.Context())
+		writeJSON(w, msgs, err)
+	})
+
+	mux.HandleFunc("GET /tasqueue/api/jobs/{id}", func(w http.ResponseWriter, r *http.Request) {
+		msg, err := s.GetJob(r.Context(), r.PathValue("id"))
+		writeJSON(w, msg, err)
+	})
+	mux.HandleFunc("GET /tasqueue/api/jobs/{id}/result", func(w http.ResponseWriter, r *http.Request) {
+		b, err := s.GetResult(r.Context(), r.PathValue("id"))
+		if err != nil {
+			writeJSON(w, nil, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(b)
+	})
+	mux.HandleFunc("GET /tasqueue/api/jobs/{id}/progress", func(w http.ResponseWriter, r *http.Request) {
+		p, err := s.GetJobProgress(r.Context(), r.PathValue("id"))
+		writeJSON(w, p, err)
+	})
+	mux.HandleFunc("POST /tasqueue/api/jobs/{id}/retry", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, true, s.RequeueDeadJob(r.Context(), r.PathValue("id")))
+	})
+	mux.HandleFunc("POST /tasqueue/api/jobs/{id}/replay", func(w http.ResponseWriter, r *http.Request) {
+		id, err := s.RetryJob(r.Context(), r.PathValue("id"))
+		writeJSON(w, map[string]string{"id": id}, err)
+	})
+	mux.HandleFunc("POST /tasqueue/api/jobs/{id}/cancel", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, true, s.CancelJob(r.Context(), r.PathValue("id")))
+	})
+	mux.HandleFunc("DELETE /tasqueue/api/jobs/{id}", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, true, s.DeleteJob(r.Context(), r.PathValue("id")))
+	})
+
+	mux.HandleFunc("GET /tasqueue/api/schedules", func(w http.ResponseWriter, r *http.Request) {
+		schs, err := s.GetSchedules(r.Context())
+		writeJSON(w, schs, err)
+	})
+	mux.HandleFunc("POST /tasqueue/api/schedules/{id}/pause", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, true, s.PauseSchedule(r.Context(), r.PathValue("id")))
+	})
+	mux.HandleFunc("POST /tasqueue/api/schedules/{id}/resume", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, true, s.ResumeSchedule(r.Context(), r.PathValue("id")))
+	})
+	mux.HandleFunc("DELETE /tasqueue/api/schedules/{id}", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, true, s.DeleteSchedule(r.Context(), r.PathValue("id")))
+	})
+}
+
+func (s *Server) handleGetQueues(w http.ResponseWriter, r *http.Request) {
+	s.q.RLock()
+	queues := make([]QueueInfo, 0, len(s.queues))
+	for name, conc := range s.queues {
+		// Autoscaled queues report their current number of workers.
+		if sc, ok := s.scalers[name]; ok {
+			conc = uint32(sc.conc.Load())
+		}
+		queues = append(queues, QueueInfo{Name: name, Concurrency: conc})
+	}
+	s.q.RUnlock()
+	sort.Slice(queues, func(i, j int) bool {
+		return queues[i].Name < queues[j].Name
+	})
+
+	for i, q := range queues {
+		paused, err := s.IsQueuePaused(r.Context(), q.Name)
+		if err != nil {
+			queues[i].Error = err.Error()
+		}
+		queues[i].Paused = paused
+
+		pending, scheduled, err := s.queueDepth(r.Context(), q.Name)
+		if err != nil {
+			queues[i].Pending, queues[i].Scheduled = -1, -1
+			queues[i].Error = err.Error()
+			continue
+		}
+		queues[i].Pending, queues[i].Scheduled = pending, scheduled
+	}
+
+	writeJSON(w, queues, nil)
+}
+
+// handleListJobs lists the jobs by the query params. from and to are RFC3339 timestamps.
+func (s *Server) handleListJobs(w http.ResponseWriter, r *http.Request) {
+	var (
+		q = r.URL.Query()
+		f = JobFilter{Task: q.Get("task")}
+	)
+	for _, t := range []struct {
+		param string
+		v     *time.Time
+	}{{"from", &f.From}, {"to", &f.To}} {
+		if v := q.Get(t.param); v != "" {
+			ts, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				http.Error(w, "invalid "+t.param+" time", http.StatusBadRequest)
+				return
+			}
+			*t.v = ts
+		}
+	}
+	var limit int
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	list, err := s.ListJobs(r.Context(), q.Get("state"), q.Get("queue"), limit, q.Get("cursor"), f)
+	writeJSON(w, list, err)
+}
+
+// writeJSON writes the value as JSON, or the error (with a relevant status code) if it is non-nil.
+func writeJSON(w http.ResponseWriter, v any, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, ErrNotFound):
+			status = http.StatusNotFound
+		case errors.Is(err, ErrDuplicateJob):
+			status = http.StatusConflict
+		}
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	json.NewEncoder(w).Encode(v)
+}
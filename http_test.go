@@ -0,0 +1,81 @@
+package tasqueue
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMountHTTP(t *testing.T) {
+	var (
+		ctx = context.Background()
+		srv = newServer(t, taskName, MockHandler)
+		mux = http.NewServeMux()
+	)
+	srv.MountHTTP(mux)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	go srv.Start(ctx)
+
+	id, err := srv.Enqueue(ctx, makeJob(t, taskName, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Second)
+
+	var msg JobMessage
+	if code := getJSON(t, ts.URL+"/tasqueue/api/jobs/"+id, &msg); code != http.StatusOK {
+		t.Fatalf("unexpected status code fetching job: %d", code)
+	}
+	if msg.ID != id || msg.Status != StatusDone {
+		t.Fatalf("incorrect job message: %+v", msg)
+	}
+
+	var list JobList
+	if code := getJSON(t, ts.URL+"/tasqueue/api/jobs?state="+StatusDone+"&task="+taskName, &list); code != http.StatusOK {
+		t.Fatalf("unexpected status code listing jobs: %d", code)
+	}
+	if len(list.Jobs) != 1 || list.Jobs[0].ID != id {
+		t.Fatalf("incorrect listed jobs: %+v", list)
+	}
+
+	var queues []QueueInfo
+	if code := getJSON(t, ts.URL+"/tasqueue/api/queues", &queues); code != http.StatusOK {
+		t.Fatalf("unexpected status code fetching queues: %d", code)
+	}
+	if len(queues) != 1 || queues[0].Name != DefaultQueue || queues[0].Pending != 0 {
+		t.Fatalf("incorrect queues: %+v", queues)
+	}
+
+	if code := getJSON(t, ts.URL+"/tasqueue/api/jobs/invalid", nil); code != http.StatusNotFound {
+		t.Fatalf("expected not found for invalid job, got: %d", code)
+	}
+
+	res, err := http.Get(ts.URL + "/tasqueue/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status code fetching dashboard: %d", res.StatusCode)
+	}
+}
+
+func getJSON(t *testing.T, url string, v any) int {
+	res, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if v != nil && res.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return res.StatusCode
+}
@@ -32,3 +32,182 @@ type Broker interface {
 	// GetPending returns a list of stored job messages on the particular queue
 	GetPending(ctx context.Context, queue string) ([]string, error)
 }
+
+// DepthBroker is implemented by brokers that can count the messages of a queue without fetching them.
+type DepthBroker interface {
+	// QueueDepth returns the number of messages pending on the queue (as returned by GetPending), and
+	// the number of messages scheduled onto it.
+	QueueDepth(ctx context.Context, queue string) (pending, scheduled int, err error)
+}
+
+// PriorityBroker is implemented by brokers that support job priorities. Jobs with a
+// higher priority are consumed before lower priority jobs pending on the same queue.
+type PriorityBroker interface {
+	// EnqueuePriority places a task in the queue with the given priority.
+	EnqueuePriority(ctx context.Context, msg []byte, queue string, priority uint8) error
+}
+
+// QueueManager is implemented by brokers that support removing pending messages from a queue.
+type QueueManager interface {
+	// Remove removes a pending message from the queue.
+	Remove(ctx context.Context, msg []byte, queue string) error
+
+	// Purge removes all the pending messages from the queue.
+	Purge(ctx context.Context, queue string) error
+}
+
+// PauseBroker is implemented by brokers that can store the paused queues, so that a queue is
+// paused on all the servers sharing the broker.
+type PauseBroker interface {
+	PauseQueue(ctx context.Context, queue string) error
+	ResumeQueue(ctx context.Context, queue string) error
+	IsPaused(ctx context.Context, queue string) (bool, error)
+}
+
+// RateLimitBroker is implemented by brokers that can enforce rate limits across all the
+// servers sharing the broker. The limits are token buckets, refilled at `rate` tokens per
+// second and holding up to `burst` tokens.
+type RateLimitBroker interface {
+	// Allow takes a token from the bucket identified by key. If no token is available, it
+	// returns the duration to wait before a token will be available.
+	Allow(ctx context.Context, key string, rate float64, burst int) (time.Duration, error)
+}
+
+// SemaphoreBroker is implemented by brokers that can limit the jobs processed concurrently across all the
+// servers sharing the broker. Slots are leased, so that the slots held by a server that crashed are freed.
+type SemaphoreBroker interface {
+	// Acquire takes one of the `limit` slots of the semaphore identified by key for the holder `token`,
+	// until `ttl` elapses. Acquiring a slot already held by the token extends its lease. It returns false
+	// if no slot is free.
+	Acquire(ctx context.Context, key, token string, limit int, ttl time.Duration) (bool, error)
+
+	// Release frees the slot held by the token, if any.
+	Release(ctx context.Context, key, token string) error
+}
+
+// MetricsCollector receives metrics of the jobs processed by the server.
+type MetricsCollector interface {
+	// JobEnqueued is called when a job is pushed onto a queue.
+	JobEnqueued(queue, task string)
+
+	// JobProcessed is called once a job's handler returns, with the time taken by the
+	// handler and the resulting job status (successful, retrying, failed or cancelled).
+	JobProcessed(queue, task, status string, d time.Duration)
+
+	// QueueDepth is called periodically with the number of jobs pending on each queue.
+	QueueDepth(queue string, depth int)
+}
+
+// UniqueResults is implemented by results stores that support unique jobs (JobOpts.UniqueKey).
+// A unique key is held by a job while it is pending or being processed.
+type UniqueResults interface {
+	// SetUnique sets the key to the job id if the key isn't already held, expiring it after ttl
+	// (if non-zero). If the key is held, it returns false along with the id of the job holding it.
+	SetUnique(ctx context.Context, key, id string, ttl time.Duration) (string, bool, error)
+
+	// DeleteUnique releases the key, only if it is still held by the job id.
+	DeleteUnique(ctx context.Context, key, id string) error
+}
+
+// MetaResults is implemented by results stores that store the server's metadata (job messages, groups,
+// schedules, offloaded payloads etc.) differently from the results saved by jobs, eg: excluding it from
+// collision detection.
+type MetaResults interface {
+	// SetMeta sets the metadata value of the id.
+	SetMeta(ctx context.Context, id string, b []byte) error
+}
+
+// PersistentResults is implemented by results stores that expire results, to store values that are
+// kept regardless of the expiry (eg: schedules).
+type PersistentResults interface {
+	// SetPersistent sets the value of the id without an expiry.
+	SetPersistent(ctx context.Context, id string, b []byte) error
+}
+
+// BatchBroker is implemented by brokers that can push multiple messages onto a queue in one round trip.
+type BatchBroker interface {
+	// EnqueueBatch places the messages in the queue, in order.
+	EnqueueBatch(ctx context.Context, msgs [][]byte, queue string) error
+}
+
+// BatchResults is implemented by results stores that can set multiple results in one round trip.
+type BatchResults interface {
+	// SetBatch sets the results (id -> result).
+	SetBatch(ctx context.Context, items map[string][]byte) error
+}
+
+// MultiResults is implemented by results stores that can get multiple results in one round trip.
+type MultiResults interface {
+	// GetMulti returns the results of the ids (id -> result). Ids without a result are omitted.
+	GetMulti(ctx context.Context, ids []string) (map[string][]byte, error)
+}
+
+// AckBroker is implemented by brokers that guarantee at-least-once delivery. A consumed message is
+// held by the broker until it is acknowledged, and is redelivered if the consumer doesn't acknowledge
+// it in time (eg: if the server crashed while processing it).
+type AckBroker interface {
+	// Ack acknowledges that the message consumed from the queue was processed, removing it from the broker.
+	Ack(ctx context.Context, msg []byte, queue string) error
+
+	// Nack returns the message consumed from the queue back onto it, to be consumed again.
+	Nack(ctx context.Context, msg []byte, queue string) error
+}
+
+// JobLister is implemented by results stores that index the finished (successful or failed) jobs by
+// their queue and task, so that they can be listed page by page instead of with GetSuccess/GetFailed.
+type JobLister interface {
+	// IndexJob indexes the job in the state (eg: "successful" or "failed") at the time. Indexing a job
+	// again replaces its previous entry.
+	IndexJob(ctx context.Context, id, state, queue, task string, at time.Time) error
+
+	// ListJobs returns up to limit ids of the jobs in the state and the times they were indexed at,
+	// most recent first, along with the cursor to pass for the next page (empty on the last page).
+	// The jobs are filtered by queue and task if non-empty, and by the time range [from, to) for
+	// the non-zero bounds.
+	ListJobs(ctx context.Context, state, queue string, limit int, cursor string,
+		task string, from, to time.Time) ([]string, []time.Time, string, error)
+}
+
+// SubscribeResults is implemented by results stores that can notify of updates to the stored results,
+// so that a job's status can be awaited without polling.
+type SubscribeResults interface {
+	// Subscribe returns a channel receiving the value of the id each time it is set, starting with its
+	// current value if it exists. The channel is closed once ctx is cancelled.
+	Subscribe(ctx context.Context, id string) (<-chan []byte, error)
+}
+
+// DequeueBroker is implemented by brokers that can pop a pending message without blocking, which is
+// used by Server.ProcessAll to process the pending jobs synchronously (eg: in tests).
+type DequeueBroker interface {
+	// Dequeue removes and returns the next pending message on the queue, or false if there is none.
+	Dequeue(ctx context.Context, queue string) ([]byte, bool, error)
+}
+
+// EventsStore records the state transitions of jobs (ServerOpts.Events), as an audit trail of their history.
+// Events are encoded as JSON by the server.
+type EventsStore interface {
+	// RecordEvent appends the event, which happened at the time, onto the job's history.
+	RecordEvent(ctx context.Context, id string, at time.Time, event []byte) error
+
+	// GetEvents returns the events of the job, in the order they were recorded.
+	GetEvents(ctx context.Context, id string) ([][]byte, error)
+}
+
+// BlobStore stores the payloads offloaded from the job messages (ServerOpts.PayloadOffloadThreshold),
+// eg: on S3. Blobs are deleted by the server once their jobs have finished.
+type BlobStore interface {
+	PutBlob(ctx context.Context, key string, b []byte) error
+
+	// GetBlob returns the blob stored with the key, or an error if it doesn't exist.
+	GetBlob(ctx context.Context, key string) ([]byte, error)
+
+	// DeleteBlob deletes the blob stored with the key. Deleting a blob that doesn't exist isn't an error.
+	DeleteBlob(ctx context.Context, key string) error
+}
+
+// Namespacer is implemented by brokers and results stores that can prefix their keys with a namespace
+// (ServerOpts.Namespace), so that multiple applications or environments can share them.
+type Namespacer interface {
+	// SetNamespace sets the namespace. It is called by NewServer(), before the broker or results store is used.
+	SetNamespace(ns string)
+}
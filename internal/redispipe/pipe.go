@@ -0,0 +1,139 @@
+// Package redispipe batches the commands of concurrent callers onto shared redis pipelines, for the
+// redis broker and results store.
+package redispipe
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Pipe queues the commands of concurrent callers onto a shared redis pipeline, which is executed every
+// period or once it holds max commands. Callers wait for the pipeline to be executed and get the errors
+// of their commands.
+type Pipe struct {
+	conn redis.UniversalClient
+	max  int
+
+	mu     sync.Mutex
+	cur    *batch
+	closed bool
+
+	// full signals that the current batch holds max commands.
+	full chan struct{}
+}
+
+// batch is a pipeline being filled, done is closed once it is executed.
+type batch struct {
+	p    redis.Pipeliner
+	cmds []redis.Cmder
+	done chan struct{}
+}
+
+// New returns a pipe executing the commands on conn, once it holds max commands (if non-zero) or when
+// it is flushed by Run.
+func New(conn redis.UniversalClient, max int) *Pipe {
+	p := &Pipe{conn: conn, max: max, full: make(chan struct{}, 1)}
+	p.cur = p.newBatch()
+	return p
+}
+
+func (p *Pipe) newBatch() *batch {
+	return &batch{p: p.conn.Pipeline(), done: make(chan struct{})}
+}
+
+// Do queues the commands added by fn onto the pipeline and waits for it to be executed, returning
+// the first error of the commands. Once the pipe is closed, the commands are executed right away.
+func (p *Pipe) Do(ctx context.Context, fn func(redis.Pipeliner)) error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		_, err := p.conn.Pipelined(ctx, func(pl redis.Pipeliner) error {
+			fn(pl)
+			return nil
+		})
+		return cmdErr(err)
+	}
+
+	var (
+		b    = p.cur
+		from = b.p.Len()
+	)
+	fn(b.p)
+	to := b.p.Len()
+	p.mu.Unlock()
+
+	if p.max > 0 && to >= p.max {
+		select {
+		case p.full <- struct{}{}:
+		default:
+		}
+	}
+
+	select {
+	case <-b.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	for _, c := range b.cmds[from:to] {
+		if err := cmdErr(c.Err()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// flush executes the current pipeline, returning its commands.
+func (p *Pipe) flush(ctx context.Context) ([]redis.Cmder, error) {
+	p.mu.Lock()
+	b := p.cur
+	if b.p.Len() == 0 {
+		p.mu.Unlock()
+		return nil, nil
+	}
+	p.cur = p.newBatch()
+	p.mu.Unlock()
+
+	cmds, err := b.p.Exec(ctx)
+	b.cmds = cmds
+	close(b.done)
+
+	return cmds, cmdErr(err)
+}
+
+// Run executes the pipeline every period, or once it is full, calling onFlush with the executed
+// commands. The pipe is flushed and closed once ctx is cancelled.
+func (p *Pipe) Run(ctx context.Context, period time.Duration, onFlush func([]redis.Cmder, error)) {
+	tk := time.NewTicker(period)
+	defer tk.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			p.mu.Lock()
+			p.closed = true
+			p.mu.Unlock()
+			onFlush(p.flush(context.WithoutCancel(ctx)))
+			return
+		case <-tk.C:
+		case <-p.full:
+		}
+
+		if cmds, err := p.flush(ctx); len(cmds) > 0 {
+			onFlush(cmds, err)
+		}
+	}
+}
+
+// cmdErr returns the error of a command, ignoring redis.Nil replies.
+func cmdErr(err error) error {
+	if errors.Is(err, redis.Nil) {
+		return nil
+	}
+
+	return err
+}
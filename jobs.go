@@ -2,13 +2,16 @@ package tasqueue
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"maps"
+	"slices"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/robfig/cron/v3"
-	"github.com/vmihailenco/msgpack/v5"
 	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
 	spans "go.opentelemetry.io/otel/trace"
 )
 
@@ -29,6 +32,10 @@ type Job struct {
 	OnError []*Job
 
 	Opts JobOpts
+
+	// PayloadRef is the key of the payload on the blob store, set by the server if the payload was
+	// offloaded from the job's message (ServerOpts.PayloadOffloadThreshold).
+	PayloadRef string
 }
 
 // JobOpts holds the various options available to configure a job.
@@ -36,13 +43,54 @@ type JobOpts struct {
 	// Optional ID passed by client. If empty, Tasqueue generates it.
 	ID string
 
+	// ETA is the time at which the job is made available to consumers.
+	// Delay is an alternative to the ETA, relative to the time of enqueue.
 	ETA        time.Time
+	Delay      time.Duration
 	Queue      string
 	MaxRetries uint32
 	Schedule   string
 	Timeout    time.Duration
+
+	// ExpiresAt, if set, is the time after which the job is discarded instead of being processed
+	// (eg: for jobs that are useless once stale). Expired jobs are set as StatusExpired and their
+	// callbacks, retries and OnError jobs are skipped.
+	ExpiresAt time.Time
+
+	// Priority of the job within its queue. Higher priority jobs are consumed first
+	// if the broker supports priorities (implements PriorityBroker), otherwise it is ignored.
+	Priority uint8
+
+	// UniqueKey, if set, rejects enqueuing the job while another job with the same key is
+	// pending or being processed. Enqueue then returns ErrDuplicateJob along with the ID of
+	// the existing job. The results store must implement UniqueResults.
+	// UniqueTTL is the duration after which the key is released even if the job hasn't finished
+	// (eg: if its worker crashed). If zero, the key is held until the job finishes.
+	UniqueKey string
+	UniqueTTL time.Duration
+
+	// Debounce, if set along with UniqueKey, coalesces the jobs with the same key enqueued within the
+	// duration into a single run (eg: reindexing an entity after a burst of updates). The first job is
+	// delayed by Debounce (unless it has an ETA), and the jobs enqueued while it is pending are not enqueued
+	// but return its ID. It is processed with the latest payload, or with all the payloads if DebounceCollect
+	// is set (decoded in the handler with DecodeDebounced()). Jobs enqueued once it is being processed are
+	// coalesced into the next run. It can't be used with Schedule.
+	Debounce        time.Duration
+	DebounceCollect bool
+
+	// Tags, if set, route the job onto a sub-queue of its queue that is only consumed by the servers
+	// having all the tags (ServerOpts.Tags), eg: []string{"gpu"}.
+	Tags []string
+
+	// Headers are arbitrary key/values carried along with the job (eg: tenant IDs, locales,
+	// correlation IDs), which are accessible in the handler with JobCtx.Headers().
+	Headers map[string]string
 }
 
+// ErrDuplicateJob is returned on enqueuing a job while another job with the same UniqueKey
+// is pending or being processed.
+var ErrDuplicateJob = errors.New("job with the same unique key is already pending")
+
 // Meta contains fields related to a job. These are updated when a task is consumed.
 type Meta struct {
 	ID           string
@@ -58,6 +106,74 @@ type Meta struct {
 	// PrevJobResults contains any job result set by the previous job in a chain.
 	// This will be nil if the previous job doesn't set the results on JobCtx.
 	PrevJobResult []byte
+
+	// TraceContext carries the trace context of the enqueuer, when tracing is enabled.
+	TraceContext map[string]string
+
+	// ID of the workflow run and the index of its step, if the job is part of a workflow.
+	WorkflowID   string
+	WorkflowStep int
+
+	// ID of the chain and the index of the job in it, if the job is part of a chain.
+	ChainID   string
+	ChainStep int
+
+	// ID of the group, if the job is part of a group.
+	GroupID string
+
+	// Worker is the ID of the server (ServerOpts.WorkerID) that processed the job last.
+	Worker string
+
+	// RetryOf is the ID of the job this job is a fresh run of, if it was enqueued with RetryJob().
+	RetryOf string
+
+	// Error is the error returned by the handler on the last attempt, empty if it succeeded.
+	Error string
+	// Attempts records each attempt at processing the job, including its retries.
+	Attempts []AttemptInfo
+}
+
+// AttemptInfo records an attempt at processing a job.
+type AttemptInfo struct {
+	StartedAt  time.Time
+	FinishedAt time.Time
+	// Error returned by the handler, empty if the attempt succeeded.
+	Error string
+	// Stack is the stack trace of the handler, if it panicked.
+	Stack string
+}
+
+// panicError is returned for a handler that panicked.
+type panicError struct {
+	val   any
+	stack []byte
+}
+
+func (e *panicError) Error() string {
+	return fmt.Sprintf("handler panicked : %v", e.val)
+}
+
+const (
+	// Maximum number of attempts recorded on a job, the oldest ones are dropped.
+	maxAttempts = 10
+	// Maximum size of the stack trace recorded on an attempt, longer stacks are truncated.
+	maxStackBytes = 8 << 10
+)
+
+// recordAttempt() appends the attempt onto the job's attempts. Only the latest `maxAttempts` attempts are
+// kept, and only the latest one keeps its stack, so that the message doesn't grow with each retry past the
+// size limits of the brokers.
+func (m *Meta) recordAttempt(a AttemptInfo) {
+	if len(a.Stack) > maxStackBytes {
+		a.Stack = a.Stack[:maxStackBytes]
+	}
+	for i := range m.Attempts {
+		m.Attempts[i].Stack = ""
+	}
+	if len(m.Attempts) >= maxAttempts {
+		m.Attempts = slices.Delete(m.Attempts, 0, len(m.Attempts)-maxAttempts+1)
+	}
+	m.Attempts = append(m.Attempts, a)
 }
 
 // DefaultMeta returns Meta with a ID and other defaults filled in.
@@ -95,13 +211,44 @@ type JobCtx struct {
 	// results just holds the results set by calling Save().
 	store Results
 	Meta  Meta
+
+	// enc encrypts the results set by calling Save(), if set.
+	enc Encrypter
+
+	// progress throttles the updates made by calling SetProgress().
+	progress *progress
+
+	// headers are the headers of the job, set with JobOpts.Headers.
+	headers map[string]string
+}
+
+// Headers() returns a copy of the headers the job was enqueued with.
+func (c JobCtx) Headers() map[string]string {
+	return maps.Clone(c.headers)
 }
 
 // Save() sets arbitrary results for a job in the results store.
 func (c JobCtx) Save(b []byte) error {
+	if c.enc != nil {
+		var err error
+		if b, err = c.enc.Encrypt(b); err != nil {
+			return fmt.Errorf("could not encrypt result : %w", err)
+		}
+	}
 	return c.store.Set(c, c.Meta.ID, b)
 }
 
+// prevResultKey is the context key holding the result of the previous job in a chain.
+type prevResultKey struct{}
+
+// GetPreviousResult() returns the result saved by the previous job in a chain (or the previous
+// step of a workflow) from the context passed to a handler. It returns nil if the job isn't part
+// of a chain or if the previous job didn't save a result.
+func GetPreviousResult(ctx context.Context) []byte {
+	b, _ := ctx.Value(prevResultKey{}).([]byte)
+	return b
+}
+
 // JobMessage is a wrapper over Task, used to transport the task over a broker.
 // It contains additional fields such as status and a ID.
 type JobMessage struct {
@@ -129,8 +276,30 @@ func (s *Server) Enqueue(ctx context.Context, t Job) (string, error) {
 func (s *Server) enqueueWithMeta(ctx context.Context, t Job, meta Meta) (string, error) {
 	var span spans.Span
 	if s.traceProv != nil {
-		ctx, span = otel.Tracer(tracer).Start(ctx, "enqueue_with_meta")
+		ctx, span = otel.Tracer(tracer).Start(ctx, "enqueue_with_meta", spans.WithSpanKind(spans.SpanKindProducer))
 		defer span.End()
+
+		// Carry the trace context over to the worker processing the job.
+		meta.TraceContext = make(map[string]string)
+		s.propagator.Inject(ctx, propagation.MapCarrier(meta.TraceContext))
+	}
+
+	if t.Opts.ETA.IsZero() && t.Opts.Delay != 0 {
+		t.Opts.ETA = s.now().Add(t.Opts.Delay)
+	}
+	if err := validTags(t.Opts.Tags); err != nil {
+		s.spanError(span, err)
+		return "", err
+	}
+	if t.Opts.Debounce != 0 {
+		if t.Opts.UniqueKey == "" || t.Opts.Schedule != "" {
+			err := fmt.Errorf("debounced jobs must have a unique key and no schedule")
+			s.spanError(span, err)
+			return "", err
+		}
+		if t.Opts.ETA.IsZero() {
+			t.Opts.ETA = s.now().Add(t.Opts.Debounce)
+		}
 	}
 
 	// If a schedule is set, add a cron job.
@@ -144,7 +313,7 @@ func (s *Server) enqueueWithMeta(ctx context.Context, t Job, meta Meta) (string,
 
 		if t.Opts.ETA.IsZero() {
 			// Set the jobs eta as the next time based on schedule
-			t.Opts.ETA = sch.Next(time.Now())
+			t.Opts.ETA = sch.Next(s.now())
 		}
 		// Create a new job that will be enqueued after existing job
 		j, err := NewJob(t.Task, t.Payload, t.Opts)
@@ -152,6 +321,7 @@ func (s *Server) enqueueWithMeta(ctx context.Context, t Job, meta Meta) (string,
 			s.spanError(span, err)
 			return "", err
 		}
+		j.PayloadRef = t.PayloadRef
 
 		// Set current jobs OnSuccess as next job
 		t.OnSuccess = append(t.OnSuccess, &j)
@@ -159,32 +329,113 @@ func (s *Server) enqueueWithMeta(ctx context.Context, t Job, meta Meta) (string,
 		j.Opts.ETA = sch.Next(t.Opts.ETA)
 	}
 
+	// Offload the large payloads onto the blob store.
 	var (
-		msg = t.message(meta)
+		orig    = t
+		payload = t.Payload
 	)
-
-	// Set job status in the results backend.
-	if err := s.statusStarted(ctx, msg); err != nil {
+	t, err := s.offloadPayloads(ctx, t)
+	if err != nil {
 		s.spanError(span, err)
 		return "", err
 	}
 
-	if !t.Opts.ETA.IsZero() {
-		if err := s.enqueueScheduled(ctx, msg); err != nil {
+	var (
+		msg = t.message(meta)
+	)
+
+	// Acquire the job's unique key, if any, or coalesce the debounced job onto the pending one.
+	// The payloads offloaded for a job that isn't enqueued are deleted, keeping the ones it
+	// was passed with.
+	if t.Opts.Debounce != 0 {
+		id, ok, err := s.acquireDebounced(ctx, msg, payload)
+		if err != nil {
 			s.spanError(span, err)
+			s.deleteBlobs(ctx, msg, []*Job{&orig})
 			return "", err
 		}
-		return msg.ID, nil
+		if !ok {
+			s.deleteBlobs(ctx, msg, []*Job{&orig})
+			return id, nil
+		}
+	} else if t.Opts.UniqueKey != "" {
+		id, err := s.acquireUnique(ctx, msg)
+		if err != nil {
+			s.spanError(span, err)
+			s.deleteBlobs(ctx, msg, []*Job{&orig})
+			return id, err
+		}
 	}
 
-	if err := s.enqueueMessage(ctx, msg); err != nil {
+	if err := s.enqueueNew(ctx, msg); err != nil {
 		s.spanError(span, err)
+		s.releaseUnique(ctx, msg)
 		return "", err
 	}
 
 	return msg.ID, nil
 }
 
+// enqueueNew() sets the status of a new job message and enqueues it (onto the scheduler
+// if the job has an ETA).
+func (s *Server) enqueueNew(ctx context.Context, msg JobMessage) error {
+	// Set job status in the results backend.
+	if err := s.statusStarted(ctx, msg); err != nil {
+		return err
+	}
+
+	if !msg.Job.Opts.ETA.IsZero() {
+		return s.enqueueScheduled(ctx, msg)
+	}
+
+	return s.enqueueMessage(ctx, msg)
+}
+
+// acquireUnique() acquires the unique key of a job. If the key is held by another job,
+// ErrDuplicateJob is returned along with the ID of that job.
+func (s *Server) acquireUnique(ctx context.Context, msg JobMessage) (string, error) {
+	ur, ok := s.results.(UniqueResults)
+	if !ok {
+		return "", fmt.Errorf("results store does not support unique jobs")
+	}
+
+	id, ok, err := ur.SetUnique(ctx, msg.Job.Opts.UniqueKey, msg.ID, msg.Job.Opts.UniqueTTL)
+	if err != nil {
+		return "", fmt.Errorf("could not set unique key : %w", err)
+	}
+	if !ok {
+		return id, ErrDuplicateJob
+	}
+
+	return msg.ID, nil
+}
+
+// releaseUnique() releases the unique key of a finished job, if any, so that new jobs with
+// the key can be enqueued.
+func (s *Server) releaseUnique(ctx context.Context, msg JobMessage) {
+	if msg.Job == nil || msg.Job.Opts.UniqueKey == "" {
+		return
+	}
+	if msg.Job.Opts.Debounce != 0 {
+		s.deleteDebounced(ctx, msg)
+	}
+	ur, ok := s.results.(UniqueResults)
+	if !ok {
+		return
+	}
+
+	if err := ur.DeleteUnique(ctx, msg.Job.Opts.UniqueKey, msg.ID); err != nil {
+		s.log.Error("could not release unique key", "id", msg.ID, "key", msg.Job.Opts.UniqueKey, "error", err)
+	}
+}
+
+// releaseKey() releases a unique key held by the server itself, eg: to enqueue the OnComplete job of a group.
+func (s *Server) releaseKey(ctx context.Context, key, id string) {
+	if err := s.results.(UniqueResults).DeleteUnique(context.WithoutCancel(ctx), key, id); err != nil {
+		s.log.Error("could not release unique key", "id", id, "key", key, "error", err)
+	}
+}
+
 func (s *Server) enqueueScheduled(ctx context.Context, msg JobMessage) error {
 	var span spans.Span
 	if s.traceProv != nil {
@@ -192,17 +443,19 @@ func (s *Server) enqueueScheduled(ctx context.Context, msg JobMessage) error {
 		defer span.End()
 	}
 
-	b, err := msgpack.Marshal(msg)
+	b, err := s.codec.marshal(msg)
 	if err != nil {
 		s.spanError(span, err)
 		return err
 	}
 
-	if err := s.broker.EnqueueScheduled(ctx, b, msg.Queue, msg.Job.Opts.ETA); err != nil {
+	if err := s.brokerFor(msg.Queue).EnqueueScheduled(ctx, b, msgQueue(msg), msg.Job.Opts.ETA); err != nil {
 		s.spanError(span, err)
 		return err
 	}
 
+	s.jobEnqueued(ctx, msg)
+
 	return nil
 }
 
@@ -213,22 +466,45 @@ func (s *Server) enqueueMessage(ctx context.Context, msg JobMessage) error {
 		defer span.End()
 	}
 
-	b, err := msgpack.Marshal(msg)
+	b, err := s.codec.marshal(msg)
 	if err != nil {
 		s.spanError(span, err)
 		return err
 	}
 
-	if err := s.broker.Enqueue(ctx, b, msg.Queue); err != nil {
+	if err := s.brokerEnqueue(ctx, b, msg); err != nil {
 		s.spanError(span, err)
 		return err
 	}
 
+	s.jobEnqueued(ctx, msg)
+
 	return nil
 }
 
+// brokerEnqueue pushes the encoded job message onto its queue (or the sub-queue of its tags), with
+// the job's priority if it is set and supported by the broker.
+func (s *Server) brokerEnqueue(ctx context.Context, b []byte, msg JobMessage) error {
+	br := s.brokerFor(msg.Queue)
+	if pb, ok := br.(PriorityBroker); ok && msg.Job.Opts.Priority != 0 {
+		return pb.EnqueuePriority(ctx, b, msgQueue(msg), msg.Job.Opts.Priority)
+	}
+
+	return br.Enqueue(ctx, b, msgQueue(msg))
+}
+
 const jobPrefix = "job:msg:"
 
+// setMeta() stores the server's metadata on the results store, through MetaResults if the store
+// implements it, so that it isn't treated as a result saved by a job.
+func setMeta(ctx context.Context, r Results, id string, b []byte) error {
+	if mr, ok := r.(MetaResults); ok {
+		return mr.SetMeta(ctx, id, b)
+	}
+
+	return r.Set(ctx, id, b)
+}
+
 func (s *Server) setJobMessage(ctx context.Context, t JobMessage) error {
 	var span spans.Span
 	if s.traceProv != nil {
@@ -236,12 +512,12 @@ func (s *Server) setJobMessage(ctx context.Context, t JobMessage) error {
 		defer span.End()
 	}
 
-	b, err := msgpack.Marshal(t)
+	b, err := s.codec.marshal(t)
 	if err != nil {
 		s.spanError(span, err)
 		return fmt.Errorf("could not set job message in store : %w", err)
 	}
-	if err := s.results.Set(ctx, jobPrefix+t.ID, b); err != nil {
+	if err := setMeta(ctx, s.results, jobPrefix+t.ID, b); err != nil {
 		s.spanError(span, err)
 		return fmt.Errorf("could not set job message in store : %w", err)
 	}
@@ -258,17 +534,63 @@ func (s *Server) GetJob(ctx context.Context, id string) (JobMessage, error) {
 		defer span.End()
 	}
 
-	b, err := s.GetResult(ctx, jobPrefix+id)
+	b, err := s.getResult(ctx, jobPrefix+id)
 	if err != nil {
 		s.spanError(span, err)
 		return JobMessage{}, err
 	}
 
 	var t JobMessage
-	if err := msgpack.Unmarshal(b, &t); err != nil {
+	if err := s.codec.unmarshal(b, &t); err != nil {
 		s.spanError(span, err)
 		return JobMessage{}, err
 	}
 
 	return t, nil
 }
+
+// SubscribeJob() returns a channel receiving the job message each time it is updated, starting with
+// its current state. The channel is closed once the job has finished (successful, failed or cancelled)
+// or ctx is cancelled. The results store must implement SubscribeResults.
+func (s *Server) SubscribeJob(ctx context.Context, id string) (<-chan JobMessage, error) {
+	sr, ok := s.results.(SubscribeResults)
+	if !ok {
+		return nil, fmt.Errorf("results store does not support subscriptions")
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	updates, err := sr.Subscribe(ctx, jobPrefix+id)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("could not subscribe to job : %w", err)
+	}
+
+	ch := make(chan JobMessage)
+	go func() {
+		defer close(ch)
+		defer cancel()
+
+		for b := range updates {
+			var t JobMessage
+			if err := s.codec.unmarshal(b, &t); err != nil {
+				s.log.Error("could not decode subscribed job message", "id", id, "error", err)
+				continue
+			}
+			select {
+			case ch <- t:
+			case <-ctx.Done():
+				return
+			}
+			if finished(t.Status) {
+				return
+			}
+		}
+	}()
+
+	return ch, nil
+}
+
+// finished() returns whether the status is a terminal one.
+func finished(status string) bool {
+	return status == StatusDone || status == StatusFailed || status == StatusCancelled || status == StatusExpired
+}
@@ -4,8 +4,16 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"log/slog"
+	"maps"
+	"os"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
+
+	rb "github.com/kalbhor/tasqueue/v2/brokers/in-memory"
+	rr "github.com/kalbhor/tasqueue/v2/results/in-memory"
 )
 
 func TestEnqueue(t *testing.T) {
@@ -271,6 +279,108 @@ func TestJobsOnError(t *testing.T) {
 	}
 }
 
+func TestJobPriority(t *testing.T) {
+	var (
+		srv   = newServer(t, taskName, MockHandler)
+		ctx   = context.Background()
+		order = make(chan string, 3)
+	)
+
+	if err := srv.RegisterTask("priority", func(b []byte, jc JobCtx) error {
+		order <- string(b)
+		return nil
+	}, TaskOpts{
+		Queue:       "priority_task",
+		Concurrency: 1,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Enqueue all the jobs before starting the server, so that they are consumed by priority.
+	for _, p := range []uint8{0, 5, 2} {
+		job, err := NewJob("priority", []byte{'0' + p}, JobOpts{Queue: "priority_task", Priority: p})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := srv.Enqueue(ctx, job); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	go srv.Start(ctx)
+
+	for _, exp := range []string{"5", "2", "0"} {
+		if got := <-order; got != exp {
+			t.Fatalf("incorrect job order, expected %s, got %s", exp, got)
+		}
+	}
+}
+
+func TestJobDelay(t *testing.T) {
+	var (
+		srv = newServer(t, taskName, MockHandler)
+		ctx = context.Background()
+		job = makeJob(t, taskName, false)
+	)
+	go srv.Start(ctx)
+
+	job.Opts.Delay = 2 * time.Second
+	uuid, err := srv.Enqueue(ctx, job)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The job must not be consumed before the delay.
+	time.Sleep(time.Second)
+	msg, err := srv.GetJob(ctx, uuid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Status != StatusStarted {
+		t.Fatalf("incorrect job status, expected %s, got %s", StatusStarted, msg.Status)
+	}
+
+	time.Sleep(2 * time.Second)
+	msg, err = srv.GetJob(ctx, uuid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Status != StatusDone {
+		t.Fatalf("incorrect job status, expected %s, got %s", StatusDone, msg.Status)
+	}
+}
+
+func TestUniqueJob(t *testing.T) {
+	var (
+		srv = newServer(t, taskName, MockHandler)
+		ctx = context.Background()
+		job = makeJob(t, taskName, false)
+	)
+	go srv.Start(ctx)
+
+	job.Opts.UniqueKey = "unique"
+	job.Opts.Delay = 500 * time.Millisecond
+	uuid, err := srv.Enqueue(ctx, job)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A job with the same key is rejected while the first one is pending.
+	dup, err := srv.Enqueue(ctx, job)
+	if err != ErrDuplicateJob {
+		t.Fatalf("expected %v, got %v", ErrDuplicateJob, err)
+	}
+	if dup != uuid {
+		t.Fatalf("expected id of the existing job %s, got %s", uuid, dup)
+	}
+
+	// The key is released once the job finishes.
+	time.Sleep(1500 * time.Millisecond)
+	if _, err := srv.Enqueue(ctx, job); err != nil {
+		t.Fatal(err)
+	}
+}
+
 func makeJob(t *testing.T, taskName string, doErr bool) Job {
 	j, err := json.Marshal(MockPayload{ShouldErr: doErr})
 	if err != nil {
@@ -284,3 +394,208 @@ func makeJob(t *testing.T, taskName string, doErr bool) Job {
 
 	return job
 }
+
+func TestJobHeaders(t *testing.T) {
+	var (
+		headers = make(chan map[string]string, 1)
+		srv     = newServer(t, taskName, MockHandler)
+		ctx     = context.Background()
+	)
+
+	if err := srv.RegisterTask("headers", func(_ []byte, j JobCtx) error {
+		headers <- j.Headers()
+		return nil
+	}, TaskOpts{}); err != nil {
+		t.Fatal(err)
+	}
+	go srv.Start(ctx)
+
+	want := map[string]string{"tenant": "acme", "locale": "en-GB"}
+	job, err := NewJob("headers", nil, JobOpts{Headers: want})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := srv.Enqueue(ctx, job); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case got := <-headers:
+		if !maps.Equal(got, want) {
+			t.Fatalf("incorrect job headers, expected %v, got %v", want, got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("job was not processed")
+	}
+}
+
+func TestSubscribeJob(t *testing.T) {
+	var (
+		srv         = newServer(t, taskName, MockHandler)
+		ctx, cancel = context.WithCancel(context.Background())
+	)
+	defer cancel()
+
+	id, err := srv.Enqueue(ctx, makeJob(t, taskName, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	updates, err := srv.SubscribeJob(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Start(ctx)
+
+	var statuses []string
+	timeout := time.After(5 * time.Second)
+	for done := false; !done; {
+		select {
+		case msg, ok := <-updates:
+			if !ok {
+				done = true
+				break
+			}
+			statuses = append(statuses, msg.Status)
+		case <-timeout:
+			t.Fatalf("subscription was not closed, got statuses %v", statuses)
+		}
+	}
+
+	// Updates that weren't received in time are coalesced, the last one is always received.
+	if len(statuses) == 0 || statuses[len(statuses)-1] != StatusDone {
+		t.Fatalf("incorrect job statuses, expected the last to be %s, got %v", StatusDone, statuses)
+	}
+}
+
+func TestJobErrors(t *testing.T) {
+	var (
+		srv         = newServer(t, taskName, MockHandler)
+		ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	)
+	defer cancel()
+
+	if err := srv.RegisterTask("panic", func([]byte, JobCtx) error {
+		panic("boom")
+	}, TaskOpts{}); err != nil {
+		t.Fatal(err)
+	}
+	go srv.Start(ctx)
+
+	// The error of each attempt is recorded.
+	res, err := srv.EnqueueAndWait(ctx, makeJob(t, taskName, true))
+	if !errors.Is(err, ErrJobFailed) {
+		t.Fatalf("expected %v, got %v", ErrJobFailed, err)
+	}
+	if res.Job.Error == "" || len(res.Job.Attempts) != 2 {
+		t.Fatalf("expected the job error and 2 attempts, got %q and %d attempts", res.Job.Error, len(res.Job.Attempts))
+	}
+	for _, a := range res.Job.Attempts {
+		if a.Error != res.Job.Error || a.StartedAt.IsZero() || a.FinishedAt.Before(a.StartedAt) {
+			t.Fatalf("incorrect attempt %+v", a)
+		}
+	}
+
+	// Panics are recovered, along with their stack.
+	job, err := NewJob("panic", nil, JobOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err = srv.EnqueueAndWait(ctx, job)
+	if !errors.Is(err, ErrJobFailed) {
+		t.Fatalf("expected %v, got %v", ErrJobFailed, err)
+	}
+	if len(res.Job.Attempts) != 1 || !strings.Contains(res.Job.Error, "boom") || res.Job.Attempts[0].Stack == "" {
+		t.Fatalf("expected the panic and its stack to be recorded, got %+v", res.Job.Attempts)
+	}
+
+	// Successful jobs have no error.
+	res, err = srv.EnqueueAndWait(ctx, makeJob(t, taskName, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Job.Error != "" || len(res.Job.Attempts) != 1 || res.Job.Attempts[0].Error != "" {
+		t.Fatalf("expected a successful attempt, got %q and %+v", res.Job.Error, res.Job.Attempts)
+	}
+
+	// Only the latest attempts are kept, and only the latest one keeps its (truncated) stack.
+	var m Meta
+	for i := 0; i < maxAttempts+5; i++ {
+		m.recordAttempt(AttemptInfo{Error: strconv.Itoa(i), Stack: strings.Repeat("x", maxStackBytes+1)})
+	}
+	if len(m.Attempts) != maxAttempts || m.Attempts[0].Error != "5" || m.Attempts[maxAttempts-1].Error != strconv.Itoa(maxAttempts+4) {
+		t.Fatalf("expected the latest %d attempts, got %+v", maxAttempts, m.Attempts)
+	}
+	for i, a := range m.Attempts {
+		if i < maxAttempts-1 && a.Stack != "" {
+			t.Fatalf("expected only the latest attempt to keep its stack, got attempt %d with one", i)
+		}
+	}
+	if len(m.Attempts[maxAttempts-1].Stack) != maxStackBytes {
+		t.Fatalf("expected the stack to be truncated to %d bytes, got %d", maxStackBytes, len(m.Attempts[maxAttempts-1].Stack))
+	}
+}
+
+func TestJobExpiry(t *testing.T) {
+	var (
+		ctx   = context.Background()
+		clock = rb.NewFakeClock(time.Now())
+		lo    = slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
+			Level: slog.LevelError,
+		}))
+		handled int
+	)
+	srv, err := NewServer(ServerOpts{
+		Broker:  rb.NewWithClock(clock),
+		Results: rr.New(),
+		Logger:  lo.Handler(),
+		Clock:   clock,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.RegisterTask(taskName, func([]byte, JobCtx) error {
+		handled++
+		return nil
+	}, TaskOpts{}); err != nil {
+		t.Fatal(err)
+	}
+
+	fresh := makeJob(t, taskName, false)
+	fresh.Opts.ExpiresAt = clock.Now().Add(time.Minute)
+	freshID, err := srv.Enqueue(ctx, fresh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The delayed job becomes due after it has expired.
+	stale := makeJob(t, taskName, false)
+	stale.Opts.ExpiresAt = clock.Now().Add(time.Minute)
+	stale.Opts.Delay = 2 * time.Minute
+	staleID, err := srv.Enqueue(ctx, stale)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := srv.ProcessAll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(2 * time.Minute)
+	if _, err := srv.ProcessAll(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	for id, exp := range map[string]string{freshID: StatusDone, staleID: StatusExpired} {
+		msg, err := srv.GetJob(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg.Status != exp {
+			t.Fatalf("incorrect job status, expected %s, got %s", exp, msg.Status)
+		}
+	}
+	if handled != 1 {
+		t.Fatalf("expected only the fresh job to be handled, got %d", handled)
+	}
+	if err := srv.CancelJob(ctx, staleID); err == nil {
+		t.Fatal("expected an error cancelling an expired job")
+	}
+}
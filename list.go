@@ -0,0 +1,72 @@
+package tasqueue
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Default number of jobs returned by ListJobs().
+const DefaultListLimit = 100
+
+// JobFilter filters the jobs listed by ListJobs().
+type JobFilter struct {
+	// Task is the name of the jobs' task. If empty, jobs of all tasks are listed.
+	Task string
+
+	// From and To limit the jobs to the ones that finished in [From, To). Zero bounds are ignored.
+	From time.Time
+	To   time.Time
+}
+
+// JobEntry is a job listed by ListJobs(), along with the time it finished at.
+type JobEntry struct {
+	ID string    `json:"id"`
+	At time.Time `json:"at"`
+}
+
+// JobList is a page of jobs returned by ListJobs().
+type JobList struct {
+	Jobs []JobEntry `json:"jobs"`
+	// Cursor is passed to ListJobs() to fetch the next page. It is empty on the last page.
+	Cursor string `json:"cursor"`
+}
+
+// ListJobs() returns a page of up to limit (DefaultListLimit if zero) jobs that finished in the state
+// (StatusDone or StatusFailed), most recent first. The jobs are filtered by queue if non-empty and by
+// the filter. The cursor is empty for the first page, and the JobList's cursor for the next ones.
+// The results store must implement JobLister.
+func (s *Server) ListJobs(ctx context.Context, state, queue string, limit int, cursor string, f JobFilter) (JobList, error) {
+	jl, ok := s.results.(JobLister)
+	if !ok {
+		return JobList{}, fmt.Errorf("results store does not support listing jobs")
+	}
+	if state != StatusDone && state != StatusFailed {
+		return JobList{}, fmt.Errorf("jobs can't be listed by state %s", state)
+	}
+	if limit <= 0 {
+		limit = DefaultListLimit
+	}
+
+	ids, ats, next, err := jl.ListJobs(ctx, state, queue, limit, cursor, f.Task, f.From, f.To)
+	if err != nil {
+		return JobList{}, err
+	}
+
+	list := JobList{Jobs: make([]JobEntry, len(ids)), Cursor: next}
+	for i, id := range ids {
+		list.Jobs[i] = JobEntry{ID: id, At: ats[i]}
+	}
+
+	return list, nil
+}
+
+// indexJob() indexes the finished job, if the results store implements JobLister.
+func (s *Server) indexJob(ctx context.Context, t JobMessage) error {
+	jl, ok := s.results.(JobLister)
+	if !ok {
+		return nil
+	}
+
+	return jl.IndexJob(ctx, t.ID, t.Status, t.Queue, t.Job.Task, t.ProcessedAt)
+}
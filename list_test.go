@@ -0,0 +1,92 @@
+package tasqueue
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestListJobs(t *testing.T) {
+	var (
+		ctx = context.Background()
+		srv = newServer(t, taskName, MockHandler)
+	)
+	if err := srv.RegisterTask("other", MockHandler, TaskOpts{}); err != nil {
+		t.Fatal(err)
+	}
+	go srv.Start(ctx)
+
+	start := time.Now()
+	var ids []string
+	for i := 0; i < 5; i++ {
+		id, err := srv.Enqueue(ctx, makeJob(t, taskName, false))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	if _, err := srv.Enqueue(ctx, makeJob(t, "other", false)); err != nil {
+		t.Fatal(err)
+	}
+	failed, err := srv.Enqueue(ctx, makeJob(t, taskName, true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Second)
+
+	// Page through the successful jobs of the task.
+	var (
+		got    = make(map[string]bool)
+		cursor string
+		pages  int
+	)
+	for {
+		list, err := srv.ListJobs(ctx, StatusDone, DefaultQueue, 2, cursor, JobFilter{Task: taskName})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, j := range list.Jobs {
+			if got[j.ID] {
+				t.Fatalf("job %s listed twice", j.ID)
+			}
+			if i > 0 && j.At.After(list.Jobs[i-1].At) {
+				t.Fatal("jobs aren't listed most recent first")
+			}
+			got[j.ID] = true
+		}
+		pages++
+		if list.Cursor == "" {
+			break
+		}
+		cursor = list.Cursor
+	}
+	if len(got) != len(ids) || pages != 3 {
+		t.Fatalf("expected %d jobs in 3 pages, got %d jobs in %d pages", len(ids), len(got), pages)
+	}
+	for _, id := range ids {
+		if !got[id] {
+			t.Fatalf("job %s wasn't listed", id)
+		}
+	}
+
+	list, err := srv.ListJobs(ctx, StatusFailed, "", 0, "", JobFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Jobs) != 1 || list.Jobs[0].ID != failed {
+		t.Fatalf("incorrect failed jobs: %+v", list.Jobs)
+	}
+
+	// Jobs outside the time range aren't listed.
+	list, err = srv.ListJobs(ctx, StatusDone, "", 0, "", JobFilter{To: start})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Jobs) != 0 {
+		t.Fatalf("expected no jobs before the range, got %+v", list.Jobs)
+	}
+
+	if _, err := srv.ListJobs(ctx, StatusProcessing, "", 0, "", JobFilter{}); err == nil {
+		t.Fatal("expected error listing jobs by an unsupported state")
+	}
+}
@@ -0,0 +1,96 @@
+package prometheus
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const DefaultNamespace = "tasqueue"
+
+type Options struct {
+	// Namespace prefixed to the metric names. Defaults to `DefaultNamespace`.
+	Namespace string
+
+	// Buckets of the job duration histogram (in seconds). Defaults to prometheus.DefBuckets.
+	Buckets []float64
+
+	// Registry the metrics are registered on. If nil, a new registry is created.
+	Registry *prometheus.Registry
+}
+
+// Metrics is a prometheus based metrics collector.
+type Metrics struct {
+	reg *prometheus.Registry
+
+	enqueued  *prometheus.CounterVec
+	processed *prometheus.CounterVec
+	duration  *prometheus.HistogramVec
+	depth     *prometheus.GaugeVec
+}
+
+// New() returns a new instance of the prometheus metrics collector, with its metrics registered.
+func New(o Options) (*Metrics, error) {
+	if o.Namespace == "" {
+		o.Namespace = DefaultNamespace
+	}
+	if o.Buckets == nil {
+		o.Buckets = prometheus.DefBuckets
+	}
+	if o.Registry == nil {
+		o.Registry = prometheus.NewRegistry()
+	}
+
+	m := &Metrics{
+		reg: o.Registry,
+		enqueued: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: o.Namespace,
+			Name:      "jobs_enqueued_total",
+			Help:      "Number of jobs enqueued.",
+		}, []string{"queue", "task"}),
+		processed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: o.Namespace,
+			Name:      "jobs_processed_total",
+			Help:      "Number of jobs processed, by resulting status (successful, retrying or failed).",
+		}, []string{"queue", "task", "status"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: o.Namespace,
+			Name:      "job_duration_seconds",
+			Help:      "Time taken by the task handlers to process jobs.",
+			Buckets:   o.Buckets,
+		}, []string{"queue", "task"}),
+		depth: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: o.Namespace,
+			Name:      "queue_depth",
+			Help:      "Number of jobs pending on the queue.",
+		}, []string{"queue"}),
+	}
+
+	for _, c := range []prometheus.Collector{m.enqueued, m.processed, m.duration, m.depth} {
+		if err := m.reg.Register(c); err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+func (m *Metrics) JobEnqueued(queue, task string) {
+	m.enqueued.WithLabelValues(queue, task).Inc()
+}
+
+func (m *Metrics) JobProcessed(queue, task, status string, d time.Duration) {
+	m.processed.WithLabelValues(queue, task, status).Inc()
+	m.duration.WithLabelValues(queue, task).Observe(d.Seconds())
+}
+
+func (m *Metrics) QueueDepth(queue string, depth int) {
+	m.depth.WithLabelValues(queue).Set(float64(depth))
+}
+
+// Handler() returns a http.Handler serving the metrics in the prometheus exposition format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.reg, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,76 @@
+package tasqueue
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	rb "github.com/kalbhor/tasqueue/v2/brokers/in-memory"
+	rr "github.com/kalbhor/tasqueue/v2/results/in-memory"
+)
+
+// mockMetrics records the metrics reported by the server.
+type mockMetrics struct {
+	mu        sync.Mutex
+	enqueued  int
+	processed map[string]int
+}
+
+func (m *mockMetrics) JobEnqueued(queue, task string) {
+	m.mu.Lock()
+	m.enqueued++
+	m.mu.Unlock()
+}
+
+func (m *mockMetrics) JobProcessed(queue, task, status string, d time.Duration) {
+	m.mu.Lock()
+	m.processed[status]++
+	m.mu.Unlock()
+}
+
+func (m *mockMetrics) QueueDepth(queue string, depth int) {}
+
+func TestMetricsCollector(t *testing.T) {
+	var (
+		ctx = context.Background()
+		mm  = &mockMetrics{processed: make(map[string]int)}
+		lo  = slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
+			Level: slog.LevelError,
+		}))
+	)
+	srv, err := NewServer(ServerOpts{
+		Broker:           rb.New(),
+		Results:          rr.New(),
+		Logger:           lo.Handler(),
+		MetricsCollector: mm,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.RegisterTask(taskName, MockHandler, TaskOpts{}); err != nil {
+		t.Fatal(err)
+	}
+	go srv.Start(ctx)
+
+	// The failing job is retried once before failing.
+	for _, doErr := range []bool{false, true} {
+		if _, err := srv.Enqueue(ctx, makeJob(t, taskName, doErr)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(time.Second)
+
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+	if mm.enqueued != 2 {
+		t.Fatalf("incorrect enqueued count, expected 2, got %d", mm.enqueued)
+	}
+	for _, status := range []string{StatusDone, StatusRetrying, StatusFailed} {
+		if mm.processed[status] != 1 {
+			t.Fatalf("incorrect processed count for %s, expected 1, got %d", status, mm.processed[status])
+		}
+	}
+}
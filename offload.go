@@ -0,0 +1,131 @@
+package tasqueue
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+// Prefix of the keys of the payloads offloaded onto the blob store.
+const payloadPrefix = "payload:"
+
+// resultsBlobs is the default blob store, storing the payloads on the results store.
+type resultsBlobs struct {
+	results Results
+}
+
+func (r resultsBlobs) PutBlob(ctx context.Context, key string, b []byte) error {
+	return setMeta(ctx, r.results, key, b)
+}
+
+func (r resultsBlobs) GetBlob(ctx context.Context, key string) ([]byte, error) {
+	return r.results.Get(ctx, key)
+}
+
+func (r resultsBlobs) DeleteBlob(ctx context.Context, key string) error {
+	return r.results.DeleteJob(ctx, key)
+}
+
+// offloadPayloads() stores the payloads of the job and its OnSuccess and OnError jobs that are larger
+// than the threshold on the blob store, returning a copy of the job referencing them. The jobs passed
+// aren't modified.
+func (s *Server) offloadPayloads(ctx context.Context, j Job) (Job, error) {
+	if s.offloadThreshold <= 0 {
+		return j, nil
+	}
+
+	if len(j.Payload) > s.offloadThreshold {
+		b := j.Payload
+		if s.enc != nil {
+			var err error
+			if b, err = s.enc.Encrypt(b); err != nil {
+				return Job{}, fmt.Errorf("could not encrypt payload : %w", err)
+			}
+		}
+
+		key := payloadPrefix + uuid.NewString()
+		if err := s.blobs.PutBlob(ctx, key, b); err != nil {
+			return Job{}, fmt.Errorf("could not offload payload : %w", err)
+		}
+		j.Payload, j.PayloadRef = nil, key
+	}
+
+	var err error
+	if j.OnSuccess, err = s.offloadJobs(ctx, j.OnSuccess); err != nil {
+		return Job{}, err
+	}
+	if j.OnError, err = s.offloadJobs(ctx, j.OnError); err != nil {
+		return Job{}, err
+	}
+
+	return j, nil
+}
+
+func (s *Server) offloadJobs(ctx context.Context, jobs []*Job) ([]*Job, error) {
+	if len(jobs) == 0 {
+		return jobs, nil
+	}
+
+	out := make([]*Job, len(jobs))
+	for i, j := range jobs {
+		if j == nil {
+			continue
+		}
+		c, err := s.offloadPayloads(ctx, *j)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = &c
+	}
+
+	return out, nil
+}
+
+// loadPayload() returns the payload of the job, loading it from the blob store if it was offloaded.
+func (s *Server) loadPayload(ctx context.Context, j *Job) ([]byte, error) {
+	if j.PayloadRef == "" {
+		return j.Payload, nil
+	}
+	if s.blobs == nil {
+		return nil, fmt.Errorf("blob store not set, could not load payload %s", j.PayloadRef)
+	}
+
+	b, err := s.blobs.GetBlob(ctx, j.PayloadRef)
+	if err != nil {
+		return nil, fmt.Errorf("could not load payload %s : %w", j.PayloadRef, err)
+	}
+	if s.enc != nil {
+		if b, err = s.enc.Decrypt(b); err != nil {
+			return nil, fmt.Errorf("could not decrypt payload : %w", err)
+		}
+	}
+
+	return b, nil
+}
+
+// deleteBlobs() deletes the offloaded payloads of the finished job and its OnSuccess and OnError jobs, except
+// the ones of the next jobs enqueued after it (eg: the OnSuccess jobs of a successful job, or the next run of a
+// scheduled job, which shares its payload).
+func (s *Server) deleteBlobs(ctx context.Context, msg JobMessage, next []*Job) {
+	if s.blobs == nil || msg.Job == nil {
+		return
+	}
+
+	keep := make(map[string]bool)
+	for _, n := range next {
+		for _, j := range jobTree(n) {
+			keep[j.PayloadRef] = true
+		}
+	}
+	for _, j := range jobTree(msg.Job) {
+		if j.PayloadRef == "" || keep[j.PayloadRef] {
+			continue
+		}
+		keep[j.PayloadRef] = true
+
+		if err := s.blobs.DeleteBlob(ctx, j.PayloadRef); err != nil {
+			s.log.Error("could not delete offloaded payload", "id", msg.ID, "key", j.PayloadRef, "error", err)
+		}
+	}
+}
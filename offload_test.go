@@ -0,0 +1,223 @@
+package tasqueue
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	rb "github.com/kalbhor/tasqueue/v2/brokers/in-memory"
+	rr "github.com/kalbhor/tasqueue/v2/results/in-memory"
+)
+
+func TestPayloadOffload(t *testing.T) {
+	lo := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
+		Level: slog.LevelError,
+	}))
+	ctx := context.Background()
+
+	enc, err := NewAESEncrypter(AESKey{ID: "k", Key: bytes.Repeat([]byte("k"), 32)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv, err := NewServer(ServerOpts{
+		Broker:                  rb.New(),
+		Results:                 rr.New(),
+		Logger:                  lo.Handler(),
+		Encrypter:               enc,
+		PayloadOffloadThreshold: 16,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	if err := srv.RegisterTask("echo", func(b []byte, j JobCtx) error {
+		got = append(got, string(b))
+		return nil
+	}, TaskOpts{}); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		large = strings.Repeat("large payload ", 100)
+		next  = strings.Repeat("next payload ", 100)
+	)
+	onSuccess, err := NewJob("echo", []byte(next), JobOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	job, err := NewJob("echo", []byte(large), JobOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	job.OnSuccess = []*Job{&onSuccess}
+	small, err := NewJob("echo", []byte("small"), JobOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := srv.Enqueue(ctx, job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := srv.Enqueue(ctx, small); err != nil {
+		t.Fatal(err)
+	}
+	// The jobs passed aren't modified.
+	if string(job.Payload) != large || string(onSuccess.Payload) != next || onSuccess.PayloadRef != "" {
+		t.Fatal("the enqueued job was modified")
+	}
+
+	msg, err := srv.GetJob(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msg.Job.Payload) != 0 || msg.Job.PayloadRef == "" || len(msg.Job.OnSuccess[0].Payload) != 0 {
+		t.Fatalf("expected the payloads to be offloaded, got %d bytes", len(msg.Job.Payload))
+	}
+	pending, err := srv.GetPending(ctx, DefaultQueue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 2 || len(pending[1].Job.Payload) != len("small") || pending[1].Job.PayloadRef != "" {
+		t.Fatalf("expected the small payload to be kept on the message, got %+v", pending)
+	}
+
+	if _, err := srv.ProcessAll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 3 || got[0] != large || got[1] != "small" || got[2] != next {
+		t.Fatalf("incorrect payloads passed to the handler, got %d payloads", len(got))
+	}
+
+	// The payloads are deleted once their jobs are done.
+	for _, ref := range []string{msg.Job.PayloadRef, msg.Job.OnSuccess[0].PayloadRef} {
+		if _, err := srv.blobs.GetBlob(ctx, ref); err == nil {
+			t.Fatalf("expected the payload %s to be deleted", ref)
+		}
+	}
+
+	// The payloads of failed jobs are kept, so that they can be replayed.
+	if err := srv.RegisterTask("fail", func(b []byte, j JobCtx) error {
+		got = append(got, string(b))
+		return errors.New("failed")
+	}, TaskOpts{}); err != nil {
+		t.Fatal(err)
+	}
+	failing, err := NewJob("fail", []byte(large), JobOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, err = srv.Enqueue(ctx, failing); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := srv.ProcessAll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := srv.RetryJob(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := srv.ProcessAll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 5 || got[3] != large || got[4] != large {
+		t.Fatalf("incorrect payloads passed to the failing handler, got %d payloads", len(got))
+	}
+}
+
+// mapBlobs is a BlobStore keeping the blobs in a map.
+type mapBlobs struct {
+	mu    sync.Mutex
+	blobs map[string][]byte
+}
+
+func (m *mapBlobs) PutBlob(_ context.Context, key string, b []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.blobs[key] = b
+	return nil
+}
+
+func (m *mapBlobs) GetBlob(_ context.Context, key string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, ok := m.blobs[key]
+	if !ok {
+		return nil, errors.New("blob not found")
+	}
+	return b, nil
+}
+
+func (m *mapBlobs) DeleteBlob(_ context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.blobs, key)
+	return nil
+}
+
+func (m *mapBlobs) len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.blobs)
+}
+
+func TestPayloadOffloadDropped(t *testing.T) {
+	lo := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
+		Level: slog.LevelError,
+	}))
+	ctx := context.Background()
+
+	var (
+		clock = rb.NewFakeClock(time.Now())
+		blobs = &mapBlobs{blobs: make(map[string][]byte)}
+	)
+	srv, err := NewServer(ServerOpts{
+		Broker:                  rb.NewWithClock(clock),
+		Results:                 rr.New(),
+		Logger:                  lo.Handler(),
+		Clock:                   clock,
+		PayloadOffloadThreshold: 16,
+		BlobStore:               blobs,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.RegisterTask("echo", func(b []byte, j JobCtx) error {
+		return nil
+	}, TaskOpts{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The payloads of the jobs coalesced onto a debounced job, or rejected as duplicates, are deleted.
+	large := strings.Repeat("large payload ", 100)
+	for _, opts := range []JobOpts{
+		{UniqueKey: "debounced", Debounce: time.Minute},
+		{UniqueKey: "unique"},
+	} {
+		for i := 0; i < 3; i++ {
+			job, err := NewJob("echo", []byte(large), opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := srv.Enqueue(ctx, job); err != nil && !errors.Is(err, ErrDuplicateJob) {
+				t.Fatal(err)
+			}
+		}
+	}
+	if n := blobs.len(); n != 2 {
+		t.Fatalf("expected the payloads of the 2 enqueued jobs to be kept, got %d", n)
+	}
+
+	clock.Advance(time.Minute)
+	if _, err := srv.ProcessAll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if n := blobs.len(); n != 0 {
+		t.Fatalf("expected the payloads to be deleted, got %d", n)
+	}
+}
@@ -0,0 +1,122 @@
+package tasqueue
+
+import (
+	"context"
+	"time"
+)
+
+// Interval at which the consumers check whether their queue was paused or resumed.
+const pausePollInterval = time.Second
+
+// PauseQueue() stops the servers from consuming new jobs from the queue, while jobs can still be
+// enqueued onto it and the jobs being processed are finished. If the broker implements PauseBroker,
+// the queue is paused on all the servers sharing the broker, otherwise only on this server.
+func (s *Server) PauseQueue(ctx context.Context, queue string) error {
+	return s.setPaused(ctx, queue, true)
+}
+
+// ResumeQueue() resumes consuming jobs from a paused queue.
+func (s *Server) ResumeQueue(ctx context.Context, queue string) error {
+	return s.setPaused(ctx, queue, false)
+}
+
+// IsQueuePaused() reports whether the queue is paused.
+func (s *Server) IsQueuePaused(ctx context.Context, queue string) (bool, error) {
+	if pb, ok := s.brokerFor(queue).(PauseBroker); ok {
+		return pb.IsPaused(ctx, queue)
+	}
+
+	s.pm.Lock()
+	defer s.pm.Unlock()
+	return s.paused[queue], nil
+}
+
+func (s *Server) setPaused(ctx context.Context, queue string, paused bool) error {
+	if pb, ok := s.brokerFor(queue).(PauseBroker); ok {
+		if paused {
+			return pb.PauseQueue(ctx, queue)
+		}
+		return pb.ResumeQueue(ctx, queue)
+	}
+
+	s.pm.Lock()
+	s.paused[queue] = paused
+	s.pm.Unlock()
+	return nil
+}
+
+// isPaused() reports whether the queue is paused, or paused on the server by a circuit breaker, logging errors. The queue is considered running
+// if its state can't be fetched.
+func (s *Server) isPaused(ctx context.Context, queue string) bool {
+	if s.isTripped(queue) {
+		return true
+	}
+
+	paused, err := s.IsQueuePaused(ctx, queue)
+	if err != nil {
+		s.log.Error("error checking whether queue is paused", "queue", queue, "error", err)
+		return false
+	}
+
+	return paused
+}
+
+// isTripped() reports whether the queue is paused on the server by the circuit breaker of one of its tasks.
+func (s *Server) isTripped(queue string) bool {
+	s.pm.Lock()
+	defer s.pm.Unlock()
+
+	until, ok := s.tripped[queue]
+	if !ok {
+		return false
+	}
+	if !s.now().Before(until) {
+		delete(s.tripped, queue)
+		return false
+	}
+
+	return true
+}
+
+// consumeQueue() consumes the queue while it isn't paused. It is a blocking function.
+func (s *Server) consumeQueue(ctx context.Context, work chan []byte, queue, sub string) {
+	tk := time.NewTicker(pausePollInterval)
+	defer tk.Stop()
+
+	for {
+		// Wait while the queue is paused.
+		for s.isPaused(ctx, queue) {
+			select {
+			case <-ctx.Done():
+				return
+			case <-tk.C:
+			}
+		}
+
+		cctx, cancel := context.WithCancel(ctx)
+		done := make(chan struct{})
+		go func() {
+			s.consume(cctx, work, queue, sub)
+			close(done)
+		}()
+
+		// Stop the consumer once the queue is paused.
+		for paused := false; !paused; {
+			select {
+			case <-ctx.Done():
+				cancel()
+				<-done
+				return
+			case <-done:
+				cancel()
+				return
+			case <-tk.C:
+				paused = s.isPaused(ctx, queue)
+			}
+		}
+
+		s.log.Info("queue paused, stopping consumer", "queue", queue)
+		cancel()
+		<-done
+	}
+}
@@ -0,0 +1,80 @@
+package tasqueue
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"testing"
+	"time"
+
+	rb "github.com/kalbhor/tasqueue/v2/brokers/in-memory"
+	rr "github.com/kalbhor/tasqueue/v2/results/in-memory"
+)
+
+func TestPauseQueue(t *testing.T) {
+	var (
+		ctx, cancel = context.WithCancel(context.Background())
+		done        = make(chan struct{}, 1)
+		lo          = slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
+			Level: slog.LevelError,
+		}))
+		opts = ServerOpts{
+			Broker:  rb.New(),
+			Results: rr.New(),
+			Logger:  lo.Handler(),
+		}
+	)
+	defer cancel()
+
+	worker, err := NewServer(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := worker.RegisterTask(taskName, func([]byte, JobCtx) error {
+		done <- struct{}{}
+		return nil
+	}, TaskOpts{}); err != nil {
+		t.Fatal(err)
+	}
+	go worker.Start(ctx)
+
+	// The queue is paused through another server sharing the broker.
+	srv, err := NewServer(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.PauseQueue(ctx, DefaultQueue); err != nil {
+		t.Fatal(err)
+	}
+	if paused, err := worker.IsQueuePaused(ctx, DefaultQueue); err != nil || !paused {
+		t.Fatalf("expected queue to be paused: %v", err)
+	}
+	time.Sleep(pausePollInterval + 100*time.Millisecond)
+
+	// Jobs can be enqueued onto the paused queue, but aren't consumed.
+	id, err := srv.Enqueue(ctx, makeJob(t, taskName, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-done:
+		t.Fatal("job on a paused queue was processed")
+	case <-time.After(pausePollInterval * 2):
+	}
+	msg, err := srv.GetJob(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Status != StatusStarted {
+		t.Fatalf("incorrect job status, expected %s, got %s", StatusStarted, msg.Status)
+	}
+
+	if err := srv.ResumeQueue(ctx, DefaultQueue); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-done:
+	case <-time.After(pausePollInterval * 3):
+		t.Fatal("job was not processed after resuming the queue")
+	}
+}
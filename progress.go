@@ -0,0 +1,91 @@
+package tasqueue
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	progressPrefix = "job:progress:"
+
+	// Minimum interval between the progress updates of a job written to the results store.
+	progressInterval = 500 * time.Millisecond
+)
+
+// Progress is the progress reported by a job's handler through JobCtx.SetProgress().
+type Progress struct {
+	Percent   float64
+	Message   string
+	UpdatedAt time.Time
+}
+
+// progress throttles the progress updates of a job. Updates made within progressInterval
+// of the last write are held and written by the next update or by flush().
+type progress struct {
+	codec   *codec
+	mu      sync.Mutex
+	last    time.Time
+	pending *Progress
+}
+
+// SetProgress() reports the progress of the job, which can be fetched with Server.GetJobProgress().
+// Updates are throttled and the latest one is written once the handler returns, so it can
+// be called as often as needed. Updates with a percent of 100 (or more) are written right away.
+func (c JobCtx) SetProgress(percent float64, msg string) error {
+	if c.progress == nil {
+		return nil
+	}
+
+	p := Progress{Percent: percent, Message: msg, UpdatedAt: time.Now()}
+
+	c.progress.mu.Lock()
+	defer c.progress.mu.Unlock()
+	if percent < 100 && p.UpdatedAt.Sub(c.progress.last) < progressInterval {
+		c.progress.pending = &p
+		return nil
+	}
+
+	c.progress.pending = nil
+	c.progress.last = p.UpdatedAt
+	return c.progress.set(c, c.store, c.Meta.ID, p)
+}
+
+// flushProgress() writes the job's last progress update, if it was held back by the throttle.
+func (s *Server) flushProgress(ctx context.Context, c JobCtx) {
+	c.progress.mu.Lock()
+	defer c.progress.mu.Unlock()
+	if c.progress.pending == nil {
+		return
+	}
+
+	if err := c.progress.set(ctx, s.results, c.Meta.ID, *c.progress.pending); err != nil {
+		s.log.Error("could not set job progress", "id", c.Meta.ID, "error", err)
+	}
+	c.progress.pending = nil
+}
+
+// GetJobProgress() returns the last progress reported by the job. ErrNotFound is returned if the
+// job hasn't reported any progress.
+func (s *Server) GetJobProgress(ctx context.Context, id string) (Progress, error) {
+	b, err := s.getResult(ctx, progressPrefix+id)
+	if err != nil {
+		return Progress{}, err
+	}
+
+	var p Progress
+	if err := s.codec.unmarshal(b, &p); err != nil {
+		return Progress{}, err
+	}
+
+	return p, nil
+}
+
+func (pr *progress) set(ctx context.Context, store Results, id string, p Progress) error {
+	b, err := pr.codec.marshal(p)
+	if err != nil {
+		return err
+	}
+
+	return setMeta(ctx, store, progressPrefix+id, b)
+}
@@ -0,0 +1,55 @@
+package tasqueue
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestJobProgress(t *testing.T) {
+	var (
+		ctx     = context.Background()
+		started = make(chan struct{})
+		resume  = make(chan struct{})
+		srv     = newServer(t, taskName, func(_ []byte, c JobCtx) error {
+			if err := c.SetProgress(10, "started"); err != nil {
+				return err
+			}
+			// Updates within the throttle interval are held back.
+			if err := c.SetProgress(20, "halfway"); err != nil {
+				return err
+			}
+			close(started)
+			<-resume
+			return c.SetProgress(50, "finishing")
+		})
+	)
+	go srv.Start(ctx)
+
+	id, err := srv.Enqueue(ctx, makeJob(t, taskName, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := srv.GetJobProgress(ctx, "missing"); err != ErrNotFound {
+		t.Fatalf("expected %v for a job without progress, got %v", ErrNotFound, err)
+	}
+
+	<-started
+	p, err := srv.GetJobProgress(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Percent != 10 || p.Message != "started" {
+		t.Fatalf("incorrect progress, expected 10 (started), got %v (%s)", p.Percent, p.Message)
+	}
+
+	// The last update is written once the handler returns.
+	close(resume)
+	time.Sleep(100 * time.Millisecond)
+	if p, err = srv.GetJobProgress(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	if p.Percent != 50 || p.Message != "finishing" {
+		t.Fatalf("incorrect progress, expected 50 (finishing), got %v (%s)", p.Percent, p.Message)
+	}
+}
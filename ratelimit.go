@@ -0,0 +1,97 @@
+package tasqueue
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// RateLimit configures a token bucket limiting jobs to `Rate` per second, allowing bursts
+// of up to `Burst` jobs. If Burst is zero, it defaults to 1. A zero Rate disables the limit.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// bucket is an in-process token bucket, used when the broker doesn't implement RateLimitBroker.
+type bucket struct {
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// allow takes a token from the bucket, returning the duration to wait if none is available.
+func (b *bucket) allow(l RateLimit, burst int) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	if b.last.IsZero() {
+		b.tokens = float64(burst)
+	} else {
+		b.tokens = math.Min(float64(burst), b.tokens+now.Sub(b.last).Seconds()*l.Rate)
+	}
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0
+	}
+
+	return time.Duration((1 - b.tokens) / l.Rate * float64(time.Second))
+}
+
+// waitRateLimit blocks until a token is available from the rate limit identified by key.
+func (s *Server) waitRateLimit(ctx context.Context, key string, l RateLimit) error {
+	if l.Rate <= 0 {
+		return nil
+	}
+	burst := l.Burst
+	if burst <= 0 {
+		burst = 1
+	}
+
+	for {
+		var (
+			wait time.Duration
+			err  error
+		)
+		if rb, ok := s.broker.(RateLimitBroker); ok {
+			if wait, err = rb.Allow(ctx, key, l.Rate, burst); err != nil {
+				return err
+			}
+		} else {
+			s.rl.Lock()
+			b, ok := s.buckets[key]
+			if !ok {
+				b = &bucket{}
+				s.buckets[key] = b
+			}
+			s.rl.Unlock()
+			wait = b.allow(l, burst)
+		}
+
+		if wait == 0 {
+			return nil
+		}
+
+		s.log.Debug("rate limited", "key", key, "wait", wait)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// rateLimit waits for the queue's and the task's rate limits, if set.
+func (s *Server) rateLimit(ctx context.Context, queue string, task Task) error {
+	if l, ok := s.queueLimits[queue]; ok {
+		if err := s.waitRateLimit(ctx, "queue:"+queue, l); err != nil {
+			return err
+		}
+	}
+
+	return s.waitRateLimit(ctx, "task:"+task.name, task.opts.RateLimit)
+}
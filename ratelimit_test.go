@@ -0,0 +1,47 @@
+package tasqueue
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTaskRateLimit(t *testing.T) {
+	var (
+		srv  = newServer(t, taskName, MockHandler)
+		ctx  = context.Background()
+		done = make(chan struct{}, 4)
+	)
+
+	if err := srv.RegisterTask("limited", func(b []byte, jc JobCtx) error {
+		done <- struct{}{}
+		return nil
+	}, TaskOpts{
+		Queue:       "limited_task",
+		Concurrency: 4,
+		RateLimit:   RateLimit{Rate: 2, Burst: 1},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	go srv.Start(ctx)
+
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		job, err := NewJob("limited", []byte{}, JobOpts{Queue: "limited_task"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := srv.Enqueue(ctx, job); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < 4; i++ {
+		<-done
+	}
+
+	// The first job is processed right away and the remaining at 2 per second.
+	if el := time.Since(start); el < 1400*time.Millisecond {
+		t.Fatalf("rate limit not enforced, processed 4 jobs in %v", el)
+	}
+}
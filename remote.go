@@ -0,0 +1,147 @@
+package tasqueue
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Maximum size of the body of a job enqueued over the remote API.
+const maxRemoteBody = 10 << 20
+
+// RemoteOpts configures the remote enqueue API mounted by MountRemote().
+type RemoteOpts struct {
+	// Tokens are the bearer tokens accepted in the Authorization header. If empty, the API is
+	// unauthenticated and access to it should be restricted by the caller.
+	Tokens []string
+
+	// OPTIONAL
+	// Tasks restricts the tasks that can be enqueued and queried remotely. If empty, jobs of any task can be enqueued.
+	Tasks []string
+}
+
+// MountRemote registers the remote enqueue API on the mux, under the /tasqueue/remote/ path, so that
+// services without access to the broker (or not written in Go) can enqueue jobs and query their
+// status with the same options as Enqueue(). Jobs are encoded as JSON (Job, with the payload base64
+// encoded). The client package is a Go client for it. The API exposes:
+//
+//	POST /tasqueue/remote/jobs             enqueue a job, returning its id
+//	GET  /tasqueue/remote/jobs/{id}        a job's message
+//	GET  /tasqueue/remote/jobs/{id}/result a job's result
+func (s *Server) MountRemote(mux *http.ServeMux, o RemoteOpts) {
+	mux.Handle("POST /tasqueue/remote/jobs", remoteAuth(o.Tokens, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var job Job
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRemoteBody)).Decode(&job); err != nil {
+			http.Error(w, "invalid job", http.StatusBadRequest)
+			return
+		}
+		if job.Task == "" {
+			http.Error(w, "task missing", http.StatusBadRequest)
+			return
+		}
+		// Payloads can't reference the blob store remotely, as they could read arbitrary keys, and
+		// ids can't overwrite the internal keys of the results store.
+		for _, j := range jobTree(&job) {
+			if j.PayloadRef != "" {
+				http.Error(w, "payload references can't be enqueued remotely", http.StatusBadRequest)
+				return
+			}
+			if j.Opts.ID != "" && !remoteID(j.Opts.ID) {
+				http.Error(w, "invalid job id", http.StatusBadRequest)
+				return
+			}
+		}
+		if len(o.Tasks) > 0 {
+			for _, j := range jobTree(&job) {
+				if !slices.Contains(o.Tasks, j.Task) {
+					http.Error(w, fmt.Sprintf("task %s can't be enqueued remotely", j.Task), http.StatusForbidden)
+					return
+				}
+			}
+		}
+
+		id, err := s.Enqueue(r.Context(), job)
+		writeJSON(w, map[string]string{"id": id}, err)
+	})))
+	mux.Handle("GET /tasqueue/remote/jobs/{id}", remoteAuth(o.Tokens, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		msg, err := s.remoteJob(r.Context(), r.PathValue("id"), o)
+		writeJSON(w, msg, err)
+	})))
+	mux.Handle("GET /tasqueue/remote/jobs/{id}/result", remoteAuth(o.Tokens, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		msg, err := s.remoteJob(r.Context(), r.PathValue("id"), o)
+		if err != nil {
+			writeJSON(w, nil, err)
+			return
+		}
+		b, err := s.GetResult(r.Context(), msg.ID)
+		if err != nil {
+			writeJSON(w, nil, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(b)
+	})))
+}
+
+// remoteID reports whether the id can be a job's id remotely. The internal keys of the results store
+// (eg: the schedules, or the group and chain messages) all contain ":".
+func remoteID(id string) bool {
+	return id != "" && !strings.Contains(id, ":")
+}
+
+// remoteJob returns the message of the job with the id, if it can be read remotely. Ids that aren't
+// job ids, and the jobs of tasks that can't be enqueued remotely (RemoteOpts.Tasks), are not found.
+func (s *Server) remoteJob(ctx context.Context, id string, o RemoteOpts) (JobMessage, error) {
+	if !remoteID(id) {
+		return JobMessage{}, ErrNotFound
+	}
+	msg, err := s.GetJob(ctx, id)
+	if err != nil {
+		return JobMessage{}, err
+	}
+	if msg.Job == nil || msg.ID != id || (len(o.Tasks) > 0 && !slices.Contains(o.Tasks, msg.Job.Task)) {
+		return JobMessage{}, ErrNotFound
+	}
+
+	return msg, nil
+}
+
+// remoteAuth rejects the requests without one of the bearer tokens, if any are set.
+func remoteAuth(tokens []string, next http.Handler) http.Handler {
+	if len(tokens) == 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tok, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if ok {
+			for _, t := range tokens {
+				if subtle.ConstantTimeCompare([]byte(tok), []byte(t)) == 1 {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+		}
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+	})
+}
+
+// jobTree returns the job along with its OnSuccess and OnError jobs, recursively.
+func jobTree(j *Job) []*Job {
+	if j == nil {
+		return nil
+	}
+	out := []*Job{j}
+	for _, c := range j.OnSuccess {
+		out = append(out, jobTree(c)...)
+	}
+	for _, c := range j.OnError {
+		out = append(out, jobTree(c)...)
+	}
+
+	return out
+}
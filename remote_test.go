@@ -0,0 +1,154 @@
+package tasqueue
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMountRemote(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	srv := newServer(t, "echo", func(b []byte, j JobCtx) error {
+		return j.Save(b)
+	})
+	mux := http.NewServeMux()
+	srv.MountRemote(mux, RemoteOpts{Tokens: []string{"secret"}, Tasks: []string{"echo"}})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	go srv.Start(ctx)
+
+	post := func(token string, job Job) (int, []byte) {
+		b, err := json.Marshal(job)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, ts.URL+"/tasqueue/remote/jobs", bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		body, err := io.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.StatusCode, body
+	}
+
+	job, err := NewJob("echo", []byte("pong"), JobOpts{UniqueKey: "echo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, token := range []string{"", "invalid"} {
+		if code, _ := post(token, job); code != http.StatusUnauthorized {
+			t.Fatalf("expected unauthorized with token %q, got %d", token, code)
+		}
+	}
+	other, err := NewJob(taskName, nil, JobOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code, _ := post("secret", Job{Task: "echo", OnSuccess: []*Job{&other}}); code != http.StatusForbidden {
+		t.Fatalf("expected forbidden for a disallowed task, got %d", code)
+	}
+
+	code, body := post("secret", job)
+	if code != http.StatusOK {
+		t.Fatalf("unexpected status code enqueuing job: %d %s", code, body)
+	}
+	var res struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(body, &res); err != nil {
+		t.Fatal(err)
+	}
+
+	// The job's options are kept. Results are polled until the job is processed.
+	for {
+		msg, err := srv.GetJob(ctx, res.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg.Status == StatusDone {
+			break
+		}
+		select {
+		case <-time.After(20 * time.Millisecond):
+		case <-ctx.Done():
+			t.Fatalf("the job wasn't processed, status %s", msg.Status)
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+"/tasqueue/remote/jobs/"+res.ID+"/result", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer secret")
+	r, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.StatusCode != http.StatusOK || string(b) != "pong" {
+		t.Fatalf("incorrect result, expected pong, got %d %q", r.StatusCode, b)
+	}
+
+	get := func(path string) int {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer secret")
+		r, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Body.Close()
+		return r.StatusCode
+	}
+
+	// The internal keys of the results store can't be read as jobs.
+	sch, err := NewSchedule("@every 1h", job, ScheduleOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := srv.RegisterSchedule(ctx, sch); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/tasqueue/remote/jobs/" + schedulesKey, "/tasqueue/remote/jobs/" + schedulesKey + "/result"} {
+		if code := get(path); code != http.StatusNotFound {
+			t.Fatalf("expected not found reading %s, got %d", path, code)
+		}
+	}
+
+	// Nor can the jobs of the tasks that can't be enqueued remotely.
+	if err := srv.RegisterTask(taskName, MockHandler, TaskOpts{}); err != nil {
+		t.Fatal(err)
+	}
+	id, err := srv.Enqueue(ctx, other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/tasqueue/remote/jobs/" + id, "/tasqueue/remote/jobs/" + id + "/result"} {
+		if code := get(path); code != http.StatusNotFound {
+			t.Fatalf("expected not found reading %s, got %d", path, code)
+		}
+	}
+}
@@ -3,7 +3,12 @@ package inmemory
 import (
 	"context"
 	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 )
 
 type Results struct {
@@ -11,6 +16,23 @@ type Results struct {
 	store   map[string][]byte
 	failed  map[string]struct{}
 	success map[string]struct{}
+	unique  map[string]uniqueKey
+	index   map[string]indexEntry
+
+	// subs holds the channels of the subscribers of each id.
+	subs map[string][]chan []byte
+}
+
+// indexEntry is a job indexed by IndexJob.
+type indexEntry struct {
+	state, queue, task string
+	at                 time.Time
+}
+
+// uniqueKey is a unique key held by a job.
+type uniqueKey struct {
+	id        string
+	expiresAt time.Time
 }
 
 func New() *Results {
@@ -18,6 +40,9 @@ func New() *Results {
 		store:   make(map[string][]byte),
 		failed:  make(map[string]struct{}),
 		success: make(map[string]struct{}),
+		unique:  make(map[string]uniqueKey),
+		index:   make(map[string]indexEntry),
+		subs:    make(map[string][]chan []byte),
 	}
 }
 
@@ -34,6 +59,21 @@ func (r *Results) Get(ctx context.Context, id string) ([]byte, error) {
 	return v, nil
 }
 
+// GetMulti returns the results of the ids that exist.
+func (r *Results) GetMulti(ctx context.Context, ids []string) (map[string][]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	out := make(map[string][]byte, len(ids))
+	for _, id := range ids {
+		if v, ok := r.store[id]; ok {
+			out[id] = v
+		}
+	}
+
+	return out, nil
+}
+
 func (r *Results) NilError() error {
 	return errNotFound
 }
@@ -43,6 +83,7 @@ func (r *Results) DeleteJob(ctx context.Context, id string) error {
 	delete(r.store, id)
 	delete(r.failed, id)
 	delete(r.success, id)
+	delete(r.index, id)
 	r.mu.Unlock()
 
 	return nil
@@ -51,11 +92,73 @@ func (r *Results) DeleteJob(ctx context.Context, id string) error {
 func (r *Results) Set(ctx context.Context, id string, b []byte) error {
 	r.mu.Lock()
 	r.store[id] = b
+	r.notify(id, b)
 	r.mu.Unlock()
 
 	return nil
 }
 
+func (r *Results) SetBatch(ctx context.Context, items map[string][]byte) error {
+	r.mu.Lock()
+	for id, b := range items {
+		r.store[id] = b
+		r.notify(id, b)
+	}
+	r.mu.Unlock()
+
+	return nil
+}
+
+// Subscribe returns a channel receiving the value of the id each time it is set, starting with its
+// current value. A subscriber that falls behind only receives the latest value.
+func (r *Results) Subscribe(ctx context.Context, id string) (<-chan []byte, error) {
+	ch := make(chan []byte, 1)
+
+	r.mu.Lock()
+	if b, ok := r.store[id]; ok {
+		ch <- b
+	}
+	r.subs[id] = append(r.subs[id], ch)
+	r.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		subs := r.subs[id]
+		for i, c := range subs {
+			if c == ch {
+				subs = append(subs[:i], subs[i+1:]...)
+				break
+			}
+		}
+		if len(subs) == 0 {
+			delete(r.subs, id)
+		} else {
+			r.subs[id] = subs
+		}
+		close(ch)
+	}()
+
+	return ch, nil
+}
+
+// notify sends the value to the subscribers of the id, replacing any value they haven't received yet.
+// It is called with the lock held.
+func (r *Results) notify(id string, b []byte) {
+	for _, ch := range r.subs[id] {
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- b
+	}
+}
+
+// SetNamespace is a no-op, as the results store isn't shared outside of its process.
+func (r *Results) SetNamespace(ns string) {}
+
 func (r *Results) SetSuccess(_ context.Context, id string) error {
 	r.mu.Lock()
 	r.success[id] = struct{}{}
@@ -93,3 +196,101 @@ func (r *Results) GetFailed(_ context.Context) ([]string, error) {
 
 	return fl, nil
 }
+
+func (r *Results) SetUnique(_ context.Context, key, id string, ttl time.Duration) (string, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if u, ok := r.unique[key]; ok && (u.expiresAt.IsZero() || time.Now().Before(u.expiresAt)) {
+		return u.id, false, nil
+	}
+
+	u := uniqueKey{id: id}
+	if ttl > 0 {
+		u.expiresAt = time.Now().Add(ttl)
+	}
+	r.unique[key] = u
+
+	return id, true, nil
+}
+
+func (r *Results) DeleteUnique(_ context.Context, key, id string) error {
+	r.mu.Lock()
+	if u, ok := r.unique[key]; ok && u.id == id {
+		delete(r.unique, key)
+	}
+	r.mu.Unlock()
+
+	return nil
+}
+
+func (r *Results) IndexJob(_ context.Context, id, state, queue, task string, at time.Time) error {
+	r.mu.Lock()
+	r.index[id] = indexEntry{state: state, queue: queue, task: task, at: at}
+	r.mu.Unlock()
+
+	return nil
+}
+
+func (r *Results) ListJobs(_ context.Context, state, queue string, limit int, cursor string,
+	task string, from, to time.Time) ([]string, []time.Time, string, error) {
+	// The cursor is the time and id of the last job of the previous page.
+	var (
+		afterAt time.Time
+		afterID string
+	)
+	if cursor != "" {
+		n, id, ok := strings.Cut(cursor, ":")
+		ns, err := strconv.ParseInt(n, 10, 64)
+		if !ok || err != nil {
+			return nil, nil, "", fmt.Errorf("invalid cursor %s", cursor)
+		}
+		afterAt, afterID = time.Unix(0, ns), id
+	}
+
+	type job struct {
+		id string
+		at time.Time
+	}
+	var jobs []job
+	r.mu.Lock()
+	for id, e := range r.index {
+		switch {
+		case e.state != state,
+			queue != "" && e.queue != queue,
+			task != "" && e.task != task,
+			!from.IsZero() && e.at.Before(from),
+			!to.IsZero() && !e.at.Before(to):
+			continue
+		}
+		if cursor != "" && (e.at.After(afterAt) || e.at.Equal(afterAt) && id >= afterID) {
+			continue
+		}
+		jobs = append(jobs, job{id: id, at: e.at})
+	}
+	r.mu.Unlock()
+
+	sort.Slice(jobs, func(i, j int) bool {
+		if !jobs[i].at.Equal(jobs[j].at) {
+			return jobs[i].at.After(jobs[j].at)
+		}
+		return jobs[i].id > jobs[j].id
+	})
+
+	var next string
+	if len(jobs) > limit {
+		jobs = jobs[:limit]
+		last := jobs[limit-1]
+		next = strconv.FormatInt(last.at.UnixNano(), 10) + ":" + last.id
+	}
+
+	var (
+		ids = make([]string, len(jobs))
+		ats = make([]time.Time, len(jobs))
+	)
+	for i, j := range jobs {
+		ids[i], ats[i] = j.id, j.at
+	}
+
+	return ids, ats, next, nil
+}
@@ -0,0 +1,214 @@
+// Package mongodb is a results store keeping the results and the success/failed status of jobs on a
+// single MongoDB collection, expired with a TTL index.
+package mongodb
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+const (
+	DefaultDatabase   = "tasqueue"
+	DefaultCollection = "tq_results"
+
+	// Values of the status field for success/failed job ids
+	success = "success"
+	failed  = "failed"
+)
+
+// never is the expiry of the fields that don't expire. MongoDB's TTL index expires a document once
+// the earliest date of its indexed field passes, so a document holding a result and a status that
+// expire at different times is expired at the later of the two, and never if either one doesn't expire.
+var never = time.Date(9999, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// A document holds the result and the status of a job, either of which may be missing:
+//
+//	{_id, data, data_expires_at, status, status_at, status_expires_at, expires_at}
+//
+// The data and status are ignored once past their own expiry, until the document is expired.
+type Results struct {
+	opts Options
+	lo   *slog.Logger
+	conn *mongo.Client
+	coll *mongo.Collection
+}
+
+type Options struct {
+	// URI is the MongoDB connection string, eg: "mongodb://localhost:27017"
+	URI string
+
+	// OPTIONAL
+	// Database and Collection the results are stored on. Default to `DefaultDatabase` and `DefaultCollection`.
+	Database   string
+	Collection string
+
+	// Expiry is the duration results are kept for. If zero, results don't expire.
+	Expiry time.Duration
+	// MetaExpiry is the duration success/failed job ids are kept for. If zero, they don't expire.
+	MetaExpiry time.Duration
+}
+
+// New() returns a new instance of the MongoDB results store. It creates the required indexes if they don't exist.
+func New(o Options, lo *slog.Logger) (*Results, error) {
+	if o.Database == "" {
+		o.Database = DefaultDatabase
+	}
+	if o.Collection == "" {
+		o.Collection = DefaultCollection
+	}
+
+	conn, err := mongo.Connect(options.Client().ApplyURI(o.URI))
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to mongodb : %w", err)
+	}
+	coll := conn.Database(o.Database).Collection(o.Collection)
+
+	// TODO: pass ctx here somehow
+	if _, err := coll.Indexes().CreateMany(context.TODO(), []mongo.IndexModel{
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "status_at", Value: -1}}},
+	}); err != nil {
+		conn.Disconnect(context.TODO())
+		return nil, fmt.Errorf("error creating mongodb indexes : %w", err)
+	}
+
+	return &Results{
+		opts: o,
+		lo:   lo,
+		conn: conn,
+		coll: coll,
+	}, nil
+}
+
+func (r *Results) Get(ctx context.Context, id string) ([]byte, error) {
+	r.lo.Debug("getting result for job", "id", id)
+
+	var doc struct {
+		Data []byte `bson:"data"`
+	}
+	if err := r.coll.FindOne(ctx, bson.M{"_id": id, "data_expires_at": bson.M{"$gt": time.Now()}},
+		options.FindOne().SetProjection(bson.M{"data": 1})).Decode(&doc); err != nil {
+		return nil, err
+	}
+
+	return doc.Data, nil
+}
+
+// GetMulti returns the results of the ids that exist, in a single query.
+func (r *Results) GetMulti(ctx context.Context, ids []string) (map[string][]byte, error) {
+	r.lo.Debug("getting results for jobs", "count", len(ids))
+
+	cur, err := r.coll.Find(ctx, bson.M{"_id": bson.M{"$in": ids}, "data_expires_at": bson.M{"$gt": time.Now()}},
+		options.Find().SetProjection(bson.M{"data": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	out := make(map[string][]byte, len(ids))
+	for cur.Next(ctx) {
+		var doc struct {
+			ID   string `bson:"_id"`
+			Data []byte `bson:"data"`
+		}
+		if err := cur.Decode(&doc); err != nil {
+			return nil, err
+		}
+		out[doc.ID] = doc.Data
+	}
+
+	return out, cur.Err()
+}
+
+func (r *Results) NilError() error {
+	return mongo.ErrNoDocuments
+}
+
+func (r *Results) Set(ctx context.Context, id string, b []byte) error {
+	r.lo.Debug("setting result for job", "id", id)
+
+	exp := expiry(r.opts.Expiry)
+	return r.upsert(ctx, id, bson.M{"data": b, "data_expires_at": exp}, exp)
+}
+
+func (r *Results) DeleteJob(ctx context.Context, id string) error {
+	r.lo.Debug("deleting job", "id", id)
+
+	_, err := r.coll.DeleteOne(ctx, bson.M{"_id": id})
+	return err
+}
+
+func (r *Results) GetSuccess(ctx context.Context) ([]string, error) {
+	r.lo.Debug("getting successful jobs")
+	return r.getStatus(ctx, success)
+}
+
+func (r *Results) GetFailed(ctx context.Context) ([]string, error) {
+	r.lo.Debug("getting failed jobs")
+	return r.getStatus(ctx, failed)
+}
+
+func (r *Results) SetSuccess(ctx context.Context, id string) error {
+	r.lo.Debug("setting job as successful", "id", id)
+	return r.setStatus(ctx, id, success)
+}
+
+func (r *Results) SetFailed(ctx context.Context, id string) error {
+	r.lo.Debug("setting job as failed", "id", id)
+	return r.setStatus(ctx, id, failed)
+}
+
+// Close disconnects from MongoDB.
+func (r *Results) Close() error {
+	return r.conn.Disconnect(context.Background())
+}
+
+// getStatus returns the ids of jobs with the status, the most recently updated first.
+func (r *Results) getStatus(ctx context.Context, status string) ([]string, error) {
+	cur, err := r.coll.Find(ctx, bson.M{"status": status, "status_expires_at": bson.M{"$gt": time.Now()}},
+		options.Find().SetProjection(bson.M{"_id": 1}).SetSort(bson.D{{Key: "status_at", Value: -1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var ids []string
+	for cur.Next(ctx) {
+		var doc struct {
+			ID string `bson:"_id"`
+		}
+		if err := cur.Decode(&doc); err != nil {
+			return nil, err
+		}
+		ids = append(ids, doc.ID)
+	}
+
+	return ids, cur.Err()
+}
+
+func (r *Results) setStatus(ctx context.Context, id, status string) error {
+	exp := expiry(r.opts.MetaExpiry)
+	return r.upsert(ctx, id, bson.M{"status": status, "status_at": time.Now(), "status_expires_at": exp}, exp)
+}
+
+// upsert sets the fields of the job's document, extending its expiry to exp if it is later.
+func (r *Results) upsert(ctx context.Context, id string, fields bson.M, exp time.Time) error {
+	_, err := r.coll.UpdateOne(ctx, bson.M{"_id": id},
+		bson.M{"$set": fields, "$max": bson.M{"expires_at": exp}}, options.UpdateOne().SetUpsert(true))
+	return err
+}
+
+// expiry returns the time a field set now expires at, or never if d is zero.
+func expiry(d time.Duration) time.Time {
+	if d == 0 {
+		return never
+	}
+
+	return time.Now().Add(d)
+}
@@ -17,6 +17,9 @@ type Results struct {
 	opt  Options
 	lo   *slog.Logger
 	conn nats.KeyValue
+
+	// prefix of the keys, which includes the namespace if set with SetNamespace().
+	prefix string
 }
 
 type Options struct {
@@ -54,11 +57,19 @@ func New(cfg Options, lo *slog.Logger) (*Results, error) {
 		opt:  cfg,
 		lo:   lo,
 		conn: kv,
+
+		prefix: resultPrefix,
 	}, nil
 }
 
+// SetNamespace prefixes the keys with the namespace ("<ns>-tasqueue-results-"), so that multiple
+// applications can share the key/value bucket. It is called by the server with ServerOpts.Namespace.
+func (r *Results) SetNamespace(ns string) {
+	r.prefix = ns + "-" + resultPrefix
+}
+
 func (r *Results) Get(_ context.Context, id string) ([]byte, error) {
-	rs, err := r.conn.Get(resultPrefix + id)
+	rs, err := r.conn.Get(r.prefix + id)
 	if err != nil {
 		return nil, err
 	}
@@ -71,11 +82,49 @@ func (r *Results) NilError() error {
 }
 
 func (r *Results) Set(_ context.Context, id string, b []byte) error {
-	if _, err := r.conn.Put(resultPrefix+id, b); err != nil {
+	if _, err := r.conn.Put(r.prefix+id, b); err != nil {
 		return err
 	}
 	return nil
 }
+
+// Subscribe watches the id on the key/value bucket, returning a channel receiving its value each
+// time it is set, starting with its current value.
+func (r *Results) Subscribe(ctx context.Context, id string) (<-chan []byte, error) {
+	w, err := r.conn.Watch(r.prefix+id, nats.Context(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("error watching key : %w", err)
+	}
+
+	ch := make(chan []byte)
+	go func() {
+		defer close(ch)
+		defer w.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case e, ok := <-w.Updates():
+				if !ok {
+					return
+				}
+				// A nil entry marks the end of the current values, deletes are skipped.
+				if e == nil || e.Operation() != nats.KeyValuePut {
+					continue
+				}
+				select {
+				case ch <- e.Value():
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return ch, nil
+}
+
 func (r *Results) SetSuccess(_ context.Context, id string) error {
 	return fmt.Errorf("method not implemented")
 }
@@ -93,5 +142,5 @@ func (r *Results) GetFailed(_ context.Context) ([]string, error) {
 }
 
 func (r *Results) DeleteJob(_ context.Context, id string) error {
-	return r.conn.Delete(resultPrefix + id)
+	return r.conn.Delete(r.prefix + id)
 }
@@ -2,10 +2,19 @@ package redis
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log/slog"
+	"net"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/google/uuid"
+	"github.com/kalbhor/tasqueue/v2/internal/redispipe"
 	"github.com/redis/go-redis/v9"
 )
 
@@ -15,13 +24,219 @@ const (
 	// Suffix for hashmaps storing success/failed job ids
 	success = "success"
 	failed  = "failed"
+
+	// Suffix for the sorted set indexing successful job ids by a custom weight
+	successByWeight = success + ":byweight"
+
+	// Prefix (after resultPrefix) for per-job write locks
+	lockPrefix = "lock:"
+
+	// Prefix (after resultPrefix) for markers recording the consumer of a result
+	consumedPrefix = "consumed:"
+
+	// Prefix (after resultPrefix) for the unique keys of jobs
+	uniquePrefix = "unique:"
+
+	// Number of keys scanned and migrated per round trip by MigrateLegacy
+	migrateBatch = 100
+
+	// Prefix (after resultPrefix) for the sorted sets indexing the jobs listed by ListJobs, the
+	// hashmap of the indexed jobs (id -> index entry) and the set of the indexed states.
+	indexPrefix    = "index:"
+	indexedKey     = "indexed"
+	indexStatesKey = "indexed:states"
+
+	// Number of jobs unindexed per round trip on expiry
+	unindexBatch = 1000
+)
+
+// DefaultLockTTL is the TTL of the per-job write locks acquired by SetWithLock, if none is passed.
+const DefaultLockTTL = 5 * time.Second
+
+// indexLib holds the functions shared by the index scripts. A job is indexed in the sorted sets of
+// all the jobs in its state, by its queue, by its task and by both, which are derived from its
+// entry ("state:len(queue):queue:task") stored in the indexed hashmap.
+const indexLib = `
+local function indexKeys(prefix, entry)
+	local state, n, rest = string.match(entry, "^([^:]*):(%d+):(.*)$")
+	n = tonumber(n)
+	local queue, task = string.sub(rest, 1, n), string.sub(rest, n + 2)
+	local function key(q, t)
+		return prefix .. state .. ":" .. string.len(q) .. ":" .. q .. ":" .. t
+	end
+	return {key("", ""), key(queue, ""), key("", task), key(queue, task)}
+end
+
+local function unindex(prefix, hash, id)
+	local old = redis.call("HGET", hash, id)
+	if old then
+		for _, k in ipairs(indexKeys(prefix, old)) do
+			redis.call("ZREM", k, id)
+		end
+		redis.call("HDEL", hash, id)
+	end
+end
+`
+
+// indexScript indexes the job ARGV[2] with the entry ARGV[3] and score ARGV[5], removing its previous entry.
+var indexScript = redis.NewScript(indexLib + `
+unindex(ARGV[1], KEYS[1], ARGV[2])
+for _, k in ipairs(indexKeys(ARGV[1], ARGV[3])) do
+	redis.call("ZADD", k, ARGV[5], ARGV[2])
+end
+redis.call("HSET", KEYS[1], ARGV[2], ARGV[3])
+redis.call("SADD", KEYS[2], ARGV[4])
+return 1
+`)
+
+// unindexScript removes the jobs (ARGV[2:]) from the indexes.
+var unindexScript = redis.NewScript(indexLib + `
+for i = 2, #ARGV do
+	unindex(ARGV[1], KEYS[1], ARGV[i])
+end
+return 1
+`)
+
+// setCollisionScript sets the result, returning the previous value and whether it was written.
+// If ARGV[3] is "1", a different existing value is not overwritten.
+var setCollisionScript = redis.NewScript(`
+local old = redis.call("GET", KEYS[1])
+if old and old ~= ARGV[1] and ARGV[3] == "1" then
+	return {old, 0}
+end
+if tonumber(ARGV[2]) > 0 then
+	redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2])
+else
+	redis.call("SET", KEYS[1], ARGV[1])
+end
+return {old, 1}
+`)
+
+// consumeScript returns the result and records ARGV[1] as its consumer if it hasn't been consumed yet.
+// The marker expires along with the result. The second return value is 1 if the caller won.
+var consumeScript = redis.NewScript(`
+local v = redis.call("GET", KEYS[1])
+if not v then
+	return false
+end
+if not redis.call("SET", KEYS[2], ARGV[1], "NX") then
+	return {v, 0}
+end
+local ttl = redis.call("PTTL", KEYS[1])
+if ttl > 0 then
+	redis.call("PEXPIRE", KEYS[2], ttl)
+end
+return {v, 1}
+`)
+
+// completeGroupScript writes the aggregate result and marks the group (ARGV[4]) successful,
+// only if all the member ids (ARGV[5:]) are in the success set. It returns 1 if the group was completed.
+var completeGroupScript = redis.NewScript(`
+for i = 5, #ARGV do
+	if not redis.call("ZSCORE", KEYS[1], ARGV[i]) then
+		return 0
+	end
+end
+if tonumber(ARGV[2]) > 0 then
+	redis.call("SET", KEYS[2], ARGV[1], "PX", ARGV[2])
+else
+	redis.call("SET", KEYS[2], ARGV[1])
+end
+redis.call("ZADD", KEYS[1], ARGV[3], ARGV[4])
+return 1
+`)
+
+// setUniqueScript sets the key to ARGV[1] if it doesn't exist, expiring it after ARGV[2] ms (if non-zero).
+// It returns 1 if the key was set, otherwise 0 along with the existing value.
+var setUniqueScript = redis.NewScript(`
+local ok
+if tonumber(ARGV[2]) > 0 then
+	ok = redis.call("SET", KEYS[1], ARGV[1], "NX", "PX", ARGV[2])
+else
+	ok = redis.call("SET", KEYS[1], ARGV[1], "NX")
+end
+if ok then
+	return {1, ARGV[1]}
+end
+return {0, redis.call("GET", KEYS[1])}
+`)
+
+// unlockScript deletes the lock key only if it still holds the caller's token,
+// so that a lock which expired and was re-acquired by another writer isn't released.
+var unlockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+var (
+	// ErrResultTooLarge is returned by Set when the result exceeds Options.MaxResultBytes.
+	ErrResultTooLarge = errors.New("result exceeds maximum allowed size")
+
+	// ErrResultCollision is returned by Set when Options.RejectCollisions is set and
+	// a different result already exists for the job.
+	ErrResultCollision = errors.New("a different result already exists")
 )
 
+// metaPrefixes are the prefixes of the metadata stored by the server (jobs, groups, chains, workflows,
+// schedules, job progress, cancellations, debounced payloads, offloaded payloads and locks). These are
+// updated often and are excluded from collision detection. The server sets its metadata with SetMeta,
+// the prefixes cover the metadata set with Set and SetBatch.
+var metaPrefixes = []string{"job:msg:", "group:msg:", "chain:msg:", "workflow:msg:", "schedule:", "job:progress:",
+	"job:cancel:", "job:debounce:", "payload:", "group:complete:", "workflow:step:", "job:stuck:"}
+
 type Results struct {
 	opts Options
 	lo   *slog.Logger
 	conn redis.UniversalClient
-	pipe redis.Pipeliner
+	pipe *redispipe.Pipe
+
+	// pfx is the prefix of the keys, which includes the namespace if set with SetNamespace().
+	pfx atomic.Pointer[string]
+
+	// stop stops the background goroutines, wg waits for them to finish.
+	stop context.CancelFunc
+	wg   sync.WaitGroup
+
+	sm    sync.Mutex
+	stats pipeCounters
+
+	cm     sync.Mutex
+	counts map[string]cachedCount
+}
+
+// cachedCount is the last known size of a success/failed set.
+type cachedCount struct {
+	n  int64
+	at time.Time
+}
+
+// PipeStats contains statistics of the redis pipe flushes, useful for tuning `PipePeriod`.
+type PipeStats struct {
+	Flushes int64
+
+	// Number of commands per flush.
+	AvgCommands float64
+	MaxCommands int
+
+	// Approximate size (of the command arguments) per flush.
+	AvgBytes float64
+	MaxBytes int
+
+	// Average time between consecutive flushes.
+	AvgInterval time.Duration
+}
+
+// pipeCounters are the running counters PipeStats are derived from.
+type pipeCounters struct {
+	flushes   int64
+	commands  int64
+	maxCmds   int
+	bytes     int64
+	maxBytes  int
+	lastFlush time.Time
+	gaps      time.Duration
 }
 
 type Options struct {
@@ -37,9 +252,85 @@ type Options struct {
 	MinIdleConns int
 
 	// OPTIONAL
-	// If non-zero, enqueue redis commands will be piped instead of being directly sent each time.
-	// The pipe will be executed every `PipePeriod` duration.
-	PipePeriod time.Duration
+	// Connection pool tuning. Zero values fall back to the go-redis defaults.
+	PoolSize    int
+	MaxRetries  int
+	PoolTimeout time.Duration
+
+	// OPTIONAL
+	// MasterName is the name of the master monitored by redis Sentinel, in which case `Addrs` are
+	// the addresses of the sentinels. SentinelPassword is the password of the sentinels, if any.
+	MasterName       string
+	SentinelPassword string
+
+	// OPTIONAL
+	// If true, the keys are wrapped in a hash tag ("{tq}:res:") so that they all land on the same slot
+	// of a redis cluster, as the multi-key operations (eg: DeleteJob, the indexes and the group scripts)
+	// span the success/failed sets and the job keys. It changes the key names, so existing results can
+	// be moved with MigrateLegacy("tq:res:"), before spreading them across the slots of a cluster.
+	HashTags bool
+
+	// OPTIONAL
+	// If non-zero, results larger than `MaxResultBytes` are rejected with ErrResultTooLarge
+	// instead of being sent to redis.
+	MaxResultBytes int
+
+	// OPTIONAL
+	// If true, Set compares the result with any existing result of the job and calls `OnCollision`
+	// if they differ. If `RejectCollisions` is also set, the existing result is kept and Set returns
+	// ErrResultCollision. Job metadata stored by the server is excluded from the check.
+	// Results checked for collisions are written directly to redis, bypassing the pipe.
+	DetectCollisions bool
+	RejectCollisions bool
+	OnCollision      func(id string, old, new []byte)
+
+	// OPTIONAL
+	// If non-zero, the counts returned by CountSuccess/CountFailed are cached for `CountCacheTTL`.
+	// If refreshing a stale count times out, the last cached count is returned (flagged as stale).
+	CountCacheTTL time.Duration
+
+	// OPTIONAL
+	// LogAttrs are attached to every log line emitted by the results store.
+	LogAttrs []slog.Attr
+
+	// OPTIONAL
+	// Retention limits the results kept for finished jobs. It is enforced in the background
+	// every `Retention.Interval` and on demand through ApplyRetention().
+	Retention RetentionPolicy
+
+	// OPTIONAL
+	// If non-zero, redis commands will be piped instead of being directly sent each time. The pipe will
+	// be executed every `PipePeriod` duration, or once it holds `PipeMaxCommands` commands if set. Calls
+	// wait for the pipe to be executed and return the errors of their commands.
+	PipePeriod      time.Duration
+	PipeMaxCommands int
+}
+
+// RetentionPolicy limits the number, total size and age of the results of finished
+// (successful or failed) jobs. A zero value disables the respective limit.
+type RetentionPolicy struct {
+	// MaxAge is the maximum duration a finished job's result is kept for.
+	MaxAge time.Duration
+	// MaxCount is the maximum number of jobs kept in each of the success/failed sets.
+	MaxCount int64
+	// MaxTotalBytes is the maximum combined size of the results of finished jobs.
+	// The oldest results are removed first.
+	MaxTotalBytes int64
+
+	// Interval at which the policy is enforced in the background.
+	// If zero, the policy is only applied through ApplyRetention().
+	Interval time.Duration
+}
+
+// RetentionReport describes the jobs removed while applying a RetentionPolicy.
+type RetentionReport struct {
+	// Number of jobs removed for exceeding MaxAge, MaxCount and MaxTotalBytes respectively.
+	Expired int
+	Trimmed int
+	Evicted int
+
+	// Bytes is the combined size of the removed results.
+	Bytes int64
 }
 
 func DefaultRedis() Options {
@@ -51,69 +342,168 @@ func DefaultRedis() Options {
 }
 
 func New(o Options, lo *slog.Logger) *Results {
+	if len(o.LogAttrs) > 0 {
+		attrs := make([]any, len(o.LogAttrs))
+		for i, a := range o.LogAttrs {
+			attrs[i] = a
+		}
+		lo = lo.With(attrs...)
+	}
+
 	rs := &Results{
 		opts: o,
 		conn: redis.NewUniversalClient(
 			&redis.UniversalOptions{
-				Addrs:           o.Addrs,
-				Password:        o.Password,
-				DB:              o.DB,
-				DialTimeout:     o.DialTimeout,
-				ReadTimeout:     o.ReadTimeout,
-				WriteTimeout:    o.WriteTimeout,
-				ConnMaxIdleTime: o.IdleTimeout,
-				MinIdleConns:    o.MinIdleConns,
+				Addrs:            o.Addrs,
+				Password:         o.Password,
+				DB:               o.DB,
+				DialTimeout:      o.DialTimeout,
+				ReadTimeout:      o.ReadTimeout,
+				WriteTimeout:     o.WriteTimeout,
+				ConnMaxIdleTime:  o.IdleTimeout,
+				MinIdleConns:     o.MinIdleConns,
+				PoolSize:         o.PoolSize,
+				MaxRetries:       o.MaxRetries,
+				PoolTimeout:      o.PoolTimeout,
+				MasterName:       o.MasterName,
+				SentinelPassword: o.SentinelPassword,
 			},
 		),
-		lo: lo,
+		lo:     lo,
+		counts: make(map[string]cachedCount),
 	}
 
-	// TODO: pass ctx here somehow
+	pfx := rs.keyPrefix("")
+	rs.pfx.Store(&pfx)
+
+	// The background goroutines run until Close() is called.
+	ctx, cancel := context.WithCancel(context.Background())
+	rs.stop = cancel
 	if o.MetaExpiry != 0 {
-		go rs.expireMeta(o.MetaExpiry)
+		rs.wg.Add(1)
+		go rs.expireMeta(ctx, o.MetaExpiry)
+	}
+	if o.Retention.Interval != 0 {
+		rs.wg.Add(1)
+		go rs.enforceRetention(ctx, o.Retention.Interval)
 	}
 	if o.PipePeriod != 0 {
-		rs.pipe = rs.conn.Pipeline()
-		go rs.execPipe(context.TODO())
+		rs.pipe = redispipe.New(rs.conn, o.PipeMaxCommands)
+		rs.wg.Add(1)
+		go func() {
+			rs.pipe.Run(ctx, o.PipePeriod, rs.pipeFlushed)
+			rs.wg.Done()
+		}()
 	}
 
 	return rs
 }
 
-func (r *Results) execPipe(ctx context.Context) {
-	tk := time.NewTicker(r.opts.PipePeriod)
-	for {
-		select {
-		case <-ctx.Done():
-			r.lo.Debug("context closed, draining redis pipe", "length", r.pipe.Len())
-			if _, err := r.pipe.Exec(ctx); err != nil {
-				r.lo.Error("could not execute redis pipe", "error", err)
-			}
-			return
-		case <-tk.C:
-			plen := r.pipe.Len()
-			if plen == 0 {
-				continue
-			}
-			r.lo.Debug("submitting redis pipe", "length", plen)
-			if _, err := r.pipe.Exec(ctx); err != nil {
-				r.lo.Error("could not execute redis pipe", "error", err)
+// SetNamespace prefixes the keys with the namespace ("tq:<ns>:res:", or "{tq:<ns>}:res:" with HashTags),
+// so that multiple applications can share a redis instance. It is called by the server with
+// ServerOpts.Namespace. Results stored without a namespace can be moved onto it with MigrateLegacy("tq:res:").
+func (r *Results) SetNamespace(ns string) {
+	pfx := r.keyPrefix(ns)
+	r.pfx.Store(&pfx)
+}
+
+// keyPrefix returns the prefix of the keys in the namespace, if any.
+func (r *Results) keyPrefix(ns string) string {
+	tag := strings.TrimSuffix(resultPrefix, ":res:")
+	if ns != "" {
+		tag += ":" + ns
+	}
+	if r.opts.HashTags {
+		tag = "{" + tag + "}"
+	}
+
+	return tag + ":res:"
+}
+
+func (r *Results) prefix() string {
+	return *r.pfx.Load()
+}
+
+// pipeFlushed records the execution of the pipe in the pipe stats.
+func (r *Results) pipeFlushed(cmds []redis.Cmder, err error) {
+	if err != nil {
+		r.lo.Error("could not execute redis pipe", "error", err)
+	}
+	if len(cmds) == 0 {
+		return
+	}
+	r.lo.Debug("submitted redis pipe", "length", len(cmds))
+
+	var size int
+	for _, c := range cmds {
+		for _, a := range c.Args() {
+			switch v := a.(type) {
+			case string:
+				size += len(v)
+			case []byte:
+				size += len(v)
+			default:
+				size += len(fmt.Sprint(v))
 			}
 		}
 	}
+
+	now := time.Now()
+	r.sm.Lock()
+	st := &r.stats
+	if !st.lastFlush.IsZero() {
+		st.gaps += now.Sub(st.lastFlush)
+	}
+	st.lastFlush = now
+	st.flushes++
+	st.commands += int64(len(cmds))
+	st.bytes += int64(size)
+	st.maxCmds = max(st.maxCmds, len(cmds))
+	st.maxBytes = max(st.maxBytes, size)
+	r.sm.Unlock()
+}
+
+// PipeStats returns statistics of the flushes of the redis pipe (when `PipePeriod` is set).
+func (r *Results) PipeStats() PipeStats {
+	r.sm.Lock()
+	defer r.sm.Unlock()
+
+	st := r.stats
+	if st.flushes == 0 {
+		return PipeStats{}
+	}
+
+	ps := PipeStats{
+		Flushes:     st.flushes,
+		AvgCommands: float64(st.commands) / float64(st.flushes),
+		MaxCommands: st.maxCmds,
+		AvgBytes:    float64(st.bytes) / float64(st.flushes),
+		MaxBytes:    st.maxBytes,
+	}
+	if st.flushes > 1 {
+		ps.AvgInterval = st.gaps / time.Duration(st.flushes-1)
+	}
+
+	return ps
 }
 
 func (r *Results) DeleteJob(ctx context.Context, id string) error {
 	r.lo.Debug("deleting job")
 
 	pipe := r.conn.Pipeline()
-	if err := pipe.ZRem(ctx, resultPrefix+success, 1, id).Err(); err != nil {
+	if err := pipe.ZRem(ctx, r.prefix()+success, 1, id).Err(); err != nil {
+		return err
+	}
+	if err := pipe.ZRem(ctx, r.prefix()+failed, 1, id).Err(); err != nil {
+		return err
+	}
+	if err := pipe.ZRem(ctx, r.prefix()+successByWeight, id).Err(); err != nil {
 		return err
 	}
-	if err := pipe.ZRem(ctx, resultPrefix+failed, 1, id).Err(); err != nil {
+	if err := pipe.Del(ctx, r.prefix()+id, r.prefix()+consumedPrefix+id).Err(); err != nil {
 		return err
 	}
-	if err := pipe.Del(ctx, resultPrefix+id).Err(); err != nil {
+	if err := unindexScript.Eval(ctx, pipe, []string{r.prefix() + indexedKey}, r.prefix()+indexPrefix, id).Err(); err != nil {
 		return err
 	}
 	if _, err := pipe.Exec(ctx); err != nil {
@@ -126,7 +516,7 @@ func (r *Results) DeleteJob(ctx context.Context, id string) error {
 func (r *Results) GetSuccess(ctx context.Context) ([]string, error) {
 	// Fetch the failed tasks with score less than current time
 	r.lo.Debug("getting successful jobs")
-	rs, err := r.conn.ZRevRangeByScore(ctx, resultPrefix+success, &redis.ZRangeBy{
+	rs, err := r.conn.ZRevRangeByScore(ctx, r.prefix()+success, &redis.ZRangeBy{
 		Min: "0",
 		Max: strconv.FormatInt(time.Now().UnixNano(), 10),
 	}).Result()
@@ -140,7 +530,7 @@ func (r *Results) GetSuccess(ctx context.Context) ([]string, error) {
 func (r *Results) GetFailed(ctx context.Context) ([]string, error) {
 	// Fetch the failed tasks with score less than current time
 	r.lo.Debug("getting failed jobs")
-	rs, err := r.conn.ZRevRangeByScore(ctx, resultPrefix+failed, &redis.ZRangeBy{
+	rs, err := r.conn.ZRevRangeByScore(ctx, r.prefix()+failed, &redis.ZRangeBy{
 		Min: "0",
 		Max: strconv.FormatInt(time.Now().UnixNano(), 10),
 	}).Result()
@@ -151,45 +541,329 @@ func (r *Results) GetFailed(ctx context.Context) ([]string, error) {
 	return rs, nil
 }
 
+// SuccessByHour returns the number of successful jobs in the last `days` days,
+// bucketed by the (UTC) hour of the day they completed in.
+func (r *Results) SuccessByHour(ctx context.Context, days int) ([24]int64, error) {
+	r.lo.Debug("getting successful jobs by hour", "days", days)
+
+	var (
+		buckets [24]int64
+		now     = time.Now()
+	)
+	rs, err := r.conn.ZRangeByScoreWithScores(ctx, r.prefix()+success, &redis.ZRangeBy{
+		Min: strconv.FormatInt(now.AddDate(0, 0, -days).UnixNano(), 10),
+		Max: strconv.FormatInt(now.UnixNano(), 10),
+	}).Result()
+	if err != nil {
+		return buckets, err
+	}
+
+	for _, z := range rs {
+		buckets[time.Unix(0, int64(z.Score)).UTC().Hour()]++
+	}
+
+	return buckets, nil
+}
+
+// CountSuccess returns the number of successful jobs. The boolean return value reports
+// whether the count is a stale cached value, returned because redis timed out.
+func (r *Results) CountSuccess(ctx context.Context) (int64, bool, error) {
+	r.lo.Debug("counting successful jobs")
+	return r.count(ctx, success)
+}
+
+// CountFailed returns the number of failed jobs. The boolean return value reports
+// whether the count is a stale cached value, returned because redis timed out.
+func (r *Results) CountFailed(ctx context.Context) (int64, bool, error) {
+	r.lo.Debug("counting failed jobs")
+	return r.count(ctx, failed)
+}
+
+func (r *Results) count(ctx context.Context, set string) (int64, bool, error) {
+	if r.opts.CountCacheTTL == 0 {
+		n, err := r.conn.ZCard(ctx, r.prefix()+set).Result()
+		return n, false, err
+	}
+
+	r.cm.Lock()
+	c, ok := r.counts[set]
+	r.cm.Unlock()
+	if ok && time.Since(c.at) < r.opts.CountCacheTTL {
+		return c.n, false, nil
+	}
+
+	n, err := r.conn.ZCard(ctx, r.prefix()+set).Result()
+	if err != nil {
+		if ok && isTimeout(err) {
+			r.lo.Error("timed out counting jobs, returning stale count", "set", set, "error", err)
+			return c.n, true, nil
+		}
+		return 0, false, err
+	}
+
+	r.cm.Lock()
+	r.counts[set] = cachedCount{n: n, at: time.Now()}
+	r.cm.Unlock()
+
+	return n, false, nil
+}
+
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var nerr net.Error
+	return errors.As(err, &nerr) && nerr.Timeout()
+}
+
 func (r *Results) SetSuccess(ctx context.Context, id string) error {
 	r.lo.Debug("setting job as successful", "id", id)
-	if r.opts.PipePeriod != 0 {
-		return r.pipe.ZAdd(ctx, resultPrefix+success, redis.Z{
-			Score:  float64(time.Now().UnixNano()),
+	return r.execTx(ctx, func(pipe redis.Pipeliner) error {
+		r.statusCmds(ctx, pipe, id, success, failed)
+		return nil
+	})
+}
+
+// SetSuccessWithWeight sets the job as successful and also indexes it by `weight`,
+// to be retrieved in the order of weight through GetTopWeighted().
+func (r *Results) SetSuccessWithWeight(ctx context.Context, id string, weight float64) error {
+	r.lo.Debug("setting job as successful with weight", "id", id, "weight", weight)
+	return r.execTx(ctx, func(pipe redis.Pipeliner) error {
+		r.statusCmds(ctx, pipe, id, success, failed)
+		pipe.ZAdd(ctx, r.prefix()+successByWeight, redis.Z{
+			Score:  weight,
 			Member: id,
-		}).Err()
+		})
+		return nil
+	})
+}
+
+// GetTopWeighted returns the ids of the `n` successful jobs with the highest weight.
+func (r *Results) GetTopWeighted(ctx context.Context, n int64) ([]string, error) {
+	r.lo.Debug("getting top weighted successful jobs", "n", n)
+	if n <= 0 {
+		return []string{}, nil
 	}
-	return r.conn.ZAdd(ctx, resultPrefix+success, redis.Z{
-		Score:  float64(time.Now().UnixNano()),
-		Member: id,
-	}).Err()
+
+	return r.conn.ZRevRange(ctx, r.prefix()+successByWeight, 0, n-1).Result()
 }
 
 func (r *Results) SetFailed(ctx context.Context, id string) error {
 	r.lo.Debug("setting job as failed", "id", id)
-	if r.opts.PipePeriod != 0 {
-		return r.pipe.ZAdd(ctx, resultPrefix+failed, redis.Z{
-			Score:  float64(time.Now().UnixNano()),
-			Member: id,
-		}).Err()
-	}
-	return r.conn.ZAdd(ctx, resultPrefix+failed, redis.Z{
+	return r.execTx(ctx, func(pipe redis.Pipeliner) error {
+		r.statusCmds(ctx, pipe, id, failed, success, successByWeight)
+		return nil
+	})
+}
+
+// statusCmds queues the commands adding the id to the `add` set and removing it from the `rem` sets,
+// so that a job only appears in the set reflecting its latest outcome.
+func (r *Results) statusCmds(ctx context.Context, pipe redis.Pipeliner, id, add string, rem ...string) {
+	pipe.ZAdd(ctx, r.prefix()+add, redis.Z{
 		Score:  float64(time.Now().UnixNano()),
 		Member: id,
-	}).Err()
+	})
+	for _, set := range rem {
+		pipe.ZRem(ctx, r.prefix()+set, id)
+	}
+}
+
+// execTx runs the commands queued by fn in a single transaction.
+// When piping is enabled the commands are queued together on the pipe instead.
+func (r *Results) execTx(ctx context.Context, fn func(redis.Pipeliner) error) error {
+	if r.pipe != nil {
+		var err error
+		if perr := r.pipe.Do(ctx, func(p redis.Pipeliner) { err = fn(p) }); perr != nil {
+			return perr
+		}
+		return err
+	}
+	_, err := r.conn.TxPipelined(ctx, fn)
+	return err
 }
 
 func (r *Results) Set(ctx context.Context, id string, b []byte) error {
 	r.lo.Debug("setting result for job", "id", id)
-	if r.opts.PipePeriod != 0 {
-		return r.pipe.Set(ctx, resultPrefix+id, b, r.opts.Expiry).Err()
+	if r.opts.MaxResultBytes != 0 && len(b) > r.opts.MaxResultBytes {
+		return ErrResultTooLarge
+	}
+	if r.opts.DetectCollisions && !isMeta(id) {
+		return r.setDetectCollision(ctx, id, b)
+	}
+	if r.pipe != nil {
+		return r.pipe.Do(ctx, func(p redis.Pipeliner) {
+			p.Set(ctx, r.prefix()+id, b, r.opts.Expiry)
+		})
 	}
-	return r.conn.Set(ctx, resultPrefix+id, b, r.opts.Expiry).Err()
+	return r.conn.Set(ctx, r.prefix()+id, b, r.opts.Expiry).Err()
+}
+
+// SetMeta sets the server's metadata, which isn't subject to collision detection.
+func (r *Results) SetMeta(ctx context.Context, id string, b []byte) error {
+	r.lo.Debug("setting metadata", "id", id)
+	if r.pipe != nil {
+		return r.pipe.Do(ctx, func(p redis.Pipeliner) {
+			p.Set(ctx, r.prefix()+id, b, r.opts.Expiry)
+		})
+	}
+	return r.conn.Set(ctx, r.prefix()+id, b, r.opts.Expiry).Err()
+}
+
+// SetPersistent sets the result without an expiry. It is used for the server's metadata and isn't
+// subject to collision detection.
+func (r *Results) SetPersistent(ctx context.Context, id string, b []byte) error {
+	r.lo.Debug("setting persistent result", "id", id)
+	if r.pipe != nil {
+		return r.pipe.Do(ctx, func(p redis.Pipeliner) {
+			p.Set(ctx, r.prefix()+id, b, 0)
+		})
+	}
+	return r.conn.Set(ctx, r.prefix()+id, b, 0).Err()
+}
+
+// SetBatch sets multiple results in a single round trip. Results subject to collision
+// detection are set individually.
+func (r *Results) SetBatch(ctx context.Context, items map[string][]byte) error {
+	r.lo.Debug("setting results for jobs", "count", len(items))
+
+	piped := make(map[string][]byte, len(items))
+	for id, b := range items {
+		if r.opts.MaxResultBytes != 0 && len(b) > r.opts.MaxResultBytes {
+			return ErrResultTooLarge
+		}
+		if r.opts.DetectCollisions && !isMeta(id) {
+			if err := r.setDetectCollision(ctx, id, b); err != nil {
+				return err
+			}
+			continue
+		}
+		piped[id] = b
+	}
+	if len(piped) == 0 {
+		return nil
+	}
+
+	set := func(p redis.Pipeliner) {
+		for id, b := range piped {
+			p.Set(ctx, r.prefix()+id, b, r.opts.Expiry)
+		}
+	}
+	if r.pipe != nil {
+		return r.pipe.Do(ctx, set)
+	}
+	_, err := r.conn.Pipelined(ctx, func(p redis.Pipeliner) error {
+		set(p)
+		return nil
+	})
+	return err
+}
+
+// setDetectCollision atomically sets the result while checking it against the existing one.
+func (r *Results) setDetectCollision(ctx context.Context, id string, b []byte) error {
+	reject := "0"
+	if r.opts.RejectCollisions {
+		reject = "1"
+	}
+
+	res, err := setCollisionScript.Run(ctx, r.conn, []string{r.prefix() + id},
+		b, r.opts.Expiry.Milliseconds(), reject).Slice()
+	if err != nil {
+		return err
+	}
+
+	old, ok := res[0].(string)
+	if !ok || old == string(b) {
+		return nil
+	}
+
+	r.lo.Debug("result collision detected", "id", id)
+	if r.opts.OnCollision != nil {
+		r.opts.OnCollision(id, []byte(old), b)
+	}
+	if res[1].(int64) == 0 {
+		return ErrResultCollision
+	}
+
+	return nil
+}
+
+func isMeta(id string) bool {
+	for _, p := range metaPrefixes {
+		if strings.HasPrefix(id, p) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SetWithLock acquires a short lived lock on the job id before writing the result and
+// releases it once written. This serializes concurrent writes for the same job without
+// a global lock. It returns false if the lock is currently held by another writer.
+// The lock expires after lockTTL (DefaultLockTTL if it isn't positive).
+// The result is always written directly to redis, bypassing the pipe.
+func (r *Results) SetWithLock(ctx context.Context, id string, b []byte, lockTTL time.Duration) (bool, error) {
+	r.lo.Debug("setting result for job with lock", "id", id)
+	if r.opts.MaxResultBytes != 0 && len(b) > r.opts.MaxResultBytes {
+		return false, ErrResultTooLarge
+	}
+	// A lock without a TTL would never be released if the writer dies holding it.
+	if lockTTL <= 0 {
+		lockTTL = DefaultLockTTL
+	}
+
+	var (
+		key   = r.prefix() + lockPrefix + id
+		token = uuid.NewString()
+	)
+	ok, err := r.conn.SetNX(ctx, key, token, lockTTL).Result()
+	if err != nil {
+		return false, err
+	}
+	if !ok {
+		r.lo.Debug("could not acquire result lock", "id", id)
+		return false, nil
+	}
+	defer func() {
+		if err := unlockScript.Run(context.WithoutCancel(ctx), r.conn, []string{key}, token).Err(); err != nil {
+			r.lo.Error("could not release result lock", "id", id, "error", err)
+		}
+	}()
+
+	if err := r.conn.Set(ctx, r.prefix()+id, b, r.opts.Expiry).Err(); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// SetUnique sets the unique key to the job id if the key isn't held by another job.
+// If it is, it returns false along with the id of the job holding the key.
+func (r *Results) SetUnique(ctx context.Context, key, id string, ttl time.Duration) (string, bool, error) {
+	r.lo.Debug("setting unique key", "key", key, "id", id)
+
+	res, err := setUniqueScript.Run(ctx, r.conn, []string{r.prefix() + uniquePrefix + key}, id, ttl.Milliseconds()).Slice()
+	if err != nil {
+		return "", false, err
+	}
+	if len(res) != 2 {
+		return "", false, fmt.Errorf("unexpected response setting unique key: %v", res)
+	}
+
+	held, _ := res[1].(string)
+	return held, res[0] == int64(1), nil
+}
+
+// DeleteUnique releases the unique key, only if it is held by the job id.
+func (r *Results) DeleteUnique(ctx context.Context, key, id string) error {
+	r.lo.Debug("deleting unique key", "key", key, "id", id)
+
+	return unlockScript.Run(ctx, r.conn, []string{r.prefix() + uniquePrefix + key}, id).Err()
 }
 
 func (r *Results) Get(ctx context.Context, id string) ([]byte, error) {
 	r.lo.Debug("getting result for job", "id", id)
-	rs, err := r.conn.Get(ctx, resultPrefix+id).Bytes()
+	rs, err := r.conn.Get(ctx, r.prefix()+id).Bytes()
 	if err != nil {
 		return nil, err
 	}
@@ -197,43 +871,534 @@ func (r *Results) Get(ctx context.Context, id string) ([]byte, error) {
 	return rs, nil
 }
 
-// TODO: accpet a ctx here and shutdown gracefully
-func (r *Results) expireMeta(ttl time.Duration) {
+// GetMulti returns the results of the ids that exist, in a single round trip. The keys are pipelined
+// instead of being fetched with MGET, so that they needn't be in the same slot of a redis cluster.
+func (r *Results) GetMulti(ctx context.Context, ids []string) (map[string][]byte, error) {
+	r.lo.Debug("getting results for jobs", "count", len(ids))
+
+	cmds := make([]*redis.StringCmd, len(ids))
+	if _, err := r.conn.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, id := range ids {
+			cmds[i] = pipe.Get(ctx, r.prefix()+id)
+		}
+		return nil
+	}); err != nil && !errors.Is(err, redis.Nil) {
+		return nil, err
+	}
+
+	out := make(map[string][]byte, len(ids))
+	for i, c := range cmds {
+		b, err := c.Bytes()
+		if errors.Is(err, redis.Nil) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		out[ids[i]] = b
+	}
+
+	return out, nil
+}
+
+// Subscribe returns a channel receiving the value of the id each time it is set, starting with its
+// current value. It relies on keyspace notifications, which must be enabled on the redis server
+// for string commands (eg: `notify-keyspace-events K$`).
+func (r *Results) Subscribe(ctx context.Context, id string) (<-chan []byte, error) {
+	r.lo.Debug("subscribing to result for job", "id", id)
+
+	key := r.prefix() + id
+	ps := r.conn.Subscribe(ctx, fmt.Sprintf("__keyspace@%d__:%s", r.opts.DB, key))
+	// Wait for the subscription to be confirmed, so that no update after the initial read is missed.
+	if _, err := ps.Receive(ctx); err != nil {
+		ps.Close()
+		return nil, err
+	}
+
+	ch := make(chan []byte)
+	go func() {
+		defer close(ch)
+		defer ps.Close()
+
+		// send reads the current value and passes it on, returning false once ctx is cancelled.
+		send := func() bool {
+			b, err := r.conn.Get(ctx, key).Bytes()
+			if err != nil {
+				if !errors.Is(err, redis.Nil) && ctx.Err() == nil {
+					r.lo.Error("could not get subscribed result", "id", id, "error", err)
+				}
+				return ctx.Err() == nil
+			}
+			select {
+			case ch <- b:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		if !send() {
+			return
+		}
+		msgs := ps.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case m, ok := <-msgs:
+				if !ok {
+					return
+				}
+				if m.Payload == "set" && !send() {
+					return
+				}
+			}
+		}
+	}()
+
+	return ch, nil
+}
+
+// MigrateLegacy moves results stored under `legacyPrefix` (which may be empty, for unprefixed keys)
+// to the current key prefix, returning the number of keys migrated. The legacy success/failed sets
+// are merged into the current ones, while other string keys are renamed (preserving their TTL) unless a key
+// with the same id already exists under the current prefix, in which case the legacy key is left as is.
+func (r *Results) MigrateLegacy(ctx context.Context, legacyPrefix string) (int, error) {
+	if legacyPrefix == r.prefix() {
+		return 0, nil
+	}
+	r.lo.Info("migrating legacy results", "prefix", legacyPrefix)
+
+	// Merge the success/failed sets first, so that they aren't picked up as regular keys below.
+	var count int
+	for _, set := range []string{success, failed} {
+		n, err := r.conn.Exists(ctx, legacyPrefix+set).Result()
+		if err != nil {
+			return count, err
+		}
+		if n == 0 {
+			continue
+		}
+
+		if _, err := r.conn.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.ZUnionStore(ctx, r.prefix()+set, &redis.ZStore{
+				Keys:      []string{r.prefix() + set, legacyPrefix + set},
+				Aggregate: "MAX",
+			})
+			pipe.Del(ctx, legacyPrefix+set)
+			return nil
+		}); err != nil {
+			return count, err
+		}
+		count++
+	}
+
+	var cursor uint64
+	for {
+		keys, next, err := r.conn.Scan(ctx, cursor, legacyPrefix+"*", migrateBatch).Result()
+		if err != nil {
+			return count, err
+		}
+
+		// With an empty legacy prefix, every key matches. Skip the ones already migrated, the ones
+		// of the other namespaces and anything that isn't a plain result (eg: broker queues sharing the DB).
+		types := make([]*redis.StatusCmd, 0, len(keys))
+		if _, err := r.conn.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for _, k := range keys {
+				types = append(types, pipe.Type(ctx, k))
+			}
+			return nil
+		}); err != nil {
+			return count, err
+		}
+
+		var (
+			pipe = r.conn.Pipeline()
+			cmds = make([]*redis.BoolCmd, 0, len(keys))
+		)
+		for i, k := range keys {
+			if strings.HasPrefix(k, r.prefix()) || types[i].Val() != "string" ||
+				legacyPrefix == "" && (strings.HasPrefix(k, "tq:") || strings.HasPrefix(k, "{tq")) {
+				continue
+			}
+			cmds = append(cmds, pipe.RenameNX(ctx, k, r.prefix()+strings.TrimPrefix(k, legacyPrefix)))
+		}
+		if len(cmds) > 0 {
+			if _, err := pipe.Exec(ctx); err != nil {
+				return count, err
+			}
+		}
+		for _, c := range cmds {
+			if c.Val() {
+				count++
+			}
+		}
+
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+
+	r.lo.Info("migrated legacy results", "prefix", legacyPrefix, "count", count)
+	return count, nil
+}
+
+// ApplyRetention enforces the configured RetentionPolicy, removing the finished jobs that
+// exceed its limits (from the success/failed sets along with their results) and reports what was removed.
+func (r *Results) ApplyRetention(ctx context.Context) (RetentionReport, error) {
+	var (
+		pol = r.opts.Retention
+		rep RetentionReport
+	)
+
+	if pol.MaxAge != 0 {
+		max := strconv.FormatInt(time.Now().Add(-pol.MaxAge).UnixNano(), 10)
+		for _, set := range []string{success, failed} {
+			ids, err := r.conn.ZRangeByScore(ctx, r.prefix()+set, &redis.ZRangeBy{Min: "0", Max: max}).Result()
+			if err != nil {
+				return rep, err
+			}
+			b, err := r.removeJobs(ctx, ids)
+			if err != nil {
+				return rep, err
+			}
+			rep.Expired += len(ids)
+			rep.Bytes += b
+		}
+	}
+
+	if pol.MaxCount != 0 {
+		for _, set := range []string{success, failed} {
+			n, err := r.conn.ZCard(ctx, r.prefix()+set).Result()
+			if err != nil {
+				return rep, err
+			}
+			if n <= pol.MaxCount {
+				continue
+			}
+
+			// Sets are scored by time, so the lowest ranks are the oldest jobs.
+			ids, err := r.conn.ZRange(ctx, r.prefix()+set, 0, n-pol.MaxCount-1).Result()
+			if err != nil {
+				return rep, err
+			}
+			b, err := r.removeJobs(ctx, ids)
+			if err != nil {
+				return rep, err
+			}
+			rep.Trimmed += len(ids)
+			rep.Bytes += b
+		}
+	}
+
+	if pol.MaxTotalBytes != 0 {
+		var jobs []redis.Z
+		for _, set := range []string{success, failed} {
+			zs, err := r.conn.ZRangeWithScores(ctx, r.prefix()+set, 0, -1).Result()
+			if err != nil {
+				return rep, err
+			}
+			jobs = append(jobs, zs...)
+		}
+		sort.Slice(jobs, func(i, j int) bool {
+			return jobs[i].Score < jobs[j].Score
+		})
+
+		sizes := make([]*redis.IntCmd, len(jobs))
+		if _, err := r.conn.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for i, z := range jobs {
+				sizes[i] = pipe.StrLen(ctx, r.prefix()+z.Member.(string))
+			}
+			return nil
+		}); err != nil {
+			return rep, err
+		}
+
+		var total int64
+		for _, c := range sizes {
+			total += c.Val()
+		}
+
+		// Evict the oldest jobs until the total size is within the limit.
+		var ids []string
+		for i := 0; i < len(jobs) && total > pol.MaxTotalBytes; i++ {
+			ids = append(ids, jobs[i].Member.(string))
+			total -= sizes[i].Val()
+		}
+		b, err := r.removeJobs(ctx, ids)
+		if err != nil {
+			return rep, err
+		}
+		rep.Evicted += len(ids)
+		rep.Bytes += b
+	}
+
+	return rep, nil
+}
+
+// removeJobs removes the ids from the success/failed sets (and the weighted index) and deletes their results,
+// returning the combined size of the deleted results.
+func (r *Results) removeJobs(ctx context.Context, ids []string) (int64, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	var (
+		members = make([]interface{}, len(ids))
+		sizes   = make([]*redis.IntCmd, len(ids))
+	)
+	for i, id := range ids {
+		members[i] = id
+	}
+	if _, err := r.conn.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, id := range ids {
+			sizes[i] = pipe.StrLen(ctx, r.prefix()+id)
+			pipe.Del(ctx, r.prefix()+id, r.prefix()+consumedPrefix+id)
+		}
+		pipe.ZRem(ctx, r.prefix()+success, members...)
+		pipe.ZRem(ctx, r.prefix()+failed, members...)
+		pipe.ZRem(ctx, r.prefix()+successByWeight, members...)
+		unindexScript.Eval(ctx, pipe, []string{r.prefix() + indexedKey}, append([]interface{}{r.prefix() + indexPrefix}, members...)...)
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+
+	var b int64
+	for _, c := range sizes {
+		b += c.Val()
+	}
+
+	return b, nil
+}
+
+func (r *Results) enforceRetention(ctx context.Context, interval time.Duration) {
+	defer r.wg.Done()
+	r.lo.Info("starting results retention enforcer", "interval", interval)
+
+	tk := time.NewTicker(interval)
+	defer tk.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			r.lo.Info("shutting down results retention enforcer")
+			return
+		case <-tk.C:
+		}
+
+		rep, err := r.ApplyRetention(ctx)
+		if err != nil {
+			r.lo.Error("could not apply results retention", "error", err)
+			continue
+		}
+		r.lo.Debug("applied results retention", "expired", rep.Expired, "trimmed", rep.Trimmed,
+			"evicted", rep.Evicted, "bytes", rep.Bytes)
+	}
+}
+
+// ConsumeOnce atomically fetches the result of a job and marks it as consumed by `consumerID`,
+// if it hasn't already been consumed. The boolean return value reports whether this caller
+// was the one to consume it. If the result doesn't exist, NilError() is returned.
+func (r *Results) ConsumeOnce(ctx context.Context, id, consumerID string) ([]byte, bool, error) {
+	r.lo.Debug("consuming result for job", "id", id, "consumer", consumerID)
+	res, err := consumeScript.Run(ctx, r.conn,
+		[]string{r.prefix() + id, r.prefix() + consumedPrefix + id}, consumerID).Slice()
+	if err != nil {
+		return nil, false, err
+	}
+
+	return []byte(res[0].(string)), res[1].(int64) == 1, nil
+}
+
+// CompleteGroup atomically stores the aggregate result of a group and marks the group as successful,
+// if all of its members have succeeded. It returns whether the group was completed.
+func (r *Results) CompleteGroup(ctx context.Context, groupID string, memberIDs []string, aggregate []byte) (bool, error) {
+	r.lo.Debug("completing group", "id", groupID, "members", len(memberIDs))
+	if r.opts.MaxResultBytes != 0 && len(aggregate) > r.opts.MaxResultBytes {
+		return false, ErrResultTooLarge
+	}
+
+	args := make([]interface{}, 0, len(memberIDs)+4)
+	args = append(args, aggregate, r.opts.Expiry.Milliseconds(), time.Now().UnixNano(), groupID)
+	for _, id := range memberIDs {
+		args = append(args, id)
+	}
+
+	ok, err := completeGroupScript.Run(ctx, r.conn,
+		[]string{r.prefix() + success, r.prefix() + groupID}, args...).Int()
+	if err != nil {
+		return false, err
+	}
+
+	return ok == 1, nil
+}
+
+func (r *Results) expireMeta(ctx context.Context, ttl time.Duration) {
+	defer r.wg.Done()
 	r.lo.Info("starting results meta purger", "ttl", ttl)
 
 	var (
 		tk = time.NewTicker(ttl)
 	)
+	defer tk.Stop()
 
 	for {
 		select {
-		// case <-ctx.Done():
-		// 	r.lo.Info("shutting down meta purger", "ttl", ttl)
-		// 	return
+		case <-ctx.Done():
+			r.lo.Info("shutting down meta purger", "ttl", ttl)
+			return
 		case <-tk.C:
 			now := time.Now().UnixNano() - int64(ttl)
 			score := strconv.FormatInt(now, 10)
 
 			r.lo.Debug("purging failed results metadata", "score", score)
-			if r.opts.PipePeriod != 0 {
-				if err := r.pipe.ZRemRangeByScore(context.Background(), resultPrefix+failed, "0", score).Err(); err != nil {
-					r.lo.Error("could not expire success/failed metadata", "err", err)
-				}
-				r.lo.Debug("purging success results metadata", "score", score)
-				if err := r.pipe.ZRemRangeByScore(context.Background(), resultPrefix+success, "0", score).Err(); err != nil {
+			if r.pipe != nil {
+				if err := r.pipe.Do(ctx, func(p redis.Pipeliner) {
+					p.ZRemRangeByScore(ctx, r.prefix()+failed, "0", score)
+					p.ZRemRangeByScore(ctx, r.prefix()+success, "0", score)
+				}); err != nil && ctx.Err() == nil {
 					r.lo.Error("could not expire success/failed metadata", "err", err)
 				}
 			} else {
-				if err := r.conn.ZRemRangeByScore(context.Background(), resultPrefix+failed, "0", score).Err(); err != nil {
+				if err := r.conn.ZRemRangeByScore(ctx, r.prefix()+failed, "0", score).Err(); err != nil {
 					r.lo.Error("could not expire success/failed metadata", "err", err)
 				}
 				r.lo.Debug("purging success results metadata", "score", score)
-				if err := r.conn.ZRemRangeByScore(context.Background(), resultPrefix+success, "0", score).Err(); err != nil {
+				if err := r.conn.ZRemRangeByScore(ctx, r.prefix()+success, "0", score).Err(); err != nil {
 					r.lo.Error("could not expire success/failed metadata", "err", err)
 				}
 			}
+
+			r.lo.Debug("purging indexed jobs")
+			if err := r.expireIndex(ctx, time.Now().Add(-ttl)); err != nil {
+				r.lo.Error("could not expire indexed jobs", "err", err)
+			}
+		}
+	}
+}
+
+// expireIndex removes the jobs indexed before the time from the indexes.
+func (r *Results) expireIndex(ctx context.Context, before time.Time) error {
+	states, err := r.conn.SMembers(ctx, r.prefix()+indexStatesKey).Result()
+	if err != nil {
+		return err
+	}
+
+	for _, st := range states {
+		for {
+			ids, err := r.conn.ZRangeByScore(ctx, r.indexKey(st, "", ""), &redis.ZRangeBy{
+				Min:   "-inf",
+				Max:   "(" + strconv.FormatInt(before.UnixMicro(), 10),
+				Count: unindexBatch,
+			}).Result()
+			if err != nil {
+				return err
+			}
+			if len(ids) == 0 {
+				break
+			}
+
+			args := make([]interface{}, 0, len(ids)+1)
+			args = append(args, r.prefix()+indexPrefix)
+			for _, id := range ids {
+				args = append(args, id)
+			}
+			if err := unindexScript.Run(ctx, r.conn, []string{r.prefix() + indexedKey}, args...).Err(); err != nil {
+				return err
+			}
 		}
 	}
+
+	return nil
+}
+
+// indexEntry returns the entry of a job indexed in the state, by its queue and task. The queue is
+// prefixed by its length, so that entries are unambiguous even if the names contain ":".
+func indexEntry(state, queue, task string) string {
+	return state + ":" + strconv.Itoa(len(queue)) + ":" + queue + ":" + task
+}
+
+// indexKey returns the key of the sorted set indexing the jobs in the state, by queue and task if non-empty.
+func (r *Results) indexKey(state, queue, task string) string {
+	return r.prefix() + indexPrefix + indexEntry(state, queue, task)
+}
+
+// IndexJob indexes the job in the state by its queue and task, scored by the time (in microseconds).
+// Indexing a job again replaces its previous entry.
+func (r *Results) IndexJob(ctx context.Context, id, state, queue, task string, at time.Time) error {
+	r.lo.Debug("indexing job", "id", id, "state", state)
+	return indexScript.Run(ctx, r.conn, []string{r.prefix() + indexedKey, r.prefix() + indexStatesKey},
+		r.prefix()+indexPrefix, id, indexEntry(state, queue, task), state, at.UnixMicro()).Err()
+}
+
+// ListJobs returns a page of the indexed jobs in the state, most recent first. The cursor is the
+// score and id of the last job of the previous page.
+func (r *Results) ListJobs(ctx context.Context, state, queue string, limit int, cursor string,
+	task string, from, to time.Time) ([]string, []time.Time, string, error) {
+	r.lo.Debug("listing jobs", "state", state, "queue", queue, "cursor", cursor)
+
+	rng := &redis.ZRangeBy{Min: "-inf", Max: "+inf", Count: int64(limit) + 1}
+	if !from.IsZero() {
+		rng.Min = strconv.FormatInt(from.UnixMicro(), 10)
+	}
+	if !to.IsZero() {
+		rng.Max = "(" + strconv.FormatInt(to.UnixMicro(), 10)
+	}
+
+	var (
+		afterScore float64
+		afterID    string
+	)
+	if cursor != "" {
+		n, id, ok := strings.Cut(cursor, ":")
+		sc, err := strconv.ParseInt(n, 10, 64)
+		if !ok || err != nil {
+			return nil, nil, "", fmt.Errorf("invalid cursor %s", cursor)
+		}
+		afterScore, afterID = float64(sc), id
+		rng.Max = n
+	}
+
+	var (
+		key = r.indexKey(state, queue, task)
+		ids []string
+		ats []time.Time
+	)
+	// Jobs with the same score as the cursor are ordered by id (in reverse), skip the ones up to it.
+	for len(ids) <= limit {
+		zs, err := r.conn.ZRevRangeByScoreWithScores(ctx, key, rng).Result()
+		if err != nil {
+			return nil, nil, "", err
+		}
+		for _, z := range zs {
+			id := z.Member.(string)
+			if cursor != "" && z.Score == afterScore && id >= afterID {
+				continue
+			}
+			ids, ats = append(ids, id), append(ats, time.UnixMicro(int64(z.Score)))
+		}
+		if len(zs) < int(rng.Count) {
+			break
+		}
+		rng.Offset += int64(len(zs))
+	}
+
+	var next string
+	if len(ids) > limit {
+		ids, ats = ids[:limit], ats[:limit]
+		next = strconv.FormatInt(ats[limit-1].UnixMicro(), 10) + ":" + ids[limit-1]
+	}
+
+	return ids, ats, next, nil
+}
+
+// Close stops the background goroutines (meta expiry, retention and the pipe, which is flushed)
+// and closes the redis connection. The results store must not be used after it is closed.
+func (r *Results) Close() error {
+	r.stop()
+	r.wg.Wait()
+
+	return r.conn.Close()
 }
 
 func (r *Results) NilError() error {
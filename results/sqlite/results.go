@@ -0,0 +1,461 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+const (
+	DefaultPurgePeriod = time.Minute
+
+	// Values of the status column for success/failed job ids
+	success = "success"
+	failed  = "failed"
+
+	// Maximum number of ids queried at once by GetMulti, below sqlite's limit on the number of parameters
+	getMultiBatch = 500
+)
+
+// schema creates the tables holding the results and the success/failed status of jobs.
+// Timestamps are stored as unix nanoseconds. A job has at most one status row, reflecting its latest outcome.
+const schema = `
+CREATE TABLE IF NOT EXISTS tq_results (
+	id         TEXT PRIMARY KEY,
+	data       BLOB NOT NULL,
+	expires_at INTEGER
+);
+CREATE INDEX IF NOT EXISTS tq_results_expires_at ON tq_results (expires_at);
+
+CREATE TABLE IF NOT EXISTS tq_status (
+	id         TEXT PRIMARY KEY,
+	status     TEXT NOT NULL,
+	updated_at INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS tq_status_status_updated_at ON tq_status (status, updated_at);
+
+CREATE TABLE IF NOT EXISTS tq_jobs (
+	id         TEXT PRIMARY KEY,
+	state      TEXT NOT NULL,
+	queue      TEXT NOT NULL,
+	task       TEXT NOT NULL,
+	indexed_at INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS tq_jobs_state_indexed_at ON tq_jobs (state, indexed_at, id);
+
+CREATE TABLE IF NOT EXISTS tq_unique (
+	key        TEXT PRIMARY KEY,
+	id         TEXT NOT NULL,
+	expires_at INTEGER
+);
+`
+
+type Results struct {
+	opts Options
+	lo   *slog.Logger
+	conn *sql.DB
+
+	// stop stops the purger, done is closed once it has stopped.
+	stop context.CancelFunc
+	done chan struct{}
+}
+
+type Options struct {
+	// Path is the path to the database file. It is created if it doesn't exist.
+	Path string
+
+	// Expiry is the duration results are kept for. If zero, results don't expire.
+	Expiry time.Duration
+	// MetaExpiry is the duration success/failed and indexed job ids are kept for. If zero, they don't expire.
+	MetaExpiry time.Duration
+
+	// OPTIONAL
+	// Interval at which expired results and metadata are purged. Defaults to `DefaultPurgePeriod`.
+	PurgePeriod time.Duration
+}
+
+// New() returns a new instance of the sqlite results store. It creates the required tables if they don't exist.
+func New(o Options, lo *slog.Logger) (*Results, error) {
+	if o.PurgePeriod == 0 {
+		o.PurgePeriod = DefaultPurgePeriod
+	}
+
+	conn, err := sql.Open("sqlite", "file:"+o.Path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, fmt.Errorf("error opening sqlite database : %w", err)
+	}
+	// SQLite allows a single writer, serialize access to the database instead of
+	// having concurrent writers wait on locks.
+	conn.SetMaxOpenConns(1)
+
+	if _, err := conn.Exec(schema); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("error creating sqlite tables : %w", err)
+	}
+
+	rs := &Results{
+		opts: o,
+		lo:   lo,
+		conn: conn,
+	}
+
+	// The purger runs until Close() is called.
+	ctx, cancel := context.WithCancel(context.Background())
+	rs.stop, rs.done = cancel, make(chan struct{})
+	if o.Expiry != 0 || o.MetaExpiry != 0 {
+		go rs.purge(ctx, o.PurgePeriod)
+	} else {
+		close(rs.done)
+	}
+
+	return rs, nil
+}
+
+func (r *Results) Get(ctx context.Context, id string) ([]byte, error) {
+	r.lo.Debug("getting result for job", "id", id)
+
+	var b []byte
+	if err := r.conn.QueryRowContext(ctx, `SELECT data FROM tq_results
+		WHERE id = ? AND (expires_at IS NULL OR expires_at > ?)`, id, time.Now().UnixNano()).Scan(&b); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// GetMulti returns the results of the ids that exist, querying up to `getMultiBatch` ids at a time.
+func (r *Results) GetMulti(ctx context.Context, ids []string) (map[string][]byte, error) {
+	r.lo.Debug("getting results for jobs", "count", len(ids))
+
+	out := make(map[string][]byte, len(ids))
+	for i := 0; i < len(ids); i += getMultiBatch {
+		chunk := ids[i:min(i+getMultiBatch, len(ids))]
+		args := make([]any, 0, len(chunk)+1)
+		for _, id := range chunk {
+			args = append(args, id)
+		}
+		args = append(args, time.Now().UnixNano())
+
+		rows, err := r.conn.QueryContext(ctx, `SELECT id, data FROM tq_results
+			WHERE id IN (?`+strings.Repeat(", ?", len(chunk)-1)+`) AND (expires_at IS NULL OR expires_at > ?)`, args...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var (
+				id string
+				b  []byte
+			)
+			if err := rows.Scan(&id, &b); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			out[id] = b
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	return out, nil
+}
+
+func (r *Results) NilError() error {
+	return sql.ErrNoRows
+}
+
+func (r *Results) Set(ctx context.Context, id string, b []byte) error {
+	r.lo.Debug("setting result for job", "id", id)
+
+	_, err := r.conn.ExecContext(ctx, setQuery, id, b, r.expiresAt(r.opts.Expiry))
+	return err
+}
+
+// SetPersistent sets the result without an expiry.
+func (r *Results) SetPersistent(ctx context.Context, id string, b []byte) error {
+	r.lo.Debug("setting persistent result", "id", id)
+
+	_, err := r.conn.ExecContext(ctx, setQuery, id, b, nil)
+	return err
+}
+
+const setQuery = `INSERT INTO tq_results (id, data, expires_at) VALUES (?, ?, ?)
+	ON CONFLICT (id) DO UPDATE SET data = excluded.data, expires_at = excluded.expires_at`
+
+// SetBatch sets multiple results in a single transaction.
+func (r *Results) SetBatch(ctx context.Context, items map[string][]byte) error {
+	r.lo.Debug("setting results for jobs", "count", len(items))
+
+	exp := r.expiresAt(r.opts.Expiry)
+	return r.tx(ctx, func(tx *sql.Tx) error {
+		stmt, err := tx.PrepareContext(ctx, setQuery)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+
+		for id, b := range items {
+			if _, err := stmt.ExecContext(ctx, id, b, exp); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (r *Results) DeleteJob(ctx context.Context, id string) error {
+	r.lo.Debug("deleting job", "id", id)
+
+	return r.tx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM tq_status WHERE id = ?`, id); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM tq_results WHERE id = ?`, id); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM tq_jobs WHERE id = ?`, id); err != nil {
+			return err
+		}
+		return nil
+	})
+}
+
+func (r *Results) GetSuccess(ctx context.Context) ([]string, error) {
+	r.lo.Debug("getting successful jobs")
+	return r.getStatus(ctx, success)
+}
+
+func (r *Results) GetFailed(ctx context.Context) ([]string, error) {
+	r.lo.Debug("getting failed jobs")
+	return r.getStatus(ctx, failed)
+}
+
+func (r *Results) SetSuccess(ctx context.Context, id string) error {
+	r.lo.Debug("setting job as successful", "id", id)
+	return r.setStatus(ctx, id, success)
+}
+
+func (r *Results) SetFailed(ctx context.Context, id string) error {
+	r.lo.Debug("setting job as failed", "id", id)
+	return r.setStatus(ctx, id, failed)
+}
+
+// getStatus returns the ids of jobs with the status, the most recently updated first.
+func (r *Results) getStatus(ctx context.Context, status string) ([]string, error) {
+	rows, err := r.conn.QueryContext(ctx, `SELECT id FROM tq_status WHERE status = ? ORDER BY updated_at DESC`, status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+func (r *Results) setStatus(ctx context.Context, id, status string) error {
+	_, err := r.conn.ExecContext(ctx, `INSERT INTO tq_status (id, status, updated_at) VALUES (?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET status = excluded.status, updated_at = excluded.updated_at`,
+		id, status, time.Now().UnixNano())
+	return err
+}
+
+// IndexJob indexes the job in the state by its queue and task, replacing its previous entry.
+func (r *Results) IndexJob(ctx context.Context, id, state, queue, task string, at time.Time) error {
+	r.lo.Debug("indexing job", "id", id, "state", state)
+	_, err := r.conn.ExecContext(ctx, `INSERT INTO tq_jobs (id, state, queue, task, indexed_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET state = excluded.state, queue = excluded.queue, task = excluded.task,
+		indexed_at = excluded.indexed_at`,
+		id, state, queue, task, at.UnixNano())
+	return err
+}
+
+// ListJobs returns a page of the indexed jobs in the state, most recent first. The cursor is the
+// time and id of the last job of the previous page.
+func (r *Results) ListJobs(ctx context.Context, state, queue string, limit int, cursor string,
+	task string, from, to time.Time) ([]string, []time.Time, string, error) {
+	r.lo.Debug("listing jobs", "state", state, "queue", queue, "cursor", cursor)
+
+	var (
+		q    = `SELECT id, indexed_at FROM tq_jobs WHERE state = ?`
+		args = []any{state}
+	)
+	if queue != "" {
+		q += ` AND queue = ?`
+		args = append(args, queue)
+	}
+	if task != "" {
+		q += ` AND task = ?`
+		args = append(args, task)
+	}
+	if !from.IsZero() {
+		q += ` AND indexed_at >= ?`
+		args = append(args, from.UnixNano())
+	}
+	if !to.IsZero() {
+		q += ` AND indexed_at < ?`
+		args = append(args, to.UnixNano())
+	}
+	if cursor != "" {
+		n, id, ok := strings.Cut(cursor, ":")
+		at, err := strconv.ParseInt(n, 10, 64)
+		if !ok || err != nil {
+			return nil, nil, "", fmt.Errorf("invalid cursor %s", cursor)
+		}
+		q += ` AND (indexed_at, id) < (?, ?)`
+		args = append(args, at, id)
+	}
+	q += ` ORDER BY indexed_at DESC, id DESC LIMIT ?`
+	args = append(args, limit+1)
+
+	rows, err := r.conn.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	defer rows.Close()
+
+	var (
+		ids []string
+		ats []time.Time
+	)
+	for rows.Next() {
+		var (
+			id string
+			at int64
+		)
+		if err := rows.Scan(&id, &at); err != nil {
+			return nil, nil, "", err
+		}
+		ids, ats = append(ids, id), append(ats, time.Unix(0, at))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, "", err
+	}
+
+	var next string
+	if len(ids) > limit {
+		ids, ats = ids[:limit], ats[:limit]
+		next = strconv.FormatInt(ats[limit-1].UnixNano(), 10) + ":" + ids[limit-1]
+	}
+
+	return ids, ats, next, nil
+}
+
+// SetUnique sets the unique key to the job id if the key isn't held by another job
+// (or the holder's key has expired). If it is, it returns false along with the id of the job holding the key.
+func (r *Results) SetUnique(ctx context.Context, key, id string, ttl time.Duration) (string, bool, error) {
+	r.lo.Debug("setting unique key", "key", key, "id", id)
+
+	var held string
+	err := r.tx(ctx, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, `INSERT INTO tq_unique (key, id, expires_at) VALUES (?, ?, ?)
+			ON CONFLICT (key) DO UPDATE SET id = excluded.id, expires_at = excluded.expires_at
+			WHERE tq_unique.expires_at IS NOT NULL AND tq_unique.expires_at <= ?`,
+			key, id, r.expiresAt(ttl), time.Now().UnixNano())
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 1 {
+			held = id
+			return nil
+		}
+
+		return tx.QueryRowContext(ctx, `SELECT id FROM tq_unique WHERE key = ?`, key).Scan(&held)
+	})
+	if err != nil {
+		return "", false, err
+	}
+
+	return held, held == id, nil
+}
+
+// DeleteUnique releases the unique key, only if it is held by the job id.
+func (r *Results) DeleteUnique(ctx context.Context, key, id string) error {
+	r.lo.Debug("deleting unique key", "key", key, "id", id)
+
+	_, err := r.conn.ExecContext(ctx, `DELETE FROM tq_unique WHERE key = ? AND id = ?`, key, id)
+	return err
+}
+
+// expiresAt returns the expiry timestamp for the duration, or nil if it is zero.
+func (r *Results) expiresAt(d time.Duration) *int64 {
+	if d <= 0 {
+		return nil
+	}
+
+	t := time.Now().Add(d).UnixNano()
+	return &t
+}
+
+// tx runs fn in a transaction, committing it if fn succeeds.
+func (r *Results) tx(ctx context.Context, fn func(*sql.Tx) error) error {
+	tx, err := r.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (r *Results) purge(ctx context.Context, period time.Duration) {
+	defer close(r.done)
+	r.lo.Info("starting results purger", "period", period)
+
+	tk := time.NewTicker(period)
+	defer tk.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			r.lo.Info("shutting down results purger")
+			return
+		case <-tk.C:
+		}
+
+		if r.opts.Expiry != 0 {
+			r.lo.Debug("purging expired results")
+			if _, err := r.conn.ExecContext(ctx, `DELETE FROM tq_results WHERE expires_at < ?`, time.Now().UnixNano()); err != nil {
+				r.lo.Error("could not purge expired results", "error", err)
+			}
+		}
+		if r.opts.MetaExpiry != 0 {
+			r.lo.Debug("purging success/failed results metadata")
+			if _, err := r.conn.ExecContext(ctx, `DELETE FROM tq_status WHERE updated_at < ?`,
+				time.Now().Add(-r.opts.MetaExpiry).UnixNano()); err != nil {
+				r.lo.Error("could not expire success/failed metadata", "error", err)
+			}
+			if _, err := r.conn.ExecContext(ctx, `DELETE FROM tq_jobs WHERE indexed_at < ?`,
+				time.Now().Add(-r.opts.MetaExpiry).UnixNano()); err != nil {
+				r.lo.Error("could not expire indexed jobs", "error", err)
+			}
+		}
+	}
+}
+
+// Close stops the purger and closes the sqlite connection. The results store must not be used
+// after it is closed.
+func (r *Results) Close() error {
+	r.stop()
+	<-r.done
+
+	return r.conn.Close()
+}
@@ -0,0 +1,263 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func newResults(t *testing.T, o Options) *Results {
+	t.Helper()
+	lo := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
+		Level: slog.LevelError,
+	}))
+
+	o.Path = filepath.Join(t.TempDir(), "results.db")
+	r, err := New(o, lo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { r.Close() })
+
+	return r
+}
+
+func TestSetGetDelete(t *testing.T) {
+	var (
+		ctx = context.Background()
+		r   = newResults(t, Options{})
+	)
+
+	if _, err := r.Get(ctx, "a"); !errors.Is(err, r.NilError()) {
+		t.Fatalf("expected %v getting a missing result, got %v", r.NilError(), err)
+	}
+
+	if err := r.Set(ctx, "a", []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	// Setting a result again replaces it.
+	if err := r.Set(ctx, "a", []byte("2")); err != nil {
+		t.Fatal(err)
+	}
+	b, err := r.Get(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "2" {
+		t.Fatalf("incorrect result, expected 2, got %s", b)
+	}
+
+	if err := r.SetBatch(ctx, map[string][]byte{"b": []byte("3"), "c": []byte("4")}); err != nil {
+		t.Fatal(err)
+	}
+	res, err := r.GetMulti(ctx, []string{"a", "b", "c", "missing"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 3 || string(res["a"]) != "2" || string(res["b"]) != "3" || string(res["c"]) != "4" {
+		t.Fatalf("incorrect results: %v", res)
+	}
+
+	if err := r.SetSuccess(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.DeleteJob(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Get(ctx, "a"); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("expected the deleted result to be missing, got %v", err)
+	}
+	ids, err := r.GetSuccess(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 0 {
+		t.Fatalf("expected the status of the deleted job to be deleted, got %v", ids)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	var (
+		ctx = context.Background()
+		r   = newResults(t, Options{})
+	)
+
+	for _, id := range []string{"a", "b", "c"} {
+		if err := r.SetFailed(ctx, id); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	// A job has a single status, its latest.
+	if err := r.SetSuccess(ctx, "b"); err != nil {
+		t.Fatal(err)
+	}
+
+	failed, err := r.GetFailed(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(failed, []string{"c", "a"}) {
+		t.Fatalf("incorrect failed jobs, expected the most recent first: %v", failed)
+	}
+	success, err := r.GetSuccess(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(success, []string{"b"}) {
+		t.Fatalf("incorrect successful jobs: %v", success)
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	var (
+		ctx = context.Background()
+		r   = newResults(t, Options{
+			Expiry:      100 * time.Millisecond,
+			MetaExpiry:  100 * time.Millisecond,
+			PurgePeriod: 50 * time.Millisecond,
+		})
+	)
+
+	if err := r.Set(ctx, "a", []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.SetPersistent(ctx, "p", []byte("2")); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.SetSuccess(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.IndexJob(ctx, "a", "successful", "q", "task", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Get(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(250 * time.Millisecond)
+
+	// Expired results are missing, and are purged along with the metadata.
+	if _, err := r.Get(ctx, "a"); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("expected the result to expire, got %v", err)
+	}
+	if res, err := r.GetMulti(ctx, []string{"a"}); err != nil || len(res) != 0 {
+		t.Fatalf("expected no results, got %v: %v", res, err)
+	}
+	var n int
+	if err := r.conn.QueryRowContext(ctx, `SELECT COUNT(*) FROM tq_results WHERE id = 'a'`).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Fatal("expected the expired result to be purged")
+	}
+	if ids, err := r.GetSuccess(ctx); err != nil || len(ids) != 0 {
+		t.Fatalf("expected the successful jobs to expire, got %v: %v", ids, err)
+	}
+	if ids, _, _, err := r.ListJobs(ctx, "successful", "", 10, "", "", time.Time{}, time.Time{}); err != nil || len(ids) != 0 {
+		t.Fatalf("expected the indexed jobs to expire, got %v: %v", ids, err)
+	}
+
+	// Persistent results don't expire.
+	b, err := r.Get(ctx, "p")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "2" {
+		t.Fatalf("incorrect persistent result: %s", b)
+	}
+}
+
+func TestListJobs(t *testing.T) {
+	var (
+		ctx = context.Background()
+		r   = newResults(t, Options{})
+		at  = time.Now()
+	)
+
+	for i, id := range []string{"a", "b", "c", "d"} {
+		queue := "q1"
+		if id == "c" {
+			queue = "q2"
+		}
+		if err := r.IndexJob(ctx, id, "failed", queue, "task", at.Add(time.Duration(i)*time.Second)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Pages, most recent first.
+	var (
+		all    []string
+		cursor string
+	)
+	for {
+		ids, ats, next, err := r.ListJobs(ctx, "failed", "", 3, cursor, "", time.Time{}, time.Time{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ids) != len(ats) {
+			t.Fatalf("expected a time for each job, got %v and %v", ids, ats)
+		}
+		all = append(all, ids...)
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	if !slices.Equal(all, []string{"d", "c", "b", "a"}) {
+		t.Fatalf("incorrect jobs: %v", all)
+	}
+
+	// Filtered by queue and time.
+	ids, _, _, err := r.ListJobs(ctx, "failed", "q1", 10, "", "task", at.Add(time.Second), time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(ids, []string{"d", "b"}) {
+		t.Fatalf("incorrect filtered jobs: %v", ids)
+	}
+
+	if _, _, _, err := r.ListJobs(ctx, "failed", "", 10, "invalid", "", time.Time{}, time.Time{}); err == nil {
+		t.Fatal("expected error listing jobs with an invalid cursor")
+	}
+}
+
+func TestUnique(t *testing.T) {
+	var (
+		ctx = context.Background()
+		r   = newResults(t, Options{})
+	)
+
+	if held, ok, err := r.SetUnique(ctx, "key", "a", 0); err != nil || !ok || held != "a" {
+		t.Fatalf("expected the key to be set, got %s, %v: %v", held, ok, err)
+	}
+	if held, ok, err := r.SetUnique(ctx, "key", "b", 0); err != nil || ok || held != "a" {
+		t.Fatalf("expected the key to be held by a, got %s, %v: %v", held, ok, err)
+	}
+
+	// The key is only released by its holder.
+	if err := r.DeleteUnique(ctx, "key", "b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err := r.SetUnique(ctx, "key", "b", 0); err != nil || ok {
+		t.Fatalf("expected the key to still be held: %v", err)
+	}
+	if err := r.DeleteUnique(ctx, "key", "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	// An expired key is taken over.
+	if _, ok, err := r.SetUnique(ctx, "key", "b", 50*time.Millisecond); err != nil || !ok {
+		t.Fatalf("expected the key to be set: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if held, ok, err := r.SetUnique(ctx, "key", "c", 0); err != nil || !ok || held != "c" {
+		t.Fatalf("expected the expired key to be taken over, got %s, %v: %v", held, ok, err)
+	}
+}
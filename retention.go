@@ -0,0 +1,169 @@
+package tasqueue
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sort"
+	"time"
+)
+
+// Default interval at which the retention of the queues (ServerOpts.QueueRetention) is enforced.
+const DefaultRetentionInterval = time.Minute
+
+// Retention limits the finished jobs of a queue kept on the results store. The results and messages
+// of the jobs exceeding it are deleted by the server. A zero value disables the respective limit.
+type Retention struct {
+	// KeepSuccessFor and KeepFailedFor are the durations successful and failed jobs are kept for
+	// after they finished.
+	KeepSuccessFor time.Duration
+	KeepFailedFor  time.Duration
+
+	// MaxKeptResults is the maximum number of successful jobs, and of failed jobs, kept. The oldest
+	// jobs are deleted first.
+	MaxKeptResults int
+}
+
+// ApplyRetention() deletes the finished jobs exceeding the retention of their queue, and returns the
+// number of jobs deleted. It is run periodically by Start() if ServerOpts.QueueRetention is set. The
+// jobs are listed with JobLister if the results store implements it, otherwise every successful and
+// failed job is fetched to find its queue.
+func (s *Server) ApplyRetention(ctx context.Context) (int, error) {
+	if len(s.retention) == 0 {
+		return 0, nil
+	}
+
+	var n int
+	for _, st := range []string{StatusDone, StatusFailed} {
+		jobs, err := s.finishedJobs(ctx, st)
+		if err != nil {
+			return n, err
+		}
+
+		for q, entries := range jobs {
+			pol, ok := s.retention[q]
+			if !ok {
+				continue
+			}
+			keep := pol.KeepSuccessFor
+			if st == StatusFailed {
+				keep = pol.KeepFailedFor
+			}
+
+			// Entries are sorted most recent first.
+			before := s.now().Add(-keep)
+			for i, e := range entries {
+				if !(pol.MaxKeptResults > 0 && i >= pol.MaxKeptResults) && !(keep > 0 && e.At.Before(before)) {
+					continue
+				}
+				if err := s.deleteFinishedJob(ctx, e.ID); err != nil {
+					return n, err
+				}
+				n++
+			}
+		}
+	}
+
+	return n, nil
+}
+
+// finishedJobs() returns the jobs that finished in the state on the queues with a retention
+// (queue -> jobs), most recent first.
+func (s *Server) finishedJobs(ctx context.Context, state string) (map[string][]JobEntry, error) {
+	out := make(map[string][]JobEntry, len(s.retention))
+
+	if jl, ok := s.results.(JobLister); ok {
+		for q := range s.retention {
+			var cursor string
+			for {
+				ids, ats, next, err := jl.ListJobs(ctx, state, q, DefaultListLimit, cursor, "", time.Time{}, time.Time{})
+				if err != nil {
+					return nil, err
+				}
+				for i, id := range ids {
+					out[q] = append(out[q], JobEntry{ID: id, At: ats[i]})
+				}
+				if next == "" {
+					break
+				}
+				cursor = next
+			}
+		}
+
+		return out, nil
+	}
+
+	get := s.results.GetSuccess
+	if state == StatusFailed {
+		get = s.results.GetFailed
+	}
+	ids, err := get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		msg, err := s.GetJob(ctx, id)
+		if err != nil {
+			if errors.Is(err, ErrNotFound) {
+				continue
+			}
+			return nil, err
+		}
+		if _, ok := s.retention[msg.Queue]; !ok || msg.Status != state {
+			continue
+		}
+		out[msg.Queue] = append(out[msg.Queue], JobEntry{ID: id, At: msg.ProcessedAt})
+	}
+	for _, entries := range out {
+		sort.SliceStable(entries, func(i, j int) bool {
+			return entries[i].At.After(entries[j].At)
+		})
+	}
+
+	return out, nil
+}
+
+// deleteFinishedJob() deletes the job's result and status, along with its message. The offloaded payload of
+// a failed job, which is kept so that the job can be replayed or requeued from the dead letter queue, is deleted.
+func (s *Server) deleteFinishedJob(ctx context.Context, id string) error {
+	if s.blobs != nil {
+		msg, err := s.GetJob(ctx, id)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+		if err == nil && msg.Job != nil && msg.Status == StatusFailed {
+			s.deleteBlobs(ctx, msg, append(slices.Clone(msg.Job.OnSuccess), msg.Job.OnError...))
+		}
+	}
+
+	// A job that finished before its cancellation was picked up leaves the marker behind.
+	for _, key := range []string{id, cancelPrefix + id} {
+		if err := s.results.DeleteJob(ctx, key); err != nil {
+			return err
+		}
+	}
+
+	return s.results.DeleteJob(ctx, jobPrefix+id)
+}
+
+// enforceRetention() periodically applies the retention of the queues.
+func (s *Server) enforceRetention(ctx context.Context) {
+	tk := time.NewTicker(s.retentionInterval)
+	defer tk.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tk.C:
+			n, err := s.ApplyRetention(ctx)
+			if err != nil {
+				s.log.Error("error applying retention", "error", err)
+				continue
+			}
+			if n > 0 {
+				s.log.Debug("applied retention", "deleted", n)
+			}
+		}
+	}
+}
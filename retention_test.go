@@ -0,0 +1,90 @@
+package tasqueue
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"testing"
+	"time"
+
+	rb "github.com/kalbhor/tasqueue/v2/brokers/in-memory"
+	rr "github.com/kalbhor/tasqueue/v2/results/in-memory"
+)
+
+func TestQueueRetention(t *testing.T) {
+	lo := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
+		Level: slog.LevelError,
+	}))
+
+	for name, results := range map[string]Results{
+		"list":  rr.New(),
+		"fetch": pollResults{rr.New()},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			clock := rb.NewFakeClock(time.Now())
+			srv, err := NewServer(ServerOpts{
+				Broker:  rb.NewWithClock(clock),
+				Results: results,
+				Logger:  lo.Handler(),
+				Clock:   clock,
+				QueueRetention: map[string]Retention{
+					"kept": {KeepSuccessFor: time.Hour, MaxKeptResults: 1},
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, q := range []string{"kept", "other"} {
+				if err := srv.RegisterTask("task-"+q, MockHandler, TaskOpts{Queue: q}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// Enqueues and processes a job of the queue, returning its id.
+			run := func(q string, fail bool) string {
+				job := makeJob(t, "task-"+q, fail)
+				job.Opts.Queue, job.Opts.MaxRetries = q, 0
+				id, err := srv.Enqueue(ctx, job)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err := srv.ProcessAll(ctx); err != nil {
+					t.Fatal(err)
+				}
+				clock.Advance(time.Second)
+				return id
+			}
+
+			var (
+				oldDone   = run("kept", false)
+				oldFailed = run("kept", true)
+				otherDone = run("other", false)
+			)
+			clock.Advance(2 * time.Hour)
+			var (
+				newFailed = run("kept", true)
+				newDone   = run("kept", false)
+			)
+
+			n, err := srv.ApplyRetention(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The old successful job is past KeepSuccessFor, and the old failed job over MaxKeptResults.
+			if n != 2 {
+				t.Fatalf("expected 2 jobs to be deleted, got %d", n)
+			}
+			for _, id := range []string{oldDone, oldFailed} {
+				if _, err := srv.GetJob(ctx, id); err != ErrNotFound {
+					t.Fatalf("expected job %s to be deleted, got %v", id, err)
+				}
+			}
+			for _, id := range []string{otherDone, newFailed, newDone} {
+				if _, err := srv.GetJob(ctx, id); err != nil {
+					t.Fatalf("expected job %s to be kept, got %v", id, err)
+				}
+			}
+		})
+	}
+}
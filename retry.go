@@ -0,0 +1,79 @@
+package tasqueue
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"time"
+)
+
+// RetryStrategy returns the duration to wait before retrying a failed job. attempt is the
+// number of the retry (starting at 1) and err is the error returned by the handler.
+// Jobs are retried right away if the returned duration is not positive.
+type RetryStrategy func(attempt int, err error) time.Duration
+
+// ConstantBackoff returns a RetryStrategy that waits for d before each retry.
+func ConstantBackoff(d time.Duration) RetryStrategy {
+	return func(int, error) time.Duration {
+		return d
+	}
+}
+
+// ExponentialBackoff returns a RetryStrategy that doubles the wait before each retry, starting
+// at base and capped at maxDelay (if non-zero), or at the longest duration otherwise. A random jitter
+// of up to half the wait is subtracted, so that jobs failing together are not retried together.
+func ExponentialBackoff(base, maxDelay time.Duration) RetryStrategy {
+	return func(attempt int, _ error) time.Duration {
+		d := base
+		// The wait stops doubling before it overflows.
+		for i := 1; i < attempt && (maxDelay == 0 || d < maxDelay) && d <= math.MaxInt64/2; i++ {
+			d *= 2
+		}
+		if maxDelay != 0 && d > maxDelay {
+			d = maxDelay
+		}
+		if d <= 1 {
+			return d
+		}
+
+		return d - rand.N(d/2)
+	}
+}
+
+// RetryJob() enqueues a fresh run of a finished (successful, failed, cancelled or expired) job, with
+// the payload and options of the original job, which doesn't have to be in the dead letter queue.
+// The new job gets a new ID and references the original one with Meta.RetryOf. A scheduled job is
+// run once, without its schedule, and the new job doesn't expire (JobOpts.ExpiresAt). It returns the ID of the new job.
+func (s *Server) RetryJob(ctx context.Context, id string) (string, error) {
+	msg, err := s.GetJob(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	if !finished(msg.Status) {
+		return "", fmt.Errorf("job %s is still %s", id, msg.Status)
+	}
+
+	job := *msg.Job
+	job.Opts.ID, job.Opts.ETA, job.Opts.Delay = "", time.Time{}, 0
+	// The expiry is absolute, the fresh run (and its OnSuccess and OnError jobs) would expire right away.
+	for _, j := range jobTree(&job) {
+		j.Opts.ExpiresAt = time.Time{}
+	}
+	// The offloaded payloads are deleted once the original job finishes, they are stored again for the new job.
+	if err := s.inlinePayloads(ctx, &job); err != nil {
+		return "", fmt.Errorf("could not load payload of job %s : %w", id, err)
+	}
+	if job.Opts.Schedule != "" {
+		// The next run of the schedule is the last OnSuccess job.
+		if n := len(job.OnSuccess); n > 0 {
+			job.OnSuccess = job.OnSuccess[:n-1]
+		}
+		job.Opts.Schedule = ""
+	}
+
+	meta := DefaultMeta(job.Opts)
+	meta.RetryOf = id
+
+	return s.enqueueWithMeta(ctx, job, meta)
+}
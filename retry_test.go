@@ -0,0 +1,158 @@
+package tasqueue
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"os"
+	"testing"
+	"time"
+
+	rb "github.com/kalbhor/tasqueue/v2/brokers/in-memory"
+	rr "github.com/kalbhor/tasqueue/v2/results/in-memory"
+)
+
+func TestExponentialBackoff(t *testing.T) {
+	backoff := ExponentialBackoff(time.Second, 10*time.Second)
+	for attempt, exp := range map[int]time.Duration{
+		1: time.Second,
+		2: 2 * time.Second,
+		3: 4 * time.Second,
+		5: 10 * time.Second,
+		9: 10 * time.Second,
+	} {
+		if d := backoff(attempt, nil); d > exp || d < exp/2 {
+			t.Fatalf("incorrect backoff for attempt %d, expected between %v and %v, got %v", attempt, exp/2, exp, d)
+		}
+	}
+
+	// Without a maximum, the wait doesn't overflow.
+	backoff = ExponentialBackoff(time.Second, 0)
+	for _, attempt := range []int{60, 64, 100} {
+		if d := backoff(attempt, nil); d < math.MaxInt64/4 {
+			t.Fatalf("incorrect backoff for attempt %d, expected at least %v, got %v", attempt, time.Duration(math.MaxInt64/4), d)
+		}
+	}
+}
+
+func TestRetryStrategy(t *testing.T) {
+	var (
+		ctx = context.Background()
+		lo  = slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
+			Level: slog.LevelError,
+		}))
+	)
+	srv, err := NewServer(ServerOpts{
+		Broker:  rb.New(),
+		Results: rr.New(),
+		Logger:  lo.Handler(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.RegisterTask(taskName, MockHandler, TaskOpts{RetryStrategy: ConstantBackoff(time.Second)}); err != nil {
+		t.Fatal(err)
+	}
+	go srv.Start(ctx)
+
+	id, err := srv.Enqueue(ctx, makeJob(t, taskName, true))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The job is retried only after the backoff.
+	time.Sleep(500 * time.Millisecond)
+	msg, err := srv.GetJob(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Status != StatusRetrying {
+		t.Fatalf("incorrect job status, expected %s, got %s", StatusRetrying, msg.Status)
+	}
+
+	time.Sleep(time.Second)
+	if msg, err = srv.GetJob(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Status != StatusFailed || msg.Retried != 1 {
+		t.Fatalf("expected job to fail after a retry, got status %s with %d retries", msg.Status, msg.Retried)
+	}
+}
+
+func TestRetryJob(t *testing.T) {
+	ctx := context.Background()
+	srv := newServer(t, taskName, MockHandler)
+
+	job := makeJob(t, taskName, true)
+	job.Opts.MaxRetries = 0
+	job.Opts.Headers = map[string]string{"tenant": "acme"}
+	id, err := srv.Enqueue(ctx, job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := srv.RetryJob(ctx, id); err == nil {
+		t.Fatal("expected an error retrying a queued job")
+	}
+	if _, err := srv.ProcessAll(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	newID, err := srv.RetryJob(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newID == id {
+		t.Fatal("expected the retried job to get a new id")
+	}
+	msg, err := srv.GetJob(ctx, newID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.RetryOf != id || msg.Status != StatusStarted || string(msg.Job.Payload) != string(job.Payload) ||
+		msg.Job.Opts.Headers["tenant"] != "acme" {
+		t.Fatalf("incorrect retried job: %+v", msg)
+	}
+
+	if _, err := srv.ProcessAll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if msg, err = srv.GetJob(ctx, newID); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Status != StatusFailed {
+		t.Fatalf("incorrect status of the retried job, expected %s, got %s", StatusFailed, msg.Status)
+	}
+
+	if _, err := srv.RetryJob(ctx, "invalid"); err != ErrNotFound {
+		t.Fatalf("expected %v, got %v", ErrNotFound, err)
+	}
+
+	// A job that expired is run again, without expiring.
+	job = makeJob(t, taskName, false)
+	job.Opts.ExpiresAt = time.Now().Add(time.Millisecond)
+	if id, err = srv.Enqueue(ctx, job); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if _, err := srv.ProcessAll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if msg, err = srv.GetJob(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Status != StatusExpired {
+		t.Fatalf("incorrect status of the expiring job, expected %s, got %s", StatusExpired, msg.Status)
+	}
+	if newID, err = srv.RetryJob(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := srv.ProcessAll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if msg, err = srv.GetJob(ctx, newID); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Status != StatusDone {
+		t.Fatalf("incorrect status of the retried expired job, expected %s, got %s", StatusDone, msg.Status)
+	}
+}
@@ -0,0 +1,78 @@
+package tasqueue
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"testing"
+
+	rb "github.com/kalbhor/tasqueue/v2/brokers/in-memory"
+	rr "github.com/kalbhor/tasqueue/v2/results/in-memory"
+)
+
+func TestQueueBrokers(t *testing.T) {
+	lo := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
+		Level: slog.LevelError,
+	}))
+	ctx := context.Background()
+
+	var (
+		fallback = rb.New()
+		bulk     = rb.New()
+	)
+	srv, err := NewServer(ServerOpts{
+		Broker:       fallback,
+		Results:      rr.New(),
+		Logger:       lo.Handler(),
+		QueueBrokers: map[string]Broker{"bulk": bulk},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []string{"fast", "bulk"} {
+		if err := srv.RegisterTask("task-"+q, MockHandler, TaskOpts{Queue: q}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var ids []string
+	for _, q := range []string{"fast", "bulk"} {
+		job := makeJob(t, "task-"+q, false)
+		job.Opts.Queue = q
+		id, err := srv.Enqueue(ctx, job)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+
+	// Each queue's jobs are only on its broker (which doesn't know of the other queue).
+	for _, c := range []struct {
+		b     *rb.Broker
+		queue string
+		want  int
+	}{
+		{fallback, "fast", 1}, {fallback, "bulk", 0}, {bulk, "bulk", 1}, {bulk, "fast", 0},
+	} {
+		p, err := c.b.GetPending(ctx, c.queue)
+		if err != nil && c.want > 0 {
+			t.Fatal(err)
+		}
+		if len(p) != c.want {
+			t.Fatalf("expected %d pending jobs of queue %s, got %d", c.want, c.queue, len(p))
+		}
+	}
+
+	if _, err := srv.ProcessAll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range ids {
+		msg, err := srv.GetJob(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg.Status != StatusDone {
+			t.Fatalf("incorrect status of job %s, expected %s, got %s", id, StatusDone, msg.Status)
+		}
+	}
+}
@@ -0,0 +1,530 @@
+package tasqueue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/robfig/cron/v3"
+)
+
+const (
+	// schedulesKey holds all the registered schedules (schedule id -> schedule).
+	schedulesKey = "schedule:list"
+
+	// Key of the lock guarding updates to the stored schedules.
+	scheduleLockKey = "schedule:lock"
+	scheduleLockTTL = 10 * time.Second
+
+	// Prefix of the locks acquired for each run of a schedule, so that only one server
+	// enqueues the scheduled job.
+	scheduleRunPrefix = "schedule:run:"
+	scheduleRunTTL    = time.Hour
+
+	// Interval at which the servers load the stored schedules, to pick up the schedules
+	// registered, paused or deleted through other servers.
+	scheduleSyncInterval = 10 * time.Second
+
+	// Prefix of the keys holding the time of the last run of each schedule.
+	scheduleLastRunPrefix = "schedule:last:"
+
+	// Maximum number of missed runs enqueued with MisfireFireAllMissed. The latest ones are enqueued.
+	maxMisfires = 100
+)
+
+// MisfirePolicy decides how the runs of a schedule missed while no server was running it (eg: during a
+// downtime) are handled once a server picks it up again. Runs missed while it was paused are always skipped.
+type MisfirePolicy string
+
+const (
+	// MisfireSkip skips the missed runs. It is the default.
+	MisfireSkip MisfirePolicy = "skip"
+	// MisfireFireNow enqueues the job once, for all the missed runs.
+	MisfireFireNow MisfirePolicy = "fire_now"
+	// MisfireFireAllMissed enqueues the job for each missed run, up to the latest 100 runs.
+	MisfireFireAllMissed MisfirePolicy = "fire_all_missed"
+)
+
+// Schedule is a job enqueued periodically, according to a cron spec. Schedules are stored in the
+// results store and run by all the servers, while each run of the job is enqueued only once.
+type Schedule struct {
+	ID string
+	// Spec is a standard cron spec (eg: "*/5 * * * *") or a descriptor (eg: "@hourly").
+	// "@every" intervals start from when each server picks up the schedule, so they only
+	// match up across servers for intervals in seconds.
+	Spec    string
+	Job     Job
+	Paused  bool
+	Misfire MisfirePolicy
+
+	Jitter    time.Duration
+	Blackouts []Blackout
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+type ScheduleOpts struct {
+	// Optional ID passed by client. If empty, Tasqueue generates it.
+	// Registering a schedule with an existing ID replaces it.
+	ID string
+
+	// Misfire is the policy for the runs missed while no server was running the schedule.
+	// Defaults to MisfireSkip.
+	Misfire MisfirePolicy
+
+	// Jitter delays the job of each run by a random duration up to Jitter, so that many schedules
+	// with the same spec don't enqueue their jobs at once.
+	Jitter time.Duration
+
+	// Blackouts are daily windows during which the runs of the schedule are skipped (eg: maintenance windows).
+	Blackouts []Blackout
+}
+
+// Blackout is a daily window, from Start to End ("15:04") in the TZ location (an IANA name, UTC if empty).
+// A window ending before it starts spans midnight, eg: {Start: "22:00", End: "02:00"}.
+type Blackout struct {
+	Start string
+	End   string
+	TZ    string
+
+	// The window's bounds in minutes of the day and its location, parsed by NewSchedule and once
+	// the schedules are loaded from the results store.
+	start, end int
+	loc        *time.Location
+}
+
+// parse() parses the window's bounds and loads its location.
+func (b *Blackout) parse() error {
+	loc, err := time.LoadLocation(b.TZ)
+	if err != nil {
+		return fmt.Errorf("invalid blackout location %s : %w", b.TZ, err)
+	}
+
+	var mins [2]int
+	for i, v := range []string{b.Start, b.End} {
+		t, err := time.Parse("15:04", v)
+		if err != nil {
+			return fmt.Errorf("invalid blackout time %s : %w", v, err)
+		}
+		mins[i] = t.Hour()*60 + t.Minute()
+	}
+	b.start, b.end, b.loc = mins[0], mins[1], loc
+
+	return nil
+}
+
+// contains() reports whether the time is within the window. A window that wasn't parsed contains no time.
+func (b Blackout) contains(at time.Time) bool {
+	if b.loc == nil {
+		return false
+	}
+
+	at = at.In(b.loc)
+	m := at.Hour()*60 + at.Minute()
+	if b.start <= b.end {
+		return m >= b.start && m < b.end
+	}
+	return m >= b.start || m < b.end
+}
+
+// inBlackout() reports whether the run of the schedule at the time is within one of its blackout windows.
+func (sch Schedule) inBlackout(at time.Time) bool {
+	for _, b := range sch.Blackouts {
+		if b.contains(at) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// scheduleEntry is a schedule added to the server's cron.
+type scheduleEntry struct {
+	id        cron.EntryID
+	updatedAt time.Time
+}
+
+// NewSchedule() accepts a cron spec and a job and returns a schedule enqueuing the job. The job is
+// a template, each run enqueues a new job with a new ID.
+func NewSchedule(spec string, j Job, opts ScheduleOpts) (Schedule, error) {
+	if _, err := cron.ParseStandard(spec); err != nil {
+		return Schedule{}, fmt.Errorf("invalid schedule spec : %w", err)
+	}
+	if j.Opts.ID != "" {
+		return Schedule{}, fmt.Errorf("job of a schedule can't have an ID")
+	}
+	if j.Opts.Schedule != "" {
+		return Schedule{}, fmt.Errorf("job of a schedule can't have a schedule")
+	}
+	switch opts.Misfire {
+	case "", MisfireSkip, MisfireFireNow, MisfireFireAllMissed:
+	default:
+		return Schedule{}, fmt.Errorf("invalid misfire policy %s", opts.Misfire)
+	}
+	if opts.Jitter < 0 {
+		return Schedule{}, fmt.Errorf("invalid jitter %s", opts.Jitter)
+	}
+	blackouts := slices.Clone(opts.Blackouts)
+	for i := range blackouts {
+		if err := blackouts[i].parse(); err != nil {
+			return Schedule{}, err
+		}
+	}
+	if opts.ID == "" {
+		opts.ID = uuid.NewString()
+	}
+
+	return Schedule{
+		ID:        opts.ID,
+		Spec:      spec,
+		Job:       j,
+		Misfire:   opts.Misfire,
+		Jitter:    opts.Jitter,
+		Blackouts: blackouts,
+	}, nil
+}
+
+// RegisterSchedule() stores the schedule, which is picked up by all the servers. The results store
+// must implement UniqueResults, which is used to lock the schedules and their runs.
+// Schedules are stored without the results store's expiry if it implements PersistentResults.
+func (s *Server) RegisterSchedule(ctx context.Context, sch Schedule) (string, error) {
+	err := s.updateSchedules(ctx, func(schs map[string]Schedule) error {
+		now := time.Now()
+		sch.CreatedAt, sch.UpdatedAt = now, now
+		if old, ok := schs[sch.ID]; ok {
+			sch.CreatedAt = old.CreatedAt
+		}
+		schs[sch.ID] = sch
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("could not register schedule : %w", err)
+	}
+
+	return sch.ID, nil
+}
+
+// GetSchedules() returns the registered schedules.
+func (s *Server) GetSchedules(ctx context.Context) ([]Schedule, error) {
+	schs, err := s.getSchedules(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]Schedule, 0, len(schs))
+	for _, sch := range schs {
+		out = append(out, sch)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].CreatedAt.Before(out[j].CreatedAt)
+	})
+
+	return out, nil
+}
+
+// PauseSchedule() pauses a schedule, its job isn't enqueued until it is resumed.
+func (s *Server) PauseSchedule(ctx context.Context, id string) error {
+	return s.setSchedulePaused(ctx, id, true)
+}
+
+// ResumeSchedule() resumes a paused schedule. Runs missed while it was paused are skipped.
+func (s *Server) ResumeSchedule(ctx context.Context, id string) error {
+	return s.setSchedulePaused(ctx, id, false)
+}
+
+// DeleteSchedule() deletes a schedule. It does not affect the jobs already enqueued by it.
+func (s *Server) DeleteSchedule(ctx context.Context, id string) error {
+	return s.updateSchedules(ctx, func(schs map[string]Schedule) error {
+		if _, ok := schs[id]; !ok {
+			return ErrNotFound
+		}
+		delete(schs, id)
+		return nil
+	})
+}
+
+func (s *Server) setSchedulePaused(ctx context.Context, id string, paused bool) error {
+	return s.updateSchedules(ctx, func(schs map[string]Schedule) error {
+		sch, ok := schs[id]
+		if !ok {
+			return ErrNotFound
+		}
+		sch.Paused = paused
+		sch.UpdatedAt = time.Now()
+		schs[id] = sch
+		return nil
+	})
+}
+
+// updateSchedules() updates the stored schedules with fn under the schedules lock, and
+// notifies the server's scheduler of the update.
+func (s *Server) updateSchedules(ctx context.Context, fn func(map[string]Schedule) error) error {
+	ur, ok := s.results.(UniqueResults)
+	if !ok {
+		return fmt.Errorf("results store does not support schedules")
+	}
+
+	// Acquire the lock, waiting for other servers updating the schedules.
+	token := uuid.NewString()
+	for {
+		_, ok, err := ur.SetUnique(ctx, scheduleLockKey, token, scheduleLockTTL)
+		if err != nil {
+			return fmt.Errorf("could not acquire schedules lock : %w", err)
+		}
+		if ok {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+	defer func() {
+		if err := ur.DeleteUnique(ctx, scheduleLockKey, token); err != nil {
+			s.log.Error("could not release schedules lock", "error", err)
+		}
+	}()
+
+	schs, err := s.getSchedules(ctx)
+	if err != nil {
+		return err
+	}
+	if err := fn(schs); err != nil {
+		return err
+	}
+
+	b, err := s.codec.marshal(schs)
+	if err != nil {
+		return err
+	}
+	if err := s.setPersistent(ctx, schedulesKey, b); err != nil {
+		return err
+	}
+
+	// Notify the scheduler, without blocking if it isn't running.
+	select {
+	case s.scheduleSync <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// setPersistent() stores the value without the results store's expiry, if the store supports it.
+func (s *Server) setPersistent(ctx context.Context, id string, b []byte) error {
+	if pr, ok := s.results.(PersistentResults); ok {
+		return pr.SetPersistent(ctx, id, b)
+	}
+
+	return setMeta(ctx, s.results, id, b)
+}
+
+func (s *Server) getSchedules(ctx context.Context) (map[string]Schedule, error) {
+	schs := make(map[string]Schedule)
+	b, err := s.getResult(ctx, schedulesKey)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return schs, nil
+		}
+		return nil, err
+	}
+
+	if err := s.codec.unmarshal(b, &schs); err != nil {
+		return nil, err
+	}
+	for id, sch := range schs {
+		for i := range sch.Blackouts {
+			if err := sch.Blackouts[i].parse(); err != nil {
+				s.log.Error("invalid blackout of schedule", "id", id, "error", err)
+			}
+		}
+	}
+
+	return schs, nil
+}
+
+// runSchedules() keeps the server's cron in sync with the stored schedules. It is a blocking function.
+func (s *Server) runSchedules(ctx context.Context) {
+	tk := time.NewTicker(scheduleSyncInterval)
+	defer tk.Stop()
+
+	for {
+		if err := s.syncSchedules(ctx); err != nil {
+			s.log.Error("error syncing schedules", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-tk.C:
+		case <-s.scheduleSync:
+		}
+	}
+}
+
+// syncSchedules() adds the active stored schedules to the server's cron, replacing the
+// ones that were updated, and removes the paused and deleted ones.
+func (s *Server) syncSchedules(ctx context.Context) error {
+	schs, err := s.getSchedules(ctx)
+	if err != nil {
+		return err
+	}
+
+	for id, e := range s.scheduleEntries {
+		if sch, ok := schs[id]; !ok || sch.Paused || !sch.UpdatedAt.Equal(e.updatedAt) {
+			s.cron.Remove(e.id)
+			delete(s.scheduleEntries, id)
+		}
+	}
+
+	for id, sch := range schs {
+		if _, ok := s.scheduleEntries[id]; ok || sch.Paused {
+			continue
+		}
+
+		if err := s.catchUp(ctx, sch); err != nil {
+			s.log.Error("could not enqueue missed runs of schedule", "id", id, "error", err)
+		}
+		eid, err := s.cron.AddFunc(sch.Spec, func() { s.runSchedule(ctx, sch) })
+		if err != nil {
+			s.log.Error("invalid schedule spec", "id", id, "spec", sch.Spec, "error", err)
+			continue
+		}
+		s.scheduleEntries[id] = scheduleEntry{id: eid, updatedAt: sch.UpdatedAt}
+	}
+
+	return nil
+}
+
+// runSchedule() enqueues the schedule's job if it is still active and this server acquires
+// the lock of the run.
+// Runs are identified by the second they fire at, which is the same across servers as the
+// cron fires at the scheduled time on each.
+func (s *Server) runSchedule(ctx context.Context, sch Schedule) {
+	at := time.Now().Truncate(time.Second)
+
+	// Skip the run if the schedule was paused, deleted or updated through another server
+	// and this server hasn't synced it yet.
+	schs, err := s.getSchedules(ctx)
+	if err != nil {
+		s.log.Error("could not get schedules", "error", err)
+		return
+	}
+	if cur, ok := schs[sch.ID]; !ok || cur.Paused || !cur.UpdatedAt.Equal(sch.UpdatedAt) {
+		return
+	}
+
+	if err := s.fireSchedule(ctx, sch, at); err != nil {
+		s.log.Error("could not enqueue scheduled job", "schedule", sch.ID, "error", err)
+	}
+}
+
+// catchUp() enqueues the runs of the schedule missed since its last run (or since it was registered,
+// updated or resumed), according to its misfire policy.
+func (s *Server) catchUp(ctx context.Context, sch Schedule) error {
+	if sch.Misfire == "" || sch.Misfire == MisfireSkip {
+		return nil
+	}
+	spec, err := cron.ParseStandard(sch.Spec)
+	if err != nil {
+		return err
+	}
+
+	since := sch.UpdatedAt
+	last, err := s.lastRun(ctx, sch.ID)
+	if err != nil {
+		return err
+	}
+	if last.After(since) {
+		since = last
+	}
+
+	var (
+		now    = time.Now()
+		missed []time.Time
+	)
+	for at := spec.Next(since); !at.After(now); at = spec.Next(at) {
+		if sch.inBlackout(at) {
+			continue
+		}
+		missed = append(missed, at)
+		if len(missed) > maxMisfires {
+			missed = missed[1:]
+		}
+	}
+	if len(missed) == 0 {
+		return nil
+	}
+	if sch.Misfire == MisfireFireNow {
+		missed = missed[len(missed)-1:]
+	}
+
+	s.log.Info("enqueuing missed runs of schedule", "id", sch.ID, "runs", len(missed))
+	for _, at := range missed {
+		if err := s.fireSchedule(ctx, sch, at); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// fireSchedule() enqueues the run of the schedule at the time, if this server acquires its lock,
+// and records it as the schedule's last run. Runs within the schedule's blackout windows are skipped.
+func (s *Server) fireSchedule(ctx context.Context, sch Schedule, at time.Time) error {
+	if sch.inBlackout(at) {
+		s.log.Debug("skipping scheduled run in blackout window", "schedule", sch.ID, "at", at)
+		return nil
+	}
+	key := scheduleRunPrefix + sch.ID + ":" + strconv.FormatInt(at.Unix(), 10)
+
+	_, ok, err := s.results.(UniqueResults).SetUnique(ctx, key, sch.ID, scheduleRunTTL)
+	if err != nil {
+		return fmt.Errorf("could not acquire schedule run lock : %w", err)
+	}
+	if !ok {
+		return nil
+	}
+
+	j := sch.Job
+	if sch.Jitter > 0 {
+		j.Opts.Delay += rand.N(sch.Jitter)
+	}
+	id, err := s.Enqueue(ctx, j)
+	if err != nil {
+		return err
+	}
+	s.log.Debug("enqueued scheduled job", "schedule", sch.ID, "id", id)
+
+	b, err := s.codec.marshal(at)
+	if err != nil {
+		return err
+	}
+	return s.setPersistent(ctx, scheduleLastRunPrefix+sch.ID, b)
+}
+
+// lastRun() returns the time of the last run of the schedule, or zero if it hasn't run.
+func (s *Server) lastRun(ctx context.Context, id string) (time.Time, error) {
+	b, err := s.getResult(ctx, scheduleLastRunPrefix+id)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return time.Time{}, nil
+		}
+		return time.Time{}, err
+	}
+
+	var at time.Time
+	if err := s.codec.unmarshal(b, &at); err != nil {
+		return time.Time{}, err
+	}
+
+	return at, nil
+}
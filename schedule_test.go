@@ -0,0 +1,291 @@
+package tasqueue
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	rb "github.com/kalbhor/tasqueue/v2/brokers/in-memory"
+	rr "github.com/kalbhor/tasqueue/v2/results/in-memory"
+	"github.com/kalbhor/tasqueue/v2/results/sqlite"
+)
+
+func TestSchedule(t *testing.T) {
+	var (
+		ctx, cancel = context.WithCancel(context.Background())
+		runs        atomic.Int32
+		lo          = slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
+			Level: slog.LevelError,
+		}))
+		opts = ServerOpts{
+			Broker:  rb.New(),
+			Results: rr.New(),
+			Logger:  lo.Handler(),
+		}
+	)
+	defer cancel()
+
+	// Both the servers run the schedule, while each run should be enqueued once.
+	var srvs []*Server
+	for i := 0; i < 2; i++ {
+		srv, err := NewServer(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := srv.RegisterTask(taskName, func([]byte, JobCtx) error {
+			runs.Add(1)
+			return nil
+		}, TaskOpts{}); err != nil {
+			t.Fatal(err)
+		}
+		srvs = append(srvs, srv)
+	}
+
+	j, err := NewJob(taskName, nil, JobOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sch, err := NewSchedule("@every 1s", j, ScheduleOpts{ID: "every-second"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := srvs[0].RegisterSchedule(ctx, sch); err != nil {
+		t.Fatal(err)
+	}
+	for _, srv := range srvs {
+		go srv.Start(ctx)
+	}
+
+	time.Sleep(3500 * time.Millisecond)
+	if n := runs.Load(); n < 2 || n > 4 {
+		t.Fatalf("expected 3 runs of the schedule, got %d", n)
+	}
+
+	schs, err := srvs[1].GetSchedules(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(schs) != 1 || schs[0].ID != "every-second" {
+		t.Fatalf("incorrect schedules: %v", schs)
+	}
+
+	// Pausing the schedule stops the runs on all the servers.
+	if err := srvs[0].PauseSchedule(ctx, sch.ID); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	n := runs.Load()
+	time.Sleep(1500 * time.Millisecond)
+	if runs.Load() != n {
+		t.Fatal("paused schedule was run")
+	}
+
+	if err := srvs[0].DeleteSchedule(ctx, sch.ID); err != nil {
+		t.Fatal(err)
+	}
+	if schs, err = srvs[0].GetSchedules(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(schs) != 0 {
+		t.Fatalf("expected no schedules, got %v", schs)
+	}
+	if err := srvs[0].DeleteSchedule(ctx, sch.ID); err != ErrNotFound {
+		t.Fatalf("expected %v deleting a missing schedule, got %v", ErrNotFound, err)
+	}
+
+	if _, err := NewSchedule("not a spec", j, ScheduleOpts{}); err == nil {
+		t.Fatal("expected error creating schedule with an invalid spec")
+	}
+}
+
+func TestScheduleExpiry(t *testing.T) {
+	var (
+		ctx = context.Background()
+		lo  = slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
+			Level: slog.LevelError,
+		}))
+	)
+
+	// Schedules are kept regardless of the expiry of the results.
+	res, err := sqlite.New(sqlite.Options{Path: t.TempDir() + "/results.db", Expiry: 100 * time.Millisecond}, lo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Close()
+	srv, err := NewServer(ServerOpts{
+		Broker:  rb.New(),
+		Results: res,
+		Logger:  lo.Handler(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sch, err := NewSchedule("@every 1h", makeJob(t, taskName, false), ScheduleOpts{ID: "hourly"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := srv.RegisterSchedule(ctx, sch); err != nil {
+		t.Fatal(err)
+	}
+	if err := res.Set(ctx, "result", []byte("result")); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	if _, err := res.Get(ctx, "result"); err == nil {
+		t.Fatal("expected the result to expire")
+	}
+	schs, err := srv.GetSchedules(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(schs) != 1 || schs[0].ID != "hourly" {
+		t.Fatalf("incorrect schedules: %v", schs)
+	}
+}
+
+func TestScheduleMisfire(t *testing.T) {
+	lo := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
+		Level: slog.LevelError,
+	}))
+
+	for policy, exp := range map[MisfirePolicy]int32{
+		MisfireSkip:          0,
+		MisfireFireNow:       1,
+		MisfireFireAllMissed: 5,
+	} {
+		t.Run(string(policy), func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			var enqueued atomic.Int32
+			srv, err := NewServer(ServerOpts{
+				Broker:  rb.New(),
+				Results: rr.New(),
+				Logger:  lo.Handler(),
+				Hooks: Hooks{OnJobEnqueued: func(context.Context, JobMessage) {
+					enqueued.Add(1)
+				}},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := srv.RegisterTask(taskName, MockHandler, TaskOpts{}); err != nil {
+				t.Fatal(err)
+			}
+
+			j, err := NewJob(taskName, nil, JobOpts{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			sch, err := NewSchedule("@every 1m", j, ScheduleOpts{ID: "every-minute", Misfire: policy})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := srv.RegisterSchedule(ctx, sch); err != nil {
+				t.Fatal(err)
+			}
+
+			// Simulate a downtime during which 5 runs were missed.
+			if err := srv.updateSchedules(ctx, func(schs map[string]Schedule) error {
+				s := schs[sch.ID]
+				s.UpdatedAt = time.Now().Add(-5*time.Minute - 30*time.Second)
+				schs[sch.ID] = s
+				return nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			go srv.Start(ctx)
+			time.Sleep(time.Second)
+
+			if n := enqueued.Load(); n != exp {
+				t.Fatalf("incorrect number of missed runs enqueued, expected %d, got %d", exp, n)
+			}
+			last, err := srv.lastRun(ctx, sch.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if exp > 0 && time.Since(last) > time.Minute {
+				t.Fatalf("last run not recorded, got %v", last)
+			}
+		})
+	}
+}
+
+func TestScheduleBlackout(t *testing.T) {
+	lo := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
+		Level: slog.LevelError,
+	}))
+	ctx := context.Background()
+
+	var (
+		clock = rb.NewFakeClock(time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC))
+		etas  []time.Time
+	)
+	srv, err := NewServer(ServerOpts{
+		Broker:  rb.NewWithClock(clock),
+		Results: rr.New(),
+		Logger:  lo.Handler(),
+		Clock:   clock,
+		Hooks: Hooks{OnJobEnqueued: func(_ context.Context, msg JobMessage) {
+			etas = append(etas, msg.Job.Opts.ETA)
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	j, err := NewJob(taskName, nil, JobOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewSchedule("@hourly", j, ScheduleOpts{Blackouts: []Blackout{{Start: "25:00", End: "02:00"}}}); err == nil {
+		t.Fatal("expected an invalid blackout to be rejected")
+	}
+	if _, err := NewSchedule("@hourly", j, ScheduleOpts{Blackouts: []Blackout{{Start: "22:00", End: "02:00", TZ: "Mars/Olympus"}}}); err == nil {
+		t.Fatal("expected a blackout with an invalid location to be rejected")
+	}
+	sch, err := NewSchedule("@hourly", j, ScheduleOpts{
+		Jitter:    time.Minute,
+		Blackouts: []Blackout{{Start: "22:00", End: "02:00"}, {Start: "09:00", End: "10:00", TZ: "Europe/Paris"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, at := range []time.Time{
+		time.Date(2026, 1, 1, 23, 0, 0, 0, time.UTC),
+		time.Date(2026, 1, 2, 1, 59, 0, 0, time.UTC),
+		time.Date(2026, 1, 2, 8, 0, 0, 0, time.UTC),
+		time.Date(2026, 1, 2, 12, 0, 0, 0, time.UTC),
+	} {
+		if err := srv.fireSchedule(ctx, sch, at); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The windows are parsed again once the schedule is loaded from the results store.
+	if _, err := srv.RegisterSchedule(ctx, sch); err != nil {
+		t.Fatal(err)
+	}
+	schs, err := srv.GetSchedules(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(schs) != 1 || !schs[0].inBlackout(time.Date(2026, 1, 2, 8, 30, 0, 0, time.UTC)) {
+		t.Fatalf("expected the loaded schedule to keep its blackout windows, got %v", schs)
+	}
+
+	// Only the run at 12:00 is out of the blackout windows, and is delayed by the jitter.
+	if len(etas) != 1 {
+		t.Fatalf("expected 1 run to be enqueued, got %d", len(etas))
+	}
+	if now := clock.Now(); etas[0].Before(now) || !etas[0].Before(now.Add(time.Minute)) {
+		t.Fatalf("expected the run to be delayed by up to a minute, got %v", etas[0].Sub(now))
+	}
+}
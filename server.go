@@ -6,13 +6,16 @@ import (
 	"fmt"
 	"log/slog"
 	"runtime"
+	"runtime/debug"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/robfig/cron/v3"
-	"github.com/vmihailenco/msgpack/v5"
 	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
 	"go.opentelemetry.io/otel/sdk/trace"
 	spans "go.opentelemetry.io/otel/trace"
 )
@@ -34,22 +37,37 @@ const (
 	// This state is analogous to statusStarted.
 	StatusRetrying = "retrying"
 
+	// The state when a job is cancelled, before or while being processed.
+	StatusCancelled = "cancelled"
+
+	// The state when a job is discarded as it was consumed past its expiry (JobOpts.ExpiresAt).
+	StatusExpired = "expired"
+
 	// name used to identify this instrumentation library.
 	tracer = "tasqueue"
+
+	// Interval at which queue depths are reported to the metrics collector.
+	queueDepthInterval = 15 * time.Second
 )
 
-// handler represents a function that can accept arbitrary payload
+// Handler represents a function that can accept arbitrary payload
 // and process it in any manner. A job ctx is passed, which allows the handler access to job details
 // and lets it save arbitrary results using JobCtx.Save()
-type handler func([]byte, JobCtx) error
+type Handler func([]byte, JobCtx) error
 
 // Task is a pre-registered job handler. It stores the callbacks (set through options), which are
 // called during different states of a job.
 type Task struct {
 	name    string
-	handler handler
+	handler Handler
 
 	opts TaskOpts
+
+	// sem holds a slot for each job of the task being processed, if MaxConcurrency is set.
+	sem chan struct{}
+
+	// breaker is the state of the task's circuit breaker, if CircuitBreaker is set.
+	breaker *breaker
 }
 
 type TaskOpts struct {
@@ -59,13 +77,58 @@ type TaskOpts struct {
 	ProcessingCB func(JobCtx)
 	RetryingCB   func(JobCtx, error)
 	FailedCB     func(JobCtx, error)
+
+	// RateLimit limits the rate at which jobs of the task are processed.
+	RateLimit RateLimit
+
+	// MaxConcurrency, if non-zero, caps the jobs of the task processed at once by the server, independently
+	// of the queue's Concurrency. GlobalConcurrency caps them across all the servers sharing the broker,
+	// which must implement SemaphoreBroker. Jobs consumed while the task is at its limit are put back
+	// onto the queue with a short delay, so that the queue's processors are free to process other tasks.
+	MaxConcurrency    uint32
+	GlobalConcurrency uint32
+
+	// CircuitBreaker stops processing the jobs of the task on the server for a cooldown, after
+	// consecutive failures.
+	CircuitBreaker CircuitBreaker
+
+	// Timeout is the maximum duration a job of the task is processed for, after which its context
+	// is cancelled and the job fails (and is retried, if it has retries left). The processor is freed
+	// even if the handler doesn't return. It is overridden by JobOpts.Timeout, if set.
+	Timeout time.Duration
+
+	// RetryStrategy returns the delay before a failed job is retried, eg: ExponentialBackoff().
+	// Delayed retries are scheduled on the broker, which must support EnqueueScheduled.
+	// If nil, failed jobs are retried right away.
+	RetryStrategy RetryStrategy
+
+	// Middleware wraps the task's handler, the first being the outermost. It runs inside
+	// ServerOpts.Middleware.
+	Middleware []func(Handler) Handler
 }
 
 // RegisterTask maps a new task against the tasks map on the server.
 // It accepts different options for the task (to set callbacks).
-func (s *Server) RegisterTask(name string, fn handler, opts TaskOpts) error {
+func (s *Server) RegisterTask(name string, fn Handler, opts TaskOpts) error {
 	s.log.Debug("registered handler", "name", name, "options", opts)
 
+	fn = wrapHandler(wrapHandler(fn, opts.Middleware), s.middleware)
+
+	if opts.GlobalConcurrency > 0 {
+		if _, ok := s.broker.(SemaphoreBroker); !ok {
+			return fmt.Errorf("broker does not support global concurrency limits")
+		}
+	}
+	var sem chan struct{}
+	if opts.MaxConcurrency > 0 {
+		sem = make(chan struct{}, opts.MaxConcurrency)
+	}
+
+	var br *breaker
+	if opts.CircuitBreaker.Threshold > 0 {
+		br = &breaker{opts: opts.CircuitBreaker}
+	}
+
 	if opts.Queue == "" {
 		opts.Queue = DefaultQueue
 	}
@@ -78,7 +141,7 @@ func (s *Server) RegisterTask(name string, fn handler, opts TaskOpts) error {
 	s.q.RUnlock()
 	if !ok {
 		s.registerQueue(opts.Queue, opts.Concurrency)
-		s.registerHandler(name, Task{name: name, handler: fn, opts: opts})
+		s.registerHandler(name, Task{name: name, handler: fn, opts: opts, sem: sem, breaker: br})
 
 		return nil
 
@@ -87,7 +150,7 @@ func (s *Server) RegisterTask(name string, fn handler, opts TaskOpts) error {
 	// If the queue is already defined and the passed concurrency optional
 	// is same (it can be default queue/conc) so simply register the handler
 	if opts.Concurrency == conc {
-		s.registerHandler(name, Task{name: name, handler: fn, opts: opts})
+		s.registerHandler(name, Task{name: name, handler: fn, opts: opts, sem: sem, breaker: br})
 		return nil
 	}
 
@@ -95,21 +158,80 @@ func (s *Server) RegisterTask(name string, fn handler, opts TaskOpts) error {
 	return fmt.Errorf("queue is already defined with %d concurrency", conc)
 }
 
+// wrapHandler() wraps the handler with the middleware, the first being the outermost.
+func wrapHandler(fn Handler, mw []func(Handler) Handler) Handler {
+	for i := len(mw) - 1; i >= 0; i-- {
+		fn = mw[i](fn)
+	}
+
+	return fn
+}
+
 // Server is the main store that holds the broker and the results communication interfaces.
 // It also stores the registered tasks.
 type Server struct {
-	log       *slog.Logger
-	broker    Broker
-	results   Results
-	cron      *cron.Cron
-	traceProv *trace.TracerProvider
+	log    *slog.Logger
+	broker Broker
+	// queueBrokers holds the brokers of the queues not routed to the default broker (queue name -> broker).
+	queueBrokers map[string]Broker
+	results      Results
+	cron         *cron.Cron
+	traceProv    *trace.TracerProvider
+	propagator   propagation.TextMapPropagator
+	metrics      MetricsCollector
+	codec        *codec
+	enc          Encrypter
+	middleware   []func(Handler) Handler
+	hooks        Hooks
 
 	p      sync.RWMutex
 	tasks  map[string]Task
 	q      sync.RWMutex
 	queues map[string]uint32
 
-	defaultConc int
+	defaultConc     int
+	deadQueue       string
+	shutdownTimeout time.Duration
+	clock           Clock
+
+	queueLimits map[string]RateLimit
+	rl          sync.Mutex
+	buckets     map[string]*bucket
+
+	// scalers holds the scalers of the autoscaled queues (queue name -> scaler).
+	scalers map[string]*scaler
+
+	w         sync.RWMutex
+	workflows map[string]Workflow
+
+	// scheduleEntries holds the schedules added to cron (schedule id -> entry). It is only
+	// accessed by the scheduler, which is notified of updates on scheduleSync.
+	scheduleEntries map[string]scheduleEntry
+	scheduleSync    chan struct{}
+
+	// paused holds the queues paused on this server, if the broker doesn't implement PauseBroker.
+	// tripped holds the queues paused on this server by the circuit breakers of their tasks, until the time.
+	pm      sync.Mutex
+	paused  map[string]bool
+	tripped map[string]time.Time
+
+	// running holds the cancel funcs of the jobs being processed (job id -> cancel).
+	rm      sync.Mutex
+	running map[string]context.CancelCauseFunc
+
+	events   EventsStore
+	workerID string
+	tags     []string
+
+	heartbeatInterval time.Duration
+	stuckTimeout      time.Duration
+	requeueStuck      bool
+
+	offloadThreshold int
+	blobs            BlobStore
+
+	retention         map[string]Retention
+	retentionInterval time.Duration
 }
 
 type ServerOpts struct {
@@ -117,6 +239,100 @@ type ServerOpts struct {
 	Results       Results
 	Logger        slog.Handler
 	TraceProvider *trace.TracerProvider
+
+	// TracePropagator is used to carry the trace context from the enqueuer of a job onto the worker
+	// processing it, so that its processing span is a child of the enqueue span.
+	// Defaults to the W3C trace context and baggage propagators.
+	TracePropagator propagation.TextMapPropagator
+
+	// MetricsCollector optionally receives metrics of the jobs processed by the server.
+	MetricsCollector MetricsCollector
+
+	// QueueBrokers routes queues onto other brokers than Broker (queue name -> broker), eg: a latency
+	// sensitive queue on redis and a bulk queue on SQS. Jobs of the queues are enqueued onto and
+	// consumed from their broker. Broker holds the state shared by the servers (rate limits and
+	// global concurrency limits) for all the queues.
+	QueueBrokers map[string]Broker
+
+	// DeadLetterQueue is the queue that jobs are pushed onto after exhausting their retries.
+	// This queue is not consumed. If empty, failed jobs are only marked as failed.
+	DeadLetterQueue string
+
+	// QueueRateLimits limits the rate at which jobs are processed on each queue (queue name -> limit).
+	// The limits are enforced across all servers if the broker implements RateLimitBroker,
+	// otherwise they are enforced per server.
+	QueueRateLimits map[string]RateLimit
+
+	// QueueRetention limits the finished jobs of each queue (queue name -> retention) kept on the
+	// results store. It is enforced by every server every RetentionInterval (defaults to
+	// DefaultRetentionInterval), regardless of the results store's own expiry.
+	QueueRetention    map[string]Retention
+	RetentionInterval time.Duration
+
+	// QueueAutoscaling scales the number of workers of each queue (queue name -> autoscaling) based on
+	// its depth and processing latency. It overrides the concurrency of the queue's tasks.
+	QueueAutoscaling map[string]Autoscaling
+
+	// Middleware wraps the handlers of all the tasks registered on the server (eg: for logging or
+	// metrics), the first being the outermost.
+	Middleware []func(Handler) Handler
+
+	// Hooks are called on the lifecycle events of all the jobs processed by the server.
+	Hooks Hooks
+
+	// Codec serializes the job messages and metadata. Defaults to MsgpackCodec.
+	Codec Codec
+
+	// Compression optionally compresses the serialized job messages and metadata above a size threshold.
+	Compression Compression
+
+	// Encrypter optionally encrypts the job messages (including their payloads), metadata and results
+	// before they are stored on the broker and results store, eg: NewAESEncrypter().
+	Encrypter Encrypter
+
+	// Namespace prefixes all the keys of the broker and results store, which must implement Namespacer,
+	// so that multiple applications (or environments) can share them without collisions.
+	Namespace string
+
+	// Clock is the clock jobs are scheduled with. Defaults to the system clock.
+	Clock Clock
+
+	// Events optionally records every state transition of the jobs, as an audit trail.
+	Events EventsStore
+
+	// WorkerID identifies the server in the events recorded by it and in the jobs it processes (Meta.Worker).
+	// Defaults to "<hostname>-<pid>".
+	WorkerID string
+
+	// Tags are the capabilities of the server (eg: "gpu", "region=eu"). Jobs requiring tags (JobOpts.Tags)
+	// are routed onto a sub-queue of their queue per set of tags, and are only processed by the servers having
+	// all of them. The server consumes the sub-queue of every set of its tags, along with the queue itself.
+	// Up to 6 tags can be set.
+	Tags []string
+
+	// HeartbeatInterval is the interval at which the server records heartbeats for the jobs it
+	// processes, so that the jobs of crashed workers can be found with GetStuckJobs(). The results
+	// store must implement JobLister. Defaults to StuckJobTimeout / 3 if it is set, otherwise
+	// heartbeats are disabled.
+	HeartbeatInterval time.Duration
+
+	// StuckJobTimeout, if non-zero, runs a watchdog flagging the jobs whose last heartbeat is older
+	// than it (calling Hooks.OnJobStuck). If RequeueStuckJobs is set, the jobs are also pushed back
+	// onto their queue. Brokers implementing AckBroker redeliver the messages of crashed workers on
+	// their own, so requeuing is mostly useful with the other brokers.
+	StuckJobTimeout  time.Duration
+	RequeueStuckJobs bool
+
+	// PayloadOffloadThreshold, if non-zero, is the size in bytes above which the payloads of the jobs
+	// enqueued are stored on BlobStore, and only a reference to them is pushed onto the broker. The
+	// payloads are loaded before the handlers are run. BlobStore defaults to the results store.
+	PayloadOffloadThreshold int
+	BlobStore               BlobStore
+
+	// ShutdownTimeout is how long Start() waits for the jobs being processed to finish once its context
+	// is cancelled. The jobs still running after it are interrupted and requeued. If zero, the jobs
+	// being processed are interrupted and requeued right away.
+	ShutdownTimeout time.Duration
 }
 
 // NewServer() returns a new instance of server, with sane defaults.
@@ -124,25 +340,126 @@ func NewServer(o ServerOpts) (*Server, error) {
 	if o.Broker == nil {
 		return nil, fmt.Errorf("broker missing in options")
 	}
+	for q, b := range o.QueueBrokers {
+		if b == nil {
+			return nil, fmt.Errorf("broker of queue %s is missing", q)
+		}
+	}
 	if o.Results == nil {
 		return nil, fmt.Errorf("results missing in options")
 	}
 	if o.Logger == nil {
 		o.Logger = slog.Default().Handler()
 	}
+	if o.WorkerID == "" {
+		o.WorkerID = defaultWorkerID()
+	}
+	o.Tags = normTags(o.Tags)
+	if err := validTags(o.Tags); err != nil {
+		return nil, err
+	}
+	if len(o.Tags) > maxTags {
+		return nil, fmt.Errorf("more than %d tags set", maxTags)
+	}
+	if o.HeartbeatInterval == 0 && o.StuckJobTimeout > 0 {
+		o.HeartbeatInterval = o.StuckJobTimeout / 3
+	}
+	if o.RetentionInterval == 0 {
+		o.RetentionInterval = DefaultRetentionInterval
+	}
+	if o.PayloadOffloadThreshold > 0 && o.BlobStore == nil {
+		o.BlobStore = resultsBlobs{o.Results}
+	}
+	if o.HeartbeatInterval > 0 {
+		if _, ok := o.Results.(JobLister); !ok {
+			return nil, fmt.Errorf("results store does not support heartbeats")
+		}
+	}
+	if o.TracePropagator == nil {
+		o.TracePropagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+	}
+	if o.Namespace != "" {
+		bn, ok := o.Broker.(Namespacer)
+		if !ok {
+			return nil, fmt.Errorf("broker does not support namespaces")
+		}
+		rn, ok := o.Results.(Namespacer)
+		if !ok {
+			return nil, fmt.Errorf("results store does not support namespaces")
+		}
+		for q, b := range o.QueueBrokers {
+			qn, ok := b.(Namespacer)
+			if !ok {
+				return nil, fmt.Errorf("broker of queue %s does not support namespaces", q)
+			}
+			qn.SetNamespace(o.Namespace)
+		}
+		bn.SetNamespace(o.Namespace)
+		rn.SetNamespace(o.Namespace)
+	}
+	cd, err := newCodec(o.Codec, o.Compression, o.Encrypter)
+	if err != nil {
+		return nil, err
+	}
+	scalers := make(map[string]*scaler, len(o.QueueAutoscaling))
+	for q, a := range o.QueueAutoscaling {
+		if scalers[q], err = newScaler(q, a); err != nil {
+			return nil, err
+		}
+	}
 
 	return &Server{
-		traceProv:   o.TraceProvider,
-		log:         slog.New(o.Logger),
-		cron:        cron.New(),
-		broker:      o.Broker,
-		results:     o.Results,
-		tasks:       make(map[string]Task),
-		defaultConc: runtime.GOMAXPROCS(0),
-		queues:      make(map[string]uint32),
+		traceProv:    o.TraceProvider,
+		propagator:   o.TracePropagator,
+		metrics:      o.MetricsCollector,
+		codec:        cd,
+		enc:          o.Encrypter,
+		middleware:   o.Middleware,
+		hooks:        o.Hooks,
+		log:          slog.New(o.Logger),
+		cron:         cron.New(),
+		broker:       o.Broker,
+		queueBrokers: o.QueueBrokers,
+		results:      o.Results,
+		tasks:        make(map[string]Task),
+		defaultConc:  runtime.GOMAXPROCS(0),
+		queues:       make(map[string]uint32),
+		deadQueue:    o.DeadLetterQueue,
+		queueLimits:  o.QueueRateLimits,
+		buckets:      make(map[string]*bucket),
+		scalers:      scalers,
+		running:      make(map[string]context.CancelCauseFunc),
+		paused:       make(map[string]bool),
+		tripped:      make(map[string]time.Time),
+		workflows:    make(map[string]Workflow),
+
+		clock:             o.Clock,
+		events:            o.Events,
+		workerID:          o.WorkerID,
+		tags:              o.Tags,
+		heartbeatInterval: o.HeartbeatInterval,
+		stuckTimeout:      o.StuckJobTimeout,
+		requeueStuck:      o.RequeueStuckJobs,
+		offloadThreshold:  o.PayloadOffloadThreshold,
+		blobs:             o.BlobStore,
+		retention:         o.QueueRetention,
+		retentionInterval: o.RetentionInterval,
+		shutdownTimeout:   o.ShutdownTimeout,
+		scheduleEntries:   make(map[string]scheduleEntry),
+		scheduleSync:      make(chan struct{}, 1),
 	}, nil
 }
 
+// brokerFor() returns the broker the queue is routed to, the default broker unless it is set in
+// ServerOpts.QueueBrokers.
+func (s *Server) brokerFor(queue string) Broker {
+	if b, ok := s.queueBrokers[queue]; ok {
+		return b
+	}
+
+	return s.broker
+}
+
 // GetTasks() returns a list of all tasks registered with the server.
 func (s *Server) GetTasks() []string {
 	s.p.RLock()
@@ -160,6 +477,54 @@ var ErrNotFound = errors.New("result not found")
 
 // GetResult() accepts a ID and returns the result of the job in the results store.
 func (s *Server) GetResult(ctx context.Context, id string) ([]byte, error) {
+	b, err := s.getResult(ctx, id)
+	if err != nil || s.enc == nil {
+		return b, err
+	}
+
+	if b, err = s.enc.Decrypt(b); err != nil {
+		return nil, fmt.Errorf("could not decrypt result : %w", err)
+	}
+	return b, nil
+}
+
+// GetResults() returns the results of the jobs (id -> result), in one round trip if the results store
+// implements MultiResults. Jobs without a result are omitted.
+func (s *Server) GetResults(ctx context.Context, ids []string) (map[string][]byte, error) {
+	var (
+		out map[string][]byte
+		err error
+	)
+	if mr, ok := s.results.(MultiResults); ok {
+		if out, err = mr.GetMulti(ctx, ids); err != nil {
+			return nil, err
+		}
+	} else {
+		out = make(map[string][]byte, len(ids))
+		for _, id := range ids {
+			b, err := s.getResult(ctx, id)
+			if errors.Is(err, ErrNotFound) {
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+			out[id] = b
+		}
+	}
+
+	if s.enc != nil {
+		for id, b := range out {
+			if out[id], err = s.enc.Decrypt(b); err != nil {
+				return nil, fmt.Errorf("could not decrypt result of %s : %w", id, err)
+			}
+		}
+	}
+
+	return out, nil
+}
+
+// getResult() returns the value of the key in the results store, as is.
+func (s *Server) getResult(ctx context.Context, id string) ([]byte, error) {
 	b, err := s.results.Get(ctx, id)
 	if err == nil {
 		return b, nil
@@ -173,16 +538,32 @@ func (s *Server) GetResult(ctx context.Context, id string) ([]byte, error) {
 	return nil, err
 }
 
-// GetPending() returns the pending job message's in the broker's queue.
+// GetPending() returns the pending job message's in the broker's queue, including its sub-queues of the
+// server's tags (ServerOpts.Tags).
 func (s *Server) GetPending(ctx context.Context, queue string) ([]JobMessage, error) {
-	rs, err := s.broker.GetPending(ctx, queue)
+	jobMsg := make([]JobMessage, 0)
+	for _, sub := range s.subQueues(queue) {
+		msgs, err := s.pending(ctx, queue, sub)
+		if err != nil {
+			return nil, err
+		}
+		jobMsg = append(jobMsg, msgs...)
+	}
+
+	return jobMsg, nil
+}
+
+// pending() returns the pending job messages in the broker queue sub of the queue (the queue itself, or one
+// of its sub-queues).
+func (s *Server) pending(ctx context.Context, queue, sub string) ([]JobMessage, error) {
+	rs, err := s.brokerFor(queue).GetPending(ctx, sub)
 	if err != nil {
 		return nil, err
 	}
 
 	var jobMsg = make([]JobMessage, len(rs))
 	for i, r := range rs {
-		if err := msgpack.Unmarshal([]byte(r), &jobMsg[i]); err != nil {
+		if err := s.codec.unmarshal([]byte(r), &jobMsg[i]); err != nil {
 			return nil, err
 		}
 	}
@@ -209,31 +590,100 @@ func (s *Server) GetSuccess(ctx context.Context) ([]string, error) {
 // Start() starts the job consumer and processor. It is a blocking function.
 func (s *Server) Start(ctx context.Context) {
 	go s.cron.Start()
+	defer s.cron.Stop()
+
+	// jobsCtx is the context the jobs are processed with. It outlives ctx by the shutdown timeout,
+	// so that the jobs being processed can finish.
+	jobsCtx, cancelJobs := context.WithCancelCause(context.WithoutCancel(ctx))
+	defer cancelJobs(errShutdown)
+	stopped := make(chan struct{})
+	defer close(stopped)
+	go func() {
+		select {
+		case <-stopped:
+			return
+		case <-ctx.Done():
+		}
+
+		tm := time.NewTimer(s.shutdownTimeout)
+		defer tm.Stop()
+		select {
+		case <-stopped:
+		case <-tm.C:
+			s.log.Info("shutdown timeout exceeded, interrupting jobs")
+			cancelJobs(errShutdown)
+		}
+	}()
+
 	// Loop over each registered queue.
 	s.q.RLock()
 	queues := s.queues
 	s.q.RUnlock()
 
 	var wg sync.WaitGroup
+	// Run the stored schedules, if the results store supports them.
+	if _, ok := s.results.(UniqueResults); ok {
+		wg.Add(1)
+		go func() {
+			s.runSchedules(ctx)
+			wg.Done()
+		}()
+	}
+	if s.metrics != nil {
+		wg.Add(1)
+		go func() {
+			s.reportQueueDepth(ctx)
+			wg.Done()
+		}()
+	}
+	if len(s.retention) > 0 {
+		wg.Add(1)
+		go func() {
+			s.enforceRetention(ctx)
+			wg.Done()
+		}()
+	}
+	if s.stuckTimeout > 0 {
+		wg.Add(1)
+		go func() {
+			s.watchStuckJobs(ctx)
+			wg.Done()
+		}()
+	}
+
 	for q, conc := range queues {
 		q := q // Hack to fix the loop variable capture issue.
+		// qctx is the queue's own traced context, ctx is read by the goroutines above.
+		qctx := ctx
 		if s.traceProv != nil {
 			var span spans.Span
-			ctx, span = otel.Tracer(tracer).Start(ctx, "start")
+			qctx, span = otel.Tracer(tracer).Start(ctx, "start")
 			defer span.End()
 		}
 
+		// The queue's sub-queues of the server's tags are consumed onto the same processors.
 		work := make(chan []byte)
-		wg.Add(1)
-		go func() {
-			s.consume(ctx, work, q)
-			wg.Done()
-		}()
+		for _, sub := range s.subQueues(q) {
+			wg.Add(1)
+			go func() {
+				s.consumeQueue(qctx, work, q, sub)
+				wg.Done()
+			}()
+		}
+
+		if sc, ok := s.scalers[q]; ok {
+			wg.Add(1)
+			go func() {
+				s.autoscale(qctx, jobsCtx, work, q, sc, &wg)
+				wg.Done()
+			}()
+			continue
+		}
 
 		for i := 0; i < int(conc); i++ {
 			wg.Add(1)
 			go func() {
-				s.process(ctx, work)
+				s.process(qctx, jobsCtx, work, q, nil)
 				wg.Done()
 			}()
 		}
@@ -241,62 +691,314 @@ func (s *Server) Start(ctx context.Context) {
 	wg.Wait()
 }
 
-// consume() listens on the queue for task messages and passes the task to processor.
-func (s *Server) consume(ctx context.Context, work chan []byte, queue string) {
-	s.log.Debug("starting task consumer..")
-	s.broker.Consume(ctx, work, queue)
+// reportQueueDepth() periodically reports the number of pending jobs on each queue to the metrics collector.
+func (s *Server) reportQueueDepth(ctx context.Context) {
+	tk := time.NewTicker(queueDepthInterval)
+	defer tk.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tk.C:
+			s.q.RLock()
+			queues := make([]string, 0, len(s.queues))
+			for q := range s.queues {
+				queues = append(queues, q)
+			}
+			s.q.RUnlock()
+
+			for _, q := range queues {
+				pending, _, err := s.queueDepth(ctx, q)
+				if err != nil {
+					s.log.Debug("could not get queue depth", "queue", q, "error", err)
+					continue
+				}
+				s.metrics.QueueDepth(q, pending)
+			}
+		}
+	}
+}
+
+// queueDepth() returns the number of jobs pending on the queue and its sub-queues of the server's tags, and
+// the number of jobs scheduled onto them (or -1 if the broker can't count them). Brokers that don't implement
+// DepthBroker have their pending jobs fetched to be counted.
+func (s *Server) queueDepth(ctx context.Context, queue string) (int, int, error) {
+	var (
+		b                  = s.brokerFor(queue)
+		db, ok             = b.(DepthBroker)
+		pending, scheduled int
+	)
+	for _, sub := range s.subQueues(queue) {
+		if !ok {
+			rs, err := b.GetPending(ctx, sub)
+			if err != nil {
+				return 0, 0, err
+			}
+			pending, scheduled = pending+len(rs), -1
+			continue
+		}
+
+		p, sc, err := db.QueueDepth(ctx, sub)
+		if err != nil {
+			return 0, 0, err
+		}
+		pending += p
+		if sc < 0 || scheduled < 0 {
+			scheduled = -1
+		} else {
+			scheduled += sc
+		}
+	}
+
+	return pending, scheduled, nil
+}
+
+// consume() listens on the queue (or its sub-queue) for task messages and passes the task to processor.
+func (s *Server) consume(ctx context.Context, work chan []byte, queue, sub string) {
+	s.log.Debug("starting task consumer..", "queue", sub)
+	s.brokerFor(queue).Consume(ctx, work, sub)
 }
 
 // process() listens on the work channel for tasks. On receiving a task it checks the
-// processors map and passes payload to relevant processor.
-func (s *Server) process(ctx context.Context, w chan []byte) {
+// processors map and passes payload to relevant processor. It stops receiving tasks once ctx
+// is cancelled, while the tasks are processed with jobsCtx. sc is the queue's scaler, if it is
+// autoscaled.
+func (s *Server) process(ctx, jobsCtx context.Context, w chan []byte, queue string, sc *scaler) {
 	s.log.Debug("starting processor..")
 	for {
-		var span spans.Span
-		if s.traceProv != nil {
-			ctx, span = otel.Tracer(tracer).Start(ctx, "process")
-			defer span.End()
-		}
-
 		select {
 		case <-ctx.Done():
 			s.log.Info("shutting down processor..")
 			return
 		case work := <-w:
-			var (
-				msg JobMessage
-				err error
-			)
-			// Decode the bytes into a job message
-			if err = msgpack.Unmarshal(work, &msg); err != nil {
-				s.spanError(span, err)
-				s.log.Error("error unmarshalling task", "error", err)
-				break
-			}
+			done := sc.track()
+			s.handle(jobsCtx, work, queue)
+			done()
+		}
+	}
+}
 
-			// Fetch the registered task handler.
-			task, err := s.getHandler(msg.Job.Task)
-			if err != nil {
-				s.spanError(span, err)
-				s.log.Error("handler not found", "error", err)
-				break
-			}
+// ProcessAll() processes the pending jobs of the registered queues in the calling goroutine, including
+// the jobs enqueued while processing them (eg: the next jobs of chains and immediate retries), until
+// none are left. Paused queues are skipped. It returns the number of jobs processed. It is meant for
+// tests, instead of Start(), and requires the broker to implement DequeueBroker (eg: the in-memory broker).
+func (s *Server) ProcessAll(ctx context.Context) (int, error) {
+	s.q.RLock()
+	queues := make([]string, 0, len(s.queues))
+	for q := range s.queues {
+		queues = append(queues, q)
+	}
+	s.q.RUnlock()
+	sort.Strings(queues)
 
-			// Set the job status as being "processed"
-			if err := s.statusProcessing(ctx, msg); err != nil {
-				s.spanError(span, err)
-				s.log.Error("error setting the status to processing", "error", err)
-				break
-			}
+	dbs := make(map[string]DequeueBroker, len(queues))
+	for _, q := range queues {
+		db, ok := s.brokerFor(q).(DequeueBroker)
+		if !ok {
+			return 0, fmt.Errorf("broker of queue %s does not support synchronous processing", q)
+		}
+		dbs[q] = db
+	}
 
-			if err := s.execJob(ctx, msg, task); err != nil {
-				s.spanError(span, err)
-				s.log.Error("could not execute job", "error", err)
+	var n int
+	for {
+		var processed bool
+		for _, q := range queues {
+			if s.isPaused(ctx, q) {
+				continue
+			}
+			for _, sub := range s.subQueues(q) {
+				for {
+					work, ok, err := dbs[q].Dequeue(ctx, sub)
+					if err != nil {
+						return n, err
+					}
+					if !ok {
+						break
+					}
+					s.handle(ctx, work, q)
+					n, processed = n+1, true
+				}
 			}
 		}
+		if !processed {
+			return n, nil
+		}
+	}
+}
+
+// handle() decodes the message consumed from the queue, processes it and acknowledges it.
+func (s *Server) handle(ctx context.Context, work []byte, queue string) {
+	var msg JobMessage
+	// Decode the bytes into a job message
+	if err := s.codec.unmarshal(work, &msg); err != nil {
+		// The message can never be processed, so it is dropped.
+		s.log.Error("error unmarshalling task", "error", err)
+		s.ack(ctx, work, queue, queue, true)
+		return
+	}
+
+	// The message may have been consumed from a sub-queue of the server's tags.
+	sub := queue
+	if len(s.tags) > 0 {
+		sub = msgQueue(msg)
+	}
+	s.ack(ctx, work, queue, sub, s.processJob(ctx, work, msg))
+}
+
+// ack() acknowledges the message consumed from the sub-queue of the queue if it was processed,
+// otherwise it is returned onto the sub-queue. It is a no-op if the broker doesn't implement AckBroker.
+func (s *Server) ack(ctx context.Context, work []byte, queue, sub string, processed bool) {
+	ab, ok := s.brokerFor(queue).(AckBroker)
+	if !ok {
+		return
+	}
+
+	// The message is acknowledged even if the server is shutting down.
+	ctx = context.WithoutCancel(ctx)
+	if processed {
+		if err := ab.Ack(ctx, work, sub); err != nil {
+			s.log.Error("error acknowledging job message", "queue", sub, "error", err)
+		}
+		return
+	}
+	if err := ab.Nack(ctx, work, sub); err != nil {
+		s.log.Error("error returning job message onto queue", "queue", sub, "error", err)
 	}
 }
 
+// processJob() executes the decoded job message (work) with the registered task handler.
+// If tracing is enabled, the job is processed in a child span of the span that enqueued it.
+// It returns false if the job couldn't be processed and should be consumed again.
+func (s *Server) processJob(ctx context.Context, work []byte, msg JobMessage) bool {
+	var span spans.Span
+	if s.traceProv != nil {
+		ctx = s.propagator.Extract(ctx, propagation.MapCarrier(msg.TraceContext))
+		ctx, span = otel.Tracer(tracer).Start(ctx, "process",
+			spans.WithSpanKind(spans.SpanKindConsumer), spans.WithAttributes(jobAttrs(msg)...))
+		defer span.End()
+	}
+
+	// Skip the job if it was cancelled while queued.
+	cancelled, err := s.isCancelled(ctx, msg.ID)
+	if err != nil {
+		s.spanError(span, err)
+		s.log.Error("error checking job cancellation", "error", err)
+		return false
+	}
+	if cancelled {
+		if err := s.statusCancelled(ctx, msg); err != nil {
+			s.spanError(span, err)
+			s.log.Error("error setting the status to cancelled", "error", err)
+			return false
+		}
+		s.clearCancel(ctx, msg.ID)
+		return true
+	}
+
+	// Discard the job if it is consumed past its expiry.
+	if exp := msg.Job.Opts.ExpiresAt; !exp.IsZero() && s.now().After(exp) {
+		if s.metrics != nil {
+			s.metrics.JobProcessed(msg.Queue, msg.Job.Task, StatusExpired, 0)
+		}
+		if err := s.statusExpired(ctx, msg); err != nil {
+			s.spanError(span, err)
+			s.log.Error("error setting the status to expired", "error", err)
+			return false
+		}
+		return true
+	}
+
+	// Fetch the registered task handler.
+	task, err := s.getHandler(msg.Job.Task)
+	if err != nil {
+		s.spanError(span, err)
+		s.log.Error("handler not found", "error", err)
+		return true
+	}
+
+	// Wait for the rate limits of the queue/task, if any.
+	if err := s.rateLimit(ctx, msg.Queue, task); err != nil {
+		if errors.Is(context.Cause(ctx), errShutdown) {
+			return s.requeue(ctx, work, msg)
+		}
+		s.spanError(span, err)
+		s.log.Error("error waiting for rate limit", "error", err)
+		return false
+	}
+
+	// Put the job back onto its queue if the task is at its concurrency limit.
+	release, ok, err := s.acquireTask(ctx, msg, task)
+	if err != nil {
+		s.spanError(span, err)
+		s.log.Error("error acquiring task concurrency slot", "error", err)
+		return false
+	}
+	if !ok {
+		return s.deferJob(ctx, work, msg, concurrencyDeferDelay)
+	}
+	defer release()
+
+	// Put the job back onto its queue while the task's circuit breaker is open.
+	if task.breaker != nil {
+		if wait, ok := task.breaker.allow(msg.ID, s.now()); !ok {
+			return s.deferJob(ctx, work, msg, wait)
+		}
+	}
+
+	// Release the unique key of a debounced job before it is processed (and its coalesced payloads are
+	// read), so that the jobs enqueued from now on are coalesced into its next run.
+	if ur, ok := s.results.(UniqueResults); ok && msg.Job.Opts.Debounce != 0 {
+		if err := ur.DeleteUnique(ctx, msg.Job.Opts.UniqueKey, msg.ID); err != nil {
+			s.spanError(span, err)
+			s.log.Error("error releasing unique key of debounced job", "error", err)
+			return false
+		}
+	}
+
+	// Set the job status as being "processed"
+	msg.Worker = s.workerID
+	if err := s.statusProcessing(ctx, msg); err != nil {
+		s.spanError(span, err)
+		s.log.Error("error setting the status to processing", "error", err)
+		return false
+	}
+
+	if err := s.execJob(ctx, msg, task); err != nil {
+		if errors.Is(err, errShutdown) {
+			return s.requeue(ctx, work, msg)
+		}
+		s.spanError(span, err)
+		s.log.Error("could not execute job", "error", err)
+		return false
+	}
+
+	return true
+}
+
+// errShutdown is the cause of the cancellation of the jobs interrupted by the server shutting down.
+var errShutdown = errors.New("server shutting down")
+
+// requeue() returns a job interrupted by the server shutting down onto its queue, to be processed
+// again. Messages of brokers implementing AckBroker are returned by not acknowledging them.
+func (s *Server) requeue(ctx context.Context, work []byte, msg JobMessage) bool {
+	s.log.Info("requeuing job interrupted by shutdown", "id", msg.ID, "queue", msg.Queue)
+
+	ctx = context.WithoutCancel(ctx)
+	if err := s.statusStarted(ctx, msg); err != nil {
+		s.log.Error("error setting the status to queued", "error", err)
+	}
+	if _, ok := s.brokerFor(msg.Queue).(AckBroker); ok {
+		return false
+	}
+	if err := s.brokerEnqueue(ctx, work, msg); err != nil {
+		s.log.Error("error requeuing job", "id", msg.ID, "error", err)
+	}
+
+	return true
+}
+
 func (s *Server) execJob(ctx context.Context, msg JobMessage, task Task) error {
 	var span spans.Span
 	if s.traceProv != nil {
@@ -305,34 +1007,77 @@ func (s *Server) execJob(ctx context.Context, msg JobMessage, task Task) error {
 	}
 	// Create the task context, which will be passed to the handler.
 	// TODO: maybe use sync.Pool
-	taskCtx := JobCtx{Meta: msg.Meta, store: s.results}
+	taskCtx := JobCtx{
+		Meta:     msg.Meta,
+		store:    s.results,
+		enc:      s.enc,
+		progress: &progress{codec: s.codec},
+		headers:  msg.Job.Opts.Headers,
+	}
 	var (
 		// errChan is to receive the error returned by the handler.
 		errChan = make(chan error, 1)
 		err     error
 
-		// jctx is the context passed to the job.
-		jctx, cancelFunc = context.WithCancel(ctx)
+		// jctx is the context passed to the job. It is cancelled with errJobCancelled
+		// as the cause if the job is cancelled.
+		jctx, cancelJob = context.WithCancelCause(ctx)
+		cancelFunc      = func() { cancelJob(nil) }
 	)
+	defer cancelJob(nil)
 
 	// If there is a deadline given, set that on jctx and not ctx
 	// because we don't want to cancel the entire context in case deadline exceeded.
-	if !(msg.Job.Opts.Timeout == 0) {
-		jctx, cancelFunc = context.WithDeadline(ctx, time.Now().Add(msg.Job.Opts.Timeout))
+	// The job's timeout takes precedence over the task's.
+	timeout := msg.Job.Opts.Timeout
+	if timeout == 0 {
+		timeout = task.opts.Timeout
+	}
+	if !(timeout == 0) {
+		jctx, cancelFunc = context.WithDeadline(jctx, time.Now().Add(timeout))
 	}
+	stopWatch := s.watchCancel(jctx, msg.ID, cancelJob)
+	stopHeartbeat := s.heartbeat(jctx, msg)
 
-	// Set jctx as the context for the task.
-	taskCtx.Context = jctx
+	// Set jctx as the context for the task, carrying the result of the previous job.
+	taskCtx.Context = context.WithValue(jctx, prevResultKey{}, msg.PrevJobResult)
 
 	if task.opts.ProcessingCB != nil {
 		task.opts.ProcessingCB(taskCtx)
 	}
+	if s.hooks.OnJobStarted != nil {
+		s.hooks.OnJobStarted(taskCtx)
+	}
 
+	start := time.Now()
+	// The handler's goroutine may outlive the attempt (eg: once it times out), while msg is updated.
+	var (
+		id  = msg.ID
+		job = *msg.Job
+	)
 	go func() {
-		errChan <- task.handler(msg.Job.Payload, taskCtx)
-		close(errChan)
+		defer close(errChan)
+		defer func() {
+			if r := recover(); r != nil {
+				errChan <- &panicError{val: r, stack: debug.Stack()}
+			}
+		}()
+		// Offloaded payloads are loaded here, failing the attempt if they can't be.
+		payload, err := s.loadPayload(jctx, &job)
+		if err != nil {
+			errChan <- err
+			return
+		}
+		if payload, err = s.debouncedPayload(jctx, id, job.Opts, payload); err != nil {
+			errChan <- err
+			return
+		}
+		errChan <- task.handler(payload, taskCtx)
 	}()
 
+	// succeeded is set if the handler returned successfully, in which case the job isn't
+	// requeued even if it was interrupted by the server shutting down.
+	var succeeded bool
 	select {
 	case <-jctx.Done():
 		cancelFunc()
@@ -343,10 +1088,63 @@ func (s *Server) execJob(ctx context.Context, msg JobMessage, task Task) error {
 	case jerr := <-errChan:
 		cancelFunc()
 		err = jerr
+		succeeded = jerr == nil
 		if jerr == context.Canceled {
 			err = nil
 		}
 	}
+	stopWatch()
+	stopHeartbeat()
+
+	// The job's status is updated even if the server is shutting down.
+	ctx = context.WithoutCancel(ctx)
+	s.flushProgress(ctx, taskCtx)
+
+	if !succeeded && errors.Is(context.Cause(jctx), errShutdown) {
+		if task.breaker != nil {
+			task.breaker.abort(msg.ID)
+		}
+		return errShutdown
+	}
+
+	// Record the attempt, along with the handler's error.
+	aerr := err
+	if errors.Is(context.Cause(jctx), errJobCancelled) {
+		aerr = errJobCancelled
+	}
+	attempt := AttemptInfo{StartedAt: start, FinishedAt: time.Now()}
+	msg.Error = ""
+	if aerr != nil {
+		attempt.Error, msg.Error = aerr.Error(), aerr.Error()
+		var pe *panicError
+		if errors.As(aerr, &pe) {
+			attempt.Stack = string(pe.stack)
+		}
+	}
+	msg.recordAttempt(attempt)
+
+	if errors.Is(context.Cause(jctx), errJobCancelled) {
+		if task.breaker != nil {
+			task.breaker.abort(msg.ID)
+		}
+		if s.metrics != nil {
+			s.metrics.JobProcessed(msg.Queue, msg.Job.Task, StatusCancelled, time.Since(start))
+		}
+		s.clearCancel(ctx, msg.ID)
+		return s.statusCancelled(ctx, msg)
+	}
+	s.recordBreaker(ctx, msg, task, err)
+
+	if s.metrics != nil {
+		status := StatusDone
+		if err != nil {
+			status = StatusFailed
+			if msg.MaxRetry != msg.Retried {
+				status = StatusRetrying
+			}
+		}
+		s.metrics.JobProcessed(msg.Queue, msg.Job.Task, status, time.Since(start))
+	}
 
 	if err != nil {
 		// Set the job's error
@@ -356,11 +1154,18 @@ func (s *Server) execJob(ctx context.Context, msg JobMessage, task Task) error {
 			if task.opts.RetryingCB != nil {
 				task.opts.RetryingCB(taskCtx, err)
 			}
-			return s.retryJob(ctx, msg)
+			if s.hooks.OnJobRetried != nil {
+				s.hooks.OnJobRetried(taskCtx, err)
+			}
+			return s.retryJob(ctx, msg, task, err)
 		} else {
+			s.releaseUnique(ctx, msg)
 			if task.opts.FailedCB != nil {
 				task.opts.FailedCB(taskCtx, err)
 			}
+			if s.hooks.OnJobFailed != nil {
+				s.hooks.OnJobFailed(taskCtx, err)
+			}
 
 			// If there are jobs to enqueued after failure, enqueue them.
 			if msg.Job.OnError != nil {
@@ -375,14 +1180,35 @@ func (s *Server) execJob(ctx context.Context, msg JobMessage, task Task) error {
 				}
 			}
 
+			// Apply the chain's failure policy, before the job is set as failed so that the
+			// next job (with ChainContinue) is recorded on it.
+			ids, err := s.failChain(ctx, msg)
+			if err != nil {
+				return fmt.Errorf("error applying chain failure policy : %w", err)
+			}
+			msg.OnSuccessIDs = append(msg.OnSuccessIDs, ids...)
+
 			// If we hit max retries, set the task status as failed.
-			return s.statusFailed(ctx, msg)
+			if err := s.statusFailed(ctx, msg); err != nil {
+				return err
+			}
+			if err := s.advanceWorkflow(ctx, msg); err != nil {
+				return fmt.Errorf("error updating workflow : %w", err)
+			}
+
+			return s.deadLetter(ctx, msg)
 		}
 	}
 
+	// Release the unique key before enqueuing the OnSuccess jobs, which may hold the
+	// same key (eg: the next run of a scheduled job).
+	s.releaseUnique(ctx, msg)
 	if task.opts.SuccessCB != nil {
 		task.opts.SuccessCB(taskCtx)
 	}
+	if s.hooks.OnJobSuccess != nil {
+		s.hooks.OnJobSuccess(taskCtx)
+	}
 
 	// If the task contains OnSuccess task (part of a chain), enqueue them.
 	if msg.Job.OnSuccess != nil {
@@ -390,8 +1216,11 @@ func (s *Server) execJob(ctx context.Context, msg JobMessage, task Task) error {
 			// Extract OnSuccessJob into a variable to get opts.
 			nj := *j
 			meta := DefaultMeta(nj.Opts)
+			if msg.ChainID != "" {
+				meta.ChainID, meta.ChainStep = msg.ChainID, msg.ChainStep+1
+			}
 			meta.PrevJobResult, err = s.GetResult(ctx, msg.ID)
-			if err != nil {
+			if err != nil && !errors.Is(err, ErrNotFound) {
 				return err
 			}
 
@@ -404,17 +1233,28 @@ func (s *Server) execJob(ctx context.Context, msg JobMessage, task Task) error {
 			msg.OnSuccessIDs = append(msg.OnSuccessIDs, onSuccessID)
 		}
 	}
+	s.deleteBlobs(ctx, msg, msg.Job.OnSuccess)
 
 	if err := s.statusDone(ctx, msg); err != nil {
 		s.spanError(span, err)
 		return err
 	}
 
+	if err := s.advanceWorkflow(ctx, msg); err != nil {
+		s.spanError(span, err)
+		return fmt.Errorf("error advancing workflow : %w", err)
+	}
+	if err := s.completeGroup(ctx, msg); err != nil {
+		s.spanError(span, err)
+		return fmt.Errorf("error completing group : %w", err)
+	}
+
 	return nil
 }
 
 // retryJob() increments the retried count and re-queues the task message.
-func (s *Server) retryJob(ctx context.Context, msg JobMessage) error {
+// If the task has a retry strategy, the job is scheduled to be re-queued after the delay it returns.
+func (s *Server) retryJob(ctx context.Context, msg JobMessage, task Task, jerr error) error {
 	var span spans.Span
 	if s.traceProv != nil {
 		ctx, span = otel.Tracer(tracer).Start(ctx, "retry_job")
@@ -422,7 +1262,7 @@ func (s *Server) retryJob(ctx context.Context, msg JobMessage) error {
 	}
 
 	msg.Retried += 1
-	b, err := msgpack.Marshal(msg)
+	b, err := s.codec.marshal(msg)
 	if err != nil {
 		s.spanError(span, err)
 		return err
@@ -433,7 +1273,17 @@ func (s *Server) retryJob(ctx context.Context, msg JobMessage) error {
 		return err
 	}
 
-	if err := s.broker.Enqueue(ctx, b, msg.Queue); err != nil {
+	if task.opts.RetryStrategy != nil {
+		if d := task.opts.RetryStrategy(int(msg.Retried), jerr); d > 0 {
+			if err := s.brokerFor(msg.Queue).EnqueueScheduled(ctx, b, msgQueue(msg), s.now().Add(d)); err != nil {
+				s.spanError(span, err)
+				return err
+			}
+			return nil
+		}
+	}
+
+	if err := s.brokerEnqueue(ctx, b, msg); err != nil {
 		s.spanError(span, err)
 		return err
 	}
@@ -471,13 +1321,14 @@ func (s *Server) statusStarted(ctx context.Context, t JobMessage) error {
 		defer span.End()
 	}
 
-	t.ProcessedAt = time.Now()
+	t.ProcessedAt = s.now()
 	t.Status = StatusStarted
 
 	if err := s.setJobMessage(ctx, t); err != nil {
 		s.spanError(span, err)
 		return err
 	}
+	s.recordEvent(ctx, t)
 
 	return nil
 }
@@ -489,13 +1340,14 @@ func (s *Server) statusProcessing(ctx context.Context, t JobMessage) error {
 		defer span.End()
 	}
 
-	t.ProcessedAt = time.Now()
+	t.ProcessedAt = s.now()
 	t.Status = StatusProcessing
 
 	if err := s.setJobMessage(ctx, t); err != nil {
 		s.spanError(span, err)
 		return err
 	}
+	s.recordEvent(ctx, t)
 
 	return nil
 }
@@ -507,17 +1359,21 @@ func (s *Server) statusDone(ctx context.Context, t JobMessage) error {
 		defer span.End()
 	}
 
-	t.ProcessedAt = time.Now()
+	t.ProcessedAt = s.now()
 	t.Status = StatusDone
 
 	if err := s.results.SetSuccess(ctx, t.ID); err != nil {
 		return err
 	}
+	if err := s.indexJob(ctx, t); err != nil {
+		return err
+	}
 
 	if err := s.setJobMessage(ctx, t); err != nil {
 		s.spanError(span, err)
 		return err
 	}
+	s.recordEvent(ctx, t)
 
 	return nil
 }
@@ -529,17 +1385,21 @@ func (s *Server) statusFailed(ctx context.Context, t JobMessage) error {
 		defer span.End()
 	}
 
-	t.ProcessedAt = time.Now()
+	t.ProcessedAt = s.now()
 	t.Status = StatusFailed
 
 	if err := s.results.SetFailed(ctx, t.ID); err != nil {
 		return err
 	}
+	if err := s.indexJob(ctx, t); err != nil {
+		return err
+	}
 
 	if err := s.setJobMessage(ctx, t); err != nil {
 		s.spanError(span, err)
 		return err
 	}
+	s.recordEvent(ctx, t)
 
 	return nil
 }
@@ -551,17 +1411,48 @@ func (s *Server) statusRetrying(ctx context.Context, t JobMessage) error {
 		defer span.End()
 	}
 
-	t.ProcessedAt = time.Now()
+	t.ProcessedAt = s.now()
 	t.Status = StatusRetrying
 
 	if err := s.setJobMessage(ctx, t); err != nil {
 		s.spanError(span, err)
 		return err
 	}
+	s.recordEvent(ctx, t)
 
 	return nil
 }
 
+func (s *Server) statusExpired(ctx context.Context, t JobMessage) error {
+	var span spans.Span
+	if s.traceProv != nil {
+		ctx, span = otel.Tracer(tracer).Start(ctx, "status_expired")
+		defer span.End()
+	}
+
+	s.releaseUnique(ctx, t)
+	s.deleteBlobs(ctx, t, nil)
+	t.ProcessedAt = s.now()
+	t.Status = StatusExpired
+
+	if err := s.setJobMessage(ctx, t); err != nil {
+		s.spanError(span, err)
+		return err
+	}
+	s.recordEvent(ctx, t)
+
+	return nil
+}
+
+// jobAttrs returns the span attributes identifying a job.
+func jobAttrs(msg JobMessage) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("job.id", msg.ID),
+		attribute.String("job.task", msg.Job.Task),
+		attribute.String("job.queue", msg.Queue),
+	}
+}
+
 // spanError checks if tracing is enabled & adds an error to
 // supplied span.
 func (s *Server) spanError(sp spans.Span, err error) {
@@ -1,10 +1,12 @@
 package tasqueue
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log/slog"
 	"os"
+	"sync"
 	"testing"
 	"time"
 
@@ -58,3 +60,346 @@ func MockHandlerWithSleep(msg []byte, _ JobCtx) error {
 
 	return nil
 }
+
+// ackBroker records the acknowledgements of consumed messages.
+type ackBroker struct {
+	*rb.Broker
+
+	mu     sync.Mutex
+	acked  int
+	nacked int
+}
+
+func (b *ackBroker) Ack(_ context.Context, _ []byte, _ string) error {
+	b.mu.Lock()
+	b.acked++
+	b.mu.Unlock()
+	return nil
+}
+
+func (b *ackBroker) Nack(_ context.Context, _ []byte, _ string) error {
+	b.mu.Lock()
+	b.nacked++
+	b.mu.Unlock()
+	return nil
+}
+
+func TestAckBroker(t *testing.T) {
+	var (
+		ctx, cancel = context.WithCancel(context.Background())
+		broker      = &ackBroker{Broker: rb.New()}
+		lo          = slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
+			Level: slog.LevelError,
+		}))
+	)
+	srv, err := NewServer(ServerOpts{
+		Broker:  broker,
+		Results: rr.New(),
+		Logger:  lo.Handler(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The second job waits on the rate limit, until the server is shut down.
+	if err := srv.RegisterTask(taskName, MockHandler, TaskOpts{
+		Concurrency: 1,
+		RateLimit:   RateLimit{Rate: 0.01, Burst: 1},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := srv.Enqueue(ctx, makeJob(t, taskName, false)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		srv.Start(ctx)
+		close(done)
+	}()
+	time.Sleep(500 * time.Millisecond)
+	cancel()
+	<-done
+
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+	if broker.acked != 1 || broker.nacked != 1 {
+		t.Fatalf("expected 1 acked and 1 nacked message, got %d acked and %d nacked", broker.acked, broker.nacked)
+	}
+}
+
+func TestTaskTimeout(t *testing.T) {
+	var (
+		ctx = context.Background()
+		lo  = slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
+			Level: slog.LevelError,
+		}))
+	)
+	srv, err := NewServer(ServerOpts{
+		Broker:  rb.New(),
+		Results: rr.New(),
+		Logger:  lo.Handler(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.RegisterTask(taskName, MockHandlerWithSleep, TaskOpts{Concurrency: 1, Timeout: 500 * time.Millisecond}); err != nil {
+		t.Fatal(err)
+	}
+	go srv.Start(ctx)
+
+	// The hung handler times out, freeing the only processor for the retry.
+	id, err := srv.Enqueue(ctx, makeJob(t, taskName, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(1500 * time.Millisecond)
+
+	msg, err := srv.GetJob(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Status != StatusFailed || msg.Retried != 1 {
+		t.Fatalf("expected job to fail after a retry, got status %s with %d retries", msg.Status, msg.Retried)
+	}
+	if msg.PrevErr != context.DeadlineExceeded.Error() {
+		t.Fatalf("incorrect job error, expected %v, got %s", context.DeadlineExceeded, msg.PrevErr)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	var (
+		ctx, cancel = context.WithCancel(context.Background())
+		mu          sync.Mutex
+		calls       []string
+		done        = make(chan struct{})
+		mw          = func(name string) func(Handler) Handler {
+			return func(next Handler) Handler {
+				return func(b []byte, c JobCtx) error {
+					mu.Lock()
+					calls = append(calls, name)
+					mu.Unlock()
+					return next(b, c)
+				}
+			}
+		}
+	)
+	defer cancel()
+
+	srv, err := NewServer(ServerOpts{
+		Broker:     rb.New(),
+		Results:    rr.New(),
+		Logger:     slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError})).Handler(),
+		Middleware: []func(Handler) Handler{mw("server-1"), mw("server-2")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.RegisterTask(taskName, func([]byte, JobCtx) error {
+		mu.Lock()
+		calls = append(calls, "handler")
+		mu.Unlock()
+		close(done)
+		return nil
+	}, TaskOpts{Middleware: []func(Handler) Handler{mw("task")}}); err != nil {
+		t.Fatal(err)
+	}
+	go srv.Start(ctx)
+
+	if _, err := srv.Enqueue(ctx, makeJob(t, taskName, false)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("job was not processed")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if got := fmt.Sprint(calls); got != "[server-1 server-2 task handler]" {
+		t.Fatalf("incorrect middleware order: %s", got)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	lo := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
+		Level: slog.LevelError,
+	}))
+
+	// start() runs a server with the handler until the job has started, then shuts it down
+	// and returns the job's status once Start() has returned. A new job is enqueued if id is empty.
+	start := func(opts ServerOpts, handler Handler, id string) (string, string) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		started := make(chan struct{}, 1)
+		srv, err := NewServer(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := srv.RegisterTask(taskName, func(b []byte, j JobCtx) error {
+			started <- struct{}{}
+			return handler(b, j)
+		}, TaskOpts{Concurrency: 1}); err != nil {
+			t.Fatal(err)
+		}
+
+		stopped := make(chan struct{})
+		go func() {
+			srv.Start(ctx)
+			close(stopped)
+		}()
+
+		if id == "" {
+			if id, err = srv.Enqueue(ctx, makeJob(t, taskName, false)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		select {
+		case <-started:
+		case <-time.After(time.Second):
+			t.Fatal("job was not started")
+		}
+		cancel()
+		select {
+		case <-stopped:
+		case <-time.After(2 * time.Second):
+			t.Fatal("server did not shut down")
+		}
+
+		msg, err := srv.GetJob(context.Background(), id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id, msg.Status
+	}
+
+	t.Run("drain", func(t *testing.T) {
+		opts := ServerOpts{Broker: rb.New(), Results: rr.New(), Logger: lo.Handler(), ShutdownTimeout: time.Second}
+		_, status := start(opts, func([]byte, JobCtx) error {
+			time.Sleep(200 * time.Millisecond)
+			return nil
+		}, "")
+		if status != StatusDone {
+			t.Fatalf("expected job to finish within the shutdown timeout, got status %s", status)
+		}
+	})
+
+	t.Run("requeue", func(t *testing.T) {
+		opts := ServerOpts{Broker: rb.New(), Results: rr.New(), Logger: lo.Handler(), ShutdownTimeout: 100 * time.Millisecond}
+		id, status := start(opts, func(_ []byte, j JobCtx) error {
+			<-j.Done()
+			return j.Err()
+		}, "")
+		if status != StatusStarted {
+			t.Fatalf("expected interrupted job to be requeued, got status %s", status)
+		}
+
+		// The requeued job is processed by the next server.
+		_, status = start(opts, func([]byte, JobCtx) error { return nil }, id)
+		if status != StatusDone {
+			t.Fatalf("expected requeued job to be processed, got status %s", status)
+		}
+	})
+}
+
+func TestProcessAll(t *testing.T) {
+	var (
+		ctx   = context.Background()
+		clock = rb.NewFakeClock(time.Now())
+		lo    = slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
+			Level: slog.LevelError,
+		}))
+	)
+	srv, err := NewServer(ServerOpts{
+		Broker:  rb.NewWithClock(clock),
+		Results: rr.New(),
+		Logger:  lo.Handler(),
+		Clock:   clock,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.RegisterTask(taskName, MockHandler, TaskOpts{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The jobs enqueued while processing (the chain's next job) are processed too.
+	chain, err := NewChain([]Job{makeJob(t, taskName, false), makeJob(t, taskName, false)}, ChainOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := srv.EnqueueChain(ctx, chain); err != nil {
+		t.Fatal(err)
+	}
+	delayed := makeJob(t, taskName, false)
+	delayed.Opts.Delay = time.Hour
+	id, err := srv.Enqueue(ctx, delayed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := srv.ProcessAll(ctx); err != nil || n != 2 {
+		t.Fatalf("expected 2 jobs to be processed, got %d (%v)", n, err)
+	}
+
+	// The delayed job is processed once the clock reaches its ETA.
+	clock.Advance(59 * time.Minute)
+	if n, err := srv.ProcessAll(ctx); err != nil || n != 0 {
+		t.Fatalf("expected no jobs to be processed, got %d (%v)", n, err)
+	}
+	clock.Advance(time.Minute)
+	if n, err := srv.ProcessAll(ctx); err != nil || n != 1 {
+		t.Fatalf("expected the delayed job to be processed, got %d (%v)", n, err)
+	}
+	msg, err := srv.GetJob(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Status != StatusDone || !msg.ProcessedAt.Equal(clock.Now()) {
+		t.Fatalf("expected the job to be processed at %v, got %s at %v", clock.Now(), msg.Status, msg.ProcessedAt)
+	}
+}
+
+func TestNamespace(t *testing.T) {
+	if _, err := NewServer(ServerOpts{Broker: rb.New(), Results: rr.New(), Namespace: "billing"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Stores that don't support namespaces are rejected, so that their keys don't collide.
+	_, err := NewServer(ServerOpts{Broker: rb.New(), Results: pollResults{rr.New()}, Namespace: "billing"})
+	if err == nil {
+		t.Fatal("expected error for results store without namespace support")
+	}
+}
+
+func TestGetResults(t *testing.T) {
+	ctx := context.Background()
+	for name, results := range map[string]Results{
+		"multi": rr.New(),
+		"each":  pollResults{rr.New()},
+	} {
+		t.Run(name, func(t *testing.T) {
+			srv, err := NewServer(ServerOpts{Broker: rb.New(), Results: results})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, id := range []string{"a", "b"} {
+				if err := results.Set(ctx, id, []byte("result-"+id)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			out, err := srv.GetResults(ctx, []string{"a", "b", "missing"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(out) != 2 || string(out["a"]) != "result-a" || string(out["b"]) != "result-b" {
+				t.Fatalf("incorrect results, got %q", out)
+			}
+		})
+	}
+}
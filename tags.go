@@ -0,0 +1,75 @@
+package tasqueue
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+const (
+	// tagSep separates the queue from the required tags in the names of its sub-queues
+	// (eg: "tasqueue:tasks@gpu,region=eu").
+	tagSep = "@"
+
+	// maxTags is the maximum number of tags of a server, which consumes a sub-queue per set of its tags.
+	maxTags = 6
+)
+
+// normTags() returns the tags sorted and without duplicates.
+func normTags(tags []string) []string {
+	t := slices.Clone(tags)
+	slices.Sort(t)
+	return slices.Compact(t)
+}
+
+// validTags() checks that the tags can be used in the names of the sub-queues.
+func validTags(tags []string) error {
+	for _, t := range tags {
+		if t == "" || strings.ContainsAny(t, tagSep+",") {
+			return fmt.Errorf("invalid tag %q", t)
+		}
+	}
+
+	return nil
+}
+
+// subQueue() returns the broker queue the jobs requiring the tags are routed to: the queue itself, or its
+// sub-queue of the tags.
+func subQueue(queue string, tags []string) string {
+	if len(tags) == 0 {
+		return queue
+	}
+
+	return queue + tagSep + strings.Join(normTags(tags), ",")
+}
+
+// msgQueue() returns the broker queue the job message is routed to (JobOpts.Tags).
+func msgQueue(msg JobMessage) string {
+	if msg.Job == nil {
+		return msg.Queue
+	}
+
+	return subQueue(msg.Queue, msg.Job.Opts.Tags)
+}
+
+// subQueues() returns the broker queues of the queue consumed by the server: the queue itself, and the
+// sub-queues of every set of the server's tags.
+func (s *Server) subQueues(queue string) []string {
+	queues := make([]string, 0, 1<<len(s.tags))
+	for set := 0; set < 1<<len(s.tags); set++ {
+		var tags []string
+		for i, t := range s.tags {
+			if set&(1<<i) != 0 {
+				tags = append(tags, t)
+			}
+		}
+		queues = append(queues, subQueue(queue, tags))
+	}
+
+	return queues
+}
+
+// Tags() returns the tags of the server (ServerOpts.Tags).
+func (s *Server) Tags() []string {
+	return slices.Clone(s.tags)
+}
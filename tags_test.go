@@ -0,0 +1,92 @@
+package tasqueue
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"testing"
+
+	rb "github.com/kalbhor/tasqueue/v2/brokers/in-memory"
+	rr "github.com/kalbhor/tasqueue/v2/results/in-memory"
+)
+
+func TestWorkerTags(t *testing.T) {
+	lo := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
+		Level: slog.LevelError,
+	}))
+	ctx := context.Background()
+
+	var (
+		broker  = rb.New()
+		results = rr.New()
+	)
+	// Returns a server sharing the broker, with the tags.
+	worker := func(id string, tags ...string) *Server {
+		srv, err := NewServer(ServerOpts{
+			Broker:   broker,
+			Results:  results,
+			Logger:   lo.Handler(),
+			WorkerID: id,
+			Tags:     tags,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := srv.RegisterTask(taskName, MockHandler, TaskOpts{}); err != nil {
+			t.Fatal(err)
+		}
+		return srv
+	}
+	var (
+		plain = worker("plain")
+		gpu   = worker("gpu", "gpu", "region=us")
+		gpuEU = worker("gpu-eu", "region=eu", "gpu")
+	)
+
+	ids := make(map[string]string)
+	for want, tags := range map[string][]string{
+		"plain":  nil,
+		"gpu":    {"gpu", "region=us"},
+		"gpu-eu": {"gpu", "region=eu"},
+	} {
+		job := makeJob(t, taskName, false)
+		job.Opts.Tags = tags
+		id, err := plain.Enqueue(ctx, job)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[id] = want
+	}
+
+	// The pending jobs of the gpu worker's sub-queues are counted on the queue.
+	pending, err := gpu.GetPending(ctx, DefaultQueue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 2 {
+		t.Fatalf("expected 2 jobs pending on the gpu worker's queues, got %d", len(pending))
+	}
+	if n, _, err := gpu.queueDepth(ctx, DefaultQueue); err != nil || n != 2 {
+		t.Fatalf("expected a depth of 2 on the gpu worker's queues, got %d: %v", n, err)
+	}
+
+	// Each worker only processes the jobs whose tags it has, so the plain worker goes first.
+	for _, srv := range []*Server{plain, gpu, gpuEU} {
+		n, err := srv.ProcessAll(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 1 {
+			t.Fatalf("expected worker %s to process 1 job, processed %d", srv.workerID, n)
+		}
+	}
+	for id, want := range ids {
+		msg, err := plain.GetJob(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg.Status != StatusDone || msg.Worker != want {
+			t.Fatalf("expected job %s to be processed by %s, got %s (%s)", id, want, msg.Worker, msg.Status)
+		}
+	}
+}
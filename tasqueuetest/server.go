@@ -0,0 +1,102 @@
+// Package tasqueuetest runs a tasqueue server on the in-memory broker and results store with a fake
+// clock, to unit test the code enqueuing and processing jobs without running a broker. Jobs are
+// processed synchronously with ProcessAll(), and delayed and scheduled jobs by advancing the clock.
+package tasqueuetest
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kalbhor/tasqueue/v2"
+	rb "github.com/kalbhor/tasqueue/v2/brokers/in-memory"
+	rr "github.com/kalbhor/tasqueue/v2/results/in-memory"
+)
+
+// Server is a tasqueue server for tests, which records the jobs enqueued on it.
+type Server struct {
+	*tasqueue.Server
+
+	Broker  *rb.Broker
+	Results *rr.Results
+	Clock   *rb.FakeClock
+
+	mu       sync.Mutex
+	enqueued []tasqueue.JobMessage
+}
+
+// New() returns a server with the options, whose broker, results store and clock are replaced by
+// the in-memory ones and a fake clock set to the current time.
+func New(opts tasqueue.ServerOpts) (*Server, error) {
+	s := &Server{
+		Clock:   rb.NewFakeClock(time.Now()),
+		Results: rr.New(),
+	}
+	s.Broker = rb.NewWithClock(s.Clock)
+
+	hook := opts.Hooks.OnJobEnqueued
+	opts.Hooks.OnJobEnqueued = func(ctx context.Context, msg tasqueue.JobMessage) {
+		s.mu.Lock()
+		s.enqueued = append(s.enqueued, msg)
+		s.mu.Unlock()
+		if hook != nil {
+			hook(ctx, msg)
+		}
+	}
+	opts.Broker, opts.Results, opts.Clock = s.Broker, s.Results, s.Clock
+
+	srv, err := tasqueue.NewServer(opts)
+	if err != nil {
+		return nil, err
+	}
+	s.Server = srv
+
+	return s, nil
+}
+
+// Advance() moves the clock forward by d and processes the pending jobs, including the delayed and
+// scheduled jobs that became due. It returns the number of jobs processed.
+func (s *Server) Advance(ctx context.Context, d time.Duration) (int, error) {
+	s.Clock.Advance(d)
+
+	return s.ProcessAll(ctx)
+}
+
+// Enqueued() returns the jobs of the task (or of all the tasks, if empty) enqueued on the server,
+// in the order they were enqueued. Retries aren't included.
+func (s *Server) Enqueued(task string) []tasqueue.JobMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var out []tasqueue.JobMessage
+	for _, m := range s.enqueued {
+		if task == "" || m.Job.Task == task {
+			out = append(out, m)
+		}
+	}
+
+	return out
+}
+
+// AssertEnqueued() fails the test unless a job of the task was enqueued with the payload.
+func (s *Server) AssertEnqueued(t testing.TB, task string, payload []byte) {
+	t.Helper()
+
+	for _, m := range s.Enqueued(task) {
+		if bytes.Equal(m.Job.Payload, payload) {
+			return
+		}
+	}
+	t.Errorf("no job of task %s was enqueued with payload %q", task, payload)
+}
+
+// AssertNotEnqueued() fails the test if a job of the task was enqueued.
+func (s *Server) AssertNotEnqueued(t testing.TB, task string) {
+	t.Helper()
+
+	if n := len(s.Enqueued(task)); n > 0 {
+		t.Errorf("expected no job of task %s to be enqueued, got %d", task, n)
+	}
+}
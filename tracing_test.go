@@ -0,0 +1,63 @@
+package tasqueue
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"testing"
+	"time"
+
+	rb "github.com/kalbhor/tasqueue/v2/brokers/in-memory"
+	rr "github.com/kalbhor/tasqueue/v2/results/in-memory"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracePropagation(t *testing.T) {
+	var (
+		ctx = context.Background()
+		rec = tracetest.NewSpanRecorder()
+		tp  = trace.NewTracerProvider(trace.WithSpanProcessor(rec))
+		lo  = slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
+			Level: slog.LevelError,
+		}))
+	)
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(tp)
+	defer otel.SetTracerProvider(prev)
+
+	srv, err := NewServer(ServerOpts{
+		Broker:        rb.New(),
+		Results:       rr.New(),
+		Logger:        lo.Handler(),
+		TraceProvider: tp,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.RegisterTask(taskName, MockHandler, TaskOpts{}); err != nil {
+		t.Fatal(err)
+	}
+	go srv.Start(ctx)
+
+	pctx, parent := otel.Tracer("test").Start(ctx, "parent")
+	if _, err := srv.Enqueue(pctx, makeJob(t, taskName, false)); err != nil {
+		t.Fatal(err)
+	}
+	parent.End()
+	time.Sleep(time.Second)
+
+	for _, sp := range rec.Ended() {
+		if sp.Name() != "process" {
+			continue
+		}
+		if sp.SpanContext().TraceID() != parent.SpanContext().TraceID() {
+			t.Fatalf("process span not in the enqueuer's trace, expected %s, got %s",
+				parent.SpanContext().TraceID(), sp.SpanContext().TraceID())
+		}
+		return
+	}
+
+	t.Fatal("process span not found")
+}
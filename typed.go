@@ -0,0 +1,67 @@
+package tasqueue
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Validator is implemented by the payloads of typed tasks that validate themselves once decoded.
+type Validator interface {
+	Validate() error
+}
+
+// TypedTask is a task registered with RegisterTaskT(), whose jobs carry a payload of type T.
+type TypedTask[T any] struct {
+	name string
+}
+
+// Name() returns the name of the task.
+func (t TypedTask[T]) Name() string {
+	return t.name
+}
+
+// NewJob() returns a job of the task with the payload.
+func (t TypedTask[T]) NewJob(payload T, opts JobOpts) (Job, error) {
+	return NewJobT(t.name, payload, opts)
+}
+
+// RegisterTaskT() registers a task whose handler is passed the job's payload decoded (as JSON) into
+// a T. If T (or *T) implements Validator, the payload is validated before the handler is called. Payloads
+// that can't be decoded or are invalid fail the job's attempt. It returns the task, to create its jobs
+// with a typed payload.
+func RegisterTaskT[T any](s *Server, name string, fn func(JobCtx, T) error, opts TaskOpts) (TypedTask[T], error) {
+	h := func(b []byte, c JobCtx) error {
+		var p T
+		if err := json.Unmarshal(b, &p); err != nil {
+			return fmt.Errorf("could not decode payload of task %s : %w", name, err)
+		}
+		// Validate may be implemented on T or on *T.
+		v, ok := any(p).(Validator)
+		if !ok {
+			v, ok = any(&p).(Validator)
+		}
+		if ok {
+			if err := v.Validate(); err != nil {
+				return fmt.Errorf("invalid payload of task %s : %w", name, err)
+			}
+		}
+
+		return fn(c, p)
+	}
+	if err := s.RegisterTask(name, h, opts); err != nil {
+		return TypedTask[T]{}, err
+	}
+
+	return TypedTask[T]{name: name}, nil
+}
+
+// NewJobT() returns a job of the task with the payload encoded as JSON, to be processed by a task
+// registered with RegisterTaskT[T]().
+func NewJobT[T any](task string, payload T, opts JobOpts) (Job, error) {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return Job{}, fmt.Errorf("could not encode payload of task %s : %w", task, err)
+	}
+
+	return NewJob(task, b, opts)
+}
@@ -0,0 +1,126 @@
+package tasqueue
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+type sumPayload struct {
+	A, B int
+}
+
+func (p sumPayload) Validate() error {
+	if p.A < 0 || p.B < 0 {
+		return errors.New("negative operand")
+	}
+	return nil
+}
+
+// namePayload is validated with a pointer receiver.
+type namePayload struct {
+	Name string
+}
+
+func (p *namePayload) Validate() error {
+	if p.Name == "" {
+		return errors.New("name missing")
+	}
+	return nil
+}
+
+func TestRegisterTaskT(t *testing.T) {
+	ctx := context.Background()
+	srv := newServer(t, taskName, MockHandler)
+
+	var sums []int
+	task, err := RegisterTaskT(srv, "sum", func(_ JobCtx, p sumPayload) error {
+		sums = append(sums, p.A+p.B)
+		return nil
+	}, TaskOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ids []string
+	for _, p := range []sumPayload{{A: 1, B: 2}, {A: -1, B: 2}} {
+		job, err := task.NewJob(p, JobOpts{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, err := srv.Enqueue(ctx, job)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	// Payloads that can't be decoded fail the job.
+	job, err := NewJob("sum", []byte("not json"), JobOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := srv.Enqueue(ctx, job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids = append(ids, id)
+
+	if _, err := srv.ProcessAll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(sums) != 1 || sums[0] != 3 {
+		t.Fatalf("incorrect sums, expected [3], got %v", sums)
+	}
+
+	for i, want := range []string{"", "invalid payload", "could not decode"} {
+		msg, err := srv.GetJob(ctx, ids[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want == "" && msg.Status != StatusDone || want != "" && (msg.Status != StatusFailed || !strings.Contains(msg.Error, want)) {
+			t.Fatalf("incorrect job %d, status %s, error %q", i, msg.Status, msg.Error)
+		}
+	}
+}
+
+func TestRegisterTaskTPointerValidator(t *testing.T) {
+	ctx := context.Background()
+	srv := newServer(t, taskName, MockHandler)
+
+	var names []string
+	task, err := RegisterTaskT(srv, "greet", func(_ JobCtx, p namePayload) error {
+		names = append(names, p.Name)
+		return nil
+	}, TaskOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ids []string
+	for _, p := range []namePayload{{Name: "tasqueue"}, {}} {
+		job, err := task.NewJob(p, JobOpts{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, err := srv.Enqueue(ctx, job)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	if _, err := srv.ProcessAll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 1 || names[0] != "tasqueue" {
+		t.Fatalf("incorrect names, expected [tasqueue], got %v", names)
+	}
+
+	msg, err := srv.GetJob(ctx, ids[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Status != StatusFailed || !strings.Contains(msg.Error, "name missing") {
+		t.Fatalf("expected the invalid payload to fail the job, got status %s, error %q", msg.Status, msg.Error)
+	}
+}
@@ -0,0 +1,117 @@
+package tasqueue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Bounds of the interval at which a job's status is polled while waiting for it. The interval doubles
+// after each poll.
+const (
+	minWaitInterval = 10 * time.Millisecond
+	maxWaitInterval = time.Second
+)
+
+var (
+	// ErrJobFailed is returned by EnqueueAndWait when the job failed, wrapping the job's error.
+	ErrJobFailed = errors.New("job failed")
+
+	// ErrJobCancelled is returned by EnqueueAndWait when the job was cancelled.
+	ErrJobCancelled = errors.New("job cancelled")
+
+	// ErrJobExpired is returned by EnqueueAndWait when the job expired before it was processed.
+	ErrJobExpired = errors.New("job expired")
+)
+
+// Result is the outcome of a job awaited with EnqueueAndWait.
+type Result struct {
+	// Job is the job message once the job finished.
+	Job JobMessage
+	// Data is the result saved by the job's handler, or nil if it didn't save one.
+	Data []byte
+}
+
+// EnqueueAndWait() enqueues the job and blocks until it has finished, returning its result. If the job
+// failed, was cancelled or expired, it returns ErrJobFailed (wrapping the job's error), ErrJobCancelled
+// or ErrJobExpired along with the job message. The job's status is awaited with SubscribeJob if the results store supports
+// it, and is polled as well. The wait can be bounded with ctx, which doesn't cancel the job.
+func (s *Server) EnqueueAndWait(ctx context.Context, t Job) (Result, error) {
+	id, err := s.Enqueue(ctx, t)
+	if err != nil {
+		return Result{}, err
+	}
+
+	msg, err := s.waitJob(ctx, id)
+	if err != nil {
+		return Result{}, err
+	}
+
+	res := Result{Job: msg}
+	switch msg.Status {
+	case StatusFailed:
+		return res, fmt.Errorf("%w : %s", ErrJobFailed, msg.PrevErr)
+	case StatusCancelled:
+		return res, ErrJobCancelled
+	case StatusExpired:
+		return res, ErrJobExpired
+	}
+
+	if res.Data, err = s.GetResult(ctx, id); err != nil && !errors.Is(err, ErrNotFound) {
+		return res, err
+	}
+
+	return res, nil
+}
+
+// waitJob() returns the job message once the job has finished. The job's status is polled with a backoff
+// along with the subscription to it, if the results store supports subscriptions, as subscriptions can miss
+// updates (eg: redis keyspace notifications that aren't enabled).
+func (s *Server) waitJob(ctx context.Context, id string) (JobMessage, error) {
+	var updates <-chan JobMessage
+	if _, ok := s.results.(SubscribeResults); ok {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		var err error
+		if updates, err = s.SubscribeJob(ctx, id); err != nil {
+			return JobMessage{}, err
+		}
+	}
+
+	// The status is checked once subscribed, in case the job finished before.
+	interval := minWaitInterval
+	for {
+		msg, err := s.GetJob(ctx, id)
+		if err != nil {
+			return JobMessage{}, err
+		}
+		if finished(msg.Status) {
+			return msg, nil
+		}
+
+		tm := time.NewTimer(interval)
+	wait:
+		for {
+			select {
+			case <-ctx.Done():
+				tm.Stop()
+				return JobMessage{}, ctx.Err()
+			case msg, ok := <-updates:
+				if !ok {
+					// The subscription was closed, the status is only polled.
+					updates = nil
+					continue
+				}
+				if finished(msg.Status) {
+					tm.Stop()
+					return msg, nil
+				}
+			case <-tm.C:
+				break wait
+			}
+		}
+		interval = min(interval*2, maxWaitInterval)
+	}
+}
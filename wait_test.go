@@ -0,0 +1,86 @@
+package tasqueue
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"testing"
+	"time"
+
+	rb "github.com/kalbhor/tasqueue/v2/brokers/in-memory"
+	rr "github.com/kalbhor/tasqueue/v2/results/in-memory"
+)
+
+// pollResults hides the optional interfaces of the in-memory results store, so that jobs are polled.
+type pollResults struct {
+	Results
+}
+
+// silentResults is a results store whose subscriptions never receive updates (eg: redis without keyspace
+// notifications), so that jobs are awaited by polling them.
+type silentResults struct {
+	Results
+}
+
+func (silentResults) Subscribe(ctx context.Context, _ string) (<-chan []byte, error) {
+	ch := make(chan []byte)
+	go func() {
+		<-ctx.Done()
+		close(ch)
+	}()
+	return ch, nil
+}
+
+func TestEnqueueAndWait(t *testing.T) {
+	lo := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
+		Level: slog.LevelError,
+	}))
+
+	for name, results := range map[string]Results{
+		"subscribe": rr.New(),
+		"poll":      pollResults{rr.New()},
+		"silent":    silentResults{rr.New()},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			srv, err := NewServer(ServerOpts{Broker: rb.New(), Results: results, Logger: lo.Handler()})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := srv.RegisterTask(taskName, MockHandler, TaskOpts{}); err != nil {
+				t.Fatal(err)
+			}
+			if err := srv.RegisterTask("echo", func(b []byte, j JobCtx) error {
+				return j.Save(b)
+			}, TaskOpts{}); err != nil {
+				t.Fatal(err)
+			}
+			go srv.Start(ctx)
+
+			job, err := NewJob("echo", []byte("pong"), JobOpts{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			res, err := srv.EnqueueAndWait(ctx, job)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Job.Status != StatusDone || string(res.Data) != "pong" {
+				t.Fatalf("incorrect result, expected %s with pong, got %s with %q", StatusDone, res.Job.Status, res.Data)
+			}
+
+			job = makeJob(t, taskName, true)
+			job.Opts.MaxRetries = 0
+			res, err = srv.EnqueueAndWait(ctx, job)
+			if !errors.Is(err, ErrJobFailed) {
+				t.Fatalf("expected %v, got %v", ErrJobFailed, err)
+			}
+			if res.Job.Status != StatusFailed {
+				t.Fatalf("incorrect job status, expected %s, got %s", StatusFailed, res.Job.Status)
+			}
+		})
+	}
+}
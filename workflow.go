@@ -0,0 +1,336 @@
+package tasqueue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+
+	"github.com/google/uuid"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Workflow is a sequence of steps, where each step runs one job or fans out into multiple jobs
+// that run in parallel. A step starts once all the jobs of the previous step are successful,
+// and receives their results as JobCtx.Meta.PrevJobResult. Workflows are registered on the
+// servers by name (like tasks), as their conditions can't be sent over the broker.
+type Workflow struct {
+	Name  string
+	Steps []Step
+}
+
+// Step is a step of a workflow.
+type Step struct {
+	Jobs []Job
+
+	// cond decides whether the step is run, based on the result of the previous step.
+	cond func(prevResult []byte) bool
+}
+
+type WorkflowOpts struct {
+	// Optional ID passed by client. If empty, Tasqueue generates it.
+	ID string
+
+	// Input is passed onto the jobs of the first step as their PrevJobResult.
+	Input []byte
+}
+
+// WorkflowMeta contains fields related to a workflow run.
+type WorkflowMeta struct {
+	ID   string
+	Name string
+	// Status of the overall workflow
+	Status string
+	// Index of the current step
+	Step int
+	// IDs of the jobs enqueued for each step. Skipped steps have no jobs.
+	JobIDs [][]string
+	// Indices of the steps that were skipped
+	Skipped []int
+}
+
+// WorkflowMessage is a workflow run, containing meta info such as status, id.
+// A WorkflowMessage is stored in the results store.
+type WorkflowMessage struct {
+	WorkflowMeta
+}
+
+// NewStep() returns a workflow step running the jobs. Multiple jobs run in parallel.
+func NewStep(jobs ...Job) Step {
+	return Step{Jobs: jobs}
+}
+
+// If() sets a condition on the step, which is skipped if fn returns false. fn is passed the result
+// of the previous step that ran, or the workflow's input if it is the first.
+func (s Step) If(fn func(prevResult []byte) bool) Step {
+	s.cond = fn
+	return s
+}
+
+// NewWorkflow() accepts a name and a list of steps and creates a workflow.
+// The jobs of the steps are templates, each workflow run enqueues new jobs with new IDs.
+func NewWorkflow(name string, steps ...Step) (Workflow, error) {
+	if name == "" {
+		return Workflow{}, fmt.Errorf("workflow name missing")
+	}
+	if len(steps) == 0 {
+		return Workflow{}, fmt.Errorf("minimum 1 step required to form workflow")
+	}
+	for i, st := range steps {
+		if len(st.Jobs) == 0 {
+			return Workflow{}, fmt.Errorf("step %d of workflow has no jobs", i)
+		}
+		for _, j := range st.Jobs {
+			if j.Opts.ID != "" {
+				return Workflow{}, fmt.Errorf("jobs of a workflow can't have an ID")
+			}
+		}
+	}
+
+	return Workflow{Name: name, Steps: steps}, nil
+}
+
+// RegisterWorkflow() registers a workflow on the server. A workflow must be registered on
+// all the servers processing its jobs, which move the workflow onto its next steps.
+func (s *Server) RegisterWorkflow(w Workflow) {
+	s.log.Debug("registered workflow", "name", w.Name)
+
+	s.w.Lock()
+	s.workflows[w.Name] = w
+	s.w.Unlock()
+}
+
+// EnqueueWorkflow() starts a run of the registered workflow and returns its ID.
+// The results store must implement UniqueResults, which is used to move the workflow onto
+// its next step only once.
+func (s *Server) EnqueueWorkflow(ctx context.Context, name string, opts WorkflowOpts) (string, error) {
+	if _, ok := s.results.(UniqueResults); !ok {
+		return "", fmt.Errorf("results store does not support workflows")
+	}
+	w, err := s.getWorkflow(name)
+	if err != nil {
+		return "", err
+	}
+
+	if opts.ID == "" {
+		opts.ID = uuid.NewString()
+	}
+	msg := WorkflowMessage{
+		WorkflowMeta: WorkflowMeta{
+			ID:     opts.ID,
+			Name:   name,
+			Status: StatusProcessing,
+			JobIDs: make([][]string, len(w.Steps)),
+		},
+	}
+
+	if err := s.startStep(ctx, w, msg, 0, opts.Input); err != nil {
+		return "", fmt.Errorf("could not enqueue workflow : %w", err)
+	}
+
+	return msg.ID, nil
+}
+
+// GetWorkflow() returns the workflow run. Its status is failed (or cancelled) if any of the
+// jobs of its current step failed (or was cancelled).
+func (s *Server) GetWorkflow(ctx context.Context, id string) (WorkflowMessage, error) {
+	w, err := s.getWorkflowMessage(ctx, id)
+	if err != nil {
+		return WorkflowMessage{}, err
+	}
+	if w.Status != StatusProcessing {
+		return w, nil
+	}
+
+	for _, id := range w.JobIDs[w.Step] {
+		j, err := s.GetJob(ctx, id)
+		if err != nil {
+			return WorkflowMessage{}, err
+		}
+		if j.Status == StatusFailed || j.Status == StatusCancelled || j.Status == StatusExpired {
+			w.Status = j.Status
+			if err := s.setWorkflowMessage(ctx, w); err != nil {
+				return WorkflowMessage{}, err
+			}
+			break
+		}
+	}
+
+	return w, nil
+}
+
+// advanceWorkflow() is called once a job of a workflow finishes. If all the jobs of its step are
+// successful, the workflow is moved onto the next step with the step's results.
+func (s *Server) advanceWorkflow(ctx context.Context, msg JobMessage) error {
+	if msg.WorkflowID == "" {
+		return nil
+	}
+
+	wm, err := s.getWorkflowMessage(ctx, msg.WorkflowID)
+	if err != nil {
+		return err
+	}
+	// The job is from a previous step (eg: it was redelivered) or the workflow has finished.
+	if wm.Status != StatusProcessing || msg.WorkflowStep != wm.Step {
+		return nil
+	}
+	w, err := s.getWorkflow(wm.Name)
+	if err != nil {
+		return err
+	}
+
+	ids := wm.JobIDs[wm.Step]
+	results := make([][]byte, len(ids))
+	for i, id := range ids {
+		j, err := s.GetJob(ctx, id)
+		if err != nil {
+			return err
+		}
+		switch j.Status {
+		case StatusDone:
+		case StatusFailed, StatusCancelled, StatusExpired:
+			wm.Status = j.Status
+			return s.setWorkflowMessage(ctx, wm)
+		default:
+			// The step has jobs that haven't finished yet.
+			return nil
+		}
+
+		results[i], err = s.GetResult(ctx, id)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+	}
+
+	// All the jobs of the step are successful. Only the server that acquires the step's
+	// key moves the workflow onto the next step, if it wasn't already moved. The key is
+	// released once the next step is enqueued (or fails to be).
+	ur := s.results.(UniqueResults)
+	key := workflowStepPrefix + wm.ID + ":" + strconv.Itoa(wm.Step)
+	if _, ok, err := ur.SetUnique(ctx, key, wm.ID, advanceKeyTTL); err != nil || !ok {
+		return err
+	}
+	defer s.releaseKey(ctx, key, wm.ID)
+
+	cur, err := s.getWorkflowMessage(ctx, wm.ID)
+	if err != nil {
+		return err
+	}
+	if cur.Status != StatusProcessing || cur.Step != wm.Step {
+		return nil
+	}
+
+	prev := results[0]
+	if len(results) > 1 {
+		if prev, err = msgpack.Marshal(results); err != nil {
+			return err
+		}
+	}
+
+	next := wm
+	next.JobIDs, next.Skipped = slices.Clone(wm.JobIDs), slices.Clone(wm.Skipped)
+	if err := s.startStep(ctx, w, next, wm.Step+1, prev); err != nil {
+		// Restore the workflow onto its step, so that it is moved again.
+		if err := s.setWorkflowMessage(context.WithoutCancel(ctx), wm); err != nil {
+			s.log.Error("could not restore workflow", "id", wm.ID, "error", err)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// startStep() enqueues the jobs of the first step from `from` whose condition is met. If there
+// are no more steps to run, the workflow is marked as successful.
+func (s *Server) startStep(ctx context.Context, w Workflow, wm WorkflowMessage, from int, prev []byte) error {
+	for i := from; i < len(w.Steps); i++ {
+		st := w.Steps[i]
+		if st.cond != nil && !st.cond(prev) {
+			wm.Skipped = append(wm.Skipped, i)
+			continue
+		}
+
+		metas := make([]Meta, len(st.Jobs))
+		ids := make([]string, len(st.Jobs))
+		for j, job := range st.Jobs {
+			metas[j] = DefaultMeta(job.Opts)
+			metas[j].WorkflowID = wm.ID
+			metas[j].WorkflowStep = i
+			metas[j].PrevJobResult = prev
+			ids[j] = metas[j].ID
+		}
+
+		// Store the job IDs of the step before enqueuing them, so that they are known
+		// to the servers processing the jobs.
+		wm.Step = i
+		wm.JobIDs[i] = ids
+		if err := s.setWorkflowMessage(ctx, wm); err != nil {
+			return err
+		}
+
+		for j, job := range st.Jobs {
+			if _, err := s.enqueueWithMeta(ctx, job, metas[j]); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	wm.Step = len(w.Steps) - 1
+	wm.Status = StatusDone
+	return s.setWorkflowMessage(ctx, wm)
+}
+
+// DecodeResults() decodes the results of a workflow step that fanned out into multiple jobs (or of
+// the jobs of a group, for its OnComplete job), in the order of the jobs. Jobs that didn't save
+// a result have a nil result.
+func DecodeResults(b []byte) ([][]byte, error) {
+	var results [][]byte
+	if err := msgpack.Unmarshal(b, &results); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+func (s *Server) getWorkflow(name string) (Workflow, error) {
+	s.w.RLock()
+	w, ok := s.workflows[name]
+	s.w.RUnlock()
+	if !ok {
+		return Workflow{}, fmt.Errorf("workflow %v not found", name)
+	}
+
+	return w, nil
+}
+
+const (
+	workflowPrefix = "workflow:msg:"
+
+	// Prefix of the unique keys acquired to move a workflow past a step.
+	workflowStepPrefix = "workflow:step:"
+)
+
+func (s *Server) setWorkflowMessage(ctx context.Context, w WorkflowMessage) error {
+	b, err := s.codec.marshal(w)
+	if err != nil {
+		return err
+	}
+	return setMeta(ctx, s.results, workflowPrefix+w.ID, b)
+}
+
+func (s *Server) getWorkflowMessage(ctx context.Context, id string) (WorkflowMessage, error) {
+	b, err := s.getResult(ctx, workflowPrefix+id)
+	if err != nil {
+		return WorkflowMessage{}, err
+	}
+
+	var w WorkflowMessage
+	if err := s.codec.unmarshal(b, &w); err != nil {
+		return WorkflowMessage{}, err
+	}
+
+	return w, nil
+}
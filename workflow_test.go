@@ -0,0 +1,91 @@
+package tasqueue
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestWorkflow(t *testing.T) {
+	var (
+		ctx    = context.Background()
+		loaded = make(chan [][]byte, 1)
+		srv    = newServer(t, "extract", func(b []byte, c JobCtx) error {
+			return c.Save(append(c.Meta.PrevJobResult, b...))
+		})
+	)
+	if err := srv.RegisterTask("transform", func(b []byte, c JobCtx) error {
+		return c.Save(append(c.Meta.PrevJobResult, b...))
+	}, TaskOpts{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.RegisterTask("load", func(b []byte, c JobCtx) error {
+		res, err := DecodeResults(c.Meta.PrevJobResult)
+		if err != nil {
+			return err
+		}
+		loaded <- res
+		return nil
+	}, TaskOpts{}); err != nil {
+		t.Fatal(err)
+	}
+	go srv.Start(ctx)
+
+	job := func(task, payload string) Job {
+		j, err := NewJob(task, []byte(payload), JobOpts{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return j
+	}
+	w, err := NewWorkflow("etl",
+		NewStep(job("extract", "-e")),
+		// Fan out into two jobs, whose results are fed to the next step that runs.
+		NewStep(job("transform", "-a"), job("transform", "-b")),
+		NewStep(job("load", "")).If(func(prev []byte) bool { return prev == nil }),
+		NewStep(job("load", "")).If(func(prev []byte) bool { return prev != nil }),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.RegisterWorkflow(w)
+
+	id, err := srv.EnqueueWorkflow(ctx, "etl", WorkflowOpts{Input: []byte("in")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case res := <-loaded:
+		if len(res) != 2 || string(res[0]) != "in-e-a" || string(res[1]) != "in-e-b" {
+			t.Fatalf("incorrect results of fanned out step: %q", res)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("workflow did not reach the last step")
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	wm, err := srv.GetWorkflow(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wm.Status != StatusDone {
+		t.Fatalf("incorrect workflow status, expected %s, got %s", StatusDone, wm.Status)
+	}
+	if len(wm.Skipped) != 1 || wm.Skipped[0] != 2 {
+		t.Fatalf("expected step 2 to be skipped, got %v", wm.Skipped)
+	}
+	if len(wm.JobIDs[1]) != 2 {
+		t.Fatalf("expected 2 jobs in the fanned out step, got %v", wm.JobIDs[1])
+	}
+
+	// The keys of the steps are released.
+	ur := srv.results.(UniqueResults)
+	for step := range w.Steps {
+		key := workflowStepPrefix + id + ":" + strconv.Itoa(step)
+		if _, ok, err := ur.SetUnique(ctx, key, "test", 0); err != nil || !ok {
+			t.Fatalf("expected the key of step %d to be released: %v", step, err)
+		}
+	}
+}